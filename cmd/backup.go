@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"docker-volume-backup/internal/crypt"
+	"docker-volume-backup/internal/operation"
+)
+
+// backupResult is the outcome of the backup of one volume.
+type backupResult struct {
+	volume string
+	// location is the archive written, or the snapshot ID in a repository
+	location string
+	elapsed  time.Duration
+	err      error
+}
+
+// runBackups backs up each volume, to its expansion of the destination
+// template or to the repository if one is set. A failed volume does not stop
+// the remaining ones. With several volumes a summary is printed at the end.
+func runBackups(ctx context.Context, volumes []string, dest string, filter operation.PathFilter, keys *crypt.Keys, hooks operation.Hooks) error {
+	if repository == "" {
+		if err := operation.ValidateDestination(dest); err != nil {
+			return err
+		}
+		if len(volumes) > 1 && !operation.IsPerVolumeDestination(dest) {
+			return fmt.Errorf("destination must contain {volume} or end in '/' when backing up several volumes")
+		}
+	}
+	if len(volumes) > 1 && previous != "" {
+		return fmt.Errorf("--incremental-from applies to the backup of a single volume")
+	}
+
+	// All archives of one run share a timestamp
+	started := time.Now()
+	var results []backupResult
+	failed := 0
+	for _, volume := range volumes {
+		result := backupResult{volume: volume, err: ctx.Err()}
+		if result.err == nil {
+			begin := time.Now()
+			result.location, result.err = backupVolume(ctx, volume, dest, filter, keys, hooks, started)
+			result.elapsed = time.Since(begin)
+		}
+		if result.err != nil {
+			failed++
+			if len(volumes) > 1 {
+				log.Printf("ERROR: backup of volume '%s' failed: %v", volume, result.err)
+			}
+		}
+		results = append(results, result)
+	}
+
+	if len(volumes) == 1 {
+		return results[0].err
+	}
+	printSummary(results)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d volumes failed", failed, len(volumes))
+	}
+	return nil
+}
+
+// backupVolume backs up one volume and returns where it was stored.
+func backupVolume(ctx context.Context, volume, dest string, filter operation.PathFilter, keys *crypt.Keys, hooks operation.Hooks, started time.Time) (string, error) {
+	op, err := operation.NewBackup(ctx, volume, compress, filter, keys, progress)
+	if err != nil {
+		return "", err
+	}
+	op.SetContainerMode(containerMode())
+	op.SetHooks(hooks)
+	op.SetDump(dumpDB)
+	op.SetStrict(strict)
+	if previous != "" {
+		if err := op.IncrementalFrom(ctx, previous); err != nil {
+			return "", err
+		}
+	}
+
+	if repository != "" {
+		snapshot, err := op.BackupToRepository(ctx, repository)
+		if err != nil {
+			return "", err
+		}
+		return "snapshot " + snapshot.ID, nil
+	}
+	location, err := operation.ExpandDestination(dest, operation.DestinationVars{
+		Volume:      volume,
+		Compression: compress,
+		Encrypted:   keys.Encrypts(),
+		Time:        started,
+	})
+	if err != nil {
+		return "", err
+	}
+	return location, op.BackupTo(ctx, location)
+}
+
+// containerMode returns what backups do with the containers using a volume.
+func containerMode() operation.ContainerMode {
+	switch {
+	case stopCtrs:
+		return operation.StopContainers
+	case pauseCtrs:
+		return operation.PauseContainers
+	}
+	return operation.KeepContainers
+}
+
+// printSummary prints the outcome of every volume's backup.
+func printSummary(results []backupResult) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VOLUME\tSTATUS\tDURATION\tRESULT")
+	succeeded := 0
+	for _, r := range results {
+		status, detail := "ok", r.location
+		if r.err != nil {
+			status, detail = "failed", r.err.Error()
+		} else {
+			succeeded++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.volume, status, r.elapsed.Round(time.Second), detail)
+	}
+	tw.Flush()
+	fmt.Printf("Backed up %d of %d volumes\n", succeeded, len(results))
+}
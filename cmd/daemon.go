@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+
+	"docker-volume-backup/internal/config"
+	"docker-volume-backup/internal/daemon"
+	"docker-volume-backup/internal/job"
+)
+
+// runDaemon runs the scheduled jobs from the configuration file, or only the
+// named ones, until ctx is cancelled.
+func runDaemon(ctx context.Context, path, statePath string, names []string) error {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	jobs, err := cfg.Select(names...)
+	if err != nil {
+		return err
+	}
+
+	var state *daemon.State
+	if statePath != "" {
+		if state, err = daemon.LoadState(statePath); err != nil {
+			return err
+		}
+	}
+
+	d, err := daemon.New(jobs, func(ctx context.Context, j config.Job) error {
+		return job.Run(ctx, j, false)
+	}, state)
+	if err != nil {
+		return err
+	}
+	return d.Run(ctx)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"docker-volume-backup/internal/hook"
+	"docker-volume-backup/internal/operation"
+)
+
+// commandHooks returns the hooks given with --pre-hook, --post-hook and
+// --error-hook, run in --hook-container if it is set.
+func commandHooks() (operation.Hooks, error) {
+	var timeout time.Duration
+	if hookTime != "" {
+		var err error
+		if timeout, err = time.ParseDuration(hookTime); err != nil || timeout <= 0 {
+			return operation.Hooks{}, fmt.Errorf("invalid --hook-timeout '%s', expected a duration such as 30m", hookTime)
+		}
+	}
+	convert := func(commands []string) []hook.Hook {
+		var hooks []hook.Hook
+		for _, command := range commands {
+			hooks = append(hooks, hook.Hook{Command: command, Container: hookCtr, Timeout: timeout})
+		}
+		return hooks
+	}
+	return operation.Hooks{Pre: convert(preHooks), Post: convert(postHooks), OnError: convert(errHooks)}, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"docker-volume-backup/internal/operation"
+)
+
+// timeLayouts are accepted by --before and --after; times without a zone are local.
+var timeLayouts = []string{
+	time.RFC3339,
+	operation.TimestampFormat,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// parseTime parses a --before or --after value.
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected e.g. 2024-03-09 or 2024-03-09T15:04:05Z", value)
+}
+
+// runList prints the backups below location as a table or as JSON.
+func runList(ctx context.Context, location string, filter operation.ListFilter, jsonOutput bool) error {
+	backups, err := operation.ListBackups(ctx, location, filter)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if backups == nil {
+			backups = []operation.BackupInfo{}
+		}
+		return enc.Encode(backups)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CREATED\tVOLUME\tSIZE\tCOMPRESSION\tCHECKSUM\tENCRYPTED\tCHAIN\tKEY\tPARENT")
+	for _, b := range backups {
+		volume := b.Volume
+		if volume == "" {
+			volume = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			b.CreatedAt.Format("2006-01-02 15:04:05"), volume, formatSize(b.Size),
+			b.Compression, yesNo(b.Checksum), yesNo(b.Encrypted), chain(b), b.Key, parent(b))
+	}
+	return tw.Flush()
+}
+
+// chain describes what a restore of a backup applies: "full" for a full
+// archive, otherwise the number of archives or "broken" if a parent is missing.
+func chain(b operation.BackupInfo) string {
+	switch {
+	case b.Parent == "":
+		return "full"
+	case b.Chain == 0:
+		return "broken"
+	default:
+		return fmt.Sprintf("incr %d", b.Chain)
+	}
+}
+
+func parent(b operation.BackupInfo) string {
+	if b.Parent == "" {
+		return "-"
+	}
+	return b.Parent
+}
+
+// formatSize formats a byte count with binary units, e.g. 1.5 MiB.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
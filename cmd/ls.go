@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"docker-volume-backup/internal/crypt"
+	"docker-volume-backup/internal/operation"
+)
+
+// runLs prints the entries of the archive at src that match pattern, one path
+// per line, in the long format of `tar -tv`, or as JSON.
+func runLs(ctx context.Context, src, pattern string, keys *crypt.Keys, long, jsonOutput bool) error {
+	if jsonOutput {
+		entries := []operation.Entry{}
+		err := operation.ListContents(ctx, src, pattern, keys, func(e operation.Entry) error {
+			entries = append(entries, e)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	return operation.ListContents(ctx, src, pattern, keys, func(e operation.Entry) error {
+		name := e.Path
+		if e.Type == "dir" {
+			name += "/"
+		}
+		if !long {
+			_, err := fmt.Println(name)
+			return err
+		}
+		if e.Linkname != "" {
+			name += " -> " + e.Linkname
+		}
+		_, err := fmt.Printf("%s %-17s %10d %s %s\n", e.Mode, owner(e), e.Size, e.ModTime.Local().Format("2006-01-02 15:04"), name)
+		return err
+	})
+}
+
+// owner formats the owner of an entry as user/group, falling back to the numeric ids.
+func owner(e operation.Entry) string {
+	user, group := e.User, e.Group
+	if user == "" {
+		user = strconv.Itoa(e.UID)
+	}
+	if group == "" {
+		group = strconv.Itoa(e.GID)
+	}
+	return user + "/" + group
+}
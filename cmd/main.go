@@ -1,30 +1,180 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
+	"docker-volume-backup/internal/crypt"
 	"docker-volume-backup/internal/operation"
+	"docker-volume-backup/internal/retention"
+
+	// Storage backends register their URL schemes on import
+	_ "docker-volume-backup/internal/s3"
 )
 
 var (
-	progress  bool
-	compress  string
-	overwrite bool
+	progress   bool
+	compress   string
+	overwrite  bool
+	configPath string
+	statePath  string
+	dryRun     bool
+	keepWithin string
+	policy     retention.Policy
+	volumeName string
+	after      string
+	before     string
+	jsonOutput bool
+	long       bool
+	merge      bool
+	includes   stringList
+	excludes   stringList
+	keyOptions crypt.Options
+	previous   string
+	repository string
+	all        bool
+	labels     stringList
+	project    string
+	stopCtrs   bool
+	pauseCtrs  bool
+	preHooks   stringList
+	postHooks  stringList
+	errHooks   stringList
+	hookCtr    string
+	hookTime   string
+	dumpDB     bool
+	replayCtr  string
+	strict     bool
+	ownerOpts  operation.OwnerOptions
+	overrides  operation.VolumeOverrides
 )
 
+// stringList is a flag that can be repeated, collecting every value.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func usage() {
 	fmt.Println(`Usage:
-  docker-volume-backup backup [--progress] [--compress gz|zstd|none] <volume> <dest>
-  docker-volume-backup restore [--progress] [--overwrite] <src> <volume>
+  docker-volume-backup backup [--progress] [--compress gz|zstd|none] [--include <glob>]... [--exclude <glob>]...
+                              [--stop-containers|--pause-containers] [--pre-hook <cmd>]... [--post-hook <cmd>]...
+                              [--error-hook <cmd>]... [--hook-container <name>] [--hook-timeout <duration>]
+                              [--recipient <key>]... [--recipients-file <file>]... [--passphrase-file <file>]
+                              [--incremental-from <src> [--identity <file>]...] [--dump] [--strict]
+                              (<volume>... | --all | --label <key[=value]>...) <dest>
+  docker-volume-backup backup --repo <repo> [--progress] [--include <glob>]... [--exclude <glob>]... [--strict]
+                              (<volume>... | --all | --label <key[=value]>...)
+  docker-volume-backup backup --compose-project <name> [flags] <bundle-dest>
+  docker-volume-backup restore [--progress] [--overwrite|--merge] [--include <glob>]... [--exclude <glob>]...
+                               [--identity <file>]... [--passphrase-file <file>] [--pre-hook <cmd>]...
+                               [--post-hook <cmd>]... [--error-hook <cmd>]... [--hook-container <name>]
+                               [--volume-driver <name>] [--volume-opt <key=value>]... [--drop-volume-opt <key>]...
+                               [--volume-label <key=value>]... [--drop-volume-label <key>]... [--plain-volume]
+                               [--map-uid <from:to[:count]>]... [--map-gid <from:to[:count]>]... [--id-map <file>]
+                               [--chown <uid[:gid]>] [--strict] <src> <volume>
+  docker-volume-backup restore --repo <repo> [--progress] [--overwrite|--merge] [--include <glob>]...
+                               [--exclude <glob>]... [--map-uid ...]... [--map-gid ...]... [--id-map <file>]
+                               [--chown <uid[:gid]>] [--strict] <snapshot> <volume>
+  docker-volume-backup restore --compose-project <name> [flags] <bundle>
+  docker-volume-backup restore --replay-dump <container> [--identity <file>]... [--passphrase-file <file>] <src>
+  docker-volume-backup verify [--progress] [--identity <file>]... [--passphrase-file <file>] <src>
+  docker-volume-backup list [--volume <name>] [--after <time>] [--before <time>] [--json] <location>
+  docker-volume-backup ls [--long] [--json] [--identity <file>]... [--passphrase-file <file>] <src> [path-glob]
+  docker-volume-backup run --config <file> [--progress] [job...]
+  docker-volume-backup daemon --config <file> [--state <file>] [job...]
+  docker-volume-backup prune [--dry-run] [--keep-last N] [--keep-daily N] [--keep-weekly N]
+                             [--keep-monthly N] [--keep-yearly N] [--keep-within 30d] <location>
+  docker-volume-backup init <repo>
+  docker-volume-backup snapshots [--volume <name>] [--json] <repo>
+  docker-volume-backup forget <repo> <snapshot>...
+  docker-volume-backup gc [--dry-run] <repo>
 
 Flags:
-  --progress          Show progress bar during backup/restore
+  --progress          Show progress bar during backup/restore/verify
   --compress <type>   Compression type: none|gz|zstd (default: gz) [backup only]
-  --overwrite         Clear existing volume before restore [restore only]`)
+  --incremental-from <src>
+                      Only store the changes since a previous backup, which restores apply first [backup only]
+  --all               Back up every volume [backup only]
+  --label <selector>  Back up every volume with a label, key or key=value; repeatable, all must match [backup only]
+  --stop-containers   Stop the containers using a volume during its backup and start them again afterwards [backup only]
+  --pause-containers  Pause the containers using a volume during its backup and unpause them afterwards [backup only]
+  --pre-hook <cmd>    Run a command before each volume's backup or restore; a failure skips it, repeatable [backup/restore only]
+  --post-hook <cmd>   Run a command after each volume's backup or restore succeeded, repeatable [backup/restore only]
+  --error-hook <cmd>  Run a command when a hook, backup or restore failed, repeatable [backup/restore only]
+  --hook-container <name>
+                      Run the hooks in a running container with docker exec instead of the local shell [backup/restore only]
+  --hook-timeout <d>  Fail hooks that run longer than a duration (default: 10m) [backup/restore only]
+  --dump              Also store a dump of the Postgres, MySQL/MariaDB or MongoDB database running in a
+                      container using the volume, taken with the database's own dump tool [backup only]
+  --replay-dump <container>
+                      Replay the database dump stored with a backup into a running database container
+                      instead of restoring the volume [restore only]
+  --strict            Fail on the first file that is left out or stored differently, e.g. an unsupported
+                      entry type or a lost extended attribute, instead of warning; a restore also checks
+                      the restored files in the volume afterwards [backup/restore only]
+  --compose-project <name>
+                      Back up every volume of a Compose project to one bundle, or restore a bundle
+                      as the volumes of this project, which may differ from the original [backup/restore only]
+  --repo <repo>       Back up to or restore from a deduplicating repository created with init [backup/restore only]
+  --overwrite         Clear existing volume before restore [restore only]
+  --volume-driver <name>
+                      Create the volume with this driver instead of the backed up volume's [restore only]
+  --volume-opt <key=value>
+                      Set a driver option of the created volume, repeatable [restore only]
+  --drop-volume-opt <key>
+                      Leave out a driver option of the backed up volume, repeatable [restore only]
+  --volume-label <key=value>
+                      Set a label of the created volume, repeatable [restore only]
+  --drop-volume-label <key>
+                      Leave out a label of the backed up volume, repeatable [restore only]
+  --plain-volume      Create the volume with the default driver, ignoring the backed up volume's settings [restore only]
+  --map-uid <from:to[:count]>
+                      Restore files owned by a user ID, or by count IDs starting at it, with the ID(s)
+                      starting at to, e.g. 999:1001 or 0:100000:65536 for userns-remap; repeatable [restore only]
+  --map-gid <from:to[:count]>
+                      Like --map-uid, for group IDs [restore only]
+  --id-map <file>     Read more mappings from a file, one per line as "uid from:to[:count]" or
+                      "gid from:to[:count]" [restore only]
+  --chown <uid[:gid]> Restore every file with this owner and, if given, group [restore only]
+  --merge             Restore into an existing volume without clearing it [restore only]
+  --include <glob>    Only back up/restore matching paths, repeatable [backup/restore only]
+  --exclude <glob>    Do not back up/restore matching paths, repeatable [backup/restore only]
+  --recipient <key>   Encrypt for an age public key (age1...), repeatable [backup only]
+  --recipients-file <file>
+                      Encrypt for the age public keys in a file, repeatable [backup only]
+  --identity <file>   Decrypt with the age private keys in a file, repeatable; backups need it
+                      for the index of an encrypted --incremental-from archive [backup/restore/verify/ls only]
+  --passphrase-file <file>
+                      Encrypt or decrypt with the passphrase in a file [backup/restore/verify/ls only]
+  --config <file>     Job configuration file (.yaml, .yml or .toml) [run/daemon only]
+  --state <file>      File recording last runs, required for catch_up [daemon only]
+  --keep-<rule> <n>   Keep the newest n archives (last), or the newest archive of each of the
+                      n most recent days/weeks/months/years (daily|weekly|monthly|yearly) [prune only]
+  --keep-within <d>   Keep archives within a duration of the newest one, e.g. 30d or 36h [prune only]
+  --dry-run           Report what would be removed without removing anything [prune/gc only]
+  --volume <name>     Only list backups or snapshots of this volume [list/snapshots only]
+  --after <time>      Only list backups created after a time, e.g. 2024-03-09 or 2024-03-09T15:04:05Z [list only]
+  --before <time>     Only list backups created before a time [list only]
+  --json              Print JSON instead of a table or listing [list/ls/snapshots only]
+  --long              Show mode, owner, size and modification time [ls only]
+
+Environment:
+  BACKUP_AGE_RECIPIENTS  age public keys, separated by commas, if no recipient flag is given
+  BACKUP_AGE_IDENTITY    Contents of an age identity file, if no --identity flag is given
+  BACKUP_PASSPHRASE      Passphrase, if no --passphrase-file flag is given`)
 	os.Exit(1)
 }
 
@@ -35,6 +185,23 @@ func checkErr(err error, msg string) {
 	}
 }
 
+// signalContext returns a context that is cancelled on SIGINT or SIGTERM, so that
+// in-flight operations stop and clean up (or, in the daemon, scheduling stops and
+// running jobs are awaited). A second signal exits immediately.
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s, shutting down (repeat to exit immediately)", sig)
+		cancel()
+		<-signals
+		log.Fatalf("ERROR: interrupted again, exiting without cleanup")
+	}()
+	return ctx
+}
+
 func main() {
 	if len(os.Args) < 3 {
 		usage()
@@ -44,42 +211,253 @@ func main() {
 	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
 	fs.BoolVar(&progress, "progress", false, "show progress bar")
 	fs.StringVar(&compress, "compress", "gz", "compression type: none|gz|zstd")
+	fs.StringVar(&previous, "incremental-from", "", "only store the changes since this backup")
+	fs.StringVar(&repository, "repo", "", "back up to or restore from this repository")
+	fs.BoolVar(&all, "all", false, "back up every volume")
+	fs.Var(&labels, "label", "back up every volume with this label (key or key=value)")
+	fs.StringVar(&project, "compose-project", "", "back up or restore the volumes of this Compose project")
+	fs.BoolVar(&stopCtrs, "stop-containers", false, "stop the containers using a volume during its backup")
+	fs.BoolVar(&pauseCtrs, "pause-containers", false, "pause the containers using a volume during its backup")
+	fs.Var(&preHooks, "pre-hook", "run this command before the backup or restore")
+	fs.Var(&postHooks, "post-hook", "run this command after the backup or restore succeeded")
+	fs.Var(&errHooks, "error-hook", "run this command if the backup or restore failed")
+	fs.StringVar(&hookCtr, "hook-container", "", "run hooks in this container with docker exec")
+	fs.StringVar(&hookTime, "hook-timeout", "", "fail hooks that run longer than this duration")
+	fs.BoolVar(&dumpDB, "dump", false, "also store a dump of the database using the volume")
+	fs.StringVar(&replayCtr, "replay-dump", "", "replay the database dump of a backup into this container")
+	fs.BoolVar(&strict, "strict", false, "fail instead of warning when a file is left out or changed")
 	fs.BoolVar(&overwrite, "overwrite", false, "clear existing volume before restore")
+	fs.BoolVar(&merge, "merge", false, "restore into an existing volume without clearing it")
+	fs.StringVar(&overrides.Driver, "volume-driver", "", "create the volume with this driver")
+	fs.Var((*stringList)(&overrides.Options), "volume-opt", "set this driver option (key=value) of the created volume")
+	fs.Var((*stringList)(&overrides.DropOptions), "drop-volume-opt", "leave out this driver option of the backed up volume")
+	fs.Var((*stringList)(&overrides.Labels), "volume-label", "set this label (key=value) of the created volume")
+	fs.Var((*stringList)(&overrides.DropLabels), "drop-volume-label", "leave out this label of the backed up volume")
+	fs.BoolVar(&overrides.Plain, "plain-volume", false, "create the volume with the default driver")
+	fs.Var((*stringList)(&ownerOpts.UIDs), "map-uid", "restore files owned by a user ID (from:to[:count]) as another")
+	fs.Var((*stringList)(&ownerOpts.GIDs), "map-gid", "restore files owned by a group ID (from:to[:count]) as another")
+	fs.StringVar(&ownerOpts.File, "id-map", "", "file with uid and gid mappings, one per line")
+	fs.StringVar(&ownerOpts.Chown, "chown", "", "restore every file with this owner (uid[:gid])")
+	fs.Var(&includes, "include", "only back up or restore paths matching this glob")
+	fs.Var(&excludes, "exclude", "do not back up or restore paths matching this glob")
+	fs.Var((*stringList)(&keyOptions.Recipients), "recipient", "encrypt for this age public key")
+	fs.Var((*stringList)(&keyOptions.RecipientsFiles), "recipients-file", "encrypt for the age public keys in this file")
+	fs.Var((*stringList)(&keyOptions.IdentityFiles), "identity", "decrypt with the age private keys in this file")
+	fs.StringVar(&keyOptions.PassphraseFile, "passphrase-file", "", "encrypt or decrypt with the passphrase in this file")
+	fs.StringVar(&configPath, "config", "", "job configuration file")
+	fs.StringVar(&statePath, "state", "", "daemon state file")
+	fs.BoolVar(&dryRun, "dry-run", false, "report without removing archives")
+	fs.IntVar(&policy.Last, "keep-last", 0, "keep the newest n archives")
+	fs.IntVar(&policy.Daily, "keep-daily", 0, "keep one archive for each of the last n days")
+	fs.IntVar(&policy.Weekly, "keep-weekly", 0, "keep one archive for each of the last n weeks")
+	fs.IntVar(&policy.Monthly, "keep-monthly", 0, "keep one archive for each of the last n months")
+	fs.IntVar(&policy.Yearly, "keep-yearly", 0, "keep one archive for each of the last n years")
+	fs.StringVar(&keepWithin, "keep-within", "", "keep archives within a duration of the newest one")
+	fs.StringVar(&volumeName, "volume", "", "only list backups of this volume")
+	fs.StringVar(&after, "after", "", "only list backups created after this time")
+	fs.StringVar(&before, "before", "", "only list backups created before this time")
+	fs.BoolVar(&jsonOutput, "json", false, "print JSON")
+	fs.BoolVar(&long, "long", false, "show mode, owner, size and modification time")
 
 	// parse flags starting from second arg (after command)
 	fs.Parse(os.Args[2:])
 	args := fs.Args()
+	ctx := signalContext()
 
 	switch cmd {
 	case "backup":
-		if len(args) != 2 {
+		if stopCtrs && pauseCtrs {
+			checkErr(fmt.Errorf("--stop-containers and --pause-containers are mutually exclusive"), "Backup failed")
+		}
+		if project != "" {
+			if len(args) != 1 {
+				usage()
+			}
+			if all || len(labels) > 0 || repository != "" || previous != "" || dumpDB || strict {
+				checkErr(fmt.Errorf("--compose-project cannot be combined with --all, --label, --repo, --incremental-from, --dump or --strict"), "Backup failed")
+			}
+			keys, err := crypt.Load(keyOptions.FromEnv())
+			checkErr(err, "Backup failed")
+			hooks, err := commandHooks()
+			checkErr(err, "Backup failed")
+			filter := operation.PathFilter{Include: includes, Exclude: excludes}
+			// The hooks run once around the whole bundle
+			env := []string{"BACKUP_OPERATION=backup", "BACKUP_PROJECT=" + project}
+			checkErr(operation.RunHooks(ctx, hooks, env, func() error {
+				_, err := operation.BackupComposeProject(ctx, project, args[0], compress, filter, keys, containerMode(), progress)
+				return err
+			}), "Backup failed")
+			break
+		}
+
+		// The last argument is the destination, unless it is a repository
+		selector := operation.VolumeSelector{Names: args, Labels: labels, All: all}
+		dest := ""
+		if repository == "" {
+			if len(args) == 0 {
+				usage()
+			}
+			selector.Names, dest = args[:len(args)-1], args[len(args)-1]
+		}
+		if selector.IsEmpty() {
 			usage()
 		}
-		volume, dest := args[0], args[1]
-		op, err := operation.NewBackup(volume, compress, progress)
+		checkErr(selector.Validate(), "Backup failed")
+		volumes, err := operation.SelectVolumes(ctx, selector)
+		checkErr(err, "Backup failed")
+		keys, err := crypt.Load(keyOptions.FromEnv())
+		checkErr(err, "Backup failed")
+		hooks, err := commandHooks()
 		checkErr(err, "Backup failed")
+		filter := operation.PathFilter{Include: includes, Exclude: excludes}
 
-		if strings.HasPrefix(dest, "s3://") {
-			checkErr(op.BackupToS3(dest), "Backup failed")
-		} else {
-			checkErr(op.BackupToFile(dest), "Backup failed")
-		}
+		checkErr(runBackups(ctx, volumes, dest, filter, keys, hooks), "Backup failed")
 
 	case "restore":
-		if len(args) != 2 {
+		if replayCtr != "" {
+			if len(args) != 1 {
+				usage()
+			}
+			if project != "" || repository != "" || overwrite || merge || len(includes) > 0 || len(excludes) > 0 || !overrides.IsEmpty() {
+				checkErr(fmt.Errorf("--replay-dump cannot be combined with --compose-project, --repo, --overwrite, --merge, --include, --exclude or volume settings"), "Replaying dump failed")
+			}
+			keys, err := crypt.Load(keyOptions.FromEnv())
+			checkErr(err, "Replaying dump failed")
+			hooks, err := commandHooks()
+			checkErr(err, "Replaying dump failed")
+			env := []string{"BACKUP_OPERATION=restore", "BACKUP_CONTAINER=" + replayCtr}
+			checkErr(operation.RunHooks(ctx, hooks, env, func() error {
+				return operation.ReplayDump(ctx, args[0], replayCtr, keys)
+			}), "Replaying dump failed")
+			break
+		}
+		// A bundle is restored into a Compose project rather than a volume
+		if (project == "" && len(args) != 2) || (project != "" && len(args) != 1) {
 			usage()
 		}
+		if project != "" && (repository != "" || strict || !ownerOpts.IsEmpty()) {
+			checkErr(fmt.Errorf("--compose-project cannot be combined with --repo, --strict or owner mappings"), "Restore failed")
+		}
+		if overwrite && merge {
+			checkErr(fmt.Errorf("--overwrite and --merge are mutually exclusive"), "Restore failed")
+		}
+		checkErr(overrides.Validate(), "Restore failed")
+		owners, err := operation.LoadOwnerMap(ownerOpts)
+		checkErr(err, "Restore failed")
+		mode := operation.RestoreNew
+		if overwrite {
+			mode = operation.RestoreOverwrite
+		} else if merge {
+			mode = operation.RestoreMerge
+		}
+		keys, err := crypt.Load(keyOptions.FromEnv())
+		checkErr(err, "Restore failed")
+		hooks, err := commandHooks()
+		checkErr(err, "Restore failed")
+		filter := operation.PathFilter{Include: includes, Exclude: excludes}
+		if project != "" {
+			env := []string{"BACKUP_OPERATION=restore", "BACKUP_PROJECT=" + project}
+			checkErr(operation.RunHooks(ctx, hooks, env, func() error {
+				return operation.RestoreComposeProject(ctx, args[0], project, filter, keys, overrides, progress, mode)
+			}), "Restore failed")
+			break
+		}
 		src, volume := args[0], args[1]
-		op, err := operation.NewRestore(volume, progress)
+		op, err := operation.NewRestore(volume, filter, keys, progress)
 		checkErr(err, "Restore failed")
+		op.SetHooks(hooks)
+		op.SetVolumeOverrides(overrides)
+		op.SetStrict(strict)
+		op.SetOwnerMap(owners)
+
+		if repository != "" {
+			checkErr(op.RestoreFromRepository(ctx, repository, src, mode), "Restore failed")
+			break
+		}
+		checkErr(op.RestoreFrom(ctx, src, mode), "Restore failed")
+
+	case "verify":
+		if len(args) != 1 {
+			usage()
+		}
+		keys, err := crypt.Load(keyOptions.FromEnv())
+		checkErr(err, "Verify failed")
+		checkErr(operation.Verify(ctx, args[0], keys, progress), "Verify failed")
 
-		if strings.HasPrefix(src, "s3://") {
-			err := op.RestoreFromS3(src, overwrite)
-			checkErr(err, "Restore failed")
-		} else {
-			err := op.RestoreFromFile(src, overwrite)
-			checkErr(err, "Restore failed")
+	case "list":
+		if len(args) != 1 {
+			usage()
+		}
+		filter := operation.ListFilter{Volume: volumeName}
+		var err error
+		if after != "" {
+			filter.After, err = parseTime(after)
+			checkErr(err, "List failed")
+		}
+		if before != "" {
+			filter.Before, err = parseTime(before)
+			checkErr(err, "List failed")
+		}
+		checkErr(runList(ctx, args[0], filter, jsonOutput), "List failed")
+
+	case "ls":
+		if len(args) < 1 || len(args) > 2 {
+			usage()
+		}
+		pattern := ""
+		if len(args) == 2 {
+			pattern = args[1]
+		}
+		keys, err := crypt.Load(keyOptions.FromEnv())
+		checkErr(err, "Listing archive contents failed")
+		checkErr(runLs(ctx, args[0], pattern, keys, long, jsonOutput), "Listing archive contents failed")
+
+	case "run":
+		if configPath == "" {
+			usage()
+		}
+		checkErr(runJobs(ctx, configPath, args), "Run failed")
+
+	case "daemon":
+		if configPath == "" {
+			usage()
+		}
+		checkErr(runDaemon(ctx, configPath, statePath, args), "Daemon failed")
+
+	case "prune":
+		if len(args) != 1 {
+			usage()
+		}
+		if keepWithin != "" {
+			within, err := retention.ParseDuration(keepWithin)
+			checkErr(err, "Prune failed")
+			policy.Within = within
+		}
+		checkErr(runPrune(ctx, args[0], policy, dryRun), "Prune failed")
+
+	case "init":
+		if len(args) != 1 {
+			usage()
+		}
+		checkErr(runInit(ctx, args[0]), "Init failed")
+
+	case "snapshots":
+		if len(args) != 1 {
+			usage()
+		}
+		checkErr(runSnapshots(ctx, args[0], volumeName, jsonOutput), "Listing snapshots failed")
+
+	case "forget":
+		if len(args) < 2 {
+			usage()
+		}
+		checkErr(runForget(ctx, args[0], args[1:]), "Forget failed")
+
+	case "gc":
+		if len(args) != 1 {
+			usage()
 		}
+		checkErr(runGC(ctx, args[0], dryRun), "Garbage collection failed")
 	default:
 		usage()
 	}
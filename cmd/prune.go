@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"docker-volume-backup/internal/operation"
+	"docker-volume-backup/internal/retention"
+)
+
+// runPrune applies policy to the archives of each volume below location and prints what was kept and why.
+func runPrune(ctx context.Context, location string, policy retention.Policy, dryRun bool) error {
+	archives, err := operation.ListArchives(ctx, location)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Println("Dry run: no archives will be removed")
+	}
+
+	decisions, err := operation.Prune(ctx, archives, policy, dryRun)
+	var kept, removed int
+	var freed int64
+	for _, d := range decisions {
+		fmt.Println(d)
+		if d.Keep {
+			kept++
+		} else {
+			removed++
+			freed += d.Size
+		}
+	}
+
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+	fmt.Printf("Kept %d archives, %s %d (%d bytes)\n", kept, verb, removed, freed)
+	return err
+}
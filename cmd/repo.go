@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"docker-volume-backup/internal/repo"
+)
+
+// runInit creates a repository at location.
+func runInit(ctx context.Context, location string) error {
+	r, err := repo.Init(ctx, location)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created repository %s (chunks of %s on average)\n", location, formatSize(int64(r.Config.Chunker.Avg)))
+	return nil
+}
+
+// snapshotInfo is the JSON description of a snapshot, without its list of chunks.
+type snapshotInfo struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Volume    string    `json:"volume"`
+	Host      string    `json:"host"`
+	Size      int64     `json:"size"`
+	Chunks    int       `json:"chunks"`
+	NewChunks int       `json:"new_chunks"`
+	NewSize   int64     `json:"new_size"`
+}
+
+// runSnapshots prints the snapshots of the repository at location, newest
+// first, optionally only those of a volume.
+func runSnapshots(ctx context.Context, location, volume string, jsonOutput bool) error {
+	r, err := repo.Open(ctx, location)
+	if err != nil {
+		return err
+	}
+	snapshots, err := r.Snapshots(ctx)
+	if err != nil {
+		return err
+	}
+	infos := []snapshotInfo{}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		s := snapshots[i]
+		if volume != "" && s.Manifest.Volume.Name != volume {
+			continue
+		}
+		infos = append(infos, snapshotInfo{
+			ID:        s.ID,
+			CreatedAt: s.CreatedAt,
+			Volume:    s.Manifest.Volume.Name,
+			Host:      s.Manifest.Host,
+			Size:      s.Size,
+			Chunks:    len(s.Chunks),
+			NewChunks: s.NewChunks,
+			NewSize:   s.NewSize,
+		})
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(infos)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCREATED\tVOLUME\tHOST\tSIZE\tCHUNKS\tADDED")
+	for _, s := range infos {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			s.ID, s.CreatedAt.Local().Format("2006-01-02 15:04:05"), s.Volume, s.Host,
+			formatSize(s.Size), s.Chunks, formatSize(s.NewSize))
+	}
+	return tw.Flush()
+}
+
+// runForget removes snapshots from the repository at location.
+func runForget(ctx context.Context, location string, ids []string) error {
+	r, err := repo.Open(ctx, location)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := r.Forget(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runGC removes the chunks that no snapshot of the repository at location uses.
+func runGC(ctx context.Context, location string, dryRun bool) error {
+	r, err := repo.Open(ctx, location)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Println("Dry run: no chunks will be removed")
+	}
+	stats, err := r.GC(ctx, repo.GCGracePeriod, dryRun)
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+	fmt.Printf("%d snapshots use %d of %d chunks; %s %d unused chunks (%s)\n",
+		stats.Snapshots, stats.Chunks-stats.Unused, stats.Chunks, verb, stats.Removed, formatSize(stats.RemovedSize))
+	if stats.Recent > 0 {
+		fmt.Printf("Kept %d unused chunks written in the last %s\n", stats.Recent, repo.GCGracePeriod)
+	}
+	return err
+}
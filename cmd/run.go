@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"docker-volume-backup/internal/config"
+	"docker-volume-backup/internal/job"
+)
+
+// runJobs runs the named jobs from the configuration file, or all of them, one
+// after another. A failing job does not stop the remaining ones.
+func runJobs(ctx context.Context, path string, names []string) error {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	jobs, err := cfg.Select(names...)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, j := range jobs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := job.Run(ctx, j, progress); err != nil {
+			log.Printf("ERROR: job '%s' failed: %v", j.Name, err)
+			failed++
+			continue
+		}
+		log.Printf("Job '%s' completed", j.Name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d jobs failed", failed, len(jobs))
+	}
+	return nil
+}
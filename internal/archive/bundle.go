@@ -0,0 +1,121 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"docker-volume-backup/internal/storage"
+	"docker-volume-backup/internal/version"
+)
+
+// BundleVersion is the newest bundle manifest format this build reads and writes.
+const BundleVersion = 1
+
+// BundleManifestName is the name of the manifest inside a bundle. It is written
+// after every archive of the bundle, so a bundle without one is incomplete.
+const BundleManifestName = "bundle.json"
+
+// Bundle describes the archives of the volumes of a Docker Compose project,
+// stored together below one location.
+type Bundle struct {
+	Version     int       `json:"version"`
+	ToolVersion string    `json:"tool_version"`
+	CreatedAt   time.Time `json:"created_at"`
+	Host        string    `json:"host"`
+	Project     string    `json:"project"`
+	// Volumes by their name in the Compose file
+	Volumes map[string]BundleVolume `json:"volumes"`
+}
+
+// BundleVolume is one volume of a bundle.
+type BundleVolume struct {
+	// Name is the name of the Docker volume, usually "<project>_<volume>"
+	Name string `json:"name"`
+	// Archive is the name of the volume's archive, relative to the bundle
+	Archive string `json:"archive"`
+}
+
+// NewBundle returns an empty bundle for project created now on this host.
+func NewBundle(project string) *Bundle {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &Bundle{
+		Version:     BundleVersion,
+		ToolVersion: version.Version,
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+		Host:        host,
+		Project:     project,
+		Volumes:     map[string]BundleVolume{},
+	}
+}
+
+// LogicalNames returns the names of the bundle's volumes in the Compose file, sorted.
+func (b *Bundle) LogicalNames() []string {
+	names := make([]string, 0, len(b.Volumes))
+	for name := range b.Volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BundleKey returns the key of a file of the bundle at prefix.
+func BundleKey(prefix, name string) string {
+	return strings.TrimSuffix(prefix, "/") + "/" + name
+}
+
+// WriteBundle stores the bundle manifest at prefix.
+func WriteBundle(ctx context.Context, backend storage.Backend, prefix string, b *Bundle) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	w, err := backend.Create(ctx, BundleKey(prefix, BundleManifestName))
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		w.Abort()
+		return fmt.Errorf("failed to write bundle manifest: %w", err)
+	}
+	return w.Close()
+}
+
+// ReadBundle reads the bundle manifest at prefix and checks that its version is
+// supported and that its archives stay inside the bundle.
+func ReadBundle(ctx context.Context, backend storage.Backend, prefix string) (*Bundle, error) {
+	r, err := backend.Open(ctx, BundleKey(prefix, BundleManifestName))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle manifest: %w", err)
+	}
+
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to parse bundle manifest: %w", err)
+	}
+	if b.Version < 1 {
+		return nil, fmt.Errorf("failed to parse bundle manifest: missing version")
+	}
+	if b.Version > BundleVersion {
+		return nil, fmt.Errorf("bundle version %d is newer than the supported version %d, upgrade docker-volume-backup", b.Version, BundleVersion)
+	}
+	for name, v := range b.Volumes {
+		if v.Archive == "" || strings.ContainsAny(v.Archive, `/\`) || strings.HasPrefix(v.Archive, ".") {
+			return nil, fmt.Errorf("invalid archive name '%s' for volume '%s' in bundle manifest", v.Archive, name)
+		}
+	}
+	return &b, nil
+}
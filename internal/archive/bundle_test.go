@@ -0,0 +1,61 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"docker-volume-backup/internal/storage"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	prefix := filepath.Join(t.TempDir(), "shop-20240309T150405Z")
+	backend := storage.FileBackend{}
+
+	if _, err := ReadBundle(ctx, backend, prefix); !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("ReadBundle() on an empty location error = %v; want ErrNotExist", err)
+	}
+
+	b := NewBundle("shop")
+	b.Volumes["db"] = BundleVolume{Name: "shop_db", Archive: "db.tar.gz"}
+	b.Volumes["cache"] = BundleVolume{Name: "shop-cache", Archive: "cache.tar.gz"}
+	if err := WriteBundle(ctx, backend, prefix, b); err != nil {
+		t.Fatalf("WriteBundle() error: %v", err)
+	}
+	got, err := ReadBundle(ctx, backend, prefix+"/")
+	if err != nil {
+		t.Fatalf("ReadBundle() error: %v", err)
+	}
+	if got.Project != "shop" || got.Volumes["db"] != b.Volumes["db"] || !got.CreatedAt.Equal(b.CreatedAt) {
+		t.Errorf("ReadBundle() = %+v; want %+v", got, b)
+	}
+	if names := got.LogicalNames(); len(names) != 2 || names[0] != "cache" || names[1] != "db" {
+		t.Errorf("LogicalNames() = %v; want [cache db]", names)
+	}
+}
+
+func TestReadBundleRejects(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		manifest string
+	}{
+		{"newer version", `{"version": 99, "project": "shop"}`},
+		{"missing version", `{"project": "shop"}`},
+		{"archive outside bundle", `{"version": 1, "project": "shop", "volumes": {"db": {"name": "shop_db", "archive": "../db.tar.gz"}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, BundleManifestName), []byte(tt.manifest), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ReadBundle(ctx, storage.FileBackend{}, dir); err == nil {
+				t.Error("ReadBundle() expected error but got none")
+			}
+		})
+	}
+}
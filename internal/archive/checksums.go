@@ -0,0 +1,129 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"docker-volume-backup/internal/storage"
+)
+
+// ChecksumsPath is the name of the entry listing the SHA-256 of every regular
+// file in the archive, in sha256sum format. It is always the last entry, as the
+// digests are only known once the files have been written.
+const ChecksumsPath = ReservedDir + "/checksums.sha256"
+
+// ChecksumSuffix is appended to an archive's key to name its sha256sum-compatible sidecar.
+const ChecksumSuffix = ".sha256"
+
+// FileDigest is the SHA-256 of one file in the archive.
+type FileDigest struct {
+	Path   string
+	SHA256 string
+}
+
+// WriteChecksumsEntry writes the file digests as the last tar entry.
+func WriteChecksumsEntry(tw *tar.Writer, digests []FileDigest, modTime time.Time) error {
+	var buf bytes.Buffer
+	for _, d := range digests {
+		name := CleanPath(d.Path)
+		// Like sha256sum, escape names with a backslash or newline and mark the line
+		if strings.ContainsAny(name, "\\\n") {
+			buf.WriteString("\\")
+			name = nameEscaper.Replace(name)
+		}
+		fmt.Fprintf(&buf, "%s  %s\n", d.SHA256, name)
+	}
+	header := &tar.Header{
+		Name:     ChecksumsPath,
+		Typeflag: tar.TypeReg,
+		Mode:     0o644,
+		Size:     int64(buf.Len()),
+		ModTime:  modTime,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write checksums: %w", err)
+	}
+	if _, err := tw.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write checksums: %w", err)
+	}
+	return nil
+}
+
+// ParseChecksums reads sha256sum output into a map from path to digest.
+func ParseChecksums(r io.Reader) (map[string]string, error) {
+	digests := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, escaped := strings.CutPrefix(scanner.Text(), "\\")
+		sum, name, ok := strings.Cut(text, "  ")
+		if !ok || !isSHA256(sum) {
+			return nil, fmt.Errorf("failed to parse checksums: invalid line %d", line)
+		}
+		if escaped {
+			name = nameUnescaper.Replace(name)
+		}
+		digests[name] = sum
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse checksums: %w", err)
+	}
+	return digests, nil
+}
+
+// ChecksumKey returns the key of the checksum sidecar for an archive.
+func ChecksumKey(key string) string {
+	return key + ChecksumSuffix
+}
+
+// WriteChecksumSidecar stores the archive digest next to the archive in the
+// format of sha256sum, so that `sha256sum -c` can check a downloaded copy.
+func WriteChecksumSidecar(ctx context.Context, backend storage.Backend, key string, sum string) error {
+	w, err := backend.Create(ctx, ChecksumKey(key))
+	if err != nil {
+		return err
+	}
+	name := path.Base(filepath.ToSlash(key))
+	if _, err := fmt.Fprintf(w, "%s  %s\n", sum, name); err != nil {
+		w.Abort()
+		return fmt.Errorf("failed to write checksum sidecar: %w", err)
+	}
+	return w.Close()
+}
+
+// ReadChecksumSidecar returns the archive digest from its checksum sidecar. The
+// error matches storage.ErrNotExist when the archive has no sidecar.
+func ReadChecksumSidecar(ctx context.Context, backend storage.Backend, key string) (string, error) {
+	r, err := backend.Open(ctx, ChecksumKey(key))
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, 4096))
+	if err != nil {
+		return "", fmt.Errorf("failed to read checksum sidecar: %w", err)
+	}
+	sum, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(string(data)), "\\"), " ")
+	if !isSHA256(sum) {
+		return "", fmt.Errorf("invalid checksum sidecar %s", ChecksumKey(key))
+	}
+	return strings.ToLower(sum), nil
+}
+
+var (
+	nameEscaper   = strings.NewReplacer("\\", "\\\\", "\n", "\\n")
+	nameUnescaper = strings.NewReplacer("\\\\", "\\", "\\n", "\n")
+)
+
+func isSHA256(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 32
+}
@@ -0,0 +1,44 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChecksumsRoundTrip(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+	digests := []FileDigest{
+		{Path: "./a.txt", SHA256: sum},
+		{Path: "./two  spaces.txt", SHA256: sum},
+		{Path: "./new\nline\\.txt", SHA256: sum},
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := WriteChecksumsEntry(tw, digests, time.Now()); err != nil {
+		t.Fatalf("WriteChecksumsEntry() error: %v", err)
+	}
+	tw.Close()
+
+	tr := tar.NewReader(&buf)
+	header, err := tr.Next()
+	if err != nil || header.Name != ChecksumsPath {
+		t.Fatalf("First entry = %v, %v; want %s", header, err, ChecksumsPath)
+	}
+	parsed, err := ParseChecksums(tr)
+	if err != nil {
+		t.Fatalf("ParseChecksums() error: %v", err)
+	}
+	for _, name := range []string{"a.txt", "two  spaces.txt", "new\nline\\.txt"} {
+		if parsed[name] != sum {
+			t.Errorf("ParseChecksums()[%q] = %q; want %q", name, parsed[name], sum)
+		}
+	}
+
+	if _, err := ParseChecksums(strings.NewReader("nothex  a.txt\n")); err == nil {
+		t.Error("ParseChecksums() of an invalid line expected error but got none")
+	}
+}
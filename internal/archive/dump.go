@@ -0,0 +1,25 @@
+package archive
+
+// DumpSuffix is appended to an archive's key to name the database dump stored
+// next to it. The dump is compressed and encrypted like the archive.
+const DumpSuffix = ".dump"
+
+// DumpKey returns the key of the database dump of an archive.
+func DumpKey(key string) string {
+	return key + DumpSuffix
+}
+
+// Dump describes the logical dump of the database using the volume, taken
+// with the database's own dump tool before the volume was read.
+type Dump struct {
+	// Engine is the kind of database: postgres, mysql or mongo
+	Engine string `json:"engine"`
+	// Container and Image are the container the dump was taken in
+	Container string `json:"container"`
+	Image     string `json:"image,omitempty"`
+	// Size and SHA256 describe the dump as stored, UncompressedSize the output
+	// of the dump tool
+	Size             int64  `json:"size"`
+	UncompressedSize int64  `json:"uncompressed_size"`
+	SHA256           string `json:"sha256"`
+}
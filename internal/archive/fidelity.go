@@ -0,0 +1,164 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MaxFidelityIssues bounds the issues a fidelity report lists one by one.
+const MaxFidelityIssues = 100
+
+// xattrPrefix starts the PAX records that carry extended attributes, such as
+// "SCHILY.xattr.security.capability".
+const xattrPrefix = "SCHILY.xattr."
+
+// Entry kinds counted by a fidelity report.
+const (
+	KindFile     = "file"
+	KindDir      = "dir"
+	KindSymlink  = "symlink"
+	KindHardlink = "hardlink"
+	KindChar     = "char-device"
+	KindBlock    = "block-device"
+	KindFifo     = "fifo"
+)
+
+// EntryKind returns the kind of a tar entry, or "" for entry types that are
+// not part of the fidelity guarantee, such as sparse or continuation entries.
+func EntryKind(header *tar.Header) string {
+	switch header.Typeflag {
+	case tar.TypeReg:
+		return KindFile
+	case tar.TypeDir:
+		return KindDir
+	case tar.TypeSymlink:
+		return KindSymlink
+	case tar.TypeLink:
+		return KindHardlink
+	case tar.TypeChar:
+		return KindChar
+	case tar.TypeBlock:
+		return KindBlock
+	case tar.TypeFifo:
+		return KindFifo
+	}
+	return ""
+}
+
+// Xattrs returns the extended attributes of a tar entry by name.
+func Xattrs(header *tar.Header) map[string]string {
+	var xattrs map[string]string
+	for key, value := range header.PAXRecords {
+		if name, ok := strings.CutPrefix(key, xattrPrefix); ok {
+			if xattrs == nil {
+				xattrs = map[string]string{}
+			}
+			xattrs[name] = value
+		}
+	}
+	return xattrs
+}
+
+// Fidelity reports how completely the entries of a volume were backed up or
+// restored: the entries kept by kind and extended attribute, and every entry
+// that was left out or stored differently.
+type Fidelity struct {
+	// Entries counts the entries by kind, see EntryKind
+	Entries map[string]int64 `json:"entries"`
+	// Xattrs counts the entries carrying each extended attribute
+	Xattrs map[string]int64 `json:"xattrs,omitempty"`
+	// Issues lists the first MaxFidelityIssues entries that were dropped or changed
+	Issues []FidelityIssue `json:"issues,omitempty"`
+	// Dropped and Changed count all issues, also those beyond the list
+	Dropped int64 `json:"dropped,omitempty"`
+	Changed int64 `json:"changed,omitempty"`
+}
+
+// FidelityIssue is an entry that was dropped or changed, and why.
+type FidelityIssue struct {
+	Path string `json:"path"`
+	// Dropped is set if the entry was left out, otherwise it was changed
+	Dropped bool   `json:"dropped,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+func (i FidelityIssue) String() string {
+	if i.Dropped {
+		return fmt.Sprintf("dropped '%s': %s", i.Path, i.Reason)
+	}
+	return fmt.Sprintf("changed '%s': %s", i.Path, i.Reason)
+}
+
+// NewFidelity returns an empty report.
+func NewFidelity() *Fidelity {
+	return &Fidelity{Entries: map[string]int64{}}
+}
+
+// Add counts an entry that was kept.
+func (f *Fidelity) Add(header *tar.Header) {
+	if kind := EntryKind(header); kind != "" {
+		f.Entries[kind]++
+	}
+	for name := range Xattrs(header) {
+		if f.Xattrs == nil {
+			f.Xattrs = map[string]int64{}
+		}
+		f.Xattrs[name]++
+	}
+}
+
+// Report records an issue.
+func (f *Fidelity) Report(issue FidelityIssue) {
+	if issue.Dropped {
+		f.Dropped++
+	} else {
+		f.Changed++
+	}
+	if len(f.Issues) < MaxFidelityIssues {
+		f.Issues = append(f.Issues, issue)
+	}
+}
+
+// Lossless reports whether no entry was dropped or changed.
+func (f *Fidelity) Lossless() bool {
+	return f.Dropped == 0 && f.Changed == 0
+}
+
+// String summarizes the report on one line, e.g. "120 files, 8 dirs, 1
+// hardlink; 2 with security.capability; 1 dropped, 0 changed".
+func (f *Fidelity) String() string {
+	var kinds []string
+	for _, kind := range []string{KindFile, KindDir, KindSymlink, KindHardlink, KindChar, KindBlock, KindFifo} {
+		if n := f.Entries[kind]; n > 0 {
+			kinds = append(kinds, fmt.Sprintf("%d %s", n, plural(kind, n)))
+		}
+	}
+	if len(kinds) == 0 {
+		kinds = append(kinds, "no entries")
+	}
+	parts := []string{strings.Join(kinds, ", ")}
+
+	names := make([]string, 0, len(f.Xattrs))
+	for name := range f.Xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var xattrs []string
+	for _, name := range names {
+		xattrs = append(xattrs, fmt.Sprintf("%d with %s", f.Xattrs[name], name))
+	}
+	if len(xattrs) > 0 {
+		parts = append(parts, strings.Join(xattrs, ", "))
+	}
+	parts = append(parts, fmt.Sprintf("%d dropped, %d changed", f.Dropped, f.Changed))
+	return strings.Join(parts, "; ")
+}
+
+func plural(kind string, n int64) string {
+	if n == 1 {
+		return kind
+	}
+	return kind + "s"
+}
@@ -0,0 +1,49 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"testing"
+)
+
+func TestFidelity(t *testing.T) {
+	f := NewFidelity()
+	f.Add(&tar.Header{Name: "./bin/", Typeflag: tar.TypeDir})
+	f.Add(&tar.Header{Name: "./bin/ping", Typeflag: tar.TypeReg, PAXRecords: map[string]string{
+		"SCHILY.xattr.security.capability": "\x01\x00\x00\x02",
+		"SCHILY.xattr.user.origin":         "build",
+		"mtime":                            "1700000000.5",
+	}})
+	f.Add(&tar.Header{Name: "./bin/ping6", Typeflag: tar.TypeLink, Linkname: "./bin/ping"})
+	f.Add(&tar.Header{Name: "./run/fifo", Typeflag: tar.TypeFifo})
+	f.Add(&tar.Header{Name: "./data.db", Typeflag: tar.TypeReg})
+	if !f.Lossless() {
+		t.Error("Lossless() without issues = false")
+	}
+	want := "2 files, 1 dir, 1 hardlink, 1 fifo; 1 with security.capability, 1 with user.origin; 0 dropped, 0 changed"
+	if got := f.String(); got != want {
+		t.Errorf("String() = %q; want %q", got, want)
+	}
+
+	f.Report(FidelityIssue{Path: "./sock", Dropped: true, Reason: "unsupported entry type"})
+	f.Report(FidelityIssue{Path: "./copy", Reason: "stored as a copy"})
+	if f.Lossless() || f.Dropped != 1 || f.Changed != 1 {
+		t.Errorf("Report() counts = %d dropped, %d changed", f.Dropped, f.Changed)
+	}
+	if got := f.Issues[0].String(); got != "dropped './sock': unsupported entry type" {
+		t.Errorf("FidelityIssue.String() = %q", got)
+	}
+	if got := NewFidelity().String(); got != "no entries; 0 dropped, 0 changed" {
+		t.Errorf("String() of an empty report = %q", got)
+	}
+}
+
+func TestFidelityIssuesAreBounded(t *testing.T) {
+	f := NewFidelity()
+	for i := range MaxFidelityIssues + 10 {
+		f.Report(FidelityIssue{Path: fmt.Sprintf("./f%d", i), Dropped: true, Reason: "test"})
+	}
+	if len(f.Issues) != MaxFidelityIssues || f.Dropped != MaxFidelityIssues+10 {
+		t.Errorf("Report() kept %d issues and counted %d; want %d and %d", len(f.Issues), f.Dropped, MaxFidelityIssues, MaxFidelityIssues+10)
+	}
+}
@@ -0,0 +1,289 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"docker-volume-backup/internal/rw"
+	"docker-volume-backup/internal/storage"
+
+	"filippo.io/age"
+)
+
+// IndexPath is the name of the entry describing every path of the backed up
+// snapshot, which the next incremental backup compares the volume against.
+const IndexPath = ReservedDir + "/index.json"
+
+// DeletionsPath is the name of the entry listing the paths of the parent
+// snapshot that an incremental archive removes, one per line.
+const DeletionsPath = ReservedDir + "/deleted"
+
+// IndexSuffix is appended to an archive's key to name its index sidecar.
+const IndexSuffix = ".index"
+
+// IndexEntry describes one path of a snapshot as it was backed up.
+type IndexEntry struct {
+	Path     string    `json:"path"`
+	Typeflag byte      `json:"typeflag"`
+	Mode     int64     `json:"mode"`
+	UID      int       `json:"uid"`
+	GID      int       `json:"gid"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mtime"`
+	Linkname string    `json:"link,omitempty"`
+	// SHA256 of a regular file's content
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// NewIndexEntry describes the entry of header, a regular file with digest sum.
+func NewIndexEntry(header *tar.Header, sum string) IndexEntry {
+	return IndexEntry{
+		Path:     strings.TrimSuffix(CleanPath(header.Name), "/"),
+		Typeflag: header.Typeflag,
+		Mode:     header.Mode,
+		UID:      header.Uid,
+		GID:      header.Gid,
+		Size:     header.Size,
+		ModTime:  header.ModTime,
+		Linkname: header.Linkname,
+		SHA256:   sum,
+	}
+}
+
+// SameMetadata reports whether header describes the entry without changes to
+// its type, size, modification time, permissions or owner.
+func (e IndexEntry) SameMetadata(header *tar.Header) bool {
+	return e.Typeflag == header.Typeflag &&
+		e.Size == header.Size &&
+		e.ModTime.Equal(header.ModTime) &&
+		e.Mode == header.Mode &&
+		e.UID == header.Uid &&
+		e.GID == header.Gid &&
+		e.Linkname == header.Linkname
+}
+
+// Index describes every path of a snapshot, including the ones an incremental
+// archive did not store because they were unchanged.
+type Index struct {
+	Entries []IndexEntry `json:"entries"`
+
+	paths map[string]int
+}
+
+// Add records an entry, replacing an earlier one for the same path.
+func (x *Index) Add(e IndexEntry) {
+	if x.paths == nil {
+		x.reindex()
+	}
+	if i, ok := x.paths[e.Path]; ok {
+		x.Entries[i] = e
+		return
+	}
+	x.paths[e.Path] = len(x.Entries)
+	x.Entries = append(x.Entries, e)
+}
+
+// Get returns the entry for a path relative to the volume root.
+func (x *Index) Get(name string) (IndexEntry, bool) {
+	if x == nil {
+		return IndexEntry{}, false
+	}
+	if x.paths == nil {
+		x.reindex()
+	}
+	i, ok := x.paths[name]
+	if !ok {
+		return IndexEntry{}, false
+	}
+	return x.Entries[i], true
+}
+
+func (x *Index) reindex() {
+	x.paths = make(map[string]int, len(x.Entries))
+	for i, e := range x.Entries {
+		x.paths[e.Path] = i
+	}
+}
+
+// Deleted returns the paths of parent that are not in x, sorted.
+func (x *Index) Deleted(parent *Index) []string {
+	var deleted []string
+	for _, e := range parent.Entries {
+		if _, ok := x.Get(e.Path); !ok {
+			deleted = append(deleted, e.Path)
+		}
+	}
+	sort.Strings(deleted)
+	return deleted
+}
+
+// WriteIndexEntry writes the index as a tar entry.
+func WriteIndexEntry(tw *tar.Writer, x *Index, modTime time.Time) error {
+	data, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+	return writeEntry(tw, IndexPath, data, modTime)
+}
+
+// ParseIndex decodes an index entry or sidecar.
+func ParseIndex(r io.Reader) (*Index, error) {
+	var x Index
+	if err := json.NewDecoder(r).Decode(&x); err != nil {
+		return nil, fmt.Errorf("failed to parse index: %w", err)
+	}
+	return &x, nil
+}
+
+// WriteDeletionsEntry writes the paths removed since the parent snapshot as a tar entry.
+func WriteDeletionsEntry(tw *tar.Writer, deleted []string, modTime time.Time) error {
+	var buf bytes.Buffer
+	for _, name := range deleted {
+		buf.WriteString(nameEscaper.Replace(name))
+		buf.WriteByte('\n')
+	}
+	return writeEntry(tw, DeletionsPath, buf.Bytes(), modTime)
+}
+
+// ParseDeletions reads the entry written by WriteDeletionsEntry.
+func ParseDeletions(r io.Reader) ([]string, error) {
+	var deleted []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if scanner.Text() != "" {
+			deleted = append(deleted, nameUnescaper.Replace(scanner.Text()))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read deletions: %w", err)
+	}
+	return deleted, nil
+}
+
+func writeEntry(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0o644,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// IndexKey returns the key of the index sidecar for an archive.
+func IndexKey(key string) string {
+	return key + IndexSuffix
+}
+
+// WriteIndexSidecar stores the index next to the archive as gzipped JSON, so
+// that the next incremental backup does not have to download the archive. It
+// lists every file name, so it is encrypted for recipients if there are any.
+func WriteIndexSidecar(ctx context.Context, backend storage.Backend, key string, x *Index, recipients []age.Recipient) error {
+	w, err := backend.Create(ctx, IndexKey(key))
+	if err != nil {
+		return err
+	}
+	if err := writeIndex(w, x, recipients); err != nil {
+		w.Abort()
+		return fmt.Errorf("failed to write index sidecar: %w", err)
+	}
+	return w.Close()
+}
+
+func writeIndex(w io.Writer, x *Index, recipients []age.Recipient) error {
+	var encrypter io.WriteCloser
+	if len(recipients) > 0 {
+		var err error
+		if encrypter, err = rw.CreateEncryptWriter(w, recipients); err != nil {
+			return err
+		}
+		w = encrypter
+	}
+	gw := gzip.NewWriter(w)
+	if err := json.NewEncoder(gw).Encode(x); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	if encrypter != nil {
+		return encrypter.Close()
+	}
+	return nil
+}
+
+// ReadIndex returns the index of an archive from its sidecar, or else from the
+// index entry near the end of the archive, which means reading all of it. It
+// returns nil without an error for archives written before indexes were introduced.
+func ReadIndex(ctx context.Context, backend storage.Backend, key string, identities []age.Identity) (*Index, error) {
+	x, err := readIndexSidecar(ctx, backend, key, identities)
+	if !errors.Is(err, storage.ErrNotExist) {
+		return x, err
+	}
+
+	in, err := backend.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	decrypted, err := rw.CreateDecryptReader(in, identities)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := rw.CreateReader(decrypted, rw.TrimEncryptedSuffix(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create decompressed reader: %w", err)
+	}
+	defer reader.Close()
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar header: %w", err)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if CleanPath(header.Name) == IndexPath {
+			return ParseIndex(tr)
+		}
+	}
+}
+
+func readIndexSidecar(ctx context.Context, backend storage.Backend, key string, identities []age.Identity) (*Index, error) {
+	r, err := backend.Open(ctx, IndexKey(key))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	decrypted, err := rw.CreateDecryptReader(r, identities)
+	if err != nil {
+		return nil, err
+	}
+	gr, err := gzip.NewReader(decrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index sidecar: %w", err)
+	}
+	defer gr.Close()
+	return ParseIndex(gr)
+}
@@ -0,0 +1,106 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"docker-volume-backup/internal/storage"
+)
+
+func TestIndex(t *testing.T) {
+	mtime := time.Date(2024, 3, 9, 3, 0, 0, 0, time.UTC)
+	header := &tar.Header{Name: "./a.txt", Typeflag: tar.TypeReg, Mode: 0o644, Size: 5, ModTime: mtime}
+
+	var parent Index
+	parent.Add(NewIndexEntry(header, "sum"))
+	parent.Add(NewIndexEntry(&tar.Header{Name: "./dir/", Typeflag: tar.TypeDir, Mode: 0o755}, ""))
+	parent.Add(NewIndexEntry(&tar.Header{Name: "./dir/b.txt", Typeflag: tar.TypeReg}, "b"))
+
+	e, ok := parent.Get("a.txt")
+	if !ok || e.SHA256 != "sum" {
+		t.Fatalf("Get(a.txt) = %+v, %v", e, ok)
+	}
+	if !e.SameMetadata(header) {
+		t.Error("SameMetadata() of the indexed header = false")
+	}
+	changed := *header
+	changed.Uid = 1000
+	if e.SameMetadata(&changed) {
+		t.Error("SameMetadata() after a change of owner = true")
+	}
+
+	var current Index
+	current.Add(NewIndexEntry(header, "old"))
+	current.Add(NewIndexEntry(header, "new"))
+	if e, _ := current.Get("a.txt"); len(current.Entries) != 1 || e.SHA256 != "new" {
+		t.Errorf("Add() of a path twice = %+v; want the later entry only", current.Entries)
+	}
+	if deleted := strings.Join(current.Deleted(&parent), ","); deleted != "dir,dir/b.txt" {
+		t.Errorf("Deleted() = %s; want dir,dir/b.txt", deleted)
+	}
+	if _, ok := (*Index)(nil).Get("a.txt"); ok {
+		t.Error("Get() on a nil index found an entry")
+	}
+}
+
+func TestDeletionsRoundTrip(t *testing.T) {
+	deleted := []string{"a.txt", "new\nline.txt", "back\\slash"}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := WriteDeletionsEntry(tw, deleted, time.Now()); err != nil {
+		t.Fatalf("WriteDeletionsEntry() error: %v", err)
+	}
+	tw.Close()
+
+	tr := tar.NewReader(&buf)
+	if header, err := tr.Next(); err != nil || header.Name != DeletionsPath {
+		t.Fatalf("First entry = %v, %v; want %s", header, err, DeletionsPath)
+	}
+	parsed, err := ParseDeletions(tr)
+	if err != nil {
+		t.Fatalf("ParseDeletions() error: %v", err)
+	}
+	if strings.Join(parsed, "|") != strings.Join(deleted, "|") {
+		t.Errorf("ParseDeletions() = %q; want %q", parsed, deleted)
+	}
+}
+
+func TestIndexSidecar(t *testing.T) {
+	ctx := context.Background()
+	key := filepath.Join(t.TempDir(), "app.tar")
+	var x Index
+	x.Add(IndexEntry{Path: "a.txt", Typeflag: tar.TypeReg, SHA256: "sum"})
+
+	// Without the sidecar the index is read from the archive
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	WriteIndexEntry(tw, &x, time.Now())
+	tw.Close()
+	w, err := storage.FileBackend{}.Create(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(buf.Bytes())
+	w.Close()
+	read, err := ReadIndex(ctx, storage.FileBackend{}, key, nil)
+	if err != nil || read == nil || len(read.Entries) != 1 {
+		t.Fatalf("ReadIndex() of the embedded index = %+v, %v", read, err)
+	}
+
+	x.Add(IndexEntry{Path: "b.txt", Typeflag: tar.TypeReg})
+	if err := WriteIndexSidecar(ctx, storage.FileBackend{}, key, &x, nil); err != nil {
+		t.Fatalf("WriteIndexSidecar() error: %v", err)
+	}
+	read, err = ReadIndex(ctx, storage.FileBackend{}, key, nil)
+	if err != nil || read == nil || len(read.Entries) != 2 {
+		t.Fatalf("ReadIndex() of the sidecar = %+v, %v", read, err)
+	}
+	if e, ok := read.Get("b.txt"); !ok || e.Path != "b.txt" {
+		t.Errorf("Get(b.txt) of the read index = %+v, %v", e, ok)
+	}
+}
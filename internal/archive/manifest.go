@@ -0,0 +1,217 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"docker-volume-backup/internal/version"
+)
+
+// ManifestVersion is the newest manifest format this build reads and writes.
+// Readers refuse manifests with a newer version.
+const ManifestVersion = 2
+
+// Full archives are still written as version 1, which older readers restore
+// correctly. Incremental archives are version 2, as restoring one on its own
+// would leave out its unchanged files.
+const fullManifestVersion = 1
+
+// ReservedDir holds entries that describe the archive itself. It is never
+// restored into a volume.
+const ReservedDir = ".docker-volume-backup"
+
+// ManifestPath is the name of the manifest entry, always the first in the archive.
+const ManifestPath = ReservedDir + "/manifest.json"
+
+// Manifest describes where an archive came from. The copy embedded as the first
+// tar entry is written before the volume data, so it only holds provenance; the
+// sidecar copy written after the archive also carries the archive statistics.
+type Manifest struct {
+	Version     int       `json:"version"`
+	ToolVersion string    `json:"tool_version"`
+	CreatedAt   time.Time `json:"created_at"`
+	Host        string    `json:"host"`
+	Volume      Volume    `json:"volume"`
+	Compression string    `json:"compression"`
+	// Encryption scheme of the archive, empty if it is not encrypted
+	Encryption string `json:"encryption,omitempty"`
+	// Filters that left parts of the volume out of the archive
+	Filter *Filter `json:"filter,omitempty"`
+	// Parent is the archive an incremental archive is based on, nil for a full archive
+	Parent *Parent `json:"parent,omitempty"`
+	// Dump is the database dump stored next to the archive, nil if there is none
+	Dump *Dump `json:"dump,omitempty"`
+
+	// Statistics, only known once the archive has been written
+	Files            int64  `json:"files,omitempty"`
+	UncompressedSize int64  `json:"uncompressed_size,omitempty"`
+	Size             int64  `json:"size,omitempty"`
+	SHA256           string `json:"sha256,omitempty"`
+	// Files of the parent snapshot that an incremental archive left out as
+	// unchanged, or lists as deleted
+	Unchanged int64 `json:"unchanged,omitempty"`
+	Deleted   int64 `json:"deleted,omitempty"`
+	// Fidelity says which entries of the volume the archive holds completely
+	Fidelity *Fidelity `json:"fidelity,omitempty"`
+}
+
+// Parent identifies the archive an incremental archive is based on.
+type Parent struct {
+	// Location of the parent as given to the backup. Restores look for an
+	// archive of the same name next to the incremental archive first.
+	Location  string    `json:"location"`
+	CreatedAt time.Time `json:"created_at"`
+	SHA256    string    `json:"sha256,omitempty"`
+}
+
+// SetParent makes the manifest describe an incremental archive based on parent.
+func (m *Manifest) SetParent(parent Parent) {
+	m.Parent = &parent
+	m.Version = ManifestVersion
+}
+
+// Volume describes the backed up volume as reported by `docker volume inspect`.
+type Volume struct {
+	Name          string            `json:"name"`
+	Driver        string            `json:"driver,omitempty"`
+	DriverOptions map[string]string `json:"driver_options,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Scope         string            `json:"scope,omitempty"`
+	// OptionsRedacted is set in the sidecar of an encrypted archive, which
+	// lists the driver options without their values, as they may hold
+	// credentials; the embedded manifest has them
+	OptionsRedacted bool `json:"options_redacted,omitempty"`
+}
+
+// Filter records the rules that selected the backed up paths, so that a restore
+// can explain why a file is missing.
+type Filter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// Rules read from the .backupignore file at the volume root
+	Ignore []string `json:"ignore,omitempty"`
+}
+
+func (f *Filter) String() string {
+	var parts []string
+	if len(f.Include) > 0 {
+		parts = append(parts, "include "+strings.Join(f.Include, ", "))
+	}
+	if len(f.Exclude) > 0 {
+		parts = append(parts, "exclude "+strings.Join(f.Exclude, ", "))
+	}
+	if len(f.Ignore) > 0 {
+		parts = append(parts, ".backupignore "+strings.Join(f.Ignore, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// NewManifest returns a manifest for an archive of volume created now on this host.
+func NewManifest(volume Volume, compression string) *Manifest {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &Manifest{
+		Version:     fullManifestVersion,
+		ToolVersion: version.Version,
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+		Host:        host,
+		Volume:      volume,
+		Compression: compression,
+	}
+}
+
+// ParseManifest decodes a manifest and checks that its version is supported.
+func ParseManifest(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if m.Version < 1 {
+		return nil, fmt.Errorf("failed to parse manifest: missing version")
+	}
+	if m.Version > ManifestVersion {
+		return nil, fmt.Errorf("manifest version %d is newer than the supported version %d, upgrade docker-volume-backup", m.Version, ManifestVersion)
+	}
+	return &m, nil
+}
+
+// Provenance returns a one-line description of where the archive came from.
+func (m *Manifest) Provenance() string {
+	driver := m.Volume.Driver
+	if driver == "" {
+		driver = "unknown"
+	}
+	return fmt.Sprintf("volume '%s' (driver %s) backed up on %s at %s by docker-volume-backup %s",
+		m.Volume.Name, driver, m.Host, m.CreatedAt.Format(time.RFC3339), m.ToolVersion)
+}
+
+// WriteManifestEntry writes the manifest as a tar entry. It must be the first entry.
+func WriteManifestEntry(tw *tar.Writer, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{
+		Name:     ManifestPath,
+		Typeflag: tar.TypeReg,
+		Mode:     0o644,
+		Size:     int64(len(data)),
+		ModTime:  m.CreatedAt,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// ReadManifestEntry reads the first entry of a tar stream if it is the manifest.
+// It returns nil for archives without a manifest (written by older versions);
+// in that case the first header is returned so the caller can still process it.
+func ReadManifestEntry(tr *tar.Reader) (*Manifest, *tar.Header, error) {
+	header, err := tr.Next()
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read tar header: %w", err)
+	}
+	if CleanPath(header.Name) != ManifestPath {
+		return nil, header, nil
+	}
+
+	var buf bytes.Buffer
+	// Manifests are small; the limit protects against a corrupt size field
+	if _, err := io.Copy(&buf, io.LimitReader(tr, 1<<20)); err != nil {
+		return nil, nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	m, err := ParseManifest(buf.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, nil, nil
+}
+
+// CleanPath returns an entry name without the "./" or "/" prefix, as volume
+// archives from the Engine API name their entries "./path".
+func CleanPath(name string) string {
+	name = strings.TrimPrefix(name, "./")
+	return strings.TrimPrefix(name, "/")
+}
+
+// IsReserved reports whether a tar entry belongs to the archive metadata rather
+// than to the volume.
+func IsReserved(name string) bool {
+	name = strings.TrimSuffix(CleanPath(name), "/")
+	return name == ReservedDir || strings.HasPrefix(name, ReservedDir+"/")
+}
@@ -0,0 +1,106 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"docker-volume-backup/internal/rw"
+	"docker-volume-backup/internal/storage"
+)
+
+// writeArchive writes a gzip archive with the manifest followed by one file.
+func writeArchive(t *testing.T, path string, m *Manifest) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz, _ := rw.CreateWriter(&buf, "gz")
+	tw := tar.NewWriter(gz)
+	if err := WriteManifestEntry(tw, m); err != nil {
+		t.Fatalf("WriteManifestEntry() error: %v", err)
+	}
+	tw.WriteHeader(&tar.Header{Name: "./data.txt", Mode: 0o644, Size: 4})
+	tw.Write([]byte("data"))
+	tw.Close()
+	gz.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	key := filepath.Join(dir, "app.tar.gz")
+	backend := storage.FileBackend{}
+
+	m := NewManifest(Volume{Name: "app", Driver: "local", Labels: map[string]string{"tier": "db"}}, "gz")
+	writeArchive(t, key, m)
+
+	// Without a sidecar the manifest comes from the first entry
+	got, err := ReadManifest(ctx, backend, key, nil)
+	if err != nil {
+		t.Fatalf("ReadManifest() error: %v", err)
+	}
+	if got == nil || got.Volume.Name != "app" || got.Volume.Labels["tier"] != "db" || !got.CreatedAt.Equal(m.CreatedAt) {
+		t.Fatalf("ReadManifest() = %+v; want %+v", got, m)
+	}
+
+	// The sidecar takes precedence and carries the statistics
+	m.Files, m.SHA256 = 1, "abc"
+	if err := WriteSidecar(ctx, backend, key, m); err != nil {
+		t.Fatalf("WriteSidecar() error: %v", err)
+	}
+	got, err = ReadManifest(ctx, backend, key, nil)
+	if err != nil {
+		t.Fatalf("ReadManifest() error: %v", err)
+	}
+	if got.Files != 1 || got.SHA256 != "abc" {
+		t.Errorf("ReadManifest() = %+v; want sidecar statistics", got)
+	}
+}
+
+func TestReadManifestEntryWithoutManifest(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "./data.txt", Mode: 0o644})
+	tw.Close()
+
+	m, first, err := ReadManifestEntry(tar.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("ReadManifestEntry() error: %v", err)
+	}
+	if m != nil || first == nil || first.Name != "./data.txt" {
+		t.Errorf("ReadManifestEntry() = %v, %v; want no manifest and the first header", m, first)
+	}
+}
+
+func TestParseManifestVersion(t *testing.T) {
+	if _, err := ParseManifest([]byte(`{"version": 3}`)); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("ParseManifest(version 3) error = %v; want newer version error", err)
+	}
+	if _, err := ParseManifest([]byte(`{}`)); err == nil {
+		t.Error("ParseManifest() without version expected error but got none")
+	}
+}
+
+func TestIsReserved(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{".docker-volume-backup/manifest.json", true},
+		{"./.docker-volume-backup/", true},
+		{"/.docker-volume-backup", true},
+		{"./.docker-volume-backup-old/file", false},
+		{"./data/.docker-volume-backup/manifest.json", false},
+	}
+	for _, tt := range tests {
+		if got := IsReserved(tt.name); got != tt.expected {
+			t.Errorf("IsReserved(%q) = %v; want %v", tt.name, got, tt.expected)
+		}
+	}
+}
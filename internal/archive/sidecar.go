@@ -0,0 +1,116 @@
+package archive
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"docker-volume-backup/internal/rw"
+	"docker-volume-backup/internal/storage"
+
+	"filippo.io/age"
+)
+
+// SidecarSuffix is appended to an archive's key to name its sidecar manifest.
+const SidecarSuffix = ".manifest.json"
+
+// SidecarKey returns the key of the sidecar manifest for an archive.
+func SidecarKey(key string) string {
+	return key + SidecarSuffix
+}
+
+// SidecarOwner returns the key of the archive a sidecar belongs to, or false if
+// key does not name a sidecar.
+func SidecarOwner(key string) (string, bool) {
+	for _, suffix := range []string{SidecarSuffix, ChecksumSuffix, IndexSuffix, DumpSuffix} {
+		if owner, ok := strings.CutSuffix(key, suffix); ok {
+			return owner, true
+		}
+	}
+	return "", false
+}
+
+// WriteSidecar stores the manifest next to the archive, so that it can be read
+// without downloading the archive. The sidecar of an encrypted archive leaves
+// out the values of the volume's driver options.
+func WriteSidecar(ctx context.Context, backend storage.Backend, key string, m *Manifest) error {
+	if m.Encryption != "" && len(m.Volume.DriverOptions) > 0 {
+		redacted := *m
+		redacted.Volume.DriverOptions = make(map[string]string, len(m.Volume.DriverOptions))
+		for option := range m.Volume.DriverOptions {
+			redacted.Volume.DriverOptions[option] = ""
+		}
+		redacted.Volume.OptionsRedacted = true
+		m = &redacted
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	w, err := backend.Create(ctx, SidecarKey(key))
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		w.Abort()
+		return fmt.Errorf("failed to write manifest sidecar: %w", err)
+	}
+	return w.Close()
+}
+
+// ReadSidecar reads the sidecar manifest of an archive. The error matches
+// storage.ErrNotExist when the archive has no sidecar.
+func ReadSidecar(ctx context.Context, backend storage.Backend, key string) (*Manifest, error) {
+	r, err := backend.Open(ctx, SidecarKey(key))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest sidecar: %w", err)
+	}
+	return ParseManifest(data)
+}
+
+// ReadManifest returns the manifest of an archive, from its sidecar if there is
+// one and otherwise from the first entry of the archive, which is decrypted with
+// identities if necessary. It returns nil without an error for archives written
+// before manifests were introduced.
+func ReadManifest(ctx context.Context, backend storage.Backend, key string, identities []age.Identity) (*Manifest, error) {
+	m, err := ReadSidecar(ctx, backend, key)
+	if err == nil {
+		return m, nil
+	}
+	if !errors.Is(err, storage.ErrNotExist) {
+		return nil, err
+	}
+	return ReadEmbeddedManifest(ctx, backend, key, identities)
+}
+
+// ReadEmbeddedManifest returns the manifest stored as the first entry of an
+// archive, which only requires reading the start of it. Encrypted archives are
+// decrypted with identities and fail with rw.ErrEncrypted without any. It
+// returns nil without an error for archives written before manifests were introduced.
+func ReadEmbeddedManifest(ctx context.Context, backend storage.Backend, key string, identities []age.Identity) (*Manifest, error) {
+	in, err := backend.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	decrypted, err := rw.CreateDecryptReader(in, identities)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := rw.CreateReader(decrypted, rw.TrimEncryptedSuffix(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create decompressed reader: %w", err)
+	}
+	defer reader.Close()
+	m, _, err := ReadManifestEntry(tar.NewReader(reader))
+	return m, err
+}
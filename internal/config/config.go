@@ -0,0 +1,366 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"docker-volume-backup/internal/crypt"
+	"docker-volume-backup/internal/docker"
+	"docker-volume-backup/internal/hook"
+	"docker-volume-backup/internal/operation"
+	"docker-volume-backup/internal/retention"
+	"docker-volume-backup/internal/rw"
+	"docker-volume-backup/internal/schedule"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is a set of backup jobs, loaded from a YAML or TOML file.
+type Config struct {
+	Jobs []Job `yaml:"jobs" toml:"jobs"`
+}
+
+// Job backs up a set of volumes, selected by name and/or label, to a destination template.
+type Job struct {
+	Name string `yaml:"name" toml:"name"`
+	// Volumes lists volumes by name.
+	Volumes []string `yaml:"volumes" toml:"volumes"`
+	// Labels selects every volume carrying all of these labels ("key" or "key=value").
+	Labels []string `yaml:"labels" toml:"labels"`
+	// Destination is a template, see operation.ExpandDestination.
+	Destination string `yaml:"destination" toml:"destination"`
+	Compression string `yaml:"compression" toml:"compression"`
+	// Include and Exclude select the volume paths to back up, see operation.PathFilter.
+	Include    []string    `yaml:"include" toml:"include"`
+	Exclude    []string    `yaml:"exclude" toml:"exclude"`
+	Encryption *Encryption `yaml:"encryption" toml:"encryption"`
+	Retention  *Retention  `yaml:"retention" toml:"retention"`
+	Hooks      Hooks       `yaml:"hooks" toml:"hooks"`
+	// Containers is what happens to the containers using a volume during its
+	// backup: keep (the default), stop or pause, see operation.ParseContainerMode.
+	Containers string `yaml:"containers" toml:"containers"`
+	// Dump also stores a logical dump of the database running in a container
+	// using each volume, see operation.Backup.SetDump.
+	Dump bool `yaml:"dump" toml:"dump"`
+	// Strict fails a backup that leaves out or changes a file instead of
+	// warning, see operation.Backup.SetStrict.
+	Strict bool `yaml:"strict" toml:"strict"`
+	// Schedule is a cron expression used by the daemon, see schedule.Parse.
+	Schedule string `yaml:"schedule" toml:"schedule"`
+	// CatchUp runs the job once at daemon start if a scheduled run was missed.
+	CatchUp bool `yaml:"catch_up" toml:"catch_up"`
+}
+
+// Retention describes which archives of a job to keep.
+type Retention struct {
+	KeepLast    int    `yaml:"keep_last" toml:"keep_last"`
+	KeepDaily   int    `yaml:"keep_daily" toml:"keep_daily"`
+	KeepWeekly  int    `yaml:"keep_weekly" toml:"keep_weekly"`
+	KeepMonthly int    `yaml:"keep_monthly" toml:"keep_monthly"`
+	KeepYearly  int    `yaml:"keep_yearly" toml:"keep_yearly"`
+	KeepWithin  string `yaml:"keep_within" toml:"keep_within"`
+}
+
+// Policy converts the configured rules to a retention policy. It must only be
+// called on validated configurations.
+func (r *Retention) Policy() retention.Policy {
+	within, _ := retention.ParseDuration(r.KeepWithin)
+	return retention.Policy{
+		Last:    r.KeepLast,
+		Daily:   r.KeepDaily,
+		Weekly:  r.KeepWeekly,
+		Monthly: r.KeepMonthly,
+		Yearly:  r.KeepYearly,
+		Within:  within,
+	}
+}
+
+// Encryption names the keys a job's archives are encrypted for: age recipients
+// or a passphrase.
+type Encryption struct {
+	Recipients      []string `yaml:"recipients" toml:"recipients"`
+	RecipientsFiles []string `yaml:"recipients_files" toml:"recipients_files"`
+	Passphrase      string   `yaml:"passphrase" toml:"passphrase"`
+	PassphraseFile  string   `yaml:"passphrase_file" toml:"passphrase_file"`
+}
+
+// Options converts the configured keys to the options of crypt.Load.
+func (e *Encryption) Options() crypt.Options {
+	return crypt.Options{
+		Recipients:      e.Recipients,
+		RecipientsFiles: e.RecipientsFiles,
+		Passphrase:      e.Passphrase,
+		PassphraseFile:  e.PassphraseFile,
+	}
+}
+
+// Hooks are shell commands run around a job's backups, on the host or, if
+// Container is set, inside that container with docker exec. The commands are
+// not interpolated, the shell expands their variables from its environment.
+type Hooks struct {
+	// Pre runs before the first backup; a failure skips the job.
+	Pre []string `yaml:"pre" toml:"pre" interpolate:"-"`
+	// Post runs after all backups succeeded.
+	Post []string `yaml:"post" toml:"post" interpolate:"-"`
+	// OnError runs when a pre hook, a backup or a post hook failed.
+	OnError   []string `yaml:"on_error" toml:"on_error" interpolate:"-"`
+	Container string   `yaml:"container" toml:"container"`
+	// Timeout bounds each hook, e.g. "30m"; hook.DefaultTimeout if empty.
+	Timeout string `yaml:"timeout" toml:"timeout"`
+}
+
+// Commands converts the configured hooks to operation hooks. It must only be
+// called on validated configurations.
+func (h Hooks) Commands() operation.Hooks {
+	timeout, _ := time.ParseDuration(h.Timeout)
+	convert := func(commands []string) []hook.Hook {
+		var hooks []hook.Hook
+		for _, command := range commands {
+			hooks = append(hooks, hook.Hook{Command: command, Container: h.Container, Timeout: timeout})
+		}
+		return hooks
+	}
+	return operation.Hooks{Pre: convert(h.Pre), Post: convert(h.Post), OnError: convert(h.OnError)}
+}
+
+// ValidationError lists every problem found in a configuration file.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Load reads a configuration file. The format is chosen by extension:
+// .yaml/.yml for YAML and .toml for TOML.
+func Load(path string) (*Config, error) {
+	var format string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		format = "yaml"
+	case ".toml":
+		format = "toml"
+	default:
+		return nil, fmt.Errorf("unsupported config file extension '%s' (use .yaml, .yml or .toml)", filepath.Ext(path))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	cfg, err := Parse(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Parse decodes a "yaml" or "toml" document, expands ${VAR} references from the
+// environment, applies defaults and validates the result.
+func Parse(data []byte, format string) (*Config, error) {
+	var cfg Config
+	switch format {
+	case "yaml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to parse YAML: %w", err)
+		}
+	case "toml":
+		md, err := toml.Decode(string(data), &cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse TOML: %w", err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("failed to parse TOML: unknown field %s", undecoded[0])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config format '%s'", format)
+	}
+
+	if problems := interpolate(&cfg, os.LookupEnv); len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	for i := range cfg.Jobs {
+		if cfg.Jobs[i].Compression == "" {
+			cfg.Jobs[i].Compression = "gz"
+		}
+	}
+	if problems := cfg.validate(); len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return &cfg, nil
+}
+
+// Select returns the named jobs in the given order, or all jobs when no names are given.
+func (c *Config) Select(names ...string) ([]Job, error) {
+	if len(names) == 0 {
+		return c.Jobs, nil
+	}
+	jobs := make([]Job, 0, len(names))
+	for _, name := range names {
+		job, ok := c.job(name)
+		if !ok {
+			return nil, fmt.Errorf("job '%s' is not defined in the config", name)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (c *Config) job(name string) (Job, bool) {
+	for _, job := range c.Jobs {
+		if job.Name == name {
+			return job, true
+		}
+	}
+	return Job{}, false
+}
+
+var jobNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+func (c *Config) validate() []string {
+	if len(c.Jobs) == 0 {
+		return []string{"no jobs defined"}
+	}
+
+	var problems []string
+	seen := map[string]bool{}
+	for i, job := range c.Jobs {
+		where := fmt.Sprintf("jobs[%d]", i)
+		if job.Name != "" {
+			where = fmt.Sprintf("job '%s'", job.Name)
+		}
+		for _, problem := range job.validate() {
+			problems = append(problems, where+": "+problem)
+		}
+		if job.Name != "" && seen[job.Name] {
+			problems = append(problems, where+": name is used by more than one job")
+		}
+		seen[job.Name] = true
+	}
+	return problems
+}
+
+func (j *Job) validate() []string {
+	var problems []string
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if j.Name == "" {
+		add("name is required")
+	} else if !jobNamePattern.MatchString(j.Name) {
+		add("name must start with alphanumeric and contain only a-z, A-Z, 0-9, -, _, .")
+	}
+
+	if len(j.Volumes) == 0 && len(j.Labels) == 0 {
+		add("volumes or labels is required")
+	}
+	for _, volume := range j.Volumes {
+		if err := docker.ValidateVolumeName(volume); err != nil {
+			add("volumes: %v", err)
+		}
+	}
+	for _, label := range j.Labels {
+		if err := operation.ValidateLabelSelector(label); err != nil {
+			add("labels: %v", err)
+		}
+	}
+
+	if err := operation.ValidateDestination(j.Destination); err != nil {
+		add("destination: %v", err)
+	} else if (len(j.Volumes) > 1 || len(j.Labels) > 0) && !operation.IsPerVolumeDestination(j.Destination) {
+		add("destination: must contain {volume} or end in '/' when a job selects several volumes")
+	}
+
+	if _, err := rw.Extension(j.Compression); err != nil {
+		add("compression: '%s' is not supported (use gz, zstd or none)", j.Compression)
+	}
+	for _, pattern := range j.Include {
+		if err := operation.ValidatePathPattern(pattern); err != nil {
+			add("include: %v", err)
+		}
+	}
+	for _, pattern := range j.Exclude {
+		if err := operation.ValidatePathPattern(pattern); err != nil {
+			add("exclude: %v", err)
+		}
+	}
+
+	if _, err := operation.ParseContainerMode(j.Containers); err != nil {
+		add("containers: %v", err)
+	}
+
+	if e := j.Encryption; e != nil {
+		hasPassphrase := e.Passphrase != "" || e.PassphraseFile != ""
+		switch {
+		case len(e.Recipients) == 0 && len(e.RecipientsFiles) == 0 && !hasPassphrase:
+			add("encryption: recipients, recipients_files, passphrase or passphrase_file is required")
+		case hasPassphrase && (len(e.Recipients) > 0 || len(e.RecipientsFiles) > 0):
+			add("encryption: a passphrase cannot be combined with recipients")
+		case e.Passphrase != "" && e.PassphraseFile != "":
+			add("encryption: passphrase and passphrase_file are mutually exclusive")
+		}
+		// Files are read when the job runs, as they may not exist yet
+		if _, err := crypt.Load(crypt.Options{Recipients: e.Recipients}); err != nil {
+			add("encryption: %v", err)
+		}
+	}
+
+	if r := j.Retention; r != nil {
+		counts := []struct {
+			name string
+			n    int
+		}{
+			{"keep_last", r.KeepLast}, {"keep_daily", r.KeepDaily}, {"keep_weekly", r.KeepWeekly},
+			{"keep_monthly", r.KeepMonthly}, {"keep_yearly", r.KeepYearly},
+		}
+		for _, count := range counts {
+			if count.n < 0 {
+				add("retention: %s cannot be negative", count.name)
+			}
+		}
+		if r.KeepWithin != "" {
+			if _, err := retention.ParseDuration(r.KeepWithin); err != nil {
+				add("retention: keep_within: %v", err)
+			}
+		}
+		if r.KeepLast <= 0 && r.KeepDaily <= 0 && r.KeepWeekly <= 0 && r.KeepMonthly <= 0 && r.KeepYearly <= 0 && r.KeepWithin == "" {
+			add("retention: at least one keep rule is required")
+		}
+		if !operation.IsTimestampedDestination(j.Destination) {
+			add("retention: destination must contain {timestamp} or end in '/', otherwise every backup replaces the previous one")
+		}
+	}
+
+	if j.Schedule != "" {
+		if _, err := schedule.Parse(j.Schedule); err != nil {
+			add("schedule: %v", err)
+		}
+	} else if j.CatchUp {
+		add("catch_up: requires a schedule")
+	}
+
+	for _, command := range slices.Concat(j.Hooks.Pre, j.Hooks.Post, j.Hooks.OnError) {
+		if strings.TrimSpace(command) == "" {
+			add("hooks: command cannot be empty")
+		}
+	}
+	if j.Hooks.Timeout != "" {
+		if timeout, err := time.ParseDuration(j.Hooks.Timeout); err != nil || timeout <= 0 {
+			add("hooks: timeout: '%s' is not a positive duration such as 30m", j.Hooks.Timeout)
+		}
+	}
+	return problems
+}
@@ -0,0 +1,252 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const yamlConfig = `
+jobs:
+  - name: app
+    volumes: [app_data, app_uploads]
+    destination: s3://${BUCKET}/{host}/{volume}-{timestamp}{ext}
+    compression: zstd
+    containers: stop
+    dump: true
+    strict: true
+    retention:
+      keep_daily: 7
+      keep_within: 2w
+    hooks:
+      pre: ["echo $HOME ${1:-none} $$"]
+      on_error: ["notify"]
+      container: app-db-1
+      timeout: 30m
+    schedule: "0 3 * * *"
+    catch_up: true
+  - name: labelled
+    labels: ["backup=true"]
+    destination: ${BACKUP_DIR:-/backups}/
+`
+
+const tomlConfig = `
+[[jobs]]
+name = "app"
+volumes = ["app_data", "app_uploads"]
+destination = "s3://${BUCKET}/{host}/{volume}-{timestamp}{ext}"
+compression = "zstd"
+containers = "stop"
+dump = true
+strict = true
+schedule = "0 3 * * *"
+catch_up = true
+
+[jobs.retention]
+keep_daily = 7
+keep_within = "2w"
+
+[jobs.hooks]
+pre = ["echo $HOME ${1:-none} $$"]
+on_error = ["notify"]
+container = "app-db-1"
+timeout = "30m"
+
+[[jobs]]
+name = "labelled"
+labels = ["backup=true"]
+destination = "${BACKUP_DIR:-/backups}/"
+`
+
+func TestParse(t *testing.T) {
+	t.Setenv("BUCKET", "my-bucket")
+
+	expected := []Job{
+		{
+			Name:        "app",
+			Volumes:     []string{"app_data", "app_uploads"},
+			Destination: "s3://my-bucket/{host}/{volume}-{timestamp}{ext}",
+			Compression: "zstd",
+			Retention:   &Retention{KeepDaily: 7, KeepWithin: "2w"},
+			Hooks:       Hooks{Pre: []string{"echo $HOME ${1:-none} $$"}, OnError: []string{"notify"}, Container: "app-db-1", Timeout: "30m"},
+			Containers:  "stop",
+			Dump:        true,
+			Strict:      true,
+			Schedule:    "0 3 * * *",
+			CatchUp:     true,
+		},
+		{
+			Name:        "labelled",
+			Labels:      []string{"backup=true"},
+			Destination: "/backups/",
+			Compression: "gz",
+		},
+	}
+
+	for format, data := range map[string]string{"yaml": yamlConfig, "toml": tomlConfig} {
+		t.Run(format, func(t *testing.T) {
+			cfg, err := Parse([]byte(data), format)
+			if err != nil {
+				t.Fatalf("Parse() error: %v", err)
+			}
+			if !reflect.DeepEqual(cfg.Jobs, expected) {
+				t.Errorf("Parse() jobs = %+v; want %+v", cfg.Jobs, expected)
+			}
+			hooks := cfg.Jobs[0].Hooks.Commands()
+			if len(hooks.OnError) != 1 || hooks.OnError[0].Container != "app-db-1" || hooks.OnError[0].Timeout != 30*time.Minute {
+				t.Errorf("Hooks.Commands() = %+v; want on-error hook in app-db-1 with a 30m timeout", hooks)
+			}
+		})
+	}
+}
+
+func TestParseMissingEnv(t *testing.T) {
+	// t.Setenv restores the variable after the test
+	t.Setenv("BUCKET", "")
+	os.Unsetenv("BUCKET")
+
+	_, err := Parse([]byte(yamlConfig), "yaml")
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Parse() error = %v; want *ValidationError", err)
+	}
+	if len(verr.Problems) != 1 || verr.Problems[0] != "jobs[0].destination: environment variable BUCKET is not set" {
+		t.Errorf("Problems = %q", verr.Problems)
+	}
+}
+
+func TestParseLeavesHookCommands(t *testing.T) {
+	os.Unsetenv("DVB_TEST_UNSET")
+	doc := `
+jobs:
+  - name: app
+    volumes: [app_data]
+    destination: /backups/
+    hooks:
+      pre: ["pg_dump -U ${DVB_TEST_UNSET} > $HOME/db.sql"]
+      post: ["echo $$ done"]
+      container: ${DVB_TEST_UNSET:-app-db-1}
+`
+	cfg, err := Parse([]byte(doc), "yaml")
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	hooks := cfg.Jobs[0].Hooks
+	if hooks.Pre[0] != "pg_dump -U ${DVB_TEST_UNSET} > $HOME/db.sql" || hooks.Post[0] != "echo $$ done" {
+		t.Errorf("Hook commands = %q, %q; want them as written", hooks.Pre[0], hooks.Post[0])
+	}
+	if hooks.Container != "app-db-1" {
+		t.Errorf("Hook container = %q; want it interpolated", hooks.Container)
+	}
+}
+
+func TestParseValidation(t *testing.T) {
+	data := `
+jobs:
+  - volumes: [data]
+    destination: /backups/data.tar.gz
+  - name: web
+    labels: ["=x"]
+    destination: /backups/web.tar.gz
+    compression: lz4
+    exclude: ["cache/[a"]
+    containers: shutdown
+    encryption:
+      recipients: ["age1nope"]
+      passphrase: secret
+    retention:
+      keep_last: -1
+    catch_up: true
+  - name: web
+    volumes: ["bad/name"]
+    destination: /backups/{date}.tar
+    hooks:
+      post: [""]
+      timeout: soon
+    schedule: "0 25 * * *"
+`
+	_, err := Parse([]byte(data), "yaml")
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Parse() error = %v; want *ValidationError", err)
+	}
+
+	expected := []string{
+		"jobs[0]: name is required",
+		"job 'web': labels: invalid selector '=x'",
+		"job 'web': destination: must contain {volume}",
+		"job 'web': compression: 'lz4' is not supported",
+		"job 'web': exclude: invalid path pattern",
+		"job 'web': containers: 'shutdown' is not a container mode",
+		"job 'web': encryption: a passphrase cannot be combined with recipients",
+		"job 'web': encryption: invalid recipient",
+		"job 'web': retention: keep_last cannot be negative",
+		"job 'web': retention: at least one keep rule is required",
+		"job 'web': retention: destination must contain {timestamp}",
+		"job 'web': catch_up: requires a schedule",
+		"job 'web': volumes: invalid volume name 'bad/name'",
+		"job 'web': destination: unknown placeholder {date}",
+		"job 'web': schedule: invalid schedule '0 25 * * *'",
+		"job 'web': hooks: command cannot be empty",
+		"job 'web': hooks: timeout: 'soon' is not a positive duration",
+		"job 'web': name is used by more than one job",
+	}
+	if len(verr.Problems) != len(expected) {
+		t.Fatalf("Got %d problems; want %d:\n%s", len(verr.Problems), len(expected), verr.Error())
+	}
+	for i, want := range expected {
+		if !strings.HasPrefix(verr.Problems[i], want) {
+			t.Errorf("Problem %d = %q; want prefix %q", i, verr.Problems[i], want)
+		}
+	}
+}
+
+func TestParseUnknownField(t *testing.T) {
+	tests := []struct {
+		format string
+		data   string
+	}{
+		{"yaml", "jobs:\n  - name: app\n    volume: app_data\n"},
+		{"toml", "[[jobs]]\nname = \"app\"\nvolume = \"app_data\"\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data), tt.format)
+			if err == nil || !strings.Contains(err.Error(), "volume") {
+				t.Errorf("Parse() error = %v; want unknown field error", err)
+			}
+		})
+	}
+}
+
+func TestLoadAndSelect(t *testing.T) {
+	t.Setenv("BUCKET", "my-bucket")
+	path := filepath.Join(t.TempDir(), "jobs.yml")
+	if err := os.WriteFile(path, []byte(yamlConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+
+	jobs, err := cfg.Select("labelled", "app")
+	if err != nil {
+		t.Fatalf("Select() error: %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].Name != "labelled" || jobs[1].Name != "app" {
+		t.Errorf("Select() returned jobs in the wrong order: %+v", jobs)
+	}
+	if _, err := cfg.Select("missing"); err == nil {
+		t.Error("Select(missing) expected error but got none")
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "jobs.json")); err == nil {
+		t.Error("Load(jobs.json) expected unsupported extension error")
+	}
+}
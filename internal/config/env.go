@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// envPattern matches "$$" (a literal "$"), "${VAR}" and "${VAR:-default}".
+var envPattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces environment variable references in s. "${VAR}" must be set;
+// "${VAR:-default}" falls back to default when VAR is unset or empty.
+func expandEnv(s string, lookup func(string) (string, bool)) (string, error) {
+	var missing string
+	expanded := envPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+		groups := envPattern.FindStringSubmatch(match)
+		value, ok := lookup(groups[1])
+		if groups[2] != "" {
+			if value == "" {
+				return groups[3]
+			}
+			return value
+		}
+		if !ok && missing == "" {
+			missing = groups[1]
+		}
+		return value
+	})
+	if missing != "" {
+		return "", fmt.Errorf("environment variable %s is not set", missing)
+	}
+	return expanded, nil
+}
+
+// interpolate expands environment variables in every string value of the
+// configuration, after parsing, so that secrets cannot change the document
+// structure. Fields tagged `interpolate:"-"`, such as shell commands, are left
+// as written. It returns one problem per failing field, e.g. "jobs[0].destination: ...".
+func interpolate(cfg *Config, lookup func(string) (string, bool)) []string {
+	var problems []string
+	var walk func(v reflect.Value, path string)
+	walk = func(v reflect.Value, path string) {
+		switch v.Kind() {
+		case reflect.Pointer:
+			if !v.IsNil() {
+				walk(v.Elem(), path)
+			}
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				if v.Type().Field(i).Tag.Get("interpolate") == "-" {
+					continue
+				}
+				name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+				walk(v.Field(i), strings.TrimPrefix(path+"."+name, "."))
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				walk(v.Index(i), fmt.Sprintf("%s[%d]", path, i))
+			}
+		case reflect.Map:
+			for _, key := range v.MapKeys() {
+				elem := reflect.New(v.Type().Elem()).Elem()
+				elem.Set(v.MapIndex(key))
+				walk(elem, fmt.Sprintf("%s.%v", path, key))
+				v.SetMapIndex(key, elem)
+			}
+		case reflect.String:
+			expanded, err := expandEnv(v.String(), lookup)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+				return
+			}
+			v.SetString(expanded)
+		}
+	}
+	walk(reflect.ValueOf(cfg), "")
+	return problems
+}
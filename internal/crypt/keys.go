@@ -0,0 +1,150 @@
+// Package crypt loads the age recipients and identities used to encrypt and
+// decrypt archives.
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// Environment variables read when the corresponding option is not set.
+const (
+	// RecipientsEnv holds age public keys separated by commas or whitespace.
+	RecipientsEnv = "BACKUP_AGE_RECIPIENTS"
+	// IdentityEnv holds the contents of an age identity file.
+	IdentityEnv = "BACKUP_AGE_IDENTITY"
+	// PassphraseEnv holds a passphrase for scrypt encryption.
+	PassphraseEnv = "BACKUP_PASSPHRASE"
+)
+
+// Scheme is the encryption scheme recorded in manifests of encrypted archives.
+const Scheme = "age"
+
+// Options name the key material. Recipients encrypt, identities decrypt; a
+// passphrase does both.
+type Options struct {
+	// Recipients are age public keys ("age1...").
+	Recipients []string
+	// RecipientsFiles contain one public key per line, as written by age-keygen -y.
+	RecipientsFiles []string
+	// IdentityFiles contain private keys, as written by age-keygen.
+	IdentityFiles []string
+	// Identity holds private keys in the format of an identity file.
+	Identity       string
+	Passphrase     string
+	PassphraseFile string
+}
+
+// FromEnv returns the options with unset fields filled from the environment.
+func (o Options) FromEnv() Options {
+	if len(o.Recipients) == 0 && len(o.RecipientsFiles) == 0 {
+		o.Recipients = strings.FieldsFunc(os.Getenv(RecipientsEnv), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\n' || r == '\t'
+		})
+	}
+	if len(o.IdentityFiles) == 0 && o.Identity == "" {
+		o.Identity = os.Getenv(IdentityEnv)
+	}
+	if o.Passphrase == "" && o.PassphraseFile == "" {
+		o.Passphrase = os.Getenv(PassphraseEnv)
+	}
+	return o
+}
+
+// Keys are the parsed recipients and identities. A nil *Keys neither encrypts
+// nor decrypts.
+type Keys struct {
+	Recipients []age.Recipient
+	Identities []age.Identity
+}
+
+// Encrypts reports whether archives are encrypted with these keys.
+func (k *Keys) Encrypts() bool {
+	return k != nil && len(k.Recipients) > 0
+}
+
+// Decrypters returns the identities to decrypt archives with.
+func (k *Keys) Decrypters() []age.Identity {
+	if k == nil {
+		return nil
+	}
+	return k.Identities
+}
+
+// Load parses the key material named by the options. It returns nil if no keys
+// are configured.
+func Load(o Options) (*Keys, error) {
+	keys := &Keys{}
+
+	for _, recipient := range o.Recipients {
+		r, err := age.ParseX25519Recipient(strings.TrimSpace(recipient))
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %w", recipient, err)
+		}
+		keys.Recipients = append(keys.Recipients, r)
+	}
+	for _, path := range o.RecipientsFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read recipients file: %w", err)
+		}
+		recipients, err := age.ParseRecipients(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipients file %s: %w", path, err)
+		}
+		keys.Recipients = append(keys.Recipients, recipients...)
+	}
+
+	for _, path := range o.IdentityFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identity file: %w", err)
+		}
+		identities, err := age.ParseIdentities(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid identity file %s: %w", path, err)
+		}
+		keys.Identities = append(keys.Identities, identities...)
+	}
+	if o.Identity != "" {
+		identities, err := age.ParseIdentities(strings.NewReader(o.Identity))
+		if err != nil {
+			return nil, fmt.Errorf("invalid identity: %w", err)
+		}
+		keys.Identities = append(keys.Identities, identities...)
+	}
+
+	passphrase := o.Passphrase
+	if o.PassphraseFile != "" {
+		data, err := os.ReadFile(o.PassphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase file: %w", err)
+		}
+		passphrase = strings.TrimRight(string(data), "\r\n")
+	}
+	if passphrase != "" {
+		if len(keys.Recipients) > 0 {
+			return nil, errors.New("a passphrase cannot be combined with recipients")
+		}
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		keys.Recipients = append(keys.Recipients, recipient)
+		keys.Identities = append(keys.Identities, identity)
+	}
+
+	if len(keys.Recipients) == 0 && len(keys.Identities) == 0 {
+		return nil, nil
+	}
+	return keys, nil
+}
@@ -0,0 +1,62 @@
+package crypt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestLoad(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	recipientsFile := filepath.Join(dir, "recipients.txt")
+	os.WriteFile(recipientsFile, []byte("# backup key\n"+identity.Recipient().String()+"\n"), 0o644)
+	identityFile := filepath.Join(dir, "key.txt")
+	os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0o600)
+	passphraseFile := filepath.Join(dir, "passphrase")
+	os.WriteFile(passphraseFile, []byte("secret\n"), 0o600)
+
+	keys, err := Load(Options{})
+	if err != nil || keys != nil || keys.Encrypts() || keys.Decrypters() != nil {
+		t.Errorf("Load() without keys = %v, %v; want nil", keys, err)
+	}
+
+	keys, err = Load(Options{Recipients: []string{identity.Recipient().String()}, RecipientsFiles: []string{recipientsFile}})
+	if err != nil || len(keys.Recipients) != 2 || !keys.Encrypts() {
+		t.Errorf("Load() recipients = %v, %v; want 2 recipients", keys, err)
+	}
+	keys, err = Load(Options{IdentityFiles: []string{identityFile}, Identity: identity.String()})
+	if err != nil || len(keys.Identities) != 2 || keys.Encrypts() {
+		t.Errorf("Load() identities = %v, %v; want 2 identities that do not encrypt", keys, err)
+	}
+	keys, err = Load(Options{PassphraseFile: passphraseFile})
+	if err != nil || len(keys.Recipients) != 1 || len(keys.Identities) != 1 {
+		t.Errorf("Load() passphrase = %v, %v; want a recipient and an identity", keys, err)
+	}
+
+	if _, err := Load(Options{Recipients: []string{"age1invalid"}}); err == nil || !strings.Contains(err.Error(), "invalid recipient") {
+		t.Errorf("Load() invalid recipient error = %v", err)
+	}
+	if _, err := Load(Options{Recipients: []string{identity.Recipient().String()}, Passphrase: "secret"}); err == nil {
+		t.Error("Load() with recipients and a passphrase expected error but got none")
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(RecipientsEnv, "age1a, age1b")
+	t.Setenv(PassphraseEnv, "secret")
+	o := Options{}.FromEnv()
+	if len(o.Recipients) != 2 || o.Recipients[1] != "age1b" || o.Passphrase != "secret" {
+		t.Errorf("FromEnv() = %+v; want the recipients and passphrase from the environment", o)
+	}
+	o = Options{Recipients: []string{"age1c"}, PassphraseFile: "/run/secrets/passphrase"}.FromEnv()
+	if len(o.Recipients) != 1 || o.Passphrase != "" {
+		t.Errorf("FromEnv() = %+v; want flags to take precedence", o)
+	}
+}
@@ -0,0 +1,153 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"docker-volume-backup/internal/config"
+	"docker-volume-backup/internal/schedule"
+)
+
+// Runner runs a single job.
+type Runner func(ctx context.Context, job config.Job) error
+
+// Daemon runs jobs on their cron schedules until its context is cancelled.
+type Daemon struct {
+	jobs      []config.Job
+	schedules map[string]schedule.Schedule
+	run       Runner
+	state     *State
+
+	mu      sync.Mutex
+	running map[string]bool
+	runs    sync.WaitGroup
+}
+
+// New creates a daemon for the jobs that have a schedule; jobs without one are
+// skipped. state may be nil, in which case missed runs cannot be caught up.
+func New(jobs []config.Job, run Runner, state *State) (*Daemon, error) {
+	d := &Daemon{
+		schedules: map[string]schedule.Schedule{},
+		run:       run,
+		state:     state,
+		running:   map[string]bool{},
+	}
+	for _, job := range jobs {
+		if job.Schedule == "" {
+			log.Printf("Job '%s' has no schedule, skipping", job.Name)
+			continue
+		}
+		if job.CatchUp && state == nil {
+			return nil, fmt.Errorf("job '%s' uses catch_up, which requires a state file", job.Name)
+		}
+		s, err := schedule.Parse(job.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("job '%s': %w", job.Name, err)
+		}
+		d.jobs = append(d.jobs, job)
+		d.schedules[job.Name] = s
+	}
+	if len(d.jobs) == 0 {
+		return nil, fmt.Errorf("no jobs with a schedule")
+	}
+	return d, nil
+}
+
+// Run schedules the jobs until ctx is cancelled, then waits for running jobs to
+// finish. Jobs are not cancelled along with ctx, so a shutdown never leaves a
+// backup half-written.
+func (d *Daemon) Run(ctx context.Context) error {
+	jobCtx := context.WithoutCancel(ctx)
+
+	var loops sync.WaitGroup
+	for _, job := range d.jobs {
+		loops.Add(1)
+		go func() {
+			defer loops.Done()
+			d.loop(ctx, jobCtx, job)
+		}()
+	}
+	log.Printf("Scheduler started with %d jobs", len(d.jobs))
+
+	<-ctx.Done()
+	loops.Wait()
+	d.mu.Lock()
+	if n := len(d.running); n > 0 {
+		log.Printf("Shutting down, waiting for %d running jobs to finish", n)
+	}
+	d.mu.Unlock()
+	d.runs.Wait()
+	log.Printf("Scheduler stopped")
+	return nil
+}
+
+// loop triggers one job at its scheduled times until ctx is cancelled.
+func (d *Daemon) loop(ctx, jobCtx context.Context, job config.Job) {
+	s := d.schedules[job.Name]
+	now := time.Now()
+
+	if job.CatchUp {
+		if last, ok := d.state.LastRun(job.Name); ok {
+			if missed := s.Next(last); !missed.IsZero() && missed.Before(now) {
+				log.Printf("Job '%s' missed its run at %s, catching up", job.Name, missed.Format(time.RFC3339))
+				d.trigger(jobCtx, job)
+			}
+		}
+	}
+
+	for {
+		next := s.Next(now)
+		if next.IsZero() {
+			log.Printf("Job '%s' has no further scheduled runs", job.Name)
+			return
+		}
+		log.Printf("Job '%s' next run at %s", job.Name, next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		d.trigger(jobCtx, job)
+		now = time.Now()
+	}
+}
+
+// trigger starts a run of job in the background unless the previous run is still going.
+func (d *Daemon) trigger(ctx context.Context, job config.Job) {
+	d.mu.Lock()
+	if d.running[job.Name] {
+		d.mu.Unlock()
+		log.Printf("Job '%s' is still running, skipping this run", job.Name)
+		return
+	}
+	d.running[job.Name] = true
+	d.runs.Add(1)
+	d.mu.Unlock()
+
+	go func() {
+		defer d.runs.Done()
+		defer func() {
+			d.mu.Lock()
+			delete(d.running, job.Name)
+			d.mu.Unlock()
+		}()
+
+		started := time.Now()
+		if err := d.run(ctx, job); err != nil {
+			log.Printf("ERROR: job '%s' failed after %s: %v", job.Name, time.Since(started).Round(time.Second), err)
+		} else {
+			log.Printf("Job '%s' completed in %s", job.Name, time.Since(started).Round(time.Second))
+		}
+		if d.state != nil {
+			if err := d.state.Record(job.Name, started); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		}
+	}()
+}
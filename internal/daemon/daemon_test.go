@@ -0,0 +1,146 @@
+package daemon
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"docker-volume-backup/internal/config"
+	"docker-volume-backup/internal/schedule"
+)
+
+func TestDaemonSkipsOverlappingRuns(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	run := func(ctx context.Context, job config.Job) error {
+		calls.Add(1)
+		<-release
+		return nil
+	}
+
+	d, err := New([]config.Job{{Name: "slow", Schedule: "@every 1s"}}, run, nil)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	d.schedules["slow"] = schedule.Every(10 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+
+	// Many ticks pass while the first run is blocked
+	time.Sleep(100 * time.Millisecond)
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Job ran %d times while the first run was active; want 1", n)
+	}
+
+	// Shutdown waits for the running job
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("Run() returned while a job was still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run() did not return after the job finished")
+	}
+}
+
+func TestDaemonJobsSurviveShutdown(t *testing.T) {
+	var jobErr error
+	var once sync.Once
+	started := make(chan struct{})
+	run := func(ctx context.Context, job config.Job) error {
+		once.Do(func() { close(started) })
+		time.Sleep(50 * time.Millisecond)
+		jobErr = ctx.Err()
+		return nil
+	}
+
+	d, _ := New([]config.Job{{Name: "job", Schedule: "@every 1s"}}, run, nil)
+	d.schedules["job"] = schedule.Every(time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	// Run waits for the job, which must not see the cancellation
+	d.Run(ctx)
+
+	if jobErr != nil {
+		t.Errorf("Running job saw a cancelled context: %v", jobErr)
+	}
+}
+
+func TestDaemonCatchUp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	state, err := LoadState(path)
+	if err != nil {
+		t.Fatalf("LoadState() error: %v", err)
+	}
+	twoDaysAgo := time.Now().Add(-48 * time.Hour)
+	state.Record("missed", twoDaysAgo)
+	state.Record("no-catch-up", twoDaysAgo)
+	state.Record("recent", time.Now())
+
+	ran := make(chan string, 3)
+	run := func(ctx context.Context, job config.Job) error {
+		ran <- job.Name
+		return nil
+	}
+	jobs := []config.Job{
+		{Name: "missed", Schedule: "@daily", CatchUp: true},
+		{Name: "no-catch-up", Schedule: "@daily"},
+		{Name: "recent", Schedule: "@daily", CatchUp: true},
+	}
+	d, err := New(jobs, run, state)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+
+	select {
+	case name := <-ran:
+		if name != "missed" {
+			t.Errorf("Caught up job %q; want %q", name, "missed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Missed run was not caught up")
+	}
+	cancel()
+	<-done
+
+	if len(ran) != 0 {
+		t.Errorf("Unexpected extra run of %q", <-ran)
+	}
+
+	// The catch-up run was recorded and survives a reload
+	reloaded, err := LoadState(path)
+	if err != nil {
+		t.Fatalf("LoadState() error: %v", err)
+	}
+	if last, _ := reloaded.LastRun("missed"); !last.After(twoDaysAgo) {
+		t.Errorf("LastRun(missed) = %v; want the catch-up run", last)
+	}
+}
+
+func TestNewRequiresStateForCatchUp(t *testing.T) {
+	run := func(ctx context.Context, job config.Job) error { return nil }
+
+	if _, err := New([]config.Job{{Name: "job", Schedule: "@daily", CatchUp: true}}, run, nil); err == nil {
+		t.Error("New() with catch_up and no state expected error but got none")
+	}
+	if _, err := New([]config.Job{{Name: "manual"}}, run, nil); err == nil {
+		t.Error("New() without scheduled jobs expected error but got none")
+	}
+}
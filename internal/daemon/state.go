@@ -0,0 +1,76 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// State remembers when each job last started, so missed runs can be caught up
+// after the daemon was down. It is persisted as JSON.
+type State struct {
+	path     string
+	mu       sync.Mutex
+	LastRuns map[string]time.Time `json:"last_runs"`
+}
+
+// LoadState reads the state file at path. A missing file is an empty state.
+func LoadState(path string) (*State, error) {
+	s := &State{path: path, LastRuns: map[string]time.Time{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	if s.LastRuns == nil {
+		s.LastRuns = map[string]time.Time{}
+	}
+	return s, nil
+}
+
+// LastRun returns when the job last started.
+func (s *State) LastRun(job string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.LastRuns[job]
+	return t, ok
+}
+
+// Record stores the start time of a job run and saves the state file.
+func (s *State) Record(job string, started time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.LastRuns[job] = started
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so a crash never leaves a truncated state file
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	return nil
+}
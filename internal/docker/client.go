@@ -0,0 +1,264 @@
+package docker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrNotFound is matched by API errors for missing containers, volumes or images.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is matched by API errors for conflicting operations (e.g. a volume in use).
+	ErrConflict = errors.New("conflict")
+)
+
+// APIError is returned when the Docker Engine API answers with an error status.
+// Use errors.Is with ErrNotFound or ErrConflict to test for common cases.
+type APIError struct {
+	StatusCode int
+	Method     string
+	Path       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("docker API %s %s: %s (status %d)", e.Method, e.Path, e.Message, e.StatusCode)
+}
+
+// Is reports whether the API error matches one of the package sentinel errors.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
+// ConnectionError is returned when the Docker daemon cannot be reached.
+type ConnectionError struct {
+	Host string
+	Err  error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("cannot connect to the Docker daemon at %s: %v", e.Host, e.Err)
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// Client talks to the Docker Engine API over a unix socket, named pipe or TCP.
+type Client struct {
+	host    string
+	baseURL string
+	http    *http.Client
+}
+
+// NewClient creates a client for the given daemon address, using the same
+// formats as DOCKER_HOST (unix:///path, tcp://host:port, npipe:////./pipe/name).
+func NewClient(host string) (*Client, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host '%s': %w", host, err)
+	}
+
+	transport := &http.Transport{}
+	baseURL := "http://docker"
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		if socket == "" {
+			return nil, fmt.Errorf("invalid docker host '%s': missing socket path", host)
+		}
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+	case "npipe":
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialPipe(ctx, u.Path)
+		}
+	case "tcp", "http", "https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid docker host '%s': missing address", host)
+		}
+		tlsConfig, err := tlsConfigFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig != nil || u.Scheme == "https" {
+			transport.TLSClientConfig = tlsConfig
+			baseURL = "https://" + u.Host
+		} else {
+			baseURL = "http://" + u.Host
+		}
+	default:
+		return nil, fmt.Errorf("unsupported docker host scheme '%s'", u.Scheme)
+	}
+
+	return &Client{
+		host:    host,
+		baseURL: baseURL,
+		http:    &http.Client{Transport: transport},
+	}, nil
+}
+
+// NewClientFromEnv creates a client for DOCKER_HOST, falling back to the platform default socket.
+func NewClientFromEnv() (*Client, error) {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = defaultHost
+	}
+	return NewClient(host)
+}
+
+// tlsConfigFromEnv builds a TLS configuration from DOCKER_TLS_VERIFY and DOCKER_CERT_PATH,
+// returning nil when TLS is not requested.
+func tlsConfigFromEnv() (*tls.Config, error) {
+	certPath := os.Getenv("DOCKER_CERT_PATH")
+	verify := os.Getenv("DOCKER_TLS_VERIFY") != ""
+	if !verify && certPath == "" {
+		return nil, nil
+	}
+	if certPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate docker certificates: %w", err)
+		}
+		certPath = filepath.Join(home, ".docker")
+	}
+
+	cfg := &tls.Config{InsecureSkipVerify: !verify}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(certPath, "cert.pem"), filepath.Join(certPath, "key.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load docker client certificate: %w", err)
+	}
+	cfg.Certificates = []tls.Certificate{cert}
+
+	if verify {
+		ca, err := os.ReadFile(filepath.Join(certPath, "ca.pem"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read docker CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid docker CA certificate in %s", certPath)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultClient *Client
+	defaultErr    error
+)
+
+// Default returns the shared client configured from the environment.
+func Default() (*Client, error) {
+	defaultOnce.Do(func() {
+		defaultClient, defaultErr = NewClientFromEnv()
+	})
+	return defaultClient, defaultErr
+}
+
+// do sends a request to the API and converts error statuses into *APIError.
+// The caller must close the response body.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &ConnectionError{Host: c.host, Err: err}
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		return nil, &APIError{
+			StatusCode: resp.StatusCode,
+			Method:     method,
+			Path:       path,
+			Message:    readErrorMessage(resp.Body),
+		}
+	}
+	return resp, nil
+}
+
+// doJSON sends an optional JSON body and decodes an optional JSON response.
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = strings.NewReader(string(data))
+		contentType = "application/json"
+	}
+
+	resp, err := c.do(ctx, method, path, query, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode docker API response for %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// readErrorMessage extracts the message from a Docker API error body.
+func readErrorMessage(r io.Reader) string {
+	data, err := io.ReadAll(io.LimitReader(r, 64*1024))
+	if err != nil {
+		return err.Error()
+	}
+	var payload struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &payload) == nil && payload.Message != "" {
+		return payload.Message
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Ping checks that the daemon is reachable.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.doJSON(ctx, http.MethodGet, "/_ping", nil, nil, nil)
+}
@@ -0,0 +1,492 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newFakeDaemon serves handler on a unix socket and returns a client connected to it.
+func newFakeDaemon(t *testing.T, handler http.Handler) *Client {
+	t.Helper()
+
+	// Socket paths are limited to ~100 bytes, so avoid the long t.TempDir() path
+	dir, err := os.MkdirTemp("", "dvb")
+	if err != nil {
+		t.Fatalf("Failed to create socket dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on unix socket: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	client, err := NewClient("unix://" + socket)
+	if err != nil {
+		t.Fatalf("NewClient() error: %v", err)
+	}
+	return client
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+// writeFrame writes one frame of a multiplexed log stream.
+func writeFrame(w io.Writer, stream byte, data string) {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	w.Write(header)
+	io.WriteString(w, data)
+}
+
+func TestNewClient(t *testing.T) {
+	tests := []struct {
+		name      string
+		host      string
+		shouldErr bool
+	}{
+		{"unix socket", "unix:///var/run/docker.sock", false},
+		{"tcp", "tcp://127.0.0.1:2375", false},
+		{"unix without path", "unix://", true},
+		{"tcp without address", "tcp://", true},
+		{"unsupported scheme", "ssh://user@host", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClient(tt.host)
+			if tt.shouldErr && err == nil {
+				t.Errorf("NewClient(%q) expected error but got none", tt.host)
+			}
+			if !tt.shouldErr && err != nil {
+				t.Errorf("NewClient(%q) unexpected error: %v", tt.host, err)
+			}
+		})
+	}
+}
+
+func TestClientVolumeExists(t *testing.T) {
+	client := newFakeDaemon(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/volumes/present":
+			json.NewEncoder(w).Encode(map[string]string{"Name": "present"})
+		case "/volumes/missing":
+			writeError(w, http.StatusNotFound, "get missing: no such volume")
+		default:
+			writeError(w, http.StatusInternalServerError, "boom")
+		}
+	}))
+	ctx := context.Background()
+
+	exists, err := client.VolumeExists(ctx, "present")
+	if err != nil || !exists {
+		t.Errorf("VolumeExists(present) = %v, %v; want true, nil", exists, err)
+	}
+
+	exists, err = client.VolumeExists(ctx, "missing")
+	if err != nil || exists {
+		t.Errorf("VolumeExists(missing) = %v, %v; want false, nil", exists, err)
+	}
+
+	// Daemon failures must not be reported as a missing volume
+	_, err = client.VolumeExists(ctx, "broken")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("VolumeExists(broken) error = %v; want *APIError with status 500", err)
+	}
+	if apiErr.Message != "boom" {
+		t.Errorf("APIError.Message = %q; want %q", apiErr.Message, "boom")
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Error("500 error should not match ErrNotFound")
+	}
+}
+
+func TestListVolumes(t *testing.T) {
+	var filters string
+	client := newFakeDaemon(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filters = r.URL.Query().Get("filters")
+		json.NewEncoder(w).Encode(map[string]any{
+			"Volumes": []map[string]string{{"Name": "web_data"}, {"Name": "db_data"}},
+		})
+	}))
+
+	names, err := client.ListVolumes(context.Background(), []string{"backup=true"})
+	if err != nil {
+		t.Fatalf("ListVolumes() error: %v", err)
+	}
+	if strings.Join(names, ",") != "db_data,web_data" {
+		t.Errorf("ListVolumes() = %v; want sorted [db_data web_data]", names)
+	}
+	if filters != `{"label":["backup=true"]}` {
+		t.Errorf("filters = %s; want label filter", filters)
+	}
+}
+
+func TestInspectVolume(t *testing.T) {
+	client := newFakeDaemon(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/volumes/data" {
+			writeError(w, http.StatusNotFound, "get missing: no such volume")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"Name":    "data",
+			"Driver":  "local",
+			"Options": map[string]string{"type": "tmpfs", "device": "tmpfs"},
+			"Labels":  map[string]string{"app": "web"},
+			"Scope":   "local",
+		})
+	}))
+
+	v, err := client.InspectVolume(context.Background(), "data")
+	if err != nil {
+		t.Fatalf("InspectVolume() error: %v", err)
+	}
+	if v.Driver != "local" || v.Options["type"] != "tmpfs" || v.Labels["app"] != "web" {
+		t.Errorf("InspectVolume() = %+v; want local tmpfs volume labelled app=web", v)
+	}
+
+	if _, err := client.InspectVolume(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("InspectVolume(missing) error = %v; want ErrNotFound", err)
+	}
+}
+
+func TestCreateVolumeFrom(t *testing.T) {
+	var body map[string]any
+	client := newFakeDaemon(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/volumes/create" {
+			writeError(w, http.StatusNotFound, "unexpected request")
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "{}")
+	}))
+
+	spec := Volume{Name: "web_data", Labels: map[string]string{"com.docker.compose.project": "web"}}
+	if err := client.CreateVolumeFrom(context.Background(), spec); err != nil {
+		t.Fatalf("CreateVolumeFrom() error: %v", err)
+	}
+	labels, _ := body["Labels"].(map[string]any)
+	if body["Name"] != "web_data" || labels["com.docker.compose.project"] != "web" {
+		t.Errorf("request body = %v; want name and labels", body)
+	}
+	if _, ok := body["Driver"]; ok {
+		t.Errorf("request body = %v; want no driver for the default driver", body)
+	}
+}
+
+func TestConnectionError(t *testing.T) {
+	client, err := NewClient("unix:///nonexistent/docker.sock")
+	if err != nil {
+		t.Fatalf("NewClient() error: %v", err)
+	}
+	_, err = client.VolumeExists(context.Background(), "any")
+	var connErr *ConnectionError
+	if !errors.As(err, &connErr) {
+		t.Errorf("VolumeExists() error = %v; want *ConnectionError", err)
+	}
+}
+
+func TestCreateContainerPullsMissingImage(t *testing.T) {
+	var mu sync.Mutex
+	pulled := false
+	var created containerConfig
+
+	client := newFakeDaemon(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/containers/create":
+			if !pulled {
+				writeError(w, http.StatusNotFound, "No such image: alpine:latest")
+				return
+			}
+			json.NewDecoder(r.Body).Decode(&created)
+			json.NewEncoder(w).Encode(map[string]string{"Id": "abc123"})
+		case r.Method == http.MethodPost && r.URL.Path == "/images/create":
+			if r.URL.Query().Get("fromImage") != "alpine" || r.URL.Query().Get("tag") != "latest" {
+				writeError(w, http.StatusBadRequest, "unexpected image "+r.URL.RawQuery)
+				return
+			}
+			pulled = true
+			io.WriteString(w, `{"status":"Pulling from library/alpine"}`+"\n"+`{"status":"Downloaded newer image"}`+"\n")
+		default:
+			writeError(w, http.StatusNotFound, "unexpected request "+r.URL.Path)
+		}
+	}))
+
+	id, err := client.CreateContainer(context.Background(), "myvolume", true)
+	if err != nil {
+		t.Fatalf("CreateContainer() error: %v", err)
+	}
+	if id != "abc123" {
+		t.Errorf("CreateContainer() = %q; want %q", id, "abc123")
+	}
+	if len(created.HostConfig.Binds) != 1 || created.HostConfig.Binds[0] != "myvolume:/data:ro" {
+		t.Errorf("Binds = %v; want [myvolume:/data:ro]", created.HostConfig.Binds)
+	}
+}
+
+func TestStopAndStartContainers(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	client := newFakeDaemon(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/containers/json" {
+			if r.URL.Query().Get("filters") != `{"volume":["db_data"]}` {
+				writeError(w, http.StatusBadRequest, "unexpected filters "+r.URL.RawQuery)
+				return
+			}
+			json.NewEncoder(w).Encode([]map[string]any{
+				{"Id": "abc", "Names": []string{"/shop-db-1"}, "State": "running", "Labels": map[string]string{"app": "db"}},
+			})
+			return
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	ctx := context.Background()
+
+	containers, err := client.ListContainersUsingVolume(ctx, "db_data")
+	if err != nil {
+		t.Fatalf("ListContainersUsingVolume() error: %v", err)
+	}
+	if len(containers) != 1 || containers[0].Name() != "shop-db-1" || containers[0].Labels["app"] != "db" {
+		t.Fatalf("ListContainersUsingVolume() = %+v; want shop-db-1", containers)
+	}
+
+	if err := client.StopContainer(ctx, "abc", 90*time.Second); err != nil {
+		t.Fatalf("StopContainer() error: %v", err)
+	}
+	if err := client.StopContainer(ctx, "abc", -1); err != nil {
+		t.Fatalf("StopContainer() error: %v", err)
+	}
+	if err := client.StartContainer(ctx, "abc"); err != nil {
+		t.Fatalf("StartContainer() error: %v", err)
+	}
+	if err := client.PauseContainer(ctx, "abc"); err != nil {
+		t.Fatalf("PauseContainer() error: %v", err)
+	}
+	if err := client.UnpauseContainer(ctx, "abc"); err != nil {
+		t.Fatalf("UnpauseContainer() error: %v", err)
+	}
+	expected := []string{
+		"POST /containers/abc/stop?t=90",
+		"POST /containers/abc/stop?",
+		"POST /containers/abc/start?",
+		"POST /containers/abc/pause?",
+		"POST /containers/abc/unpause?",
+	}
+	if strings.Join(requests, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("requests = %q; want %q", requests, expected)
+	}
+}
+
+func TestExec(t *testing.T) {
+	var created map[string]any
+	client := newFakeDaemon(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/containers/shop-db-1/exec":
+			json.NewDecoder(r.Body).Decode(&created)
+			json.NewEncoder(w).Encode(map[string]string{"Id": "exec1"})
+		case r.Method == http.MethodPost && r.URL.Path == "/exec/exec1/start":
+			writeFrame(w, 1, "dumped\n")
+			writeFrame(w, 2, "warning\n")
+		case r.Method == http.MethodGet && r.URL.Path == "/exec/exec1/json":
+			json.NewEncoder(w).Encode(map[string]any{"Running": false, "ExitCode": 2})
+		default:
+			writeError(w, http.StatusNotFound, "unexpected request "+r.URL.Path)
+		}
+	}))
+
+	var output bytes.Buffer
+	code, err := client.Exec(context.Background(), "shop-db-1", []string{"sh", "-c", "pg_dumpall"}, []string{"A=b"}, &output)
+	if err != nil {
+		t.Fatalf("Exec() error: %v", err)
+	}
+	if code != 2 || output.String() != "dumped\nwarning\n" {
+		t.Errorf("Exec() = %d, %q; want 2 and both streams", code, output.String())
+	}
+	if cmd, _ := created["Cmd"].([]any); len(cmd) != 3 || cmd[2] != "pg_dumpall" || created["AttachStdout"] != true {
+		t.Errorf("exec config = %v", created)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if _, err := client.ExecStreams(context.Background(), "shop-db-1", []string{"true"}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("ExecStreams() error: %v", err)
+	}
+	if stdout.String() != "dumped\n" || stderr.String() != "warning\n" {
+		t.Errorf("ExecStreams() stdout %q, stderr %q; want the streams apart", stdout.String(), stderr.String())
+	}
+
+	if _, err := client.Exec(context.Background(), "missing", []string{"true"}, nil, &output); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Exec(missing) error = %v; want ErrNotFound", err)
+	}
+}
+
+func TestPullImageReportsStreamError(t *testing.T) {
+	client := newFakeDaemon(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"status":"Pulling"}`+"\n"+`{"error":"manifest unknown"}`+"\n")
+	}))
+
+	err := client.PullImage(context.Background(), "alpine:latest")
+	if err == nil || !strings.Contains(err.Error(), "manifest unknown") {
+		t.Errorf("PullImage() error = %v; want error mentioning manifest unknown", err)
+	}
+}
+
+func TestCopyFromAndToContainer(t *testing.T) {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	tw.WriteHeader(&tar.Header{Name: "./test.txt", Mode: 0644, Size: 5, Typeflag: tar.TypeReg})
+	tw.Write([]byte("hello"))
+	tw.Close()
+
+	var uploaded []byte
+	var uploadPath, contentType string
+	client := newFakeDaemon(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/containers/abc123/archive" {
+			writeError(w, http.StatusNotFound, "No such container")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if r.URL.Query().Get("path") != "/data/." {
+				writeError(w, http.StatusBadRequest, "unexpected path")
+				return
+			}
+			w.Header().Set("Content-Type", "application/x-tar")
+			w.Write(archive.Bytes())
+		case http.MethodPut:
+			uploadPath = r.URL.Query().Get("path")
+			contentType = r.Header.Get("Content-Type")
+			uploaded, _ = io.ReadAll(r.Body)
+		}
+	}))
+	ctx := context.Background()
+
+	rc, err := client.CopyFromContainer(ctx, "abc123", "/data/.")
+	if err != nil {
+		t.Fatalf("CopyFromContainer() error: %v", err)
+	}
+	tr := tar.NewReader(rc)
+	header, err := tr.Next()
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	data, _ := io.ReadAll(tr)
+	rc.Close()
+	if header.Name != "./test.txt" || string(data) != "hello" {
+		t.Errorf("Got entry %q with %q; want ./test.txt with hello", header.Name, data)
+	}
+
+	if err := client.CopyToContainer(ctx, "abc123", "/data/", bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("CopyToContainer() error: %v", err)
+	}
+	if uploadPath != "/data/" || contentType != "application/x-tar" {
+		t.Errorf("Upload path/content type = %q/%q; want /data/ and application/x-tar", uploadPath, contentType)
+	}
+	if !bytes.Equal(uploaded, archive.Bytes()) {
+		t.Error("Uploaded archive does not match")
+	}
+
+	_, err = client.CopyFromContainer(ctx, "missing", "/data/.")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("CopyFromContainer(missing) error = %v; want ErrNotFound", err)
+	}
+}
+
+// fakeRunDaemon emulates the create/start/wait/logs/delete cycle of runContainer.
+func fakeRunDaemon(t *testing.T, exitCode int, stdout, stderr string) (*Client, *[]string) {
+	var mu sync.Mutex
+	var calls []string
+	client := newFakeDaemon(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/containers/create":
+			json.NewEncoder(w).Encode(map[string]string{"Id": "run1"})
+		case r.URL.Path == "/containers/run1/start":
+			w.WriteHeader(http.StatusNoContent)
+		case r.URL.Path == "/containers/run1/wait":
+			json.NewEncoder(w).Encode(map[string]int{"StatusCode": exitCode})
+		case r.URL.Path == "/containers/run1/logs":
+			writeFrame(w, 1, stdout)
+			writeFrame(w, 2, stderr)
+		case r.Method == http.MethodDelete && r.URL.Path == "/containers/run1":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, http.StatusNotFound, "unexpected request "+r.URL.Path)
+		}
+	}))
+	return client, &calls
+}
+
+func TestGetVolumeSize(t *testing.T) {
+	client, calls := fakeRunDaemon(t, 0, "4096\n", "")
+
+	size, err := client.GetVolumeSize(context.Background(), "myvolume")
+	if err != nil {
+		t.Fatalf("GetVolumeSize() error: %v", err)
+	}
+	if size != 4096 {
+		t.Errorf("GetVolumeSize() = %d; want 4096", size)
+	}
+	if last := (*calls)[len(*calls)-1]; last != "DELETE /containers/run1" {
+		t.Errorf("Helper container was not removed, last call: %s", last)
+	}
+}
+
+func TestClearVolumeFailure(t *testing.T) {
+	client, calls := fakeRunDaemon(t, 1, "", "rm: permission denied")
+
+	err := client.ClearVolume(context.Background(), "myvolume")
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("ClearVolume() error = %v; want error with container output", err)
+	}
+	if last := (*calls)[len(*calls)-1]; last != "DELETE /containers/run1" {
+		t.Errorf("Helper container was not removed, last call: %s", last)
+	}
+}
+
+func TestDemuxStream(t *testing.T) {
+	var stream bytes.Buffer
+	writeFrame(&stream, 1, "out1 ")
+	writeFrame(&stream, 2, "err")
+	writeFrame(&stream, 1, "out2")
+
+	var stdout, stderr bytes.Buffer
+	if err := demuxStream(&stream, &stdout, &stderr); err != nil {
+		t.Fatalf("demuxStream() error: %v", err)
+	}
+	if stdout.String() != "out1 out2" || stderr.String() != "err" {
+		t.Errorf("demuxStream() stdout=%q stderr=%q", stdout.String(), stderr.String())
+	}
+}
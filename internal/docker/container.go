@@ -1,23 +1,397 @@
 package docker
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os/exec"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// CreateContainerWithVolume creates a temporary container with the volume mounted
-func CreateContainerWithVolume(volume string) (string, error) {
-	cmd := exec.Command("docker", "create", "-v", volume+":/data", "alpine", "true")
-	output, err := cmd.Output()
+// helperImage is the image used for temporary containers that mount a volume.
+const helperImage = "alpine:latest"
+
+// CleanupTimeout bounds cleanup calls that run after the caller's context was cancelled.
+const CleanupTimeout = 30 * time.Second
+
+// CleanupContext returns a context for cleanup that survives cancellation of
+// ctx, e.g. on SIGINT, bounded by CleanupTimeout.
+func CleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), CleanupTimeout)
+}
+
+// containerConfig is the subset of the container create request used by this tool.
+type containerConfig struct {
+	Image      string     `json:"Image"`
+	Cmd        []string   `json:"Cmd,omitempty"`
+	HostConfig hostConfig `json:"HostConfig"`
+}
+
+type hostConfig struct {
+	Binds []string `json:"Binds,omitempty"`
+}
+
+// CreateContainer creates a (stopped) helper container with the volume mounted at /data.
+// The helper image is pulled if it is not present locally.
+func (c *Client) CreateContainer(ctx context.Context, volume string, readOnly bool, cmd ...string) (string, error) {
+	bind := volume + ":/data"
+	if readOnly {
+		bind += ":ro"
+	}
+	if len(cmd) == 0 {
+		cmd = []string{"true"}
+	}
+	cfg := containerConfig{
+		Image:      helperImage,
+		Cmd:        cmd,
+		HostConfig: hostConfig{Binds: []string{bind}},
+	}
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err := c.doJSON(ctx, http.MethodPost, "/containers/create", nil, cfg, &created)
+	if errors.Is(err, ErrNotFound) {
+		if err := c.PullImage(ctx, helperImage); err != nil {
+			return "", err
+		}
+		err = c.doJSON(ctx, http.MethodPost, "/containers/create", nil, cfg, &created)
+	}
 	if err != nil {
 		return "", fmt.Errorf("failed to create container: %w", err)
 	}
-	return strings.TrimSpace(string(output)), nil
+	return created.ID, nil
+}
+
+// RemoveContainer force-removes a container. Volumes mounted by it are kept.
+func (c *Client) RemoveContainer(ctx context.Context, containerID string) error {
+	query := url.Values{"force": {"1"}}
+	if err := c.doJSON(ctx, http.MethodDelete, "/containers/"+containerID, query, nil, nil); err != nil {
+		return fmt.Errorf("failed to remove container %s: %w", containerID, err)
+	}
+	return nil
+}
+
+// Container is the subset of `docker ps` data that describes a running container.
+type Container struct {
+	ID    string   `json:"Id"`
+	Names []string `json:"Names"`
+	// State is "running" or "paused" for the containers listed here
+	State  string            `json:"State"`
+	Image  string            `json:"Image"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// Name returns the container's name, or its short ID if it has none.
+func (c Container) Name() string {
+	if len(c.Names) > 0 {
+		return strings.TrimPrefix(c.Names[0], "/")
+	}
+	if len(c.ID) > 12 {
+		return c.ID[:12]
+	}
+	return c.ID
+}
+
+// ListContainersUsingVolume returns the running and paused containers that mount volume.
+func (c *Client) ListContainersUsingVolume(ctx context.Context, volume string) ([]Container, error) {
+	filters, err := json.Marshal(map[string][]string{"volume": {volume}})
+	if err != nil {
+		return nil, err
+	}
+	var containers []Container
+	if err := c.doJSON(ctx, http.MethodGet, "/containers/json", url.Values{"filters": {string(filters)}}, nil, &containers); err != nil {
+		return nil, fmt.Errorf("failed to list containers using volume '%s': %w", volume, err)
+	}
+	return containers, nil
+}
+
+// StopContainer stops a container, killing it if it has not stopped after
+// timeout. A negative timeout uses the container's own stop timeout.
+func (c *Client) StopContainer(ctx context.Context, containerID string, timeout time.Duration) error {
+	var query url.Values
+	if timeout >= 0 {
+		query = url.Values{"t": {strconv.Itoa(int(timeout.Seconds()))}}
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/containers/"+containerID+"/stop", query, nil, nil); err != nil {
+		return fmt.Errorf("failed to stop container %s: %w", containerID, err)
+	}
+	return nil
+}
+
+// StartContainer starts a stopped container.
+func (c *Client) StartContainer(ctx context.Context, containerID string) error {
+	if err := c.doJSON(ctx, http.MethodPost, "/containers/"+containerID+"/start", nil, nil, nil); err != nil {
+		return fmt.Errorf("failed to start container %s: %w", containerID, err)
+	}
+	return nil
+}
+
+// PauseContainer suspends all processes of a container.
+func (c *Client) PauseContainer(ctx context.Context, containerID string) error {
+	if err := c.doJSON(ctx, http.MethodPost, "/containers/"+containerID+"/pause", nil, nil, nil); err != nil {
+		return fmt.Errorf("failed to pause container %s: %w", containerID, err)
+	}
+	return nil
+}
+
+// UnpauseContainer resumes the processes of a paused container.
+func (c *Client) UnpauseContainer(ctx context.Context, containerID string) error {
+	if err := c.doJSON(ctx, http.MethodPost, "/containers/"+containerID+"/unpause", nil, nil, nil); err != nil {
+		return fmt.Errorf("failed to unpause container %s: %w", containerID, err)
+	}
+	return nil
+}
+
+// Exec runs cmd in a running container, like `docker exec`, with env added to
+// its environment. The combined stdout and stderr are written to output, and
+// the exit code of cmd is returned.
+func (c *Client) Exec(ctx context.Context, container string, cmd []string, env []string, output io.Writer) (int, error) {
+	return c.ExecStreams(ctx, container, cmd, env, output, output)
+}
+
+// ExecStreams runs cmd like Exec, but writes its stdout and stderr to separate writers.
+func (c *Client) ExecStreams(ctx context.Context, container string, cmd []string, env []string, stdout, stderr io.Writer) (int, error) {
+	cfg := struct {
+		Cmd          []string
+		Env          []string `json:",omitempty"`
+		AttachStdout bool
+		AttachStderr bool
+	}{cmd, env, true, true}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/containers/"+url.PathEscape(container)+"/exec", nil, cfg, &created); err != nil {
+		return 0, fmt.Errorf("failed to create exec in container '%s': %w", container, err)
+	}
+
+	// Without a TTY the output is multiplexed; the stream ends with the command
+	body := strings.NewReader(`{"Detach":false,"Tty":false}`)
+	resp, err := c.do(ctx, http.MethodPost, "/exec/"+created.ID+"/start", nil, body, "application/json")
+	if err != nil {
+		return 0, fmt.Errorf("failed to start exec in container '%s': %w", container, err)
+	}
+	defer resp.Body.Close()
+	if err := demuxStream(resp.Body, stdout, stderr); err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("failed to read exec output: %w", err)
+	}
+
+	var result struct {
+		Running  bool `json:"Running"`
+		ExitCode int  `json:"ExitCode"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/exec/"+created.ID+"/json", nil, nil, &result); err != nil {
+		return 0, fmt.Errorf("failed to inspect exec: %w", err)
+	}
+	if result.Running {
+		return 0, fmt.Errorf("exec in container '%s' is still running after its output ended", container)
+	}
+	return result.ExitCode, nil
+}
+
+// PullImage pulls an image reference such as "alpine:latest".
+func (c *Client) PullImage(ctx context.Context, ref string) error {
+	image, tag := ref, "latest"
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		image, tag = ref[:i], ref[i+1:]
+	}
+	query := url.Values{"fromImage": {image}, "tag": {tag}}
+	resp, err := c.do(ctx, http.MethodPost, "/images/create", query, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", ref, err)
+	}
+	defer resp.Body.Close()
+
+	// The pull progress is a stream of JSON messages; failures are reported inline.
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to pull image %s: %w", ref, err)
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("failed to pull image %s: %s", ref, msg.Error)
+		}
+	}
+}
+
+// CopyFromContainer streams a tar archive of path inside the container.
+// A path ending in "/." archives the directory contents rather than the directory itself.
+func (c *Client) CopyFromContainer(ctx context.Context, containerID, path string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/containers/"+containerID+"/archive", url.Values{"path": {path}}, nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy %s from container: %w", path, err)
+	}
+	return resp.Body, nil
+}
+
+// CopyToContainer extracts the tar stream read from r into path inside the container.
+func (c *Client) CopyToContainer(ctx context.Context, containerID, path string, r io.Reader) error {
+	resp, err := c.do(ctx, http.MethodPut, "/containers/"+containerID+"/archive", url.Values{"path": {path}}, r, "application/x-tar")
+	if err != nil {
+		return fmt.Errorf("failed to copy to %s in container: %w", path, err)
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+// runContainer runs cmd in a helper container with the volume mounted and returns its stdout.
+// A non-zero exit status is reported as an error that includes the container output.
+func (c *Client) runContainer(ctx context.Context, volume string, readOnly bool, cmd ...string) ([]byte, error) {
+	containerID, err := c.CreateContainer(ctx, volume, readOnly, cmd...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// Remove the helper even when ctx was cancelled, e.g. on SIGINT
+		cleanupCtx, cancel := CleanupContext(ctx)
+		defer cancel()
+		c.RemoveContainer(cleanupCtx, containerID)
+	}()
+
+	if err := c.doJSON(ctx, http.MethodPost, "/containers/"+containerID+"/start", nil, nil, nil); err != nil {
+		return nil, fmt.Errorf("failed to start container: %w", err)
+	}
+
+	var result struct {
+		StatusCode int `json:"StatusCode"`
+		Error      *struct {
+			Message string `json:"Message"`
+		} `json:"Error"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/containers/"+containerID+"/wait", nil, nil, &result); err != nil {
+		return nil, fmt.Errorf("failed to wait for container: %w", err)
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, "/containers/"+containerID+"/logs", url.Values{"stdout": {"1"}, "stderr": {"1"}}, nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read container logs: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var stdout, stderr bytes.Buffer
+	if err := demuxStream(resp.Body, &stdout, &stderr); err != nil {
+		return nil, fmt.Errorf("failed to read container logs: %w", err)
+	}
+
+	if result.Error != nil && result.Error.Message != "" {
+		return nil, fmt.Errorf("container failed: %s", result.Error.Message)
+	}
+	if result.StatusCode != 0 {
+		return nil, fmt.Errorf("container exited with status %d, output: %s", result.StatusCode, strings.TrimSpace(stderr.String()+stdout.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// CreateContainerWithVolume creates a temporary container with the volume mounted
+func CreateContainerWithVolume(ctx context.Context, volume string) (string, error) {
+	c, err := Default()
+	if err != nil {
+		return "", err
+	}
+	return c.CreateContainer(ctx, volume, false)
 }
 
 // RemoveContainer removes a container
-func RemoveContainer(containerID string) error {
-	cmd := exec.Command("docker", "rm", containerID)
-	return cmd.Run()
+func RemoveContainer(ctx context.Context, containerID string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+	return c.RemoveContainer(ctx, containerID)
+}
+
+// CopyFromContainer streams a tar archive of path inside the container.
+func CopyFromContainer(ctx context.Context, containerID, path string) (io.ReadCloser, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return c.CopyFromContainer(ctx, containerID, path)
+}
+
+// CopyToContainer extracts a tar stream into path inside the container.
+func CopyToContainer(ctx context.Context, containerID, path string, r io.Reader) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+	return c.CopyToContainer(ctx, containerID, path, r)
+}
+
+// ListContainersUsingVolume returns the running and paused containers that mount volume.
+func ListContainersUsingVolume(ctx context.Context, volume string) ([]Container, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return c.ListContainersUsingVolume(ctx, volume)
+}
+
+// StopContainer stops a container, killing it after timeout.
+func StopContainer(ctx context.Context, containerID string, timeout time.Duration) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+	return c.StopContainer(ctx, containerID, timeout)
+}
+
+// StartContainer starts a stopped container.
+func StartContainer(ctx context.Context, containerID string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+	return c.StartContainer(ctx, containerID)
+}
+
+// PauseContainer suspends all processes of a container.
+func PauseContainer(ctx context.Context, containerID string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+	return c.PauseContainer(ctx, containerID)
+}
+
+// UnpauseContainer resumes the processes of a paused container.
+func UnpauseContainer(ctx context.Context, containerID string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+	return c.UnpauseContainer(ctx, containerID)
+}
+
+// Exec runs cmd in a running container and returns its exit code.
+func Exec(ctx context.Context, container string, cmd []string, env []string, output io.Writer) (int, error) {
+	c, err := Default()
+	if err != nil {
+		return 0, err
+	}
+	return c.Exec(ctx, container, cmd, env, output)
+}
+
+// ExecStreams runs cmd in a running container with separate stdout and stderr.
+func ExecStreams(ctx context.Context, container string, cmd []string, env []string, stdout, stderr io.Writer) (int, error) {
+	c, err := Default()
+	if err != nil {
+		return 0, err
+	}
+	return c.ExecStreams(ctx, container, cmd, env, stdout, stderr)
 }
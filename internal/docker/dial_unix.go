@@ -0,0 +1,16 @@
+//go:build !windows
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+const defaultHost = "unix:///var/run/docker.sock"
+
+// dialPipe is only supported on Windows.
+func dialPipe(_ context.Context, path string) (net.Conn, error) {
+	return nil, fmt.Errorf("named pipe '%s' is only supported on Windows", path)
+}
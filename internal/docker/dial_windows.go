@@ -0,0 +1,18 @@
+//go:build windows
+
+package docker
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/Microsoft/go-winio"
+)
+
+const defaultHost = "npipe:////./pipe/docker_engine"
+
+// dialPipe connects to the Docker Engine named pipe (e.g. //./pipe/docker_engine).
+func dialPipe(ctx context.Context, path string) (net.Conn, error) {
+	return winio.DialPipeContext(ctx, strings.ReplaceAll(path, "/", `\`))
+}
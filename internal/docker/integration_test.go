@@ -4,6 +4,7 @@
 package docker
 
 import (
+	"context"
 	"os/exec"
 	"testing"
 )
@@ -15,7 +16,7 @@ func TestVolumeExists(t *testing.T) {
 	}
 
 	// Test non-existent volume
-	exists, err := VolumeExists("nonexistent-test-volume-xyz123")
+	exists, err := VolumeExists(context.Background(), "nonexistent-test-volume-xyz123")
 	if err != nil {
 		t.Errorf("VolumeExists() error: %v", err)
 	}
@@ -35,13 +36,13 @@ func TestCreateAndDeleteVolume(t *testing.T) {
 	exec.Command("docker", "volume", "rm", volumeName).Run()
 
 	// Create volume
-	err := CreateVolume(volumeName)
+	err := CreateVolume(context.Background(), volumeName)
 	if err != nil {
 		t.Fatalf("CreateVolume() error: %v", err)
 	}
 
 	// Verify it exists
-	exists, err := VolumeExists(volumeName)
+	exists, err := VolumeExists(context.Background(), volumeName)
 	if err != nil {
 		t.Errorf("VolumeExists() error: %v", err)
 	}
@@ -67,13 +68,13 @@ func TestEnsureVolumeExists(t *testing.T) {
 	exec.Command("docker", "volume", "rm", volumeName).Run()
 
 	// Ensure volume exists (should create it)
-	err := EnsureVolumeExists(volumeName)
+	err := EnsureVolumeExists(context.Background(), volumeName)
 	if err != nil {
 		t.Fatalf("EnsureVolumeExists() error: %v", err)
 	}
 
 	// Verify it exists
-	exists, err := VolumeExists(volumeName)
+	exists, err := VolumeExists(context.Background(), volumeName)
 	if err != nil {
 		t.Errorf("VolumeExists() error: %v", err)
 	}
@@ -82,7 +83,7 @@ func TestEnsureVolumeExists(t *testing.T) {
 	}
 
 	// Call again (should not error)
-	err = EnsureVolumeExists(volumeName)
+	err = EnsureVolumeExists(context.Background(), volumeName)
 	if err != nil {
 		t.Errorf("EnsureVolumeExists() on existing volume error: %v", err)
 	}
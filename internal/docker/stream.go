@@ -0,0 +1,36 @@
+package docker
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// demuxStream splits a multiplexed Docker log/attach stream into stdout and stderr.
+// Each frame starts with an 8-byte header: stream type, three padding bytes and a
+// big-endian uint32 payload length.
+func demuxStream(r io.Reader, stdout, stderr io.Writer) error {
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var dst io.Writer
+		switch header[0] {
+		case 0, 1:
+			dst = stdout
+		case 2:
+			dst = stderr
+		default:
+			return fmt.Errorf("invalid stream type %d in multiplexed stream", header[0])
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(dst, r, size); err != nil {
+			return err
+		}
+	}
+}
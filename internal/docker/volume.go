@@ -1,30 +1,29 @@
 package docker
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os/exec"
+	"net/http"
+	"net/url"
+	"path"
 	"regexp"
+	"sort"
 	"strings"
 )
 
 // GetVolumeSize estimates the size of a Docker volume in bytes
-func GetVolumeSize(volume string) (int64, error) {
-	// Use docker run to calculate size
-	cmd := exec.Command("docker", "run", "--rm",
-		"-v", fmt.Sprintf("%s:/data:ro", volume),
-		"alpine",
-		"sh", "-c", "du -sb /data | cut -f1")
-	output, err := cmd.Output()
+func (c *Client) GetVolumeSize(ctx context.Context, volume string) (int64, error) {
+	output, err := c.runContainer(ctx, volume, true, "sh", "-c", "du -sb /data | cut -f1")
 	if err != nil {
-		// If we can't get size, return 0 (progress will be indeterminate)
-		return 0, nil
+		return 0, fmt.Errorf("failed to measure volume size: %w", err)
 	}
 
 	var size int64
-	_, err = fmt.Sscanf(strings.TrimSpace(string(output)), "%d", &size)
-	if err != nil {
-		return 0, nil
+	if _, err := fmt.Sscanf(strings.TrimSpace(string(output)), "%d", &size); err != nil {
+		return 0, fmt.Errorf("unexpected volume size output %q", strings.TrimSpace(string(output)))
 	}
 	return size, nil
 }
@@ -46,62 +45,238 @@ func ValidateVolumeName(volume string) error {
 	return nil
 }
 
+// Volume is the subset of `docker volume inspect` data that describes how a volume was created.
+type Volume struct {
+	Name    string            `json:"Name"`
+	Driver  string            `json:"Driver"`
+	Options map[string]string `json:"Options"`
+	Labels  map[string]string `json:"Labels"`
+	Scope   string            `json:"Scope"`
+}
+
+// InspectVolume returns a volume's driver, options and labels. A missing volume
+// is reported as an error matching ErrNotFound.
+func (c *Client) InspectVolume(ctx context.Context, volume string) (*Volume, error) {
+	var v Volume
+	if err := c.doJSON(ctx, http.MethodGet, "/volumes/"+url.PathEscape(volume), nil, nil, &v); err != nil {
+		return nil, fmt.Errorf("failed to inspect volume '%s': %w", volume, err)
+	}
+	return &v, nil
+}
+
 // VolumeExists checks if a Docker volume with the given name exists.
-// It returns true if the volume exists, false otherwise, along with any error encountered during execution.
-func VolumeExists(volume string) (bool, error) {
-	cmd := exec.Command("docker", "volume", "inspect", volume)
-	err := cmd.Run()
-	if err != nil {
-		// Volume doesn't exist
+// It returns true if the volume exists, false if the daemon reports it missing,
+// and an error for any other failure.
+func (c *Client) VolumeExists(ctx context.Context, volume string) (bool, error) {
+	err := c.doJSON(ctx, http.MethodGet, "/volumes/"+url.PathEscape(volume), nil, nil, nil)
+	if errors.Is(err, ErrNotFound) {
 		return false, nil
 	}
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect volume '%s': %w", volume, err)
+	}
 	return true, nil
 }
 
+// ListVolumes returns the names of all volumes matching every label selector,
+// sorted. A selector is either "key" or "key=value", as in `docker volume ls --filter label=...`.
+func (c *Client) ListVolumes(ctx context.Context, labels []string) ([]string, error) {
+	var query url.Values
+	if len(labels) > 0 {
+		filters, err := json.Marshal(map[string][]string{"label": labels})
+		if err != nil {
+			return nil, err
+		}
+		query = url.Values{"filters": {string(filters)}}
+	}
+
+	var result struct {
+		Volumes []struct {
+			Name string `json:"Name"`
+		} `json:"Volumes"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/volumes", query, nil, &result); err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+
+	names := make([]string, 0, len(result.Volumes))
+	for _, v := range result.Volumes {
+		names = append(names, v.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
 // CreateVolume creates a new Docker volume with the specified name. It returns an error if the volume creation fails.
-func CreateVolume(volume string) error {
-	log.Printf("Creating volume '%s'", volume)
-	cmd := exec.Command("docker", "volume", "create", volume)
-	output, err := cmd.CombinedOutput()
+func (c *Client) CreateVolume(ctx context.Context, volume string) error {
+	return c.CreateVolumeFrom(ctx, Volume{Name: volume})
+}
+
+// CreateVolumeFrom creates a new Docker volume with the name, driver, driver
+// options and labels of spec. An empty driver selects the default driver.
+func (c *Client) CreateVolumeFrom(ctx context.Context, spec Volume) error {
+	log.Printf("Creating volume '%s'", spec.Name)
+	body := struct {
+		Name       string
+		Driver     string            `json:",omitempty"`
+		DriverOpts map[string]string `json:",omitempty"`
+		Labels     map[string]string `json:",omitempty"`
+	}{spec.Name, spec.Driver, spec.Options, spec.Labels}
+	if err := c.doJSON(ctx, http.MethodPost, "/volumes/create", nil, body, nil); err != nil {
+		return fmt.Errorf("failed to create volume: %w", err)
+	}
+	return nil
+}
+
+// RemoveVolume deletes a Docker volume. It fails if the volume is still in use.
+func (c *Client) RemoveVolume(ctx context.Context, volume string) error {
+	log.Printf("Removing volume '%s'", volume)
+	if err := c.doJSON(ctx, http.MethodDelete, "/volumes/"+url.PathEscape(volume), nil, nil, nil); err != nil {
+		return fmt.Errorf("failed to remove volume: %w", err)
+	}
+	return nil
+}
+
+// ClearVolume removes all contents of the specified Docker volume using an Alpine container.
+// Returns an error if the operation fails.
+func (c *Client) ClearVolume(ctx context.Context, volume string) error {
+	log.Printf("Clearing volume '%s'", volume)
+	// Hidden entries need their own globs; unmatched globs are passed literally and ignored by rm -f
+	_, err := c.runContainer(ctx, volume, false, "sh", "-c", "rm -rf /data/* /data/..?* /data/.[!.]*")
 	if err != nil {
-		return fmt.Errorf("failed to create volume: %v, output: %s", err, string(output))
+		return fmt.Errorf("failed to clear volume: %w", err)
 	}
 	return nil
 }
 
+// maxRemoveArgs bounds the total length of the paths passed to one rm command.
+const maxRemoveArgs = 64 << 10
+
+// RemovePaths removes files and directories, given relative to the volume root,
+// from the volume. Paths that do not exist are ignored.
+func (c *Client) RemovePaths(ctx context.Context, volume string, paths []string) error {
+	log.Printf("Removing %d deleted paths from volume '%s'", len(paths), volume)
+	var batch []string
+	length := 0
+	for i, p := range paths {
+		target := path.Clean("/data/" + p)
+		if !strings.HasPrefix(target, "/data/") {
+			return fmt.Errorf("refusing to remove '%s', it is outside the volume", p)
+		}
+		batch = append(batch, target)
+		length += len(target)
+		if length < maxRemoveArgs && i < len(paths)-1 {
+			continue
+		}
+		cmd := append([]string{"rm", "-rf", "--"}, batch...)
+		if _, err := c.runContainer(ctx, volume, false, cmd...); err != nil {
+			return fmt.Errorf("failed to remove deleted paths: %w", err)
+		}
+		batch, length = batch[:0], 0
+	}
+	return nil
+}
+
+// GetVolumeSize estimates the size of a Docker volume in bytes
+func GetVolumeSize(ctx context.Context, volume string) (int64, error) {
+	c, err := Default()
+	if err != nil {
+		return 0, err
+	}
+	return c.GetVolumeSize(ctx, volume)
+}
+
+// InspectVolume returns a volume's driver, options and labels.
+func InspectVolume(ctx context.Context, volume string) (*Volume, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return c.InspectVolume(ctx, volume)
+}
+
+// VolumeExists checks if a Docker volume with the given name exists.
+func VolumeExists(ctx context.Context, volume string) (bool, error) {
+	c, err := Default()
+	if err != nil {
+		return false, err
+	}
+	return c.VolumeExists(ctx, volume)
+}
+
+// ListVolumes returns the names of all volumes matching every label selector.
+func ListVolumes(ctx context.Context, labels []string) ([]string, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return c.ListVolumes(ctx, labels)
+}
+
+// CreateVolume creates a new Docker volume with the specified name.
+func CreateVolume(ctx context.Context, volume string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+	return c.CreateVolume(ctx, volume)
+}
+
+// CreateVolumeFrom creates a new Docker volume as described by spec.
+func CreateVolumeFrom(ctx context.Context, spec Volume) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+	return c.CreateVolumeFrom(ctx, spec)
+}
+
 // EnsureVolumeExists ensures that a Docker volume with the given name exists.
 // If the volume does not exist, it attempts to create it.
 // Returns an error if checking existence or creating the volume fails.
-func EnsureVolumeExists(volume string) error {
-	exists, err := VolumeExists(volume)
+func EnsureVolumeExists(ctx context.Context, volume string) error {
+	exists, err := VolumeExists(ctx, volume)
 	if err != nil {
 		return err
 	}
 	if !exists {
-		return CreateVolume(volume)
+		return CreateVolume(ctx, volume)
 	}
 	return nil
 }
 
-// ClearVolume removes all contents of the specified Docker volume using an Alpine container.
-// Returns an error if the operation fails.
-func ClearVolume(volume string) error {
-	log.Printf("Clearing volume '%s'", volume)
-	// Use Alpine container to remove all contents from the volume
-	cmd := exec.Command("docker", "run", "--rm",
-		"-v", fmt.Sprintf("%s:/data", volume),
-		"alpine",
-		"sh", "-c", "rm -rf /data/* /data/..?* /data/.[!.]* 2>/dev/null || true")
-	output, err := cmd.CombinedOutput()
+// RemoveVolume deletes a Docker volume.
+func RemoveVolume(ctx context.Context, volume string) error {
+	c, err := Default()
 	if err != nil {
-		return fmt.Errorf("failed to clear volume: %v, output: %s", err, string(output))
+		return err
 	}
-	return nil
+	return c.RemoveVolume(ctx, volume)
+}
+
+// ClearVolume removes all contents of the specified Docker volume.
+func ClearVolume(ctx context.Context, volume string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+	return c.ClearVolume(ctx, volume)
+}
+
+// RemovePaths removes paths relative to the volume root from a volume.
+func RemovePaths(ctx context.Context, volume string, paths []string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+	return c.RemovePaths(ctx, volume, paths)
 }
 
-// Helper function to check if Docker is available
+// IsDockerAvailable reports whether the Docker daemon answers on the configured host.
 func IsDockerAvailable() bool {
-	cmd := exec.Command("docker", "version")
-	err := cmd.Run()
-	return err == nil
+	c, err := Default()
+	if err != nil {
+		return false
+	}
+	return c.Ping(context.Background()) == nil
 }
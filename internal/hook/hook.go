@@ -0,0 +1,84 @@
+package hook
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"docker-volume-backup/internal/docker"
+)
+
+// DefaultTimeout bounds hooks that do not set a timeout.
+const DefaultTimeout = 10 * time.Minute
+
+// Hook is a command run through the host shell or, if Container is set,
+// through sh inside that running container with docker exec.
+type Hook struct {
+	Command string
+	// Container is the name or ID of the container to run the command in
+	Container string
+	// Timeout bounds the command, DefaultTimeout if zero
+	Timeout time.Duration
+}
+
+func (h Hook) String() string {
+	if h.Container != "" {
+		return fmt.Sprintf("'%s' in container '%s'", h.Command, h.Container)
+	}
+	return fmt.Sprintf("'%s'", h.Command)
+}
+
+// Run runs the hook with env added to its environment. The output is logged
+// line by line; a non-zero exit status or running longer than the timeout is
+// returned as an error. Cancelling ctx or the timeout kills a host command;
+// docker exec has no way to stop a command, which is left running.
+func (h Hook) Run(ctx context.Context, env []string) error {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	var err error
+	if h.Container != "" {
+		log.Printf("Running hook in container '%s': %s", h.Container, h.Command)
+		var code int
+		code, err = docker.Exec(ctx, h.Container, []string{"sh", "-c", h.Command}, env, &output)
+		if err == nil && code != 0 {
+			err = fmt.Errorf("exit status %d", code)
+		}
+	} else {
+		shell, flag := "sh", "-c"
+		if runtime.GOOS == "windows" {
+			shell, flag = "cmd", "/C"
+		}
+		log.Printf("Running hook: %s", h.Command)
+		cmd := exec.CommandContext(ctx, shell, flag, h.Command)
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stdout, cmd.Stderr = &output, &output
+		// Do not wait for children of a killed shell that keep its output open
+		cmd.WaitDelay = time.Second
+		err = cmd.Run()
+	}
+
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		log.Printf("  | %s", scanner.Text())
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("hook %s timed out after %s", h, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("hook %s failed: %w", h, err)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	if err := (Hook{Command: "echo hello $BACKUP_JOB"}).Run(context.Background(), []string{"BACKUP_JOB=nightly"}); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if !strings.Contains(logs.String(), "| hello nightly") {
+		t.Errorf("Hook output was not logged:\n%s", logs.String())
+	}
+
+	if err := (Hook{Command: "echo failing >&2; exit 3"}).Run(context.Background(), nil); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("Run() error = %v; want exit status 3", err)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	h := Hook{Command: "sleep 5", Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	err := h.Run(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("Run() error = %v; want timeout", err)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("Run() took %s; want it to stop at the timeout", time.Since(start))
+	}
+}
@@ -0,0 +1,174 @@
+// Package ignore implements .gitignore style path matching for .backupignore files.
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// FileName is the name of the ignore file read from the root of a volume.
+const FileName = ".backupignore"
+
+type rule struct {
+	pattern string
+	regexp  *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Matcher decides which paths are ignored. Like git, the last matching rule
+// wins, a "!" rule re-includes a path, and nothing below an ignored directory
+// can be re-included.
+type Matcher struct {
+	rules []rule
+	// Results for directories, which are looked up again for every path below them
+	dirs map[string]bool
+}
+
+// Parse reads rules in .gitignore syntax. Paths are relative to the volume root.
+func Parse(r io.Reader) (*Matcher, error) {
+	m := &Matcher{dirs: map[string]bool{}}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), " \t\r")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule, err := parseRule(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", FileName, line, err)
+		}
+		m.rules = append(m.rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", FileName, err)
+	}
+	return m, nil
+}
+
+// Rules returns the patterns of the rules, as written in the file.
+func (m *Matcher) Rules() []string {
+	patterns := make([]string, 0, len(m.rules))
+	for _, r := range m.rules {
+		patterns = append(patterns, r.pattern)
+	}
+	return patterns
+}
+
+func parseRule(text string) (rule, error) {
+	r := rule{pattern: text}
+	switch {
+	case strings.HasPrefix(text, "!"):
+		r.negate = true
+		text = text[1:]
+	case strings.HasPrefix(text, `\!`), strings.HasPrefix(text, `\#`):
+		text = text[1:]
+	}
+	if strings.HasSuffix(text, "/") {
+		r.dirOnly = true
+		text = strings.TrimRight(text, "/")
+	}
+	// A slash at the start or in the middle anchors the pattern to the root,
+	// otherwise it matches at any depth
+	anchored := strings.Contains(text, "/")
+	text = strings.TrimPrefix(text, "/")
+	if text == "" {
+		return r, fmt.Errorf("empty pattern %q", r.pattern)
+	}
+
+	expr := globToRegexp(text)
+	if anchored || strings.HasPrefix(text, "**/") {
+		expr = "^" + expr + "$"
+	} else {
+		expr = "^(?:.*/)?" + expr + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return r, fmt.Errorf("invalid pattern %q: %w", r.pattern, err)
+	}
+	r.regexp = re
+	return r, nil
+}
+
+// globToRegexp translates a gitignore glob to a regular expression: "*" and "?"
+// do not match "/", "**" matches across directories.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/") && (i == 0 || glob[i-1] == '/'):
+			// Zero or more directories
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**") && (i == 0 || glob[i-1] == '/') && i+2 == len(glob):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// Match reports whether a path relative to the volume root is ignored, either
+// by a rule or because one of its parent directories is.
+func (m *Matcher) Match(name string, isDir bool) bool {
+	name = strings.Trim(name, "/")
+	if name == "" || name == "." {
+		return false
+	}
+	if i := strings.LastIndex(name, "/"); i >= 0 && m.dirIgnored(name[:i]) {
+		return true
+	}
+	return m.matchRules(name, isDir)
+}
+
+func (m *Matcher) dirIgnored(dir string) bool {
+	if ignored, ok := m.dirs[dir]; ok {
+		return ignored
+	}
+	ignored := false
+	if i := strings.LastIndex(dir, "/"); i >= 0 && m.dirIgnored(dir[:i]) {
+		ignored = true
+	} else {
+		ignored = m.matchRules(dir, true)
+	}
+	m.dirs[dir] = ignored
+	return ignored
+}
+
+func (m *Matcher) matchRules(name string, isDir bool) bool {
+	ignored := false
+	for _, r := range m.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		if r.regexp.MatchString(name) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
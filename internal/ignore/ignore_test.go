@@ -0,0 +1,71 @@
+package ignore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	m, err := Parse(strings.NewReader(`
+# Caches and temporary files
+*.tmp
+cache/
+/build
+logs/**/*.log
+!logs/keep/important.log
+**/node_modules
+docs/*.md
+!README.md
+\#literal
+vendor/
+!vendor/keep.go
+`))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		isDir    bool
+		expected bool
+	}{
+		{"a.tmp", false, true},
+		{"deep/dir/a.tmp", false, true},
+		{"a.tmpl", false, false},
+		// Directory-only rule
+		{"cache", true, true},
+		{"cache", false, false},
+		{"app/cache/data.bin", false, true},
+		// Anchored to the root
+		{"build", true, true},
+		{"build/out.o", false, true},
+		{"src/build", true, false},
+		{"logs/app.log", false, true},
+		{"logs/2024/03/app.log", false, true},
+		{"logs/keep/important.log", false, false},
+		{"web/node_modules/x/index.js", false, true},
+		{"node_modules", true, true},
+		{"docs/guide.md", false, true},
+		{"docs/sub/guide.md", false, false},
+		{"#literal", false, true},
+		// Nothing below an ignored directory can be re-included
+		{"vendor/keep.go", false, true},
+		{"src/main.go", false, false},
+		{"", true, false},
+	}
+	for _, tt := range tests {
+		if got := m.Match(tt.name, tt.isDir); got != tt.expected {
+			t.Errorf("Match(%q, %v) = %v; want %v", tt.name, tt.isDir, got, tt.expected)
+		}
+	}
+
+	if n := len(m.Rules()); n != 11 {
+		t.Errorf("Rules() returned %d rules; want 11", n)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := Parse(strings.NewReader("ok\n/\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Parse() error = %v; want error on line 2", err)
+	}
+}
@@ -0,0 +1,116 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"docker-volume-backup/internal/config"
+	"docker-volume-backup/internal/crypt"
+	"docker-volume-backup/internal/operation"
+)
+
+// Run executes a job: its pre hooks, a backup of every selected volume and, if
+// all backups succeeded, its post hooks, or its on-error hooks if anything
+// failed. A failed volume does not stop the remaining ones; all failures are
+// returned together.
+func Run(ctx context.Context, job config.Job, showProgress bool) error {
+	log.Printf("Running job '%s'", job.Name)
+
+	volumes, err := ResolveVolumes(ctx, job)
+	if err != nil {
+		return err
+	}
+	var keys *crypt.Keys
+	if job.Encryption != nil {
+		if keys, err = crypt.Load(job.Encryption.Options()); err != nil {
+			return fmt.Errorf("encryption: %w", err)
+		}
+	}
+
+	env := []string{
+		"BACKUP_JOB=" + job.Name,
+		"BACKUP_VOLUMES=" + strings.Join(volumes, " "),
+	}
+	return operation.RunHooks(ctx, job.Hooks.Commands(), env, func() error {
+		return backupVolumes(ctx, job, volumes, keys, showProgress)
+	})
+}
+
+// backupVolumes backs up the volumes of a job and applies its retention policy.
+func backupVolumes(ctx context.Context, job config.Job, volumes []string, keys *crypt.Keys, showProgress bool) error {
+	// All archives of one run share a timestamp
+	started := time.Now()
+	var errs []error
+	for _, volume := range volumes {
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+		if err := backupVolume(ctx, job, volume, keys, started, showProgress); err != nil {
+			log.Printf("ERROR: backup of volume '%s' failed: %v", volume, err)
+			errs = append(errs, fmt.Errorf("volume '%s': %w", volume, err))
+			continue
+		}
+		if job.Retention != nil {
+			if err := pruneVolume(ctx, job, volume); err != nil {
+				log.Printf("ERROR: retention for volume '%s' failed: %v", volume, err)
+				errs = append(errs, fmt.Errorf("volume '%s': retention: %w", volume, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func backupVolume(ctx context.Context, job config.Job, volume string, keys *crypt.Keys, started time.Time, showProgress bool) error {
+	dest, err := operation.ExpandDestination(job.Destination, operation.DestinationVars{
+		Job:         job.Name,
+		Volume:      volume,
+		Compression: job.Compression,
+		Encrypted:   keys.Encrypts(),
+		Time:        started,
+	})
+	if err != nil {
+		return err
+	}
+	filter := operation.PathFilter{Include: job.Include, Exclude: job.Exclude}
+	op, err := operation.NewBackup(ctx, volume, job.Compression, filter, keys, showProgress)
+	if err != nil {
+		return err
+	}
+	// Validated with the configuration
+	mode, _ := operation.ParseContainerMode(job.Containers)
+	op.SetContainerMode(mode)
+	op.SetDump(job.Dump)
+	op.SetStrict(job.Strict)
+	return op.BackupTo(ctx, dest)
+}
+
+// pruneVolume applies the job's retention policy to the archives of one volume.
+func pruneVolume(ctx context.Context, job config.Job, volume string) error {
+	archives, err := operation.ListSeries(ctx, job.Destination, operation.DestinationVars{
+		Job:    job.Name,
+		Volume: volume,
+	})
+	if err != nil {
+		return err
+	}
+	decisions, err := operation.Prune(ctx, archives, job.Retention.Policy(), false)
+	removed := 0
+	for _, d := range decisions {
+		if !d.Keep {
+			removed++
+		}
+	}
+	log.Printf("Retention for volume '%s': kept %d, removed %d archives", volume, len(decisions)-removed, removed)
+	return err
+}
+
+// ResolveVolumes returns the volumes named by the job plus every volume matching
+// its label selectors, sorted and without duplicates.
+func ResolveVolumes(ctx context.Context, job config.Job) ([]string, error) {
+	return operation.SelectVolumes(ctx, operation.VolumeSelector{Names: job.Volumes, Labels: job.Labels})
+}
@@ -2,89 +2,205 @@ package operation
 
 import (
 	"archive/tar"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"os"
-	"os/exec"
+	"strings"
 
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/crypt"
 	"docker-volume-backup/internal/docker"
+	"docker-volume-backup/internal/ignore"
 	"docker-volume-backup/internal/rw"
-	"docker-volume-backup/internal/s3"
+	"docker-volume-backup/internal/storage"
 
+	"filippo.io/age"
 	"github.com/schollz/progressbar/v3"
 )
 
 type Backup struct {
 	volume       string
+	info         *docker.Volume
 	compression  string
+	filter       PathFilter
+	keys         *crypt.Keys
 	showProgress bool
+	containers   ContainerMode
+	hooks        Hooks
+	dump         bool
+	strict       bool
+
+	// Set for incremental backups, see IncrementalFrom
+	parent      *archive.Parent
+	parentIndex *archive.Index
 }
 
-func NewBackup(volume string, compression string, showProgress bool) (*Backup, error) {
+// NewBackup prepares a backup of the volume paths selected by filter and not
+// ignored by the volume's .backupignore file. The archive is encrypted if keys
+// has recipients.
+func NewBackup(ctx context.Context, volume string, compression string, filter PathFilter, keys *crypt.Keys, showProgress bool) (*Backup, error) {
 	if err := docker.ValidateVolumeName(volume); err != nil {
 		return nil, err
 	}
-	exists, err := docker.VolumeExists(volume)
-	if err != nil {
+	if err := filter.Validate(); err != nil {
 		return nil, err
 	}
-	if !exists {
+	info, err := docker.InspectVolume(ctx, volume)
+	if errors.Is(err, docker.ErrNotFound) {
 		return nil, fmt.Errorf("volume '%s' does not exist", volume)
 	}
+	if err != nil {
+		return nil, err
+	}
 
 	return &Backup{
 		volume:       volume,
+		info:         info,
 		compression:  compression,
+		filter:       filter,
+		keys:         keys,
 		showProgress: showProgress,
 	}, nil
 }
 
-// BackupToFile saves the volume data to the specified destination file path with optional validation and logging.
-func (b *Backup) BackupToFile(dest string) error {
-	if err := ValidateFilePath(dest); err != nil {
+// SetContainerMode makes the backup stop or pause the containers using the
+// volume while it reads the volume.
+func (b *Backup) SetContainerMode(mode ContainerMode) {
+	b.containers = mode
+}
+
+// SetHooks adds hooks to run around the backup, before those declared by
+// labels on the containers using the volume.
+func (b *Backup) SetHooks(hooks Hooks) {
+	b.hooks = hooks
+}
+
+// SetDump makes the backup also store a logical dump of the database running
+// in a container using the volume, if there is one, taken with the database's
+// own dump tool before the volume is read.
+func (b *Backup) SetDump(enabled bool) {
+	b.dump = enabled
+}
+
+// SetStrict makes the backup fail instead of warning when an entry of the
+// volume is left out or stored differently, see fidelityReport.
+func (b *Backup) SetStrict(enabled bool) {
+	b.strict = enabled
+}
+
+// BackupTo streams the volume data to dest, which may be a local path or any
+// location with a registered storage scheme (e.g. s3://bucket/key).
+// The destination is only committed once the archive has been written completely;
+// on failure or cancellation of ctx the partial output is discarded.
+func (b *Backup) BackupTo(ctx context.Context, dest string) error {
+	return runHooks(ctx, b.volume, false, b.hooks, func() error {
+		return b.backupTo(ctx, dest)
+	})
+}
+
+func (b *Backup) backupTo(ctx context.Context, dest string) error {
+	backend, key, err := storage.Resolve(ctx, dest)
+	if err != nil {
 		return err
 	}
-	log.Printf("Backing up volume '%s' to %s", b.volume, dest)
-	return b.runBackup(dest)
-}
 
-// BackupToS3 performs a backup of the volume to a local file, then uploads the file to the specified S3 path.
-func (b *Backup) BackupToS3(s3Path string) error {
-	if err := s3.ValidatePath(s3Path); err != nil {
+	out, err := backend.Create(ctx, key)
+	if err != nil {
 		return err
 	}
-	// Create temporary file for backup
-	tmpFile, err := os.CreateTemp("", fmt.Sprintf("docker-volume-backup-%s-*.tar.gz", b.volume))
+
+	log.Printf("Backing up volume '%s' to %s", b.volume, dest)
+	manifest := b.newManifest()
+	// The database must still be running to dump it
+	if b.dump {
+		if manifest.Dump, err = dumpDatabase(ctx, b.volume, backend, key, b.compression, b.keys); err != nil {
+			out.Abort()
+			return err
+		}
+	}
+	discard := func() {
+		log.Printf("Discarding incomplete backup %s", dest)
+		if abortErr := out.Abort(); abortErr != nil {
+			log.Printf("Warning: failed to discard incomplete backup: %v", abortErr)
+		}
+		if manifest.Dump != nil {
+			removeDump(ctx, backend, key)
+		}
+	}
+	release, err := quiesceContainers(ctx, b.volume, b.containers)
 	if err != nil {
-		return fmt.Errorf("failed to create temporary file: %w", err)
+		discard()
+		return err
+	}
+	index, err := b.runBackup(ctx, out, manifest)
+	// The containers are needed again as soon as the volume has been read
+	releaseErr := release()
+	if err != nil {
+		discard()
+		return errors.Join(err, releaseErr)
+	}
+	if err := out.Close(); err != nil {
+		if manifest.Dump != nil {
+			removeDump(ctx, backend, key)
+		}
+		return err
 	}
-	tmpFilePath := tmpFile.Name()
-	tmpFile.Close()
-	defer os.Remove(tmpFilePath)
 
-	// First backup to local file
-	log.Printf("Creating temporary backup of volume '%s'", b.volume)
-	if err := b.runBackup(tmpFilePath); err != nil {
-		return fmt.Errorf("failed to create temporary backup: %v", err)
+	// The archive is complete without the sidecars, they only save readers a download
+	if err := archive.WriteSidecar(ctx, backend, key, manifest); err != nil {
+		log.Printf("Warning: failed to write manifest sidecar: %v", err)
+	}
+	if err := archive.WriteChecksumSidecar(ctx, backend, key, manifest.SHA256); err != nil {
+		log.Printf("Warning: failed to write checksum sidecar: %v", err)
+	}
+	var recipients []age.Recipient
+	if b.keys.Encrypts() {
+		recipients = b.keys.Recipients
+	}
+	if err := archive.WriteIndexSidecar(ctx, backend, key, index, recipients); err != nil {
+		log.Printf("Warning: failed to write index sidecar, the next incremental backup will read the archive instead: %v", err)
 	}
 
-	// Then upload to S3
-	log.Printf("Uploading to S3: %s", s3Path)
-	if err := s3.UploadFile(tmpFilePath, s3Path); err != nil {
-		return err
+	if b.parent != nil {
+		log.Printf("Incremental backup stored %d changed entries, skipped %d unchanged files and recorded %d deletions",
+			manifest.Files, manifest.Unchanged, manifest.Deleted)
 	}
 
-	log.Printf("Successfully backed up volume '%s' to %s", b.volume, s3Path)
+	log.Printf("Fidelity: %s", manifest.Fidelity)
+	log.Printf("Successfully backed up volume '%s' to %s", b.volume, dest)
+	if releaseErr != nil {
+		return fmt.Errorf("backup succeeded, but containers were not restarted: %w", releaseErr)
+	}
 	return nil
 }
 
-// runBackup performs a backup of the specified Docker volume to the destination file with optional compression and progress.
-func (b *Backup) runBackup(dest string) error {
+// newManifest describes the backup about to be written.
+func (b *Backup) newManifest() *archive.Manifest {
+	manifest := archive.NewManifest(archive.Volume{
+		Name:          b.info.Name,
+		Driver:        b.info.Driver,
+		DriverOptions: b.info.Options,
+		Labels:        b.info.Labels,
+		Scope:         b.info.Scope,
+	}, b.compression)
+	if b.keys.Encrypts() {
+		manifest.Encryption = crypt.Scheme
+	}
+	if b.parent != nil {
+		manifest.SetParent(*b.parent)
+	}
+	return manifest
+}
+
+// runBackup writes a backup of the Docker volume to out with optional compression and progress.
+// It returns the index of the backed up snapshot.
+func (b *Backup) runBackup(ctx context.Context, out io.Writer, manifest *archive.Manifest) (*archive.Index, error) {
 	// Get volume size for progress bar
 	var bar *progressbar.ProgressBar
 	if b.showProgress {
-		volumeSize, err := docker.GetVolumeSize(b.volume)
+		volumeSize, err := docker.GetVolumeSize(ctx, b.volume)
 		if err != nil {
 			log.Printf("Warning: could not determine volume size: %v", err)
 		}
@@ -102,74 +218,342 @@ func (b *Backup) runBackup(dest string) error {
 		defer bar.Finish()
 	}
 
-	// Create output file
-	outFile, err := os.Create(dest)
+	// Wrap output with progress tracking if enabled
+	outWriter := out
+	if bar != nil {
+		outWriter = rw.NewProgressWriter(out, bar)
+	}
+
+	// Create a temporary container to access the volume
+	containerID, err := docker.CreateContainerWithVolume(ctx, b.volume)
 	if err != nil {
-		return fmt.Errorf("failed to create backup file: %w", err)
+		return nil, fmt.Errorf("failed to create temp container: %w", err)
 	}
-	defer outFile.Close()
+	defer removeContainer(ctx, containerID)
 
-	// Wrap output file with progress tracking if enabled
-	var outWriter io.Writer = outFile
-	if bar != nil {
-		outWriter = rw.NewProgressWriter(outFile, bar)
+	rules, err := readIgnoreFile(ctx, containerID)
+	if err != nil {
+		return nil, err
 	}
+	match := b.selection(rules, manifest)
 
-	// Create writer with compression
-	writer, err := rw.CreateWriter(outWriter, b.compression)
+	// Stream the volume contents as a tar archive from the Engine API
+	volumeArchive, err := docker.CopyFromContainer(ctx, containerID, "/data/.")
 	if err != nil {
-		return fmt.Errorf("failed to create compressed writer: %w", err)
+		return nil, err
 	}
-	defer writer.Close()
+	defer volumeArchive.Close()
 
-	// Create tar writer
-	tarWriter := tar.NewWriter(writer)
-	defer tarWriter.Close()
+	return writeArchive(outWriter, volumeArchive, manifest, archiveOptions{
+		compression: b.compression,
+		keys:        b.keys,
+		match:       match,
+		parent:      b.parentIndex,
+		strict:      b.strict,
+		refetch: func(name string) (io.ReadCloser, error) {
+			return docker.CopyFromContainer(ctx, containerID, "/data/"+archive.CleanPath(name))
+		},
+	})
+}
 
-	// Use docker cp to copy volume contents to tar stream
-	// Create a temporary container to access the volume
-	containerID, err := docker.CreateContainerWithVolume(b.volume)
+// selection combines the include/exclude filter with the .backupignore rules,
+// records them in the manifest and returns the function selecting the entries
+// to back up, or nil to back up everything.
+func (b *Backup) selection(rules *ignore.Matcher, manifest *archive.Manifest) func(name string, isDir bool) bool {
+	if b.filter.IsEmpty() && rules == nil {
+		return nil
+	}
+	manifest.Filter = &archive.Filter{Include: b.filter.Include, Exclude: b.filter.Exclude}
+	if rules != nil {
+		manifest.Filter.Ignore = rules.Rules()
+	}
+	log.Printf("Backing up only paths matching %s", manifest.Filter)
+	return func(name string, isDir bool) bool {
+		if !b.filter.Match(name) {
+			return false
+		}
+		return rules == nil || !rules.Match(name, isDir)
+	}
+}
+
+// readIgnoreFile reads the .backupignore file at the root of the volume
+// mounted in the container. It returns nil if there is none.
+func readIgnoreFile(ctx context.Context, containerID string) (*ignore.Matcher, error) {
+	stream, err := docker.CopyFromContainer(ctx, containerID, "/data/"+ignore.FileName)
+	if errors.Is(err, docker.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	tarReader := tar.NewReader(stream)
+	header, err := tarReader.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", ignore.FileName, err)
+	}
+	if header.Typeflag != tar.TypeReg {
+		log.Printf("Warning: %s is not a regular file, ignoring it", ignore.FileName)
+		return nil, nil
+	}
+	rules, err := ignore.Parse(tarReader)
 	if err != nil {
-		return fmt.Errorf("failed to create temp container: %w", err)
+		return nil, err
+	}
+	log.Printf("Using %s with %d rules", ignore.FileName, len(rules.Rules()))
+	return rules, nil
+}
+
+// archiveOptions say how writeArchive builds an archive from a volume's tar stream.
+type archiveOptions struct {
+	compression string
+	// keys encrypt the archive if they have recipients
+	keys *crypt.Keys
+	// match selects the entries to back up, all if nil
+	match func(name string, isDir bool) bool
+	// parent is the index of the snapshot an incremental archive is based on,
+	// nil for a full archive
+	parent *archive.Index
+	// refetch returns a tar stream of one file of the volume. Incremental
+	// archives use it for files whose content changed although their metadata
+	// did not, which is only known once the file has been read.
+	refetch func(name string) (io.ReadCloser, error)
+	// strict fails the archive on the first entry that is dropped or changed
+	strict bool
+}
+
+// writeArchive copies the entries of a volume's tar stream selected by
+// opts.match into a compressed archive on out, along with their parent
+// directories, and encrypts it if opts.keys has recipients. An incremental
+// archive only stores the regular files that changed since opts.parent and
+// lists the paths that were deleted. The manifest is written as the first entry
+// and the file checksums as the last; the manifest's statistics are filled in
+// once the archive is complete, including a fidelity report of the entries
+// that were left out or stored differently. The returned index describes the
+// whole snapshot.
+func writeArchive(out io.Writer, volumeArchive io.Reader, manifest *archive.Manifest, opts archiveOptions) (*archive.Index, error) {
+	// Checksum the archive exactly as it is stored
+	digest := rw.NewDigestWriter(out)
+
+	// Encrypt the compressed stream, compressing ciphertext would gain nothing
+	var stored io.Writer = digest
+	var encrypter io.WriteCloser
+	if opts.keys.Encrypts() {
+		var err error
+		encrypter, err = rw.CreateEncryptWriter(digest, opts.keys.Recipients)
+		if err != nil {
+			return nil, err
+		}
+		stored = encrypter
 	}
-	defer docker.RemoveContainer(containerID)
 
-	// Use docker cp to stream the volume data
-	cmd := exec.Command("docker", "cp", containerID+":/data/.", "-")
-	stdout, err := cmd.StdoutPipe()
+	// Create writer with compression
+	writer, err := rw.CreateWriter(stored, opts.compression)
 	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %w", err)
+		return nil, fmt.Errorf("failed to create compressed writer: %w", err)
 	}
 
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start docker cp: %w", err)
+	// Create tar writer
+	tarWriter := tar.NewWriter(writer)
+	if err := archive.WriteManifestEntry(tarWriter, manifest); err != nil {
+		return nil, err
 	}
 
-	// Copy the tar stream from docker cp to our compressed tar
-	tarReader := tar.NewReader(stdout)
+	// Copy the tar stream from the container to our compressed tar
+	tarReader := tar.NewReader(volumeArchive)
+	selector := newEntrySelector(opts.match)
+	index := &archive.Index{}
+	var digests []archive.FileDigest
+	fidelity := newFidelityReport(opts.strict)
+	// Files that look unchanged but whose content differs from the parent's,
+	// and the hardlinks to them, which must follow the refetched files
+	var changed, links []*tar.Header
+	pending := map[string]bool{}
+	// Hardlinks whose target is not backed up, stored as copies
+	var copies []*tar.Header
+	writeFile := func(header *tar.Header, r io.Reader) error {
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write tar header: %w", err)
+		}
+		fileDigest := rw.NewDigestWriter(tarWriter)
+		n, err := io.Copy(fileDigest, r)
+		if err != nil {
+			return fmt.Errorf("failed to write file data: %w", err)
+		}
+		manifest.UncompressedSize += n
+		manifest.Files++
+		digests = append(digests, archive.FileDigest{Path: header.Name, SHA256: fileDigest.Sum()})
+		index.Add(archive.NewIndexEntry(header, fileDigest.Sum()))
+		fidelity.Add(header)
+		return nil
+	}
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("failed to read tar header: %w", err)
+			return nil, fmt.Errorf("failed to read tar header: %w", err)
 		}
-
-		if err := tarWriter.WriteHeader(header); err != nil {
-			return fmt.Errorf("failed to write tar header: %w", err)
+		// Restore would skip these, so they must not be mistaken for archive metadata
+		if archive.IsReserved(header.Name) {
+			if err := fidelity.lose(archive.FidelityIssue{Path: header.Name, Dropped: true, Reason: "the name is reserved for archive metadata"}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		ok, parents := selector.selectEntry(header)
+		if !ok {
+			continue
+		}
+		for _, dir := range parents {
+			if err := tarWriter.WriteHeader(dir); err != nil {
+				return nil, fmt.Errorf("failed to write tar header: %w", err)
+			}
+			addToIndex(index, dir)
+			fidelity.Add(dir)
+		}
+		if archive.EntryKind(header) == "" {
+			if err := fidelity.lose(archive.FidelityIssue{Path: header.Name, Dropped: true, Reason: fmt.Sprintf("unsupported entry type '%c'", header.Typeflag)}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if header.Typeflag == tar.TypeLink {
+			target := indexPath(header.Linkname)
+			if pending[target] {
+				links = append(links, header)
+				continue
+			}
+			if _, ok := index.Get(target); !ok {
+				// Restoring a hardlink to a path missing from the archive fails
+				copies = append(copies, header)
+				continue
+			}
 		}
 
 		if header.Typeflag == tar.TypeReg {
-			if _, err := io.Copy(tarWriter, tarReader); err != nil {
-				return fmt.Errorf("failed to write file data: %w", err)
+			if previous, ok := opts.parent.Get(indexPath(header.Name)); ok && previous.SameMetadata(header) {
+				// Unchanged unless the content differs
+				fileDigest := rw.NewDigestWriter(io.Discard)
+				if _, err := io.Copy(fileDigest, tarReader); err != nil {
+					return nil, fmt.Errorf("failed to read file data: %w", err)
+				}
+				if fileDigest.Sum() == previous.SHA256 {
+					manifest.Unchanged++
+					index.Add(previous)
+					fidelity.Add(header)
+				} else {
+					changed = append(changed, header)
+					pending[indexPath(header.Name)] = true
+				}
+				continue
+			}
+			if err := writeFile(header, tarReader); err != nil {
+				return nil, err
 			}
+			continue
+		}
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return nil, fmt.Errorf("failed to write tar header: %w", err)
+		}
+		if header.Typeflag != tar.TypeDir {
+			manifest.Files++
 		}
+		addToIndex(index, header)
+		fidelity.Add(header)
 	}
 
-	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("docker cp failed: %w", err)
+	for _, header := range changed {
+		if err := refetchFile(header, opts.refetch, writeFile); err != nil {
+			return nil, err
+		}
+	}
+	for _, header := range links {
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return nil, fmt.Errorf("failed to write tar header: %w", err)
+		}
+		manifest.Files++
+		addToIndex(index, header)
+		fidelity.Add(header)
+	}
+	for _, header := range copies {
+		issue := archive.FidelityIssue{Path: header.Name, Reason: fmt.Sprintf("stored as a copy, its hardlink target '%s' is not backed up", header.Linkname)}
+		if err := fidelity.lose(issue); err != nil {
+			return nil, err
+		}
+		if err := refetchFile(header, opts.refetch, writeFile); err != nil {
+			return nil, err
+		}
 	}
+	manifest.Fidelity = fidelity.Fidelity
 
-	return nil
+	if opts.parent != nil {
+		deleted := index.Deleted(opts.parent)
+		manifest.Deleted = int64(len(deleted))
+		if err := archive.WriteDeletionsEntry(tarWriter, deleted, manifest.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.WriteIndexEntry(tarWriter, index, manifest.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := archive.WriteChecksumsEntry(tarWriter, digests, manifest.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	// Flush the tar footer and compression trailer; errors here mean a truncated archive
+	if err := tarWriter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish tar archive: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish compressed archive: %w", err)
+	}
+	if encrypter != nil {
+		if err := encrypter.Close(); err != nil {
+			return nil, fmt.Errorf("failed to finish encrypted archive: %w", err)
+		}
+	}
+	manifest.Size = digest.Size()
+	manifest.SHA256 = digest.Sum()
+	return index, nil
+}
+
+// refetchFile writes a file whose content changed without a change to its
+// metadata, read again from the volume, as its content was not kept.
+func refetchFile(header *tar.Header, refetch func(name string) (io.ReadCloser, error), writeFile func(*tar.Header, io.Reader) error) error {
+	if refetch == nil {
+		return fmt.Errorf("cannot read '%s' again", header.Name)
+	}
+	stream, err := refetch(header.Name)
+	if err != nil {
+		return fmt.Errorf("failed to read '%s' again: %w", header.Name, err)
+	}
+	defer stream.Close()
+	tarReader := tar.NewReader(stream)
+	fetched, err := tarReader.Next()
+	if err != nil {
+		return fmt.Errorf("failed to read '%s' again: %w", header.Name, err)
+	}
+	if fetched.Typeflag != tar.TypeReg {
+		return fmt.Errorf("'%s' is no longer a regular file", header.Name)
+	}
+	// The stream names the file by its base name, and it may have changed again
+	fetched.Name = header.Name
+	return writeFile(fetched, tarReader)
+}
+
+// addToIndex records a non-regular entry. The volume root is left out, so that
+// it can never be listed as deleted.
+func addToIndex(index *archive.Index, header *tar.Header) {
+	if name := indexPath(header.Name); name != "" && name != "." {
+		index.Add(archive.NewIndexEntry(header, ""))
+	}
+}
+
+// indexPath returns the path of a tar entry as recorded in the index.
+func indexPath(name string) string {
+	return strings.TrimSuffix(archive.CleanPath(name), "/")
 }
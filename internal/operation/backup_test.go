@@ -0,0 +1,74 @@
+package operation
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/ignore"
+)
+
+func TestWriteArchiveFilters(t *testing.T) {
+	rules, err := ignore.Parse(strings.NewReader("*.tmp\ncache/\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Backup{filter: PathFilter{Exclude: []string{"logs"}}}
+	m := archive.NewManifest(archive.Volume{Name: "app"}, "gz")
+	match := b.selection(rules, m)
+	if m.Filter == nil || m.Filter.String() != "exclude logs; .backupignore *.tmp, cache/" {
+		t.Errorf("Manifest filter = %v; want the exclude and ignore rules", m.Filter)
+	}
+
+	var buf bytes.Buffer
+	files := map[string]string{
+		"app/config.yml":   "a: 1",
+		"app/upload.tmp":   "partial",
+		"app/cache/x.bin":  "cached",
+		"logs/app.log":     "log line",
+		".backupignore":    "*.tmp\ncache/\n",
+		"data/db.sqlite":   "rows",
+		"data/db.sqlite~1": "rows",
+	}
+	if _, err := writeArchive(&buf, volumeTar(t, files), m, archiveOptions{compression: "none", match: match}); err != nil {
+		t.Fatalf("writeArchive() error: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "app.tar")
+	os.WriteFile(path, buf.Bytes(), 0o644)
+
+	var got []string
+	err = ListContents(context.Background(), path, "", nil, func(e Entry) error {
+		got = append(got, e.Path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListContents() error: %v", err)
+	}
+	expected := map[string]bool{".backupignore": true, "app/config.yml": true, "data/db.sqlite": true, "data/db.sqlite~1": true}
+	if len(got) != len(expected) {
+		t.Errorf("Archive entries = %v; want %v", got, expected)
+	}
+	for _, name := range got {
+		if !expected[name] {
+			t.Errorf("Archive contains %s, which should have been filtered", name)
+		}
+	}
+	if m.Files != 4 {
+		t.Errorf("Manifest files = %d; want 4", m.Files)
+	}
+	if err := Verify(context.Background(), path, nil, false); err != nil {
+		t.Errorf("Verify() of a filtered archive error: %v", err)
+	}
+}
+
+func TestSelectionWithoutFilters(t *testing.T) {
+	b := &Backup{}
+	m := archive.NewManifest(archive.Volume{Name: "app"}, "gz")
+	if match := b.selection(nil, m); match != nil || m.Filter != nil {
+		t.Error("selection() without filters should back up everything and record no filter")
+	}
+}
@@ -0,0 +1,26 @@
+package operation
+
+import (
+	"context"
+	"log"
+
+	"docker-volume-backup/internal/docker"
+)
+
+// removeContainer removes a helper container, even if ctx was cancelled.
+func removeContainer(ctx context.Context, containerID string) {
+	ctx, cancel := docker.CleanupContext(ctx)
+	defer cancel()
+	if err := docker.RemoveContainer(ctx, containerID); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// removeVolume removes a volume created by a failed restore, even if ctx was cancelled.
+func removeVolume(ctx context.Context, volume string) {
+	ctx, cancel := docker.CleanupContext(ctx)
+	defer cancel()
+	if err := docker.RemoveVolume(ctx, volume); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/crypt"
+	"docker-volume-backup/internal/docker"
+	"docker-volume-backup/internal/rw"
+	"docker-volume-backup/internal/storage"
+)
+
+// Labels Docker Compose puts on the volumes it creates.
+const (
+	ComposeProjectLabel = "com.docker.compose.project"
+	ComposeVolumeLabel  = "com.docker.compose.volume"
+)
+
+// defaultBundleName is appended to bundle destinations that end in "/".
+const defaultBundleName = "{project}-{timestamp}"
+
+var projectNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ValidateProjectName checks a Compose project name as Docker Compose does:
+// lowercase letters, digits, dashes and underscores, starting with a letter or digit.
+func ValidateProjectName(project string) error {
+	if !projectNamePattern.MatchString(project) {
+		return fmt.Errorf("invalid Compose project name '%s': must start with a lowercase letter or digit and contain only a-z, 0-9, -, _", project)
+	}
+	return nil
+}
+
+// ExpandBundleLocation fills in a bundle destination such as
+// "s3://bucket/{host}/{project}-{timestamp}". Supported placeholders are
+// {project}, {host} and {timestamp} (UTC). A destination ending in "/" gets
+// "{project}-{timestamp}" appended.
+func ExpandBundleLocation(template, project string, t time.Time) (string, error) {
+	if template == "" {
+		return "", fmt.Errorf("destination cannot be empty")
+	}
+	if strings.HasSuffix(template, "/") {
+		template += defaultBundleName
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	values := map[string]string{
+		"{project}":   project,
+		"{host}":      host,
+		"{timestamp}": t.UTC().Format(TimestampFormat),
+	}
+
+	var unknown []string
+	expanded := placeholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		value, ok := values[placeholder]
+		if !ok {
+			unknown = append(unknown, placeholder)
+		}
+		return value
+	})
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("unknown placeholder %s in destination '%s' (supported: {project}, {host}, {timestamp})", unknown[0], template)
+	}
+	return expanded, nil
+}
+
+// ComposeVolumes returns the volumes of a Compose project, keyed by their name
+// in the Compose file.
+func ComposeVolumes(ctx context.Context, project string) (map[string]string, error) {
+	names, err := docker.ListVolumes(ctx, []string{ComposeProjectLabel + "=" + project})
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no volumes belong to Compose project '%s'", project)
+	}
+
+	volumes := map[string]string{}
+	for _, name := range names {
+		info, err := docker.InspectVolume(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		logical := info.Labels[ComposeVolumeLabel]
+		if logical == "" {
+			logical = strings.TrimPrefix(name, project+"_")
+		}
+		if err := docker.ValidateVolumeName(logical); err != nil {
+			return nil, fmt.Errorf("volume '%s' has an unsupported Compose volume name: %w", name, err)
+		}
+		if other, dup := volumes[logical]; dup {
+			return nil, fmt.Errorf("volumes '%s' and '%s' are both volume '%s' of Compose project '%s'", other, name, logical, project)
+		}
+		volumes[logical] = name
+	}
+	return volumes, nil
+}
+
+// BackupComposeProject backs up every volume of a Compose project into one
+// bundle: an archive per volume plus a manifest mapping the volumes' names in
+// the Compose file to their archives. The bundle location is dest expanded by
+// ExpandBundleLocation and is returned. The manifest is written last; if a
+// volume fails, the archives already written are removed again.
+func BackupComposeProject(ctx context.Context, project, dest, compression string, filter PathFilter, keys *crypt.Keys, containers ContainerMode, showProgress bool) (string, error) {
+	if err := ValidateProjectName(project); err != nil {
+		return "", err
+	}
+	ext, err := rw.Extension(compression)
+	if err != nil {
+		return "", err
+	}
+	if keys.Encrypts() {
+		ext += rw.EncryptedSuffix
+	}
+	bundle := archive.NewBundle(project)
+	location, err := ExpandBundleLocation(dest, project, bundle.CreatedAt)
+	if err != nil {
+		return "", err
+	}
+	backend, prefix, err := storage.Resolve(ctx, location)
+	if err != nil {
+		return "", err
+	}
+	volumes, err := ComposeVolumes(ctx, project)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("Backing up %d volumes of Compose project '%s' to bundle %s", len(volumes), project, location)
+	var written []string
+	for _, logical := range slices.Sorted(maps.Keys(volumes)) {
+		name := archive.BundleKey(location, logical+ext)
+		b, err := NewBackup(ctx, volumes[logical], compression, filter, keys, showProgress)
+		if err == nil {
+			b.SetContainerMode(containers)
+			err = b.BackupTo(ctx, name)
+		}
+		if err != nil {
+			removeArchives(ctx, backend, written)
+			return "", fmt.Errorf("failed to back up volume '%s': %w", volumes[logical], err)
+		}
+		written = append(written, archive.BundleKey(prefix, logical+ext))
+		bundle.Volumes[logical] = archive.BundleVolume{Name: volumes[logical], Archive: logical + ext}
+	}
+	if err := archive.WriteBundle(ctx, backend, prefix, bundle); err != nil {
+		removeArchives(ctx, backend, written)
+		return "", err
+	}
+	log.Printf("Successfully backed up Compose project '%s' to bundle %s", project, location)
+	return location, nil
+}
+
+// RestoreComposeProject restores every volume of the bundle at src as a volume
+// of Compose project, which may differ from the project the bundle was taken
+// from to clone it. Volumes named "<project>_<volume>" are renamed for the new
+// project; volumes with a custom name keep it. Created volumes get their
+// recorded settings changed by overrides, and the labels Docker Compose
+// expects. The mode applies to every volume, and with RestoreNew nothing is
+// restored if any of the volumes already exists.
+func RestoreComposeProject(ctx context.Context, src, project string, filter PathFilter, keys *crypt.Keys, overrides VolumeOverrides, showProgress bool, mode RestoreMode) error {
+	if err := ValidateProjectName(project); err != nil {
+		return err
+	}
+	backend, prefix, err := storage.Resolve(ctx, src)
+	if err != nil {
+		return err
+	}
+	bundle, err := archive.ReadBundle(ctx, backend, prefix)
+	if errors.Is(err, storage.ErrNotExist) {
+		return fmt.Errorf("%s is not a complete bundle, it has no %s", src, archive.BundleManifestName)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Bundle contains %d volumes of Compose project '%s' backed up on %s at %s",
+		len(bundle.Volumes), bundle.Project, bundle.Host, bundle.CreatedAt.Format(time.RFC3339))
+	if project != bundle.Project {
+		log.Printf("Restoring them as Compose project '%s'", project)
+	}
+
+	// Check every target before touching any of them
+	targets := map[string]string{}
+	for _, logical := range bundle.LogicalNames() {
+		v := bundle.Volumes[logical]
+		target := composeVolumeName(v.Name, logical, bundle.Project, project)
+		if target == v.Name && project != bundle.Project {
+			log.Printf("Warning: volume '%s' has a custom name and is restored under the same name", v.Name)
+		}
+		if err := docker.ValidateVolumeName(target); err != nil {
+			return err
+		}
+		if mode == RestoreNew {
+			exists, err := docker.VolumeExists(ctx, target)
+			if err != nil {
+				return err
+			}
+			if exists {
+				return fmt.Errorf("volume '%s' already exists. Use --overwrite flag to clear and restore, --merge to restore into it, or delete the volume first", target)
+			}
+		}
+		targets[logical] = target
+	}
+
+	for i, logical := range bundle.LogicalNames() {
+		v := bundle.Volumes[logical]
+		r, err := NewRestore(targets[logical], filter, keys, showProgress)
+		if err != nil {
+			return err
+		}
+		r.SetVolumeOverrides(overrides)
+		r.SetLabels(map[string]string{ComposeProjectLabel: project, ComposeVolumeLabel: logical})
+		if err := r.RestoreFrom(ctx, archive.BundleKey(src, v.Archive), mode); err != nil {
+			if i > 0 {
+				log.Printf("Warning: %d of %d volumes were restored before the failure", i, len(bundle.Volumes))
+			}
+			return fmt.Errorf("failed to restore volume '%s': %w", targets[logical], err)
+		}
+	}
+	log.Printf("Successfully restored %d volumes of Compose project '%s'", len(bundle.Volumes), project)
+	return nil
+}
+
+// composeVolumeName returns the name of a volume of project from when it is
+// restored into project to: Compose names volumes "<project>_<volume>" unless
+// the Compose file gives them a name.
+func composeVolumeName(name, logical, from, to string) string {
+	if name == from+"_"+logical {
+		return to + "_" + logical
+	}
+	return name
+}
+
+// removeArchives removes archives and their sidecars, even if ctx was cancelled.
+func removeArchives(ctx context.Context, backend storage.Backend, keys []string) {
+	ctx, cancel := docker.CleanupContext(ctx)
+	defer cancel()
+	for _, key := range keys {
+		log.Printf("Removing archive %s", key)
+		for _, k := range []string{key, archive.SidecarKey(key), archive.ChecksumKey(key), archive.IndexKey(key), archive.DumpKey(key)} {
+			if err := backend.Delete(ctx, k); err != nil && !errors.Is(err, storage.ErrNotExist) {
+				log.Printf("Warning: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package operation
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestValidateProjectName(t *testing.T) {
+	for _, name := range []string{"shop", "shop-staging", "2024_app"} {
+		if err := ValidateProjectName(name); err != nil {
+			t.Errorf("ValidateProjectName(%q) unexpected error: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "Shop", "-shop", "shop/db", "shop db"} {
+		if err := ValidateProjectName(name); err == nil {
+			t.Errorf("ValidateProjectName(%q) expected error but got none", name)
+		}
+	}
+}
+
+func TestExpandBundleLocation(t *testing.T) {
+	host, _ := os.Hostname()
+	now := time.Date(2024, 3, 9, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		template  string
+		expected  string
+		shouldErr bool
+	}{
+		{"plain path", "/backups/shop", "/backups/shop", false},
+		{"directory", "s3://bucket/", "s3://bucket/shop-20240309T150405Z", false},
+		{"placeholders", "/backups/{host}/{project}-{timestamp}", "/backups/" + host + "/shop-20240309T150405Z", false},
+		{"volume placeholder", "/backups/{volume}", "", true},
+		{"empty", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandBundleLocation(tt.template, "shop", now)
+			if tt.shouldErr {
+				if err == nil {
+					t.Errorf("ExpandBundleLocation(%q) expected error but got %q", tt.template, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExpandBundleLocation(%q) unexpected error: %v", tt.template, err)
+			}
+			if got != tt.expected {
+				t.Errorf("ExpandBundleLocation(%q) = %q; want %q", tt.template, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestComposeVolumeName(t *testing.T) {
+	tests := []struct {
+		name, logical, expected string
+	}{
+		{"prod_db", "db", "staging_db"},
+		{"prod_media_files", "media_files", "staging_media_files"},
+		// Volumes with a name set in the Compose file keep it
+		{"shared-cache", "cache", "shared-cache"},
+	}
+	for _, tt := range tests {
+		if got := composeVolumeName(tt.name, tt.logical, "prod", "staging"); got != tt.expected {
+			t.Errorf("composeVolumeName(%q, %q) = %q; want %q", tt.name, tt.logical, got, tt.expected)
+		}
+	}
+}
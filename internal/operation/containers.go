@@ -0,0 +1,182 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"docker-volume-backup/internal/docker"
+)
+
+// ContainerMode says what a backup does with the containers using the volume.
+type ContainerMode int
+
+const (
+	// KeepContainers backs up the volume while its containers keep running.
+	KeepContainers ContainerMode = iota
+	// StopContainers stops the containers for the backup and starts them again afterwards.
+	StopContainers
+	// PauseContainers pauses the containers for the backup and unpauses them afterwards.
+	PauseContainers
+)
+
+// ParseContainerMode parses "keep" (or ""), "stop" or "pause".
+func ParseContainerMode(s string) (ContainerMode, error) {
+	switch s {
+	case "", "keep":
+		return KeepContainers, nil
+	case "stop":
+		return StopContainers, nil
+	case "pause":
+		return PauseContainers, nil
+	}
+	return KeepContainers, fmt.Errorf("'%s' is not a container mode (use keep, stop or pause)", s)
+}
+
+// Labels on the containers using a volume that change how backups treat them.
+const (
+	// StopLabel set to "false" keeps a container running during backups.
+	StopLabel = "docker-volume-backup.stop"
+	// StopTimeoutLabel is how long a container may take to stop before it is
+	// killed, e.g. "2m". Without it the container's own stop timeout applies.
+	StopTimeoutLabel = "docker-volume-backup.stop-timeout"
+)
+
+// Labels Docker Compose puts on the containers of a service.
+const (
+	composeServiceLabel   = "com.docker.compose.service"
+	composeDependsOnLabel = "com.docker.compose.depends_on"
+)
+
+// quiescedContainer is a container a backup stopped or paused.
+type quiescedContainer struct {
+	docker.Container
+	timeout time.Duration
+}
+
+// quiesceContainers stops or pauses the running containers that use volume,
+// containers before the containers they depend on, and returns the function
+// that starts or unpauses them again in reverse order. The release function
+// works even if ctx was cancelled. If quiescing fails, the containers already
+// stopped or paused are released before the error is returned.
+func quiesceContainers(ctx context.Context, volume string, mode ContainerMode) (func() error, error) {
+	if mode == KeepContainers {
+		return func() error { return nil }, nil
+	}
+	found, err := docker.ListContainersUsingVolume(ctx, volume)
+	if err != nil {
+		return nil, err
+	}
+	var selected []quiescedContainer
+	for _, c := range found {
+		if c.State != "running" {
+			continue
+		}
+		if value := c.Labels[StopLabel]; value != "" {
+			stop, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s label '%s' on container '%s'", StopLabel, value, c.Name())
+			}
+			if !stop {
+				log.Printf("Leaving container '%s' running, it has %s=%s", c.Name(), StopLabel, value)
+				continue
+			}
+		}
+		timeout := time.Duration(-1)
+		if value := c.Labels[StopTimeoutLabel]; value != "" {
+			timeout, err = time.ParseDuration(value)
+			if err != nil || timeout < 0 {
+				return nil, fmt.Errorf("invalid %s label '%s' on container '%s'", StopTimeoutLabel, value, c.Name())
+			}
+		}
+		selected = append(selected, quiescedContainer{c, timeout})
+	}
+	selected = stopOrder(selected)
+
+	var done []quiescedContainer
+	release := func() error {
+		var errs []error
+		for _, c := range slices.Backward(done) {
+			if err := resumeContainer(ctx, c, mode); err != nil {
+				log.Printf("ERROR: %v", err)
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+	for _, c := range selected {
+		var err error
+		if mode == PauseContainers {
+			log.Printf("Pausing container '%s'", c.Name())
+			err = docker.PauseContainer(ctx, c.ID)
+		} else {
+			log.Printf("Stopping container '%s'", c.Name())
+			err = docker.StopContainer(ctx, c.ID, c.timeout)
+		}
+		if err != nil {
+			release()
+			return nil, err
+		}
+		done = append(done, c)
+	}
+	return release, nil
+}
+
+// resumeContainer starts or unpauses a container, even if ctx was cancelled.
+func resumeContainer(ctx context.Context, c quiescedContainer, mode ContainerMode) error {
+	ctx, cancel := docker.CleanupContext(ctx)
+	defer cancel()
+	if mode == PauseContainers {
+		log.Printf("Unpausing container '%s'", c.Name())
+		return docker.UnpauseContainer(ctx, c.ID)
+	}
+	log.Printf("Starting container '%s'", c.Name())
+	return docker.StartContainer(ctx, c.ID)
+}
+
+// stopOrder sorts containers so that each comes before the containers of the
+// same Compose project it depends on. Dependency cycles are broken arbitrarily.
+func stopOrder(containers []quiescedContainer) []quiescedContainer {
+	slices.SortFunc(containers, func(a, b quiescedContainer) int { return strings.Compare(a.Name(), b.Name()) })
+
+	// Visit dependencies first to get the start order, then reverse it
+	visited := map[string]bool{}
+	var order []quiescedContainer
+	var visit func(c quiescedContainer)
+	visit = func(c quiescedContainer) {
+		if visited[c.ID] {
+			return
+		}
+		visited[c.ID] = true
+		for _, dep := range dependencies(c.Labels) {
+			for _, d := range containers {
+				if d.Labels[composeServiceLabel] == dep && d.Labels[ComposeProjectLabel] == c.Labels[ComposeProjectLabel] {
+					visit(d)
+				}
+			}
+		}
+		order = append(order, c)
+	}
+	for _, c := range containers {
+		visit(c)
+	}
+	slices.Reverse(order)
+	return order
+}
+
+// dependencies returns the services a Compose container depends on, from a
+// depends_on label such as "db:service_healthy:false,cache:service_started:false".
+func dependencies(labels map[string]string) []string {
+	var services []string
+	for _, dep := range strings.Split(labels[composeDependsOnLabel], ",") {
+		if name, _, _ := strings.Cut(strings.TrimSpace(dep), ":"); name != "" {
+			services = append(services, name)
+		}
+	}
+	return services
+}
@@ -0,0 +1,74 @@
+package operation
+
+import (
+	"strings"
+	"testing"
+
+	"docker-volume-backup/internal/docker"
+)
+
+func TestParseContainerMode(t *testing.T) {
+	tests := map[string]ContainerMode{"": KeepContainers, "keep": KeepContainers, "stop": StopContainers, "pause": PauseContainers}
+	for s, want := range tests {
+		if got, err := ParseContainerMode(s); err != nil || got != want {
+			t.Errorf("ParseContainerMode(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseContainerMode("kill"); err == nil {
+		t.Error("ParseContainerMode(kill) expected error but got none")
+	}
+}
+
+func TestStopOrder(t *testing.T) {
+	container := func(name, project, service, dependsOn string) quiescedContainer {
+		labels := map[string]string{ComposeProjectLabel: project, composeServiceLabel: service}
+		if dependsOn != "" {
+			labels[composeDependsOnLabel] = dependsOn
+		}
+		return quiescedContainer{Container: docker.Container{ID: name, Names: []string{"/" + name}, Labels: labels}}
+	}
+
+	tests := []struct {
+		name       string
+		containers []quiescedContainer
+		expected   string
+	}{
+		{
+			"dependents first",
+			[]quiescedContainer{
+				container("shop-db-1", "shop", "db", ""),
+				container("shop-app-1", "shop", "app", "db:service_healthy:false,cache:service_started:false"),
+				container("shop-cache-1", "shop", "cache", ""),
+				container("shop-worker-1", "shop", "worker", "app:service_started:false"),
+			},
+			"shop-worker-1 shop-app-1 shop-cache-1 shop-db-1",
+		},
+		{
+			"other projects are independent",
+			[]quiescedContainer{
+				container("a-db-1", "a", "db", ""),
+				container("b-app-1", "b", "app", "db:service_started:false"),
+			},
+			"b-app-1 a-db-1",
+		},
+		{
+			"cycles are broken",
+			[]quiescedContainer{
+				container("x-1", "p", "x", "y:service_started:false"),
+				container("y-1", "p", "y", "x:service_started:false"),
+			},
+			"x-1 y-1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var names []string
+			for _, c := range stopOrder(tt.containers) {
+				names = append(names, c.Name())
+			}
+			if got := strings.Join(names, " "); got != tt.expected {
+				t.Errorf("stopOrder() = %s; want %s", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package operation
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/crypt"
+	"docker-volume-backup/internal/storage"
+)
+
+// Entry describes one file, directory or link in an archive.
+type Entry struct {
+	Path     string    `json:"path"`
+	Type     string    `json:"type"`
+	Mode     string    `json:"mode"`
+	UID      int       `json:"uid"`
+	GID      int       `json:"gid"`
+	User     string    `json:"user,omitempty"`
+	Group    string    `json:"group,omitempty"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mtime"`
+	Linkname string    `json:"link,omitempty"`
+}
+
+func newEntry(header *tar.Header) Entry {
+	return Entry{
+		Path:     strings.TrimSuffix(archive.CleanPath(header.Name), "/"),
+		Type:     entryType(header.Typeflag),
+		Mode:     header.FileInfo().Mode().String(),
+		UID:      header.Uid,
+		GID:      header.Gid,
+		User:     header.Uname,
+		Group:    header.Gname,
+		Size:     header.Size,
+		ModTime:  header.ModTime,
+		Linkname: header.Linkname,
+	}
+}
+
+func entryType(flag byte) string {
+	switch flag {
+	case tar.TypeReg:
+		return "file"
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeChar, tar.TypeBlock:
+		return "device"
+	case tar.TypeFifo:
+		return "fifo"
+	default:
+		return "other"
+	}
+}
+
+// ValidatePathPattern checks a glob as accepted by MatchPath.
+func ValidatePathPattern(pattern string) error {
+	if _, err := path.Match(archive.CleanPath(pattern), ""); err != nil {
+		return fmt.Errorf("invalid path pattern %q: %w", pattern, err)
+	}
+	return nil
+}
+
+// MatchPath reports whether an archive path matches a glob (see path.Match),
+// either itself or through one of its parent directories, so that a pattern
+// naming a directory selects everything below it. Paths and patterns are
+// relative to the volume root; a leading "./" or "/" is ignored.
+func MatchPath(pattern, name string) bool {
+	pattern = strings.TrimSuffix(archive.CleanPath(pattern), "/")
+	name = strings.TrimSuffix(archive.CleanPath(name), "/")
+	for {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			return false
+		}
+		name = name[:i]
+	}
+}
+
+// ListContents streams the archive at src and calls fn for every entry of the
+// volume matching pattern, or for every entry if pattern is empty. It needs
+// neither Docker nor a temporary copy of the archive. Encrypted archives are
+// decrypted with the identities in keys.
+func ListContents(ctx context.Context, src string, pattern string, keys *crypt.Keys, fn func(Entry) error) error {
+	if pattern != "" {
+		if err := ValidatePathPattern(pattern); err != nil {
+			return err
+		}
+	}
+	backend, key, err := storage.Resolve(ctx, src)
+	if err != nil {
+		return err
+	}
+	compression := ""
+	manifest, err := archive.ReadSidecar(ctx, backend, key)
+	switch {
+	case err == nil:
+		compression = manifest.Compression
+	case !errors.Is(err, storage.ErrNotExist):
+		return err
+	}
+
+	in, err := backend.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	reader, err := decompress(in, key, compression, keys)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar header: %w", err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if archive.IsReserved(header.Name) {
+			continue
+		}
+		entry := newEntry(header)
+		// The volume root itself
+		if entry.Path == "" || entry.Path == "." {
+			continue
+		}
+		if pattern != "" && !MatchPath(pattern, entry.Path) {
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
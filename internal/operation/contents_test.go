@@ -0,0 +1,63 @@
+package operation
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{"etc/nginx", "./etc/nginx/nginx.conf", true},
+		{"etc/nginx/", "etc/nginx", true},
+		{"/etc/nginx", "etc/nginx/conf.d/default.conf", true},
+		{"etc/nginx", "etc/nginx.bak", false},
+		{"*.conf", "nginx.conf", true},
+		// * does not cross directories
+		{"*.conf", "etc/nginx.conf", false},
+		{"etc/*/default.conf", "etc/nginx/default.conf", true},
+		{"data/db?.sqlite", "data/db1.sqlite", true},
+	}
+	for _, tt := range tests {
+		if got := MatchPath(tt.pattern, tt.name); got != tt.expected {
+			t.Errorf("MatchPath(%q, %q) = %v; want %v", tt.pattern, tt.name, got, tt.expected)
+		}
+	}
+
+	if err := ValidatePathPattern("data/[a"); err == nil {
+		t.Error("ValidatePathPattern() of a malformed glob expected error but got none")
+	}
+}
+
+func TestListContents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.tar.gz")
+	createArchive(t, path, "app", "gz", map[string]string{"a.txt": "hello", "b/c.txt": "world!"})
+
+	list := func(pattern string) []string {
+		var entries []string
+		err := ListContents(context.Background(), path, pattern, nil, func(e Entry) error {
+			entries = append(entries, fmt.Sprintf("%s:%s:%d", e.Path, e.Mode, e.Size))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ListContents(%q) error: %v", pattern, err)
+		}
+		return entries
+	}
+
+	// The manifest and checksums are not part of the volume
+	if got := list(""); len(got) != 2 {
+		t.Errorf("ListContents() = %v; want the 2 files", got)
+	}
+	if got := fmt.Sprint(list("b")); got != "[b/c.txt:-rw-r--r--:6]" {
+		t.Errorf("ListContents(b) = %s; want [b/c.txt:-rw-r--r--:6]", got)
+	}
+	if got := list("*.md"); len(got) != 0 {
+		t.Errorf("ListContents(*.md) = %v; want nothing", got)
+	}
+}
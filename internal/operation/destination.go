@@ -0,0 +1,173 @@
+package operation
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"docker-volume-backup/internal/rw"
+)
+
+// TimestampFormat is used for {timestamp} in destination templates. It sorts
+// chronologically and is safe in file names and S3 keys.
+const TimestampFormat = "20060102T150405Z"
+
+// defaultArchiveName is appended to destinations that end in "/".
+const defaultArchiveName = "{volume}-{timestamp}{ext}"
+
+var placeholderPattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+// Patterns matching the values of the placeholders that change between runs.
+var seriesPatterns = map[string]string{
+	"{timestamp}": `\d{8}T\d{6}Z`,
+	"{ext}":       `(\.tar|\.tar\.gz|\.tgz|\.tar\.zst)(\.age)?`,
+}
+
+// DestinationVars are the values substituted into a destination template.
+type DestinationVars struct {
+	Job         string
+	Volume      string
+	Compression string
+	// Encrypted adds the .age suffix to {ext}
+	Encrypted bool
+	Time      time.Time
+}
+
+func (vars DestinationVars) values() (map[string]string, error) {
+	ext, err := rw.Extension(vars.Compression)
+	if err != nil {
+		return nil, err
+	}
+	if vars.Encrypted {
+		ext += rw.EncryptedSuffix
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return map[string]string{
+		"{job}":       vars.Job,
+		"{volume}":    vars.Volume,
+		"{host}":      host,
+		"{timestamp}": vars.Time.UTC().Format(TimestampFormat),
+		"{ext}":       ext,
+	}, nil
+}
+
+// ExpandDestination fills in a destination template such as
+// "s3://bucket/{host}/{volume}-{timestamp}{ext}". Supported placeholders are
+// {job}, {volume}, {host}, {timestamp} (UTC) and {ext}, the archive extension for
+// the compression type, followed by ".age" for encrypted archives. A template
+// ending in "/" is treated as a directory and gets "{volume}-{timestamp}{ext}" appended.
+func ExpandDestination(template string, vars DestinationVars) (string, error) {
+	if strings.HasSuffix(template, "/") {
+		template += defaultArchiveName
+	}
+	values, err := vars.values()
+	if err != nil {
+		return "", err
+	}
+
+	var unknown []string
+	expanded := placeholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		value, ok := values[placeholder]
+		if !ok {
+			unknown = append(unknown, placeholder)
+		}
+		return value
+	})
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("unknown placeholder %s in destination '%s' (supported: {job}, {volume}, {host}, {timestamp}, {ext})", unknown[0], template)
+	}
+	return expanded, nil
+}
+
+// ValidateDestination checks that a destination template only uses supported placeholders.
+func ValidateDestination(template string) error {
+	if template == "" {
+		return fmt.Errorf("destination cannot be empty")
+	}
+	_, err := ExpandDestination(template, DestinationVars{Job: "job", Volume: "volume", Compression: "none"})
+	return err
+}
+
+// IsPerVolumeDestination reports whether a template yields a distinct location for each volume.
+func IsPerVolumeDestination(template string) bool {
+	return strings.HasSuffix(template, "/") || strings.Contains(template, "{volume}")
+}
+
+// IsTimestampedDestination reports whether a template yields a new location for each run.
+func IsTimestampedDestination(template string) bool {
+	return strings.HasSuffix(template, "/") || strings.Contains(template, "{timestamp}")
+}
+
+// ArchiveSeries describes all archives a destination template produces for one
+// volume of one job, across runs and compression types.
+type ArchiveSeries struct {
+	// Prefix is the location prefix shared by all archives of the series.
+	Prefix string
+	// suffix matches the rest of an archive's location after Prefix.
+	suffix *regexp.Regexp
+}
+
+// NewArchiveSeries returns the series of archives that template produces for vars.
+// vars.Time, vars.Compression and vars.Encrypted are ignored.
+func NewArchiveSeries(template string, vars DestinationVars) (*ArchiveSeries, error) {
+	if strings.HasSuffix(template, "/") {
+		template += defaultArchiveName
+	}
+	vars.Compression = "none"
+	vars.Encrypted = false
+	values, err := vars.values()
+	if err != nil {
+		return nil, err
+	}
+
+	var prefix, suffix strings.Builder
+	varying := false
+	last := 0
+	for _, loc := range placeholderPattern.FindAllStringIndex(template, -1) {
+		literal, placeholder := template[last:loc[0]], template[loc[0]:loc[1]]
+		last = loc[1]
+
+		pattern, isVarying := seriesPatterns[placeholder]
+		value, known := values[placeholder]
+		if !known {
+			return nil, fmt.Errorf("unknown placeholder %s in destination '%s'", placeholder, template)
+		}
+		if !varying {
+			prefix.WriteString(literal)
+			if !isVarying {
+				prefix.WriteString(value)
+				continue
+			}
+			varying = true
+			suffix.WriteString(pattern)
+			continue
+		}
+		suffix.WriteString(regexp.QuoteMeta(literal))
+		if isVarying {
+			suffix.WriteString(pattern)
+		} else {
+			suffix.WriteString(regexp.QuoteMeta(value))
+		}
+	}
+	if !varying {
+		return nil, fmt.Errorf("destination '%s' has no {timestamp}, so it does not produce a series of archives", template)
+	}
+	suffix.WriteString(regexp.QuoteMeta(template[last:]))
+
+	return &ArchiveSeries{
+		Prefix: prefix.String(),
+		suffix: regexp.MustCompile("^" + suffix.String() + "$"),
+	}, nil
+}
+
+// Contains reports whether key belongs to the series. keyPrefix is the key that
+// the series Prefix resolved to in its storage backend.
+func (s *ArchiveSeries) Contains(keyPrefix, key string) bool {
+	rest, ok := strings.CutPrefix(key, keyPrefix)
+	return ok && s.suffix.MatchString(rest)
+}
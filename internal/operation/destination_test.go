@@ -0,0 +1,56 @@
+package operation
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestExpandDestination(t *testing.T) {
+	host, _ := os.Hostname()
+	vars := DestinationVars{
+		Job:         "nightly",
+		Volume:      "app_data",
+		Compression: "zstd",
+		Time:        time.Date(2024, 3, 9, 4, 5, 6, 0, time.FixedZone("CET", 3600)),
+	}
+
+	tests := []struct {
+		name      string
+		template  string
+		expected  string
+		shouldErr bool
+	}{
+		{"plain path", "/backups/app.tar.gz", "/backups/app.tar.gz", false},
+		{"all placeholders", "s3://bucket/{host}/{job}/{volume}-{timestamp}{ext}", "s3://bucket/" + host + "/nightly/app_data-20240309T030506Z.tar.zst", false},
+		{"directory", "s3://bucket/{host}/", "s3://bucket/" + host + "/app_data-20240309T030506Z.tar.zst", false},
+		{"unknown placeholder", "/backups/{date}.tar", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandDestination(tt.template, vars)
+			if tt.shouldErr {
+				if err == nil {
+					t.Errorf("ExpandDestination(%q) expected error but got %q", tt.template, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExpandDestination(%q) unexpected error: %v", tt.template, err)
+			}
+			if got != tt.expected {
+				t.Errorf("ExpandDestination(%q) = %q; want %q", tt.template, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEncryptedDestination(t *testing.T) {
+	vars := DestinationVars{Volume: "app", Compression: "gz", Encrypted: true, Time: time.Date(2024, 3, 9, 3, 5, 6, 0, time.UTC)}
+	got, err := ExpandDestination("/backups/", vars)
+	if err != nil || got != "/backups/app-20240309T030506Z.tar.gz.age" {
+		t.Errorf("ExpandDestination() = %q, %v; want the .age suffix", got, err)
+	}
+
+}
@@ -0,0 +1,374 @@
+package operation
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/crypt"
+	"docker-volume-backup/internal/docker"
+	"docker-volume-backup/internal/rw"
+	"docker-volume-backup/internal/storage"
+)
+
+// DumpLabel on a container using a volume names the database it runs,
+// postgres, mysql or mongo, for images that are not recognised. Set to
+// "false" the container is never dumped.
+const DumpLabel = "docker-volume-backup.dump"
+
+// replayFile is where a dump is uploaded in the container it is replayed into.
+const replayFile = "/tmp/docker-volume-backup.dump"
+
+// dumpEngine is a database whose native tools dump and replay it.
+type dumpEngine struct {
+	name string
+	// images are the image names, without registry and tag, recognised as this database
+	images []string
+	// dump writes the dump to stdout; replay reads it from $DUMP_FILE. Both run
+	// with sh -c in the database container and take credentials from the
+	// environment variables of the official images.
+	dump   string
+	replay string
+}
+
+// mongoAuth sets the positional parameters to the credentials of the root user,
+// if any. Like the entrypoints of the official images, it reads the *_FILE
+// variables, e.g. Docker secrets, when the plain ones are empty.
+const mongoAuth = `set --; user="$MONGO_INITDB_ROOT_USERNAME"; pass="$MONGO_INITDB_ROOT_PASSWORD"; ` +
+	`if [ -z "$user" ] && [ -n "$MONGO_INITDB_ROOT_USERNAME_FILE" ]; then user="$(cat "$MONGO_INITDB_ROOT_USERNAME_FILE")"; fi; ` +
+	`if [ -z "$pass" ] && [ -n "$MONGO_INITDB_ROOT_PASSWORD_FILE" ]; then pass="$(cat "$MONGO_INITDB_ROOT_PASSWORD_FILE")"; fi; ` +
+	`if [ -n "$user" ]; then set -- --username "$user" --password "$pass" --authenticationDatabase admin; fi; `
+
+// mysqlAuth passes the root password to the MySQL and MariaDB clients, read
+// from the *_FILE variables if the plain ones are empty.
+const mysqlAuth = `pass="${MARIADB_ROOT_PASSWORD:-$MYSQL_ROOT_PASSWORD}"; file="${MARIADB_ROOT_PASSWORD_FILE:-$MYSQL_ROOT_PASSWORD_FILE}"; ` +
+	`if [ -z "$pass" ] && [ -n "$file" ]; then pass="$(cat "$file")"; fi; export MYSQL_PWD="$pass"; `
+
+var dumpEngines = []dumpEngine{
+	{
+		name:   "postgres",
+		images: []string{"postgres", "postgresql", "postgis", "timescaledb", "timescaledb-ha"},
+		dump:   `exec pg_dumpall -U "${POSTGRES_USER:-postgres}"`,
+		replay: `exec psql -X -q -U "${POSTGRES_USER:-postgres}" -d postgres -f "$DUMP_FILE"`,
+	},
+	{
+		name:   "mysql",
+		images: []string{"mysql", "mysql-server", "mariadb", "percona", "percona-server"},
+		dump:   mysqlAuth + `exec "$(command -v mariadb-dump || command -v mysqldump)" -uroot --all-databases --single-transaction --routines --events --triggers`,
+		replay: mysqlAuth + `exec "$(command -v mariadb || command -v mysql)" -uroot < "$DUMP_FILE"`,
+	},
+	{
+		name:   "mongo",
+		images: []string{"mongo", "mongodb", "mongodb-community-server", "mongodb-enterprise-server"},
+		dump:   mongoAuth + `exec mongodump --archive --quiet "$@"`,
+		replay: mongoAuth + `exec mongorestore --archive="$DUMP_FILE" --drop "$@"`,
+	},
+}
+
+// findEngine returns the engine called name, or nil if there is none.
+func findEngine(name string) *dumpEngine {
+	for i := range dumpEngines {
+		if dumpEngines[i].name == name {
+			return &dumpEngines[i]
+		}
+	}
+	return nil
+}
+
+// imageName returns the name of an image reference without registry, tag or
+// digest, e.g. "postgis" for "docker.io/postgis/postgis:16-3.4".
+func imageName(ref string) string {
+	name, _, _ := strings.Cut(ref, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	return path.Base(name)
+}
+
+// containerEngine returns the database a container runs according to its
+// DumpLabel or else its image, or nil if it runs no known database.
+func containerEngine(c docker.Container) (*dumpEngine, error) {
+	if value := c.Labels[DumpLabel]; value != "" {
+		if enabled, err := strconv.ParseBool(value); err == nil && !enabled {
+			return nil, nil
+		}
+		engine := findEngine(value)
+		if engine == nil {
+			return nil, fmt.Errorf("invalid %s label '%s' on container '%s' (use postgres, mysql, mongo or false)", DumpLabel, value, c.Name())
+		}
+		return engine, nil
+	}
+	name := imageName(c.Image)
+	for i := range dumpEngines {
+		if slices.Contains(dumpEngines[i].images, name) {
+			return &dumpEngines[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// dumpTarget is a database container and the engine that dumps it.
+type dumpTarget struct {
+	container docker.Container
+	engine    *dumpEngine
+}
+
+// findDumpTarget returns the running database container using volume, or nil
+// if there is none. Several database containers are an error, as it is
+// unclear which one owns the volume.
+func findDumpTarget(ctx context.Context, volume string) (*dumpTarget, error) {
+	containers, err := docker.ListContainersUsingVolume(ctx, volume)
+	if err != nil {
+		return nil, err
+	}
+	var found []dumpTarget
+	for _, c := range containers {
+		if c.State != "running" {
+			continue
+		}
+		engine, err := containerEngine(c)
+		if err != nil {
+			return nil, err
+		}
+		if engine != nil {
+			found = append(found, dumpTarget{c, engine})
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &found[0], nil
+	}
+	var names []string
+	for _, t := range found {
+		names = append(names, t.container.Name())
+	}
+	return nil, fmt.Errorf("several database containers use volume '%s' (%s), set %s=false on all but one", volume, strings.Join(names, ", "), DumpLabel)
+}
+
+// dumpDatabase dumps the database running in a container using volume to the
+// dump object of the archive key, compressed and encrypted like the archive.
+// It returns nil if no database container uses the volume.
+func dumpDatabase(ctx context.Context, volume string, backend storage.Backend, key string, compression string, keys *crypt.Keys) (*archive.Dump, error) {
+	target, err := findDumpTarget(ctx, volume)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		log.Printf("No database container uses volume '%s', backing it up without a dump", volume)
+		return nil, nil
+	}
+	name := target.container.Name()
+	log.Printf("Dumping %s database of container '%s'", target.engine.name, name)
+	dump, err := writeDump(ctx, backend, archive.DumpKey(key), target, compression, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump database of container '%s': %w", name, err)
+	}
+	log.Printf("Dumped %d bytes of %s database, stored as %d bytes", dump.UncompressedSize, dump.Engine, dump.Size)
+	return dump, nil
+}
+
+// writeDump streams the output of the engine's dump command through
+// compression and encryption to the object key. The object is discarded if
+// the command fails.
+func writeDump(ctx context.Context, backend storage.Backend, key string, target *dumpTarget, compression string, keys *crypt.Keys) (*archive.Dump, error) {
+	out, err := backend.Create(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	stored := rw.NewDigestWriter(out)
+	var encrypted io.Writer = stored
+	var encrypter io.WriteCloser
+	if keys.Encrypts() {
+		if encrypter, err = rw.CreateEncryptWriter(stored, keys.Recipients); err != nil {
+			out.Abort()
+			return nil, err
+		}
+		encrypted = encrypter
+	}
+	writer, err := rw.CreateWriter(encrypted, compression)
+	if err != nil {
+		out.Abort()
+		return nil, fmt.Errorf("failed to create compressed writer: %w", err)
+	}
+	raw := rw.NewDigestWriter(writer)
+
+	var stderr bytes.Buffer
+	code, err := docker.ExecStreams(ctx, target.container.ID, []string{"sh", "-c", target.engine.dump}, nil, raw, &stderr)
+	logOutput(&stderr)
+	switch {
+	case err != nil:
+	case code != 0:
+		err = fmt.Errorf("dump command exited with status %d", code)
+	case raw.Size() == 0:
+		err = fmt.Errorf("dump is empty")
+	default:
+		err = writer.Close()
+		if err == nil && encrypter != nil {
+			err = encrypter.Close()
+		}
+	}
+	if err != nil {
+		if abortErr := out.Abort(); abortErr != nil {
+			log.Printf("Warning: failed to discard incomplete dump: %v", abortErr)
+		}
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	return &archive.Dump{
+		Engine:           target.engine.name,
+		Container:        target.container.Name(),
+		Image:            target.container.Image,
+		Size:             stored.Size(),
+		UncompressedSize: raw.Size(),
+		SHA256:           stored.Sum(),
+	}, nil
+}
+
+// removeDump removes the dump of an archive that was not completed, even if ctx was cancelled.
+func removeDump(ctx context.Context, backend storage.Backend, key string) {
+	ctx, cancel := docker.CleanupContext(ctx)
+	defer cancel()
+	if err := backend.Delete(ctx, archive.DumpKey(key)); err != nil && !errors.Is(err, storage.ErrNotExist) {
+		log.Printf("Warning: failed to remove database dump: %v", err)
+	}
+}
+
+// ReplayDump replays the database dump stored next to the archive at src
+// into a running container of the same kind of database, with the database's
+// own client. The dump is uploaded into the container and checked against its
+// recorded checksum before it is replayed, and removed again afterwards.
+// Postgres and MySQL dumps are replayed on top of the existing databases,
+// Mongo dumps replace the collections they contain.
+func ReplayDump(ctx context.Context, src, container string, keys *crypt.Keys) error {
+	backend, key, err := storage.Resolve(ctx, src)
+	if err != nil {
+		return err
+	}
+	manifest, err := archive.ReadManifest(ctx, backend, key, keys.Decrypters())
+	if err != nil {
+		return err
+	}
+	if manifest == nil || manifest.Dump == nil {
+		return fmt.Errorf("%s has no database dump", src)
+	}
+	dump := manifest.Dump
+	engine := findEngine(dump.Engine)
+	if engine == nil {
+		return fmt.Errorf("unsupported database '%s' in %s, upgrade docker-volume-backup", dump.Engine, src)
+	}
+	log.Printf("Replaying %s dump of container '%s' taken at %s into container '%s'",
+		dump.Engine, dump.Container, manifest.CreatedAt.Format(time.RFC3339), container)
+
+	if err := uploadDump(ctx, backend, key, manifest, container, keys); err != nil {
+		return err
+	}
+	defer removeReplayFile(ctx, container)
+
+	var output bytes.Buffer
+	code, err := docker.Exec(ctx, container, []string{"sh", "-c", engine.replay}, []string{"DUMP_FILE=" + replayFile}, &output)
+	logOutput(&output)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("replaying the dump failed with exit status %d", code)
+	}
+	log.Printf("Successfully replayed the dump of %s into container '%s'", src, container)
+	return nil
+}
+
+// uploadDump decrypts and decompresses the dump of the archive key into
+// replayFile in the container, and checks the stored dump against its
+// recorded checksum.
+func uploadDump(ctx context.Context, backend storage.Backend, key string, manifest *archive.Manifest, container string, keys *crypt.Keys) error {
+	in, err := backend.Open(ctx, archive.DumpKey(key))
+	if errors.Is(err, storage.ErrNotExist) {
+		return fmt.Errorf("the database dump %s is missing", archive.DumpKey(key))
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	digest := rw.NewDigestWriter(io.Discard)
+	stored := io.TeeReader(in, digest)
+	reader, err := decompress(stored, "", manifest.Compression, keys)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	// The archive upload needs the size up front, which the manifest records
+	pr, pw := io.Pipe()
+	copyErr := make(chan error, 1)
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := tw.WriteHeader(&tar.Header{
+			Name:     path.Base(replayFile),
+			Typeflag: tar.TypeReg,
+			Mode:     0o600,
+			Size:     manifest.Dump.UncompressedSize,
+			ModTime:  manifest.CreatedAt,
+		})
+		if err == nil {
+			_, err = io.Copy(tw, reader)
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to read database dump: %w", err)
+		}
+		pw.CloseWithError(err)
+		copyErr <- err
+	}()
+	uploadErr := docker.CopyToContainer(ctx, container, path.Dir(replayFile), pr)
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err := <-copyErr; err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		return err
+	}
+	if uploadErr != nil {
+		return uploadErr
+	}
+
+	// Read up to the end of the object, so that the digest covers every stored byte
+	if _, err := io.Copy(io.Discard, stored); err != nil {
+		return fmt.Errorf("failed to read database dump: %w", err)
+	}
+	if digest.Sum() != manifest.Dump.SHA256 {
+		removeReplayFile(ctx, container)
+		return fmt.Errorf("database dump checksum mismatch: got %s, want %s", digest.Sum(), manifest.Dump.SHA256)
+	}
+	return nil
+}
+
+// removeReplayFile removes an uploaded dump from the container, even if ctx was cancelled.
+func removeReplayFile(ctx context.Context, container string) {
+	ctx, cancel := docker.CleanupContext(ctx)
+	defer cancel()
+	if _, err := docker.Exec(ctx, container, []string{"rm", "-f", replayFile}, nil, io.Discard); err != nil {
+		log.Printf("Warning: failed to remove %s from container '%s': %v", replayFile, container, err)
+	}
+}
+
+// logOutput logs the output of a command run in a container line by line.
+func logOutput(output io.Reader) {
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		log.Printf("  | %s", scanner.Text())
+	}
+}
@@ -0,0 +1,139 @@
+package operation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/docker"
+	"docker-volume-backup/internal/storage"
+)
+
+func TestImageName(t *testing.T) {
+	tests := map[string]string{
+		"postgres":                           "postgres",
+		"postgres:16-alpine":                 "postgres",
+		"docker.io/postgis/postgis:16-3.4":   "postgis",
+		"localhost:5000/mariadb":             "mariadb",
+		"mongo@sha256:0123456789abcdef":      "mongo",
+		"registry.example.com:443/mysql:8.4": "mysql",
+	}
+	for ref, want := range tests {
+		if got := imageName(ref); got != want {
+			t.Errorf("imageName(%q) = %q; want %q", ref, got, want)
+		}
+	}
+}
+
+func TestContainerEngine(t *testing.T) {
+	container := func(image string, labels map[string]string) docker.Container {
+		return docker.Container{ID: "c1", Names: []string{"/shop-db-1"}, Image: image, Labels: labels}
+	}
+	tests := []struct {
+		name      string
+		container docker.Container
+		expected  string
+	}{
+		{"postgres image", container("postgres:16", nil), "postgres"},
+		{"mariadb image", container("mariadb:11", nil), "mysql"},
+		{"mongo image", container("mongo:7", nil), "mongo"},
+		{"unknown image", container("redis:7", nil), ""},
+		{"label", container("registry.example.com/our-db:1", map[string]string{DumpLabel: "postgres"}), "postgres"},
+		{"opted out", container("postgres:16", map[string]string{DumpLabel: "false"}), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := containerEngine(tt.container)
+			if err != nil {
+				t.Fatalf("containerEngine() error: %v", err)
+			}
+			got := ""
+			if engine != nil {
+				got = engine.name
+			}
+			if got != tt.expected {
+				t.Errorf("containerEngine() = %q; want %q", got, tt.expected)
+			}
+		})
+	}
+
+	_, err := containerEngine(container("postgres:16", map[string]string{DumpLabel: "oracle"}))
+	if err == nil || !strings.Contains(err.Error(), "invalid "+DumpLabel) {
+		t.Errorf("containerEngine(oracle) error = %v; want invalid label", err)
+	}
+}
+
+func TestVerifyDump(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db.tar.gz")
+	m := createArchive(t, path, "db", "gz", map[string]string{"PG_VERSION": "16"})
+	dump := []byte("stored dump")
+	sum := sha256.Sum256(dump)
+	m.Dump = &archive.Dump{Engine: "postgres", Container: "shop-db-1", Size: int64(len(dump)), SHA256: hex.EncodeToString(sum[:])}
+	if err := archive.WriteSidecar(ctx, storage.FileBackend{}, path, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(archive.DumpKey(path), dump, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(ctx, path, nil, false); err != nil {
+		t.Errorf("Verify() error: %v", err)
+	}
+
+	os.WriteFile(archive.DumpKey(path), []byte("changed dump"), 0o644)
+	if err := Verify(ctx, path, nil, false); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Errorf("Verify() with a changed dump error = %v; want corrupt archive", err)
+	}
+
+	os.Remove(archive.DumpKey(path))
+	if err := Verify(ctx, path, nil, false); err == nil {
+		t.Error("Verify() without the dump expected error but got none")
+	}
+}
+
+func TestDumpAuth(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell")
+	}
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		auth     string
+		env      []string
+		expected string
+	}{
+		{"mysql", mysqlAuth, []string{"MYSQL_ROOT_PASSWORD=pw"}, "pw"},
+		{"mariadb first", mysqlAuth, []string{"MARIADB_ROOT_PASSWORD=maria", "MYSQL_ROOT_PASSWORD=pw"}, "maria"},
+		{"mysql file", mysqlAuth, []string{"MYSQL_ROOT_PASSWORD_FILE=" + secret}, "s3cret"},
+		{"mariadb file", mysqlAuth, []string{"MARIADB_ROOT_PASSWORD_FILE=" + secret}, "s3cret"},
+		{"mysql variable over file", mysqlAuth, []string{"MYSQL_ROOT_PASSWORD=pw", "MYSQL_ROOT_PASSWORD_FILE=" + secret}, "pw"},
+		{"mysql none", mysqlAuth, nil, ""},
+		{"mongo", mongoAuth, []string{"MONGO_INITDB_ROOT_USERNAME=root", "MONGO_INITDB_ROOT_PASSWORD=pw"}, "--username root --password pw --authenticationDatabase admin"},
+		{"mongo files", mongoAuth, []string{"MONGO_INITDB_ROOT_USERNAME_FILE=" + secret, "MONGO_INITDB_ROOT_PASSWORD_FILE=" + secret}, "--username s3cret --password s3cret --authenticationDatabase admin"},
+		{"mongo password file", mongoAuth, []string{"MONGO_INITDB_ROOT_USERNAME=root", "MONGO_INITDB_ROOT_PASSWORD_FILE=" + secret}, "--username root --password s3cret --authenticationDatabase admin"},
+		{"mongo none", mongoAuth, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Print what the dump command would get: the mysql password or the mongo arguments
+			cmd := exec.Command("sh", "-c", tt.auth+`printf '%s' "$MYSQL_PWD$*"`)
+			cmd.Env = append([]string{"PATH=" + os.Getenv("PATH")}, tt.env...)
+			out, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("auth script error: %v", err)
+			}
+			if string(out) != tt.expected {
+				t.Errorf("auth script gave %q; want %q", out, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package operation
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/docker"
+)
+
+// Fidelity guarantee. Backups and restores keep, for every entry the Engine
+// API archives: the kind (regular file, directory, symlink, hardlink,
+// character or block device, fifo), content, permission bits including
+// setuid, setgid and sticky, numeric owner and group, modification time,
+// symlink and hardlink targets, device numbers, and the extended attributes
+// the Engine API reads, which is security.capability. Sparse files keep their
+// content but are restored fully allocated, and sockets are never archived by
+// the Engine API. Everything else that is left out or changed is reported as
+// an issue, and strict mode fails on the first one.
+
+// fidelityReport collects the fidelity of a backup or restore.
+type fidelityReport struct {
+	*archive.Fidelity
+	strict bool
+}
+
+func newFidelityReport(strict bool) *fidelityReport {
+	return &fidelityReport{Fidelity: archive.NewFidelity(), strict: strict}
+}
+
+// lose records an entry that was dropped or changed. In strict mode the loss is
+// returned as an error.
+func (r *fidelityReport) lose(issue archive.FidelityIssue) error {
+	r.Report(issue)
+	if r.strict {
+		return fmt.Errorf("strict mode: %s", issue)
+	}
+	log.Printf("Warning: %s", issue)
+	return nil
+}
+
+// restoredEntries tracks the entries a restore writes into the volume, across
+// the archives of an incremental chain, to report the fidelity of the restore
+// and, in strict mode, to check the volume against them afterwards.
+type restoredEntries struct {
+	report  *fidelityReport
+	headers map[string]*tar.Header
+}
+
+func newRestoredEntries(strict bool) *restoredEntries {
+	return &restoredEntries{report: newFidelityReport(strict), headers: map[string]*tar.Header{}}
+}
+
+// lose records a dropped or changed entry; without tracking it is only logged.
+func (e *restoredEntries) lose(issue archive.FidelityIssue) error {
+	if e == nil {
+		log.Printf("Warning: %s", issue)
+		return nil
+	}
+	return e.report.lose(issue)
+}
+
+// add records an entry written into the volume.
+func (e *restoredEntries) add(header *tar.Header) {
+	if e != nil {
+		e.headers[indexPath(header.Name)] = header
+	}
+}
+
+// remove forgets a path that was deleted from the volume, with its contents.
+func (e *restoredEntries) remove(name string) {
+	if e == nil {
+		return
+	}
+	maps.DeleteFunc(e.headers, func(path string, _ *tar.Header) bool {
+		return path == name || strings.HasPrefix(path, name+"/")
+	})
+}
+
+// finish counts the restored entries into the report and returns it.
+func (e *restoredEntries) finish() *archive.Fidelity {
+	for _, header := range e.headers {
+		e.report.Add(header)
+	}
+	return e.report.Fidelity
+}
+
+// checkVolume reads the entries of the volume back and reports every restored
+// entry whose metadata the daemon did not apply, e.g. extended attributes on a
+// file system without support for them, or devices in a user namespace.
+func (e *restoredEntries) checkVolume(ctx context.Context, volume string) error {
+	log.Printf("Checking the metadata of %d restored entries", len(e.headers))
+	containerID, err := docker.CreateContainerWithVolume(ctx, volume)
+	if err != nil {
+		return fmt.Errorf("failed to create temp container: %w", err)
+	}
+	defer removeContainer(ctx, containerID)
+	stream, err := docker.CopyFromContainer(ctx, containerID, "/data/.")
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	actual := map[string]*tar.Header{}
+	tarReader := tar.NewReader(stream)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar header: %w", err)
+		}
+		if name := indexPath(header.Name); e.headers[name] != nil {
+			actual[name] = header
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(e.headers)) {
+		if name == "" || name == "." {
+			continue
+		}
+		got, ok := actual[name]
+		if !ok {
+			if err := e.lose(archive.FidelityIssue{Path: name, Dropped: true, Reason: "missing from the volume after the restore"}); err != nil {
+				return err
+			}
+			continue
+		}
+		if reason := compareEntry(e.headers[name], got); reason != "" {
+			if err := e.lose(archive.FidelityIssue{Path: name, Reason: reason}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compareEntry returns how an entry read back from a volume differs from the
+// entry restored into it, or "" if it has the same metadata. A hardlink may be
+// read back as the file it links to and the other way round, as which of the
+// paths comes first depends on the order the volume is read in.
+func compareEntry(want, got *tar.Header) string {
+	wantKind, gotKind := archive.EntryKind(want), archive.EntryKind(got)
+	linked := func(kind string) bool { return kind == archive.KindFile || kind == archive.KindHardlink }
+	switch {
+	case wantKind != gotKind && !(linked(wantKind) && linked(gotKind)):
+		return fmt.Sprintf("restored as a %s instead of a %s", gotKind, wantKind)
+	case want.Mode&0o7777 != got.Mode&0o7777:
+		return fmt.Sprintf("mode %04o instead of %04o", got.Mode&0o7777, want.Mode&0o7777)
+	case want.Uid != got.Uid || want.Gid != got.Gid:
+		return fmt.Sprintf("owner %d:%d instead of %d:%d", got.Uid, got.Gid, want.Uid, want.Gid)
+	case wantKind == archive.KindSymlink && want.Linkname != got.Linkname:
+		return fmt.Sprintf("symlink to '%s' instead of '%s'", got.Linkname, want.Linkname)
+	case (wantKind == archive.KindChar || wantKind == archive.KindBlock) && (want.Devmajor != got.Devmajor || want.Devminor != got.Devminor):
+		return fmt.Sprintf("device %d:%d instead of %d:%d", got.Devmajor, got.Devminor, want.Devmajor, want.Devminor)
+	case wantKind == archive.KindFile && gotKind == archive.KindFile && want.Size != got.Size:
+		return fmt.Sprintf("size %d instead of %d", got.Size, want.Size)
+	case wantKind == archive.KindFile && !want.ModTime.Truncate(time.Second).Equal(got.ModTime.Truncate(time.Second)):
+		return fmt.Sprintf("modification time %s instead of %s", got.ModTime.UTC(), want.ModTime.UTC())
+	}
+	gotXattrs := archive.Xattrs(got)
+	for name, value := range archive.Xattrs(want) {
+		if current, ok := gotXattrs[name]; !ok {
+			return fmt.Sprintf("extended attribute %s is missing", name)
+		} else if current != value {
+			return fmt.Sprintf("extended attribute %s has another value", name)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,213 @@
+package operation
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"docker-volume-backup/internal/archive"
+)
+
+const capability = "\x01\x00\x00\x02\x00\x20\x00\x00"
+
+// specialVolumeTar returns a volume's tar stream with an entry of every kind
+// the fidelity guarantee covers, plus a socket-like entry of an unknown type.
+func specialVolumeTar(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	mtime := time.Date(2024, 3, 9, 3, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	headers := []*tar.Header{
+		{Name: "./", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "./bin/", Typeflag: tar.TypeDir, Mode: 0o755, Uid: 999, Gid: 999},
+		{Name: "./bin/ping", Typeflag: tar.TypeReg, Mode: 0o4755, Uid: 999, Gid: 999, Size: 4, ModTime: mtime,
+			PAXRecords: map[string]string{"SCHILY.xattr.security.capability": capability}},
+		{Name: "./sbin/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "./sbin/ping", Typeflag: tar.TypeLink, Linkname: "bin/ping", Mode: 0o4755, Uid: 999, Gid: 999},
+		{Name: "./dev/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "./dev/null", Typeflag: tar.TypeChar, Mode: 0o666, Devmajor: 1, Devminor: 3},
+		{Name: "./run/", Typeflag: tar.TypeDir, Mode: 0o1777},
+		{Name: "./run/queue", Typeflag: tar.TypeFifo, Mode: 0o600},
+		{Name: "./run/current", Typeflag: tar.TypeSymlink, Linkname: "queue"},
+		{Name: "./run/other", Typeflag: 'Z', Mode: 0o600},
+	}
+	for _, header := range headers {
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			tw.Write([]byte("ping"))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// readHeaders returns the volume entries of an uncompressed archive by path.
+func readHeaders(t *testing.T, r io.Reader) map[string]*tar.Header {
+	t.Helper()
+	headers := map[string]*tar.Header{}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return headers
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !archive.IsReserved(header.Name) {
+			headers[indexPath(header.Name)] = header
+		}
+	}
+}
+
+func TestWriteArchiveFidelity(t *testing.T) {
+	var buf bytes.Buffer
+	m := archive.NewManifest(archive.Volume{Name: "app"}, "none")
+	if _, err := writeArchive(&buf, specialVolumeTar(t), m, archiveOptions{compression: "none"}); err != nil {
+		t.Fatalf("writeArchive() error: %v", err)
+	}
+	headers := readHeaders(t, &buf)
+
+	ping := headers["bin/ping"]
+	if ping == nil || ping.Mode != 0o4755 || ping.Uid != 999 || archive.Xattrs(ping)["security.capability"] != capability {
+		t.Errorf("bin/ping = %+v; want setuid, owner 999 and its capability", ping)
+	}
+	if link := headers["sbin/ping"]; link == nil || link.Typeflag != tar.TypeLink || link.Linkname != "bin/ping" {
+		t.Errorf("sbin/ping = %+v; want a hardlink to bin/ping", link)
+	}
+	if dev := headers["dev/null"]; dev == nil || dev.Typeflag != tar.TypeChar || dev.Devmajor != 1 || dev.Devminor != 3 {
+		t.Errorf("dev/null = %+v; want character device 1:3", dev)
+	}
+	if fifo := headers["run/queue"]; fifo == nil || fifo.Typeflag != tar.TypeFifo {
+		t.Errorf("run/queue = %+v; want a fifo", fifo)
+	}
+	if _, ok := headers["run/other"]; ok {
+		t.Error("Archive contains run/other, whose entry type is not supported")
+	}
+
+	f := m.Fidelity
+	if f == nil {
+		t.Fatal("Manifest has no fidelity report")
+	}
+	if f.Entries[archive.KindHardlink] != 1 || f.Entries[archive.KindChar] != 1 || f.Entries[archive.KindFifo] != 1 || f.Xattrs["security.capability"] != 1 {
+		t.Errorf("Fidelity = %s", f)
+	}
+	if f.Dropped != 1 || len(f.Issues) != 1 || f.Issues[0].Path != "./run/other" {
+		t.Errorf("Fidelity issues = %v; want run/other dropped", f.Issues)
+	}
+
+	_, err := writeArchive(io.Discard, specialVolumeTar(t), archive.NewManifest(archive.Volume{Name: "app"}, "none"), archiveOptions{compression: "none", strict: true})
+	if err == nil || !strings.Contains(err.Error(), "strict mode: dropped './run/other'") {
+		t.Errorf("writeArchive() in strict mode error = %v; want run/other dropped", err)
+	}
+}
+
+func TestWriteArchiveCopiesHardlinksToExcludedFiles(t *testing.T) {
+	refetch := func(name string) (io.ReadCloser, error) {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Name: "ping", Typeflag: tar.TypeReg, Mode: 0o4755, Uid: 999, Gid: 999, Size: 4})
+		tw.Write([]byte("ping"))
+		tw.Close()
+		return io.NopCloser(&buf), nil
+	}
+	match := func(name string, _ bool) bool { return !strings.HasPrefix(name, "bin") && name != "run/other" }
+
+	var buf bytes.Buffer
+	m := archive.NewManifest(archive.Volume{Name: "app"}, "none")
+	if _, err := writeArchive(&buf, specialVolumeTar(t), m, archiveOptions{compression: "none", match: match, refetch: refetch}); err != nil {
+		t.Fatalf("writeArchive() error: %v", err)
+	}
+	headers := readHeaders(t, &buf)
+	if copied := headers["sbin/ping"]; copied == nil || copied.Typeflag != tar.TypeReg || copied.Size != 4 || copied.Mode != 0o4755 {
+		t.Errorf("sbin/ping = %+v; want a copy of bin/ping", copied)
+	}
+	if m.Fidelity.Changed != 1 || m.Fidelity.Dropped != 0 {
+		t.Errorf("Fidelity = %s; want sbin/ping changed", m.Fidelity)
+	}
+
+	_, err := writeArchive(io.Discard, specialVolumeTar(t), archive.NewManifest(archive.Volume{Name: "app"}, "none"),
+		archiveOptions{compression: "none", match: match, refetch: refetch, strict: true})
+	if err == nil || !strings.Contains(err.Error(), "hardlink target") {
+		t.Errorf("writeArchive() in strict mode error = %v; want the copied hardlink", err)
+	}
+}
+
+func TestCopyTarDropsHardlinksToExcludedFiles(t *testing.T) {
+	var src bytes.Buffer
+	m := archive.NewManifest(archive.Volume{Name: "app"}, "none")
+	if _, err := writeArchive(&src, specialVolumeTar(t), m, archiveOptions{compression: "none"}); err != nil {
+		t.Fatalf("writeArchive() error: %v", err)
+	}
+
+	var out bytes.Buffer
+	entries := newRestoredEntries(false)
+	filter := PathFilter{Exclude: []string{"bin"}}
+	if _, _, err := copyTar(tar.NewReader(bytes.NewReader(src.Bytes())), &out, filter, entries, nil); err != nil {
+		t.Fatalf("copyTar() error: %v", err)
+	}
+	if _, ok := readHeaders(t, &out)["sbin/ping"]; ok {
+		t.Error("copyTar() kept sbin/ping, whose hardlink target is excluded")
+	}
+	f := entries.finish()
+	if f.Dropped != 1 || f.Issues[0].Path != "./sbin/ping" || f.Entries[archive.KindFifo] != 1 {
+		t.Errorf("Fidelity = %s, issues %v; want sbin/ping dropped", f, f.Issues)
+	}
+
+	_, _, err := copyTar(tar.NewReader(bytes.NewReader(src.Bytes())), io.Discard, filter, newRestoredEntries(true), nil)
+	if err == nil || !strings.Contains(err.Error(), "strict mode") {
+		t.Errorf("copyTar() in strict mode error = %v; want sbin/ping dropped", err)
+	}
+}
+
+func TestRestoredEntriesRemove(t *testing.T) {
+	entries := newRestoredEntries(false)
+	for _, name := range []string{"./a/", "./a/b.txt", "./ab.txt"} {
+		entries.add(&tar.Header{Name: name, Typeflag: tar.TypeReg})
+	}
+	entries.remove("a")
+	if len(entries.headers) != 1 || entries.headers["ab.txt"] == nil {
+		t.Errorf("remove(a) left %v; want ab.txt", entries.headers)
+	}
+}
+
+func TestCompareEntry(t *testing.T) {
+	mtime := time.Date(2024, 3, 9, 3, 0, 0, 0, time.UTC)
+	file := &tar.Header{Name: "bin/ping", Typeflag: tar.TypeReg, Mode: 0o4755, Uid: 999, Gid: 999, Size: 4, ModTime: mtime,
+		PAXRecords: map[string]string{"SCHILY.xattr.security.capability": capability}}
+	with := func(change func(h *tar.Header)) *tar.Header {
+		h := *file
+		h.PAXRecords = map[string]string{"SCHILY.xattr.security.capability": capability}
+		change(&h)
+		return &h
+	}
+	tests := []struct {
+		name     string
+		got      *tar.Header
+		expected string
+	}{
+		{"same", with(func(h *tar.Header) {}), ""},
+		{"sub-second mtime", with(func(h *tar.Header) { h.ModTime = mtime.Add(time.Millisecond) }), ""},
+		{"read back as hardlink", with(func(h *tar.Header) { h.Typeflag, h.Size, h.Linkname = tar.TypeLink, 0, "sbin/ping" }), ""},
+		{"kind", with(func(h *tar.Header) { h.Typeflag = tar.TypeFifo }), "restored as a fifo instead of a file"},
+		{"setuid lost", with(func(h *tar.Header) { h.Mode = 0o755 }), "mode 0755 instead of 4755"},
+		{"owner", with(func(h *tar.Header) { h.Uid = 0 }), "owner 0:999 instead of 999:999"},
+		{"size", with(func(h *tar.Header) { h.Size = 0 }), "size 0 instead of 4"},
+		{"xattr missing", with(func(h *tar.Header) { h.PAXRecords = nil }), "extended attribute security.capability is missing"},
+		{"xattr value", with(func(h *tar.Header) { h.PAXRecords["SCHILY.xattr.security.capability"] = "x" }), "extended attribute security.capability has another value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareEntry(file, tt.got); got != tt.expected {
+				t.Errorf("compareEntry() = %q; want %q", got, tt.expected)
+			}
+		})
+	}
+}
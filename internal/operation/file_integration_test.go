@@ -4,12 +4,18 @@
 package operation
 
 import (
+	"context"
+	"errors"
 	"os"
 	"os/exec"
 	"strings"
 	"testing"
+	"time"
 
+	"docker-volume-backup/internal/crypt"
 	"docker-volume-backup/internal/docker"
+	"docker-volume-backup/internal/repo"
+	"docker-volume-backup/internal/rw"
 )
 
 func TestBackupAndRestoreWorkflow(t *testing.T) {
@@ -42,7 +48,7 @@ func TestBackupAndRestoreWorkflow(t *testing.T) {
 			exec.Command("docker", "volume", "rm", volumeName).Run()
 
 			// Create test volume and write data to it
-			if err := docker.CreateVolume(volumeName); err != nil {
+			if err := docker.CreateVolume(context.Background(), volumeName); err != nil {
 				t.Fatalf("CreateVolume() error: %v", err)
 			}
 			defer exec.Command("docker", "volume", "rm", volumeName).Run()
@@ -58,12 +64,12 @@ func TestBackupAndRestoreWorkflow(t *testing.T) {
 			}
 
 			// Backup the volume with specific compression
-			bkpOp, err := NewBackup(volumeName, tt.compress, false)
+			bkpOp, err := NewBackup(context.Background(), volumeName, tt.compress, PathFilter{}, nil, false)
 			if err != nil {
 				t.Fatalf("NewBackup() error: %v", err)
 			}
-			if err := bkpOp.runBackup(backupFile); err != nil {
-				t.Fatalf("runBackup() error: %v", err)
+			if err := bkpOp.BackupTo(context.Background(), backupFile); err != nil {
+				t.Fatalf("BackupTo() error: %v", err)
 			}
 
 			// Verify backup file exists
@@ -78,16 +84,16 @@ func TestBackupAndRestoreWorkflow(t *testing.T) {
 			}
 
 			// Restore the volume
-			if err := docker.EnsureVolumeExists(volumeName); err != nil {
+			if err := docker.EnsureVolumeExists(context.Background(), volumeName); err != nil {
 				t.Fatalf("EnsureVolumeExists() error: %v", err)
 			}
 
-			restoreOp, err := NewRestore(volumeName, false)
+			restoreOp, err := NewRestore(volumeName, PathFilter{}, nil, false)
 			if err != nil {
 				t.Fatalf("NewRestore() error: %v", err)
 			}
-			if err := restoreOp.runRestore(backupFile); err != nil {
-				t.Fatalf("runRestore() error: %v", err)
+			if err := restoreOp.RestoreFrom(context.Background(), backupFile, RestoreOverwrite); err != nil {
+				t.Fatalf("RestoreFrom() error: %v", err)
 			}
 
 			// Verify data was restored
@@ -136,7 +142,7 @@ func TestRestoreToExistingVolumeWithoutOverwrite(t *testing.T) {
 			exec.Command("docker", "volume", "rm", volumeName).Run()
 
 			// Create volume with existing data
-			if err := docker.CreateVolume(volumeName); err != nil {
+			if err := docker.CreateVolume(context.Background(), volumeName); err != nil {
 				t.Fatalf("CreateVolume() error: %v", err)
 			}
 			defer exec.Command("docker", "volume", "rm", volumeName).Run()
@@ -150,22 +156,22 @@ func TestRestoreToExistingVolumeWithoutOverwrite(t *testing.T) {
 			}
 
 			// Create a different backup
-			backupOp, err := NewBackup(volumeName, tt.compress, false)
+			backupOp, err := NewBackup(context.Background(), volumeName, tt.compress, PathFilter{}, nil, false)
 			if err != nil {
 				t.Fatalf("NewBackup() error: %v", err)
 			}
-			if err := backupOp.runBackup(backupFile); err != nil {
-				t.Fatalf("runBackup() error: %v", err)
+			if err := backupOp.BackupTo(context.Background(), backupFile); err != nil {
+				t.Fatalf("BackupTo() error: %v", err)
 			}
 
 			// Try to restore without --overwrite flag (should fail)
-			restoreOp, err := NewRestore(volumeName, false)
+			restoreOp, err := NewRestore(volumeName, PathFilter{}, nil, false)
 			if err != nil {
 				t.Fatalf("NewRestore() error: %v", err)
 			}
-			err = restoreOp.RestoreFromFile(backupFile, false)
+			err = restoreOp.RestoreFrom(context.Background(), backupFile, RestoreNew)
 			if err == nil {
-				t.Error("restoreFromFile() should have failed for existing volume without --overwrite")
+				t.Error("RestoreFrom() should have failed for existing volume without --overwrite")
 			}
 			if !strings.Contains(err.Error(), "already exists") {
 				t.Errorf("Error should mention volume already exists, got: %v", err)
@@ -203,7 +209,7 @@ func TestRestoreToExistingVolumeWithOverwrite(t *testing.T) {
 			exec.Command("docker", "volume", "rm", volumeName).Run()
 
 			// Create volume with existing data
-			if err := docker.CreateVolume(volumeName); err != nil {
+			if err := docker.CreateVolume(context.Background(), volumeName); err != nil {
 				t.Fatalf("CreateVolume() error: %v", err)
 			}
 			defer exec.Command("docker", "volume", "rm", volumeName).Run()
@@ -219,7 +225,7 @@ func TestRestoreToExistingVolumeWithOverwrite(t *testing.T) {
 
 			// Create backup with different data from a temp volume
 			tempVolume := "test-temp-backup-volume-xyz123-" + tt.name
-			if err := docker.CreateVolume(tempVolume); err != nil {
+			if err := docker.CreateVolume(context.Background(), tempVolume); err != nil {
 				t.Fatalf("CreateVolume() error: %v", err)
 			}
 			defer exec.Command("docker", "volume", "rm", tempVolume).Run()
@@ -232,21 +238,21 @@ func TestRestoreToExistingVolumeWithOverwrite(t *testing.T) {
 				t.Fatalf("Failed to write backup data: %v", err)
 			}
 
-			backupOp, err := NewBackup(tempVolume, tt.compress, false)
+			backupOp, err := NewBackup(context.Background(), tempVolume, tt.compress, PathFilter{}, nil, false)
 			if err != nil {
 				t.Fatalf("NewBackup() error: %v", err)
 			}
-			if err := backupOp.runBackup(backupFile); err != nil {
-				t.Fatalf("runBackup() error: %v", err)
+			if err := backupOp.BackupTo(context.Background(), backupFile); err != nil {
+				t.Fatalf("BackupTo() error: %v", err)
 			}
 
 			// Restore with --overwrite flag (should succeed and clear old data)
-			restoreOp, err := NewRestore(volumeName, false)
+			restoreOp, err := NewRestore(volumeName, PathFilter{}, nil, false)
 			if err != nil {
 				t.Fatalf("NewRestore() error: %v", err)
 			}
-			if err := restoreOp.RestoreFromFile(backupFile, true); err != nil {
-				t.Fatalf("RestoreFromFile() with --overwrite error: %v", err)
+			if err := restoreOp.RestoreFrom(context.Background(), backupFile, RestoreOverwrite); err != nil {
+				t.Fatalf("RestoreFrom() with --overwrite error: %v", err)
 			}
 
 			// Verify old file is gone and new file exists
@@ -278,7 +284,7 @@ func TestClearVolume(t *testing.T) {
 	exec.Command("docker", "volume", "rm", volumeName).Run()
 
 	// Create volume with multiple files including hidden files
-	if err := docker.CreateVolume(volumeName); err != nil {
+	if err := docker.CreateVolume(context.Background(), volumeName); err != nil {
 		t.Fatalf("CreateVolume() error: %v", err)
 	}
 	defer exec.Command("docker", "volume", "rm", volumeName).Run()
@@ -292,7 +298,7 @@ func TestClearVolume(t *testing.T) {
 	}
 
 	// Clear the volume
-	if err := docker.ClearVolume(volumeName); err != nil {
+	if err := docker.ClearVolume(context.Background(), volumeName); err != nil {
 		t.Fatalf("ClearVolume() error: %v", err)
 	}
 
@@ -311,34 +317,6 @@ func TestClearVolume(t *testing.T) {
 	}
 }
 
-func TestValidateFilePath(t *testing.T) {
-	tests := []struct {
-		name      string
-		path      string
-		shouldErr bool
-	}{
-		{"valid absolute", "/tmp/backup.tar.gz", false},
-		{"valid relative", "backup.tar.gz", false},
-		{"valid nested", "/var/backups/data/backup.tar", false},
-		{"empty", "", true},
-		{"path traversal", "../etc/passwd", true},
-		{"path traversal absolute", "/tmp/../etc/passwd", true},
-		{"double dot in name", "backup..tar", true},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			err := ValidateFilePath(tt.path)
-			if tt.shouldErr && err == nil {
-				t.Errorf("ValidateFilePath(%q) expected error but got none", tt.path)
-			}
-			if !tt.shouldErr && err != nil {
-				t.Errorf("ValidateFilePath(%q) unexpected error: %v", tt.path, err)
-			}
-		})
-	}
-}
-
 func TestGetFileName(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -385,3 +363,515 @@ func TestGetHostDir(t *testing.T) {
 		})
 	}
 }
+
+func TestEncryptedBackupAndRestore(t *testing.T) {
+	if !docker.IsDockerAvailable() {
+		t.Skip("Docker is not available, skipping integration test")
+	}
+	ctx := context.Background()
+	volumeName := "test-volume-encrypted-xyz123"
+	backupFile := t.TempDir() + "/backup.tar.gz.age"
+
+	exec.Command("docker", "volume", "rm", volumeName).Run()
+	if err := docker.CreateVolume(ctx, volumeName); err != nil {
+		t.Fatalf("CreateVolume() error: %v", err)
+	}
+	defer exec.Command("docker", "volume", "rm", volumeName).Run()
+	cmd := exec.Command("docker", "run", "--rm", "-v", volumeName+":/data", "alpine",
+		"sh", "-c", "echo secret > /data/test.txt")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to write test data: %v", err)
+	}
+
+	keys, err := crypt.Load(crypt.Options{Passphrase: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backupOp, err := NewBackup(ctx, volumeName, "gz", PathFilter{}, keys, false)
+	if err != nil {
+		t.Fatalf("NewBackup() error: %v", err)
+	}
+	if err := backupOp.BackupTo(ctx, backupFile); err != nil {
+		t.Fatalf("BackupTo() error: %v", err)
+	}
+
+	// Without the passphrase the restore fails before touching the volume
+	restoreOp, _ := NewRestore(volumeName, PathFilter{}, nil, false)
+	if err := restoreOp.RestoreFrom(ctx, backupFile, RestoreOverwrite); !errors.Is(err, rw.ErrEncrypted) {
+		t.Fatalf("RestoreFrom() without identity error = %v; want ErrEncrypted", err)
+	}
+
+	restoreOp, _ = NewRestore(volumeName, PathFilter{}, keys, false)
+	if err := restoreOp.RestoreFrom(ctx, backupFile, RestoreOverwrite); err != nil {
+		t.Fatalf("RestoreFrom() error: %v", err)
+	}
+	output, err := exec.Command("docker", "run", "--rm", "-v", volumeName+":/data", "alpine",
+		"cat", "/data/test.txt").Output()
+	if err != nil {
+		t.Fatalf("Failed to read restored data: %v", err)
+	}
+	if string(output) != "secret\n" {
+		t.Errorf("Restored data mismatch: got %q, want %q", output, "secret\n")
+	}
+}
+
+func TestIncrementalBackupAndRestore(t *testing.T) {
+	if !docker.IsDockerAvailable() {
+		t.Skip("Docker is not available, skipping integration test")
+	}
+	ctx := context.Background()
+	volumeName := "test-volume-incremental-xyz123"
+	dir := t.TempDir()
+
+	exec.Command("docker", "volume", "rm", volumeName).Run()
+	if err := docker.CreateVolume(ctx, volumeName); err != nil {
+		t.Fatalf("CreateVolume() error: %v", err)
+	}
+	defer exec.Command("docker", "volume", "rm", volumeName).Run()
+	run := func(script string) string {
+		t.Helper()
+		output, err := exec.Command("docker", "run", "--rm", "-v", volumeName+":/data", "alpine", "sh", "-c", script).Output()
+		if err != nil {
+			t.Fatalf("Failed to run %q: %v", script, err)
+		}
+		return string(output)
+	}
+
+	run("echo one > /data/keep.txt && echo two > /data/gone.txt")
+	backupOp, err := NewBackup(ctx, volumeName, "gz", PathFilter{}, nil, false)
+	if err != nil {
+		t.Fatalf("NewBackup() error: %v", err)
+	}
+	if err := backupOp.BackupTo(ctx, dir+"/full.tar.gz"); err != nil {
+		t.Fatalf("BackupTo() error: %v", err)
+	}
+
+	run("rm /data/gone.txt && echo three > /data/new.txt")
+	backupOp, _ = NewBackup(ctx, volumeName, "gz", PathFilter{}, nil, false)
+	if err := backupOp.IncrementalFrom(ctx, dir+"/full.tar.gz"); err != nil {
+		t.Fatalf("IncrementalFrom() error: %v", err)
+	}
+	if err := backupOp.BackupTo(ctx, dir+"/incr.tar.gz"); err != nil {
+		t.Fatalf("BackupTo() error: %v", err)
+	}
+
+	run("rm -rf /data/*")
+	restoreOp, _ := NewRestore(volumeName, PathFilter{}, nil, false)
+	if err := restoreOp.RestoreFrom(ctx, dir+"/incr.tar.gz", RestoreOverwrite); err != nil {
+		t.Fatalf("RestoreFrom() error: %v", err)
+	}
+	if got := run("ls /data | tr '\\n' ' '"); got != "keep.txt new.txt " {
+		t.Errorf("Restored files = %q; want keep.txt and new.txt", got)
+	}
+}
+
+func TestRepositoryBackupAndRestore(t *testing.T) {
+	if !docker.IsDockerAvailable() {
+		t.Skip("Docker is not available, skipping integration test")
+	}
+	ctx := context.Background()
+	volumeName := "test-volume-repository-xyz123"
+	location := t.TempDir() + "/repo"
+
+	exec.Command("docker", "volume", "rm", volumeName).Run()
+	if err := docker.CreateVolume(ctx, volumeName); err != nil {
+		t.Fatalf("CreateVolume() error: %v", err)
+	}
+	defer exec.Command("docker", "volume", "rm", volumeName).Run()
+	cmd := exec.Command("docker", "run", "--rm", "-v", volumeName+":/data", "alpine",
+		"sh", "-c", "echo deduplicated > /data/test.txt")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to write test data: %v", err)
+	}
+
+	if _, err := repo.Init(ctx, location); err != nil {
+		t.Fatalf("Init() error: %v", err)
+	}
+	var snapshots []*repo.Snapshot
+	for range 2 {
+		backupOp, err := NewBackup(ctx, volumeName, "gz", PathFilter{}, nil, false)
+		if err != nil {
+			t.Fatalf("NewBackup() error: %v", err)
+		}
+		snapshot, err := backupOp.BackupToRepository(ctx, location)
+		if err != nil {
+			t.Fatalf("BackupToRepository() error: %v", err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	// Only the chunk holding the manifest differs
+	if snapshots[1].NewChunks > 1 {
+		t.Errorf("Second backup added %d chunks; want at most 1", snapshots[1].NewChunks)
+	}
+
+	restoreOp, _ := NewRestore(volumeName, PathFilter{}, nil, false)
+	if err := restoreOp.RestoreFromRepository(ctx, location, snapshots[0].ID, RestoreOverwrite); err != nil {
+		t.Fatalf("RestoreFromRepository() error: %v", err)
+	}
+	output, err := exec.Command("docker", "run", "--rm", "-v", volumeName+":/data", "alpine",
+		"cat", "/data/test.txt").Output()
+	if err != nil {
+		t.Fatalf("Failed to read restored data: %v", err)
+	}
+	if string(output) != "deduplicated\n" {
+		t.Errorf("Restored data mismatch: got %q, want %q", output, "deduplicated\n")
+	}
+}
+
+func TestComposeProjectBundle(t *testing.T) {
+	if !docker.IsDockerAvailable() {
+		t.Skip("Docker is not available, skipping integration test")
+	}
+	ctx := context.Background()
+	from, to := "dvbtestprod", "dvbtestclone"
+	dest := t.TempDir() + "/"
+
+	for _, v := range []string{from + "_db", to + "_db"} {
+		exec.Command("docker", "volume", "rm", v).Run()
+		defer exec.Command("docker", "volume", "rm", v).Run()
+	}
+	err := docker.CreateVolumeFrom(ctx, docker.Volume{
+		Name:   from + "_db",
+		Labels: map[string]string{ComposeProjectLabel: from, ComposeVolumeLabel: "db"},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolumeFrom() error: %v", err)
+	}
+	cmd := exec.Command("docker", "run", "--rm", "-v", from+"_db:/data", "alpine",
+		"sh", "-c", "echo rows > /data/table")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to write test data: %v", err)
+	}
+
+	bundle, err := BackupComposeProject(ctx, from, dest, "gz", PathFilter{}, nil, KeepContainers, false)
+	if err != nil {
+		t.Fatalf("BackupComposeProject() error: %v", err)
+	}
+	if err := RestoreComposeProject(ctx, bundle, to, PathFilter{}, nil, VolumeOverrides{}, false, RestoreNew); err != nil {
+		t.Fatalf("RestoreComposeProject() error: %v", err)
+	}
+
+	info, err := docker.InspectVolume(ctx, to+"_db")
+	if err != nil {
+		t.Fatalf("InspectVolume() error: %v", err)
+	}
+	if info.Labels[ComposeProjectLabel] != to || info.Labels[ComposeVolumeLabel] != "db" {
+		t.Errorf("Restored volume labels = %v; want project %s, volume db", info.Labels, to)
+	}
+	output, err := exec.Command("docker", "run", "--rm", "-v", to+"_db:/data", "alpine",
+		"cat", "/data/table").Output()
+	if err != nil {
+		t.Fatalf("Failed to read restored data: %v", err)
+	}
+	if string(output) != "rows\n" {
+		t.Errorf("Restored data mismatch: got %q, want %q", output, "rows\n")
+	}
+}
+
+func TestBackupStopsContainers(t *testing.T) {
+	if !docker.IsDockerAvailable() {
+		t.Skip("Docker is not available, skipping integration test")
+	}
+	ctx := context.Background()
+	volumeName := "test-volume-stop-xyz123"
+	containerName := "test-container-stop-xyz123"
+	dest := t.TempDir() + "/stopped.tar.gz"
+
+	exec.Command("docker", "rm", "-f", containerName).Run()
+	exec.Command("docker", "volume", "rm", volumeName).Run()
+	if err := docker.CreateVolume(ctx, volumeName); err != nil {
+		t.Fatalf("CreateVolume() error: %v", err)
+	}
+	defer exec.Command("docker", "volume", "rm", volumeName).Run()
+	cmd := exec.Command("docker", "run", "-d", "--name", containerName, "-v", volumeName+":/data",
+		"--label", StopTimeoutLabel+"=1s", "alpine", "sleep", "600")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to start container: %v", err)
+	}
+	defer exec.Command("docker", "rm", "-f", containerName).Run()
+
+	backupOp, err := NewBackup(ctx, volumeName, "gz", PathFilter{}, nil, false)
+	if err != nil {
+		t.Fatalf("NewBackup() error: %v", err)
+	}
+	backupOp.SetContainerMode(StopContainers)
+	if err := backupOp.BackupTo(ctx, dest); err != nil {
+		t.Fatalf("BackupTo() error: %v", err)
+	}
+
+	output, err := exec.Command("docker", "inspect", "-f", "{{.State.Running}}", containerName).Output()
+	if err != nil {
+		t.Fatalf("Failed to inspect container: %v", err)
+	}
+	if strings.TrimSpace(string(output)) != "true" {
+		t.Errorf("Container is not running after the backup")
+	}
+}
+
+func TestBackupRunsContainerHooks(t *testing.T) {
+	if !docker.IsDockerAvailable() {
+		t.Skip("Docker is not available, skipping integration test")
+	}
+	ctx := context.Background()
+	volumeName := "test-volume-hooks-xyz123"
+	restoredName := volumeName + "-restored"
+	containerName := "test-container-hooks-xyz123"
+	dest := t.TempDir() + "/hooks.tar.gz"
+
+	exec.Command("docker", "rm", "-f", containerName).Run()
+	for _, v := range []string{volumeName, restoredName} {
+		exec.Command("docker", "volume", "rm", v).Run()
+		defer exec.Command("docker", "volume", "rm", v).Run()
+	}
+	if err := docker.CreateVolume(ctx, volumeName); err != nil {
+		t.Fatalf("CreateVolume() error: %v", err)
+	}
+	// The pre hook writes a file that must be part of the archive
+	cmd := exec.Command("docker", "run", "-d", "--name", containerName, "-v", volumeName+":/data",
+		"--label", PreBackupLabel+"=echo flushed > /data/flushed", "alpine", "sleep", "600")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to start container: %v", err)
+	}
+	defer exec.Command("docker", "rm", "-f", containerName).Run()
+
+	backupOp, err := NewBackup(ctx, volumeName, "gz", PathFilter{}, nil, false)
+	if err != nil {
+		t.Fatalf("NewBackup() error: %v", err)
+	}
+	if err := backupOp.BackupTo(ctx, dest); err != nil {
+		t.Fatalf("BackupTo() error: %v", err)
+	}
+	restoreOp, _ := NewRestore(restoredName, PathFilter{}, nil, false)
+	if err := restoreOp.RestoreFrom(ctx, dest, RestoreNew); err != nil {
+		t.Fatalf("RestoreFrom() error: %v", err)
+	}
+	output, err := exec.Command("docker", "run", "--rm", "-v", restoredName+":/data", "alpine",
+		"cat", "/data/flushed").Output()
+	if err != nil {
+		t.Fatalf("Failed to read restored data: %v", err)
+	}
+	if string(output) != "flushed\n" {
+		t.Errorf("Restored data mismatch: got %q, want %q", output, "flushed\n")
+	}
+}
+
+func TestBackupDumpsAndReplaysDatabase(t *testing.T) {
+	if !docker.IsDockerAvailable() {
+		t.Skip("Docker is not available, skipping integration test")
+	}
+	ctx := context.Background()
+	volumeName := "test-volume-dump-xyz123"
+	source := "test-container-dump-xyz123"
+	target := source + "-replay"
+	dest := t.TempDir() + "/db.tar.gz"
+
+	for _, c := range []string{source, target} {
+		exec.Command("docker", "rm", "-f", c).Run()
+		defer exec.Command("docker", "rm", "-f", c).Run()
+	}
+	exec.Command("docker", "volume", "rm", volumeName).Run()
+	defer exec.Command("docker", "volume", "rm", volumeName).Run()
+	if err := docker.CreateVolume(ctx, volumeName); err != nil {
+		t.Fatalf("CreateVolume() error: %v", err)
+	}
+	psql := func(container, query string) (string, error) {
+		output, err := exec.Command("docker", "exec", container, "psql", "-U", "postgres", "-tAc", query).Output()
+		return strings.TrimSpace(string(output)), err
+	}
+	startPostgres := func(name string, args ...string) {
+		args = append(append([]string{"run", "-d", "--name", name, "-e", "POSTGRES_PASSWORD=secret"}, args...), "postgres:16-alpine")
+		if err := exec.Command("docker", args...).Run(); err != nil {
+			t.Fatalf("Failed to start postgres: %v", err)
+		}
+		// The entrypoint restarts the server once initialization is done
+		for i := 0; ; i++ {
+			if _, err := psql(name, "SELECT 1"); err == nil {
+				time.Sleep(2 * time.Second)
+				if _, err := psql(name, "SELECT 1"); err == nil {
+					return
+				}
+			}
+			if i == 60 {
+				t.Fatalf("postgres in container '%s' did not start", name)
+			}
+			time.Sleep(time.Second)
+		}
+	}
+
+	startPostgres(source, "-v", volumeName+":/var/lib/postgresql/data")
+	if _, err := psql(source, "CREATE TABLE orders (id int); INSERT INTO orders VALUES (42)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	backupOp, err := NewBackup(ctx, volumeName, "gz", PathFilter{}, nil, false)
+	if err != nil {
+		t.Fatalf("NewBackup() error: %v", err)
+	}
+	backupOp.SetDump(true)
+	if err := backupOp.BackupTo(ctx, dest); err != nil {
+		t.Fatalf("BackupTo() error: %v", err)
+	}
+	if err := Verify(ctx, dest, nil, false); err != nil {
+		t.Errorf("Verify() error: %v", err)
+	}
+
+	startPostgres(target)
+	if err := ReplayDump(ctx, dest, target, nil); err != nil {
+		t.Fatalf("ReplayDump() error: %v", err)
+	}
+	got, err := psql(target, "SELECT id FROM orders")
+	if err != nil || got != "42" {
+		t.Errorf("Replayed table = %q, %v; want 42", got, err)
+	}
+}
+
+func TestRestoreRecreatesVolumeSettings(t *testing.T) {
+	if !docker.IsDockerAvailable() {
+		t.Skip("Docker is not available, skipping integration test")
+	}
+	ctx := context.Background()
+	volumeName := "test-volume-settings-xyz123"
+	restoredName := volumeName + "-restored"
+	dest := t.TempDir() + "/settings.tar.gz"
+
+	for _, v := range []string{volumeName, restoredName} {
+		exec.Command("docker", "volume", "rm", v).Run()
+		defer exec.Command("docker", "volume", "rm", v).Run()
+	}
+	err := docker.CreateVolumeFrom(ctx, docker.Volume{
+		Name:    volumeName,
+		Driver:  "local",
+		Options: map[string]string{"type": "tmpfs", "device": "tmpfs", "o": "size=16m"},
+		Labels:  map[string]string{"tier": "gold"},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolumeFrom() error: %v", err)
+	}
+	cmd := exec.Command("docker", "run", "--rm", "-v", volumeName+":/data", "alpine",
+		"sh", "-c", "echo 'in memory' > /data/file.txt")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to write test data: %v", err)
+	}
+
+	backupOp, err := NewBackup(ctx, volumeName, "gz", PathFilter{}, nil, false)
+	if err != nil {
+		t.Fatalf("NewBackup() error: %v", err)
+	}
+	if err := backupOp.BackupTo(ctx, dest); err != nil {
+		t.Fatalf("BackupTo() error: %v", err)
+	}
+	restoreOp, _ := NewRestore(restoredName, PathFilter{}, nil, false)
+	restoreOp.SetVolumeOverrides(VolumeOverrides{Options: []string{"o=size=8m"}, Labels: []string{"restored=yes"}})
+	if err := restoreOp.RestoreFrom(ctx, dest, RestoreNew); err != nil {
+		t.Fatalf("RestoreFrom() error: %v", err)
+	}
+
+	info, err := docker.InspectVolume(ctx, restoredName)
+	if err != nil {
+		t.Fatalf("InspectVolume() error: %v", err)
+	}
+	if info.Driver != "local" || info.Options["type"] != "tmpfs" || info.Options["o"] != "size=8m" {
+		t.Errorf("Restored volume driver %s, options %v; want local tmpfs with size=8m", info.Driver, info.Options)
+	}
+	if info.Labels["tier"] != "gold" || info.Labels["restored"] != "yes" {
+		t.Errorf("Restored volume labels = %v; want tier=gold and restored=yes", info.Labels)
+	}
+}
+
+func TestStrictBackupAndRestoreKeepsMetadata(t *testing.T) {
+	if !docker.IsDockerAvailable() {
+		t.Skip("Docker is not available, skipping integration test")
+	}
+	ctx := context.Background()
+	volumeName := "test-volume-fidelity-xyz123"
+	restoredName := volumeName + "-restored"
+	dest := t.TempDir() + "/fidelity.tar.gz"
+
+	for _, v := range []string{volumeName, restoredName} {
+		exec.Command("docker", "volume", "rm", v).Run()
+		defer exec.Command("docker", "volume", "rm", v).Run()
+	}
+	if err := docker.CreateVolume(ctx, volumeName); err != nil {
+		t.Fatalf("CreateVolume() error: %v", err)
+	}
+	cmd := exec.Command("docker", "run", "--rm", "-v", volumeName+":/data", "alpine", "sh", "-c",
+		"mkdir /data/bin /data/run && echo ping > /data/bin/ping && chown 999:999 /data/bin/ping && chmod 4755 /data/bin/ping && "+
+			"ln /data/bin/ping /data/bin/ping6 && mkfifo /data/run/queue && ln -s queue /data/run/current && chmod 1777 /data/run")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Failed to write test data: %v: %s", err, out)
+	}
+
+	backupOp, err := NewBackup(ctx, volumeName, "gz", PathFilter{}, nil, false)
+	if err != nil {
+		t.Fatalf("NewBackup() error: %v", err)
+	}
+	backupOp.SetStrict(true)
+	if err := backupOp.BackupTo(ctx, dest); err != nil {
+		t.Fatalf("BackupTo() in strict mode error: %v", err)
+	}
+	restoreOp, _ := NewRestore(restoredName, PathFilter{}, nil, false)
+	restoreOp.SetStrict(true)
+	if err := restoreOp.RestoreFrom(ctx, dest, RestoreNew); err != nil {
+		t.Fatalf("RestoreFrom() in strict mode error: %v", err)
+	}
+
+	out, err := exec.Command("docker", "run", "--rm", "-v", restoredName+":/data", "alpine", "sh", "-c",
+		"stat -c '%n %a %u:%g %h %F' /data/bin/ping /data/run /data/run/queue && readlink /data/run/current").Output()
+	if err != nil {
+		t.Fatalf("Failed to read restored metadata: %v", err)
+	}
+	expected := "/data/bin/ping 4755 999:999 2 regular file\n/data/run 1777 0:0 2 directory\n/data/run/queue 644 0:0 1 fifo\nqueue\n"
+	if string(out) != expected {
+		t.Errorf("Restored metadata =\n%s\nwant\n%s", out, expected)
+	}
+}
+
+func TestRestoreMapsOwners(t *testing.T) {
+	if !docker.IsDockerAvailable() {
+		t.Skip("Docker is not available, skipping integration test")
+	}
+	ctx := context.Background()
+	volumeName := "test-volume-owners-xyz123"
+	restoredName := volumeName + "-restored"
+	dest := t.TempDir() + "/owners.tar.gz"
+
+	for _, v := range []string{volumeName, restoredName} {
+		exec.Command("docker", "volume", "rm", v).Run()
+		defer exec.Command("docker", "volume", "rm", v).Run()
+	}
+	if err := docker.CreateVolume(ctx, volumeName); err != nil {
+		t.Fatalf("CreateVolume() error: %v", err)
+	}
+	cmd := exec.Command("docker", "run", "--rm", "-v", volumeName+":/data", "alpine", "sh", "-c",
+		"mkdir /data/db && echo 16 > /data/db/PG_VERSION && chown -R 999:999 /data/db && echo x > /data/other && chown 33:33 /data/other")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Failed to write test data: %v: %s", err, out)
+	}
+
+	backupOp, err := NewBackup(ctx, volumeName, "gz", PathFilter{}, nil, false)
+	if err != nil {
+		t.Fatalf("NewBackup() error: %v", err)
+	}
+	if err := backupOp.BackupTo(ctx, dest); err != nil {
+		t.Fatalf("BackupTo() error: %v", err)
+	}
+	owners, err := LoadOwnerMap(OwnerOptions{UIDs: []string{"999:1001"}, GIDs: []string{"999:1001"}})
+	if err != nil {
+		t.Fatalf("LoadOwnerMap() error: %v", err)
+	}
+	restoreOp, _ := NewRestore(restoredName, PathFilter{}, nil, false)
+	restoreOp.SetOwnerMap(owners)
+	restoreOp.SetStrict(true)
+	if err := restoreOp.RestoreFrom(ctx, dest, RestoreNew); err != nil {
+		t.Fatalf("RestoreFrom() error: %v", err)
+	}
+
+	out, err := exec.Command("docker", "run", "--rm", "-v", restoredName+":/data", "alpine",
+		"stat", "-c", "%n %u:%g", "/data/db", "/data/db/PG_VERSION", "/data/other").Output()
+	if err != nil {
+		t.Fatalf("Failed to read restored owners: %v", err)
+	}
+	expected := "/data/db 1001:1001\n/data/db/PG_VERSION 1001:1001\n/data/other 33:33\n"
+	if string(out) != expected {
+		t.Errorf("Restored owners =\n%s\nwant\n%s", out, expected)
+	}
+}
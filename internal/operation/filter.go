@@ -0,0 +1,108 @@
+package operation
+
+import (
+	"archive/tar"
+	"strings"
+
+	"docker-volume-backup/internal/archive"
+)
+
+// PathFilter selects archive entries by glob, see MatchPath. An entry is
+// selected if it matches an include pattern, or there are none, and matches no
+// exclude pattern.
+type PathFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// IsEmpty reports whether the filter selects every entry.
+func (f PathFilter) IsEmpty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// Validate checks that all patterns are well-formed globs.
+func (f PathFilter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if err := ValidatePathPattern(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Match reports whether the filter selects an archive entry.
+func (f PathFilter) Match(name string) bool {
+	if len(f.Include) > 0 && !matchAny(f.Include, name) {
+		return false
+	}
+	return !matchAny(f.Exclude, name)
+}
+
+func (f PathFilter) String() string {
+	var parts []string
+	if len(f.Include) > 0 {
+		parts = append(parts, "include "+strings.Join(f.Include, ", "))
+	}
+	if len(f.Exclude) > 0 {
+		parts = append(parts, "exclude "+strings.Join(f.Exclude, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if MatchPath(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// entrySelector picks entries of a tar stream. Directories that are not selected
+// themselves are held back and only written as parents of selected entries, so
+// that their ownership and permissions are kept.
+type entrySelector struct {
+	// match reports whether an entry is selected; nil selects everything
+	match    func(name string, isDir bool) bool
+	pending  map[string]*tar.Header
+	selected int
+}
+
+func newEntrySelector(match func(name string, isDir bool) bool) *entrySelector {
+	return &entrySelector{match: match, pending: map[string]*tar.Header{}}
+}
+
+// selectEntry reports whether an entry is selected and returns the held back
+// parent directories to write before it, outermost first.
+func (s *entrySelector) selectEntry(header *tar.Header) (bool, []*tar.Header) {
+	name := strings.TrimSuffix(archive.CleanPath(header.Name), "/")
+	isDir := header.Typeflag == tar.TypeDir
+	if s.match != nil && !s.match(name, isDir) {
+		if isDir {
+			s.pending[name] = header
+		}
+		return false, nil
+	}
+	s.selected++
+
+	var parents []*tar.Header
+	for _, dir := range parentDirs(name) {
+		if dirHeader, ok := s.pending[dir]; ok {
+			parents = append(parents, dirHeader)
+			delete(s.pending, dir)
+		}
+	}
+	return true, parents
+}
+
+// parentDirs returns the paths of the directories containing an archive path,
+// outermost first, starting with the volume root as "" and ".".
+func parentDirs(name string) []string {
+	dirs := []string{"", "."}
+	for i := 0; i < len(name); i++ {
+		if name[i] == '/' {
+			dirs = append(dirs, name[:i])
+		}
+	}
+	return dirs
+}
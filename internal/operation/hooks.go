@@ -0,0 +1,138 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"docker-volume-backup/internal/docker"
+	"docker-volume-backup/internal/hook"
+)
+
+// Labels on the containers using a volume that declare hooks run inside the
+// container around the volume's backups and restores.
+const (
+	PreBackupLabel   = "docker-volume-backup.pre"
+	PostBackupLabel  = "docker-volume-backup.post"
+	PreRestoreLabel  = "docker-volume-backup.pre-restore"
+	PostRestoreLabel = "docker-volume-backup.post-restore"
+	// OnErrorLabel runs when a backup or restore of the volume fails
+	OnErrorLabel = "docker-volume-backup.on-error"
+	// HookTimeoutLabel bounds each of the container's hooks, e.g. "30m"
+	HookTimeoutLabel = "docker-volume-backup.hook-timeout"
+)
+
+// Hooks are commands run around the backup or restore of a volume. Pre hooks
+// run first, and a failing one skips the operation. Post hooks run after it
+// succeeded. OnError hooks run whenever a pre hook, the operation or a post
+// hook failed, also if it was cancelled.
+type Hooks struct {
+	Pre     []hook.Hook
+	Post    []hook.Hook
+	OnError []hook.Hook
+}
+
+// IsEmpty reports whether there are no hooks at all.
+func (h Hooks) IsEmpty() bool {
+	return len(h.Pre) == 0 && len(h.Post) == 0 && len(h.OnError) == 0
+}
+
+// add appends the hooks of other.
+func (h *Hooks) add(other Hooks) {
+	h.Pre = append(h.Pre, other.Pre...)
+	h.Post = append(h.Post, other.Post...)
+	h.OnError = append(h.OnError, other.OnError...)
+}
+
+// containerHooks returns the hooks that the running containers using volume
+// declare with labels, for a restore or a backup.
+func containerHooks(ctx context.Context, volume string, restore bool) (Hooks, error) {
+	preLabel, postLabel := PreBackupLabel, PostBackupLabel
+	if restore {
+		preLabel, postLabel = PreRestoreLabel, PostRestoreLabel
+	}
+	containers, err := docker.ListContainersUsingVolume(ctx, volume)
+	if err != nil {
+		return Hooks{}, err
+	}
+
+	var hooks Hooks
+	for _, c := range containers {
+		if c.State != "running" {
+			continue
+		}
+		var timeout time.Duration
+		if value := c.Labels[HookTimeoutLabel]; value != "" {
+			timeout, err = time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return Hooks{}, fmt.Errorf("invalid %s label '%s' on container '%s'", HookTimeoutLabel, value, c.Name())
+			}
+		}
+		labelHook := func(label string) []hook.Hook {
+			if command := c.Labels[label]; command != "" {
+				return []hook.Hook{{Command: command, Container: c.Name(), Timeout: timeout}}
+			}
+			return nil
+		}
+		hooks.add(Hooks{Pre: labelHook(preLabel), Post: labelHook(postLabel), OnError: labelHook(OnErrorLabel)})
+	}
+	return hooks, nil
+}
+
+// runHooks runs run between the hooks of the volume: those given
+// explicitly, followed by those the containers using the volume declare.
+func runHooks(ctx context.Context, volume string, restore bool, explicit Hooks, run func() error) error {
+	hooks := explicit
+	found, err := containerHooks(ctx, volume, restore)
+	if err != nil {
+		return err
+	}
+	hooks.add(found)
+
+	kind := "backup"
+	if restore {
+		kind = "restore"
+	}
+	return RunHooks(ctx, hooks, []string{"BACKUP_OPERATION=" + kind, "BACKUP_VOLUME=" + volume}, run)
+}
+
+// RunHooks runs run between hooks, with env added to the hooks' environment.
+// On-error hooks also get the error in BACKUP_ERROR. All failures are returned.
+func RunHooks(ctx context.Context, hooks Hooks, env []string, run func() error) error {
+	if hooks.IsEmpty() {
+		return run()
+	}
+	err := func() error {
+		for _, h := range hooks.Pre {
+			if err := h.Run(ctx, env); err != nil {
+				return fmt.Errorf("pre hook failed: %w", err)
+			}
+		}
+		if err := run(); err != nil {
+			return err
+		}
+		for _, h := range hooks.Post {
+			if err := h.Run(ctx, env); err != nil {
+				return fmt.Errorf("post hook failed: %w", err)
+			}
+		}
+		return nil
+	}()
+	if err == nil {
+		return nil
+	}
+
+	// On-error hooks also run after cancellation, bounded by their timeout
+	hookCtx := context.WithoutCancel(ctx)
+	errEnv := append(env[:len(env):len(env)], "BACKUP_ERROR="+err.Error())
+	errs := []error{err}
+	for _, h := range hooks.OnError {
+		if hookErr := h.Run(hookCtx, errEnv); hookErr != nil {
+			log.Printf("ERROR: on-error %v", hookErr)
+			errs = append(errs, hookErr)
+		}
+	}
+	return errors.Join(errs...)
+}
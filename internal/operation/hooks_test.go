@@ -0,0 +1,97 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"docker-volume-backup/internal/hook"
+)
+
+func TestRunHooks(t *testing.T) {
+	// Every hook appends its name to a file, so the test sees the order they ran in
+	trace := filepath.Join(t.TempDir(), "trace")
+	record := func(name string) hook.Hook {
+		return hook.Hook{Command: "echo " + name + " $BACKUP_VOLUME $BACKUP_ERROR >> " + trace}
+	}
+	failing := hook.Hook{Command: "exit 4"}
+	env := []string{"BACKUP_VOLUME=data"}
+
+	tests := []struct {
+		name     string
+		hooks    Hooks
+		runErr   error
+		expected string
+		errText  string
+	}{
+		{
+			"success",
+			Hooks{Pre: []hook.Hook{record("pre")}, Post: []hook.Hook{record("post")}, OnError: []hook.Hook{record("error")}},
+			nil,
+			"pre data\nrun\npost data\n",
+			"",
+		},
+		{
+			"failing pre hook skips the operation",
+			Hooks{Pre: []hook.Hook{failing, record("pre")}, Post: []hook.Hook{record("post")}, OnError: []hook.Hook{record("error")}},
+			nil,
+			"error data pre hook failed: hook 'exit 4' failed: exit status 4\n",
+			"pre hook failed",
+		},
+		{
+			"failing operation",
+			Hooks{Pre: []hook.Hook{record("pre")}, Post: []hook.Hook{record("post")}, OnError: []hook.Hook{record("error")}},
+			errors.New("boom"),
+			"pre data\nrun\nerror data boom\n",
+			"boom",
+		},
+		{
+			"failing post hook",
+			Hooks{Post: []hook.Hook{failing}, OnError: []hook.Hook{record("error")}},
+			nil,
+			"run\nerror data post hook failed: hook 'exit 4' failed: exit status 4\n",
+			"post hook failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(trace)
+			err := RunHooks(context.Background(), tt.hooks, env, func() error {
+				f, _ := os.OpenFile(trace, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+				f.WriteString("run\n")
+				f.Close()
+				return tt.runErr
+			})
+			if tt.errText == "" && err != nil {
+				t.Errorf("RunHooks() unexpected error: %v", err)
+			}
+			if tt.errText != "" && (err == nil || !strings.Contains(err.Error(), tt.errText)) {
+				t.Errorf("RunHooks() error = %v; want %q", err, tt.errText)
+			}
+			data, _ := os.ReadFile(trace)
+			if string(data) != tt.expected {
+				t.Errorf("hooks ran as %q; want %q", data, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRunHooksAfterCancel(t *testing.T) {
+	trace := filepath.Join(t.TempDir(), "trace")
+	ctx, cancel := context.WithCancel(context.Background())
+	hooks := Hooks{OnError: []hook.Hook{{Command: "echo cleanup > " + trace}}}
+
+	err := RunHooks(ctx, hooks, nil, func() error {
+		cancel()
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("RunHooks() error = %v; want context.Canceled", err)
+	}
+	if data, _ := os.ReadFile(trace); string(data) != "cleanup\n" {
+		t.Errorf("on-error hook did not run after cancellation")
+	}
+}
@@ -0,0 +1,134 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/rw"
+	"docker-volume-backup/internal/storage"
+
+	"filippo.io/age"
+)
+
+// maxChainLength bounds incremental chains, as a guard against parent links
+// that form a loop.
+const maxChainLength = 1000
+
+// IncrementalFrom makes the backup incremental: it only stores the files that
+// changed since the archive at location was written, a list of the deleted
+// paths and a link to that archive. The previous archive may be full or
+// incremental itself; restores apply the whole chain.
+func (b *Backup) IncrementalFrom(ctx context.Context, location string) error {
+	backend, key, err := storage.Resolve(ctx, location)
+	if err != nil {
+		return err
+	}
+	manifest, err := archive.ReadManifest(ctx, backend, key, b.keys.Decrypters())
+	if err != nil {
+		return fmt.Errorf("failed to read previous backup %s: %w", location, err)
+	}
+	if manifest == nil {
+		return fmt.Errorf("previous backup %s has no manifest, incremental backups need one written by this version", location)
+	}
+	if manifest.Volume.Name != b.volume {
+		return fmt.Errorf("previous backup %s is of volume '%s', not '%s'", location, manifest.Volume.Name, b.volume)
+	}
+
+	index, err := archive.ReadIndex(ctx, backend, key, b.keys.Decrypters())
+	if errors.Is(err, rw.ErrEncrypted) {
+		return fmt.Errorf("previous backup %s is encrypted, reading its index needs an age identity or the passphrase", location)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the index of previous backup %s: %w", location, err)
+	}
+	if index == nil {
+		return fmt.Errorf("previous backup %s has no index, incremental backups need one written by this version", location)
+	}
+
+	sum := manifest.SHA256
+	if checksum, err := archive.ReadChecksumSidecar(ctx, backend, key); err == nil {
+		sum = checksum
+	}
+	b.parent = &archive.Parent{Location: location, CreatedAt: manifest.CreatedAt, SHA256: sum}
+	b.parentIndex = index
+	log.Printf("Backing up changes since %s (%d entries, created %s)", location, len(index.Entries), manifest.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+// chainLink is one archive of an incremental chain.
+type chainLink struct {
+	backend  storage.Backend
+	key      string
+	manifest *archive.Manifest
+	size     int64
+}
+
+// restoreChain returns the archives needed to restore the last one,
+// oldest (the full archive) first, checking that every parent is the archive
+// its child was based on.
+func restoreChain(ctx context.Context, last chainLink, identities []age.Identity) ([]chainLink, error) {
+	chain := []chainLink{last}
+	for link := last; link.manifest != nil && link.manifest.Parent != nil; {
+		if len(chain) >= maxChainLength {
+			return nil, fmt.Errorf("incremental chain of %s is longer than %d archives", last.key, maxChainLength)
+		}
+		parent, err := findParent(ctx, link, identities)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, parent)
+		link = parent
+	}
+	// Oldest first
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain, nil
+}
+
+// findParent locates the parent of an incremental archive: an archive of the
+// same name next to it, so that chains can be moved or copied together, or else
+// the location recorded at backup time.
+func findParent(ctx context.Context, child chainLink, identities []age.Identity) (chainLink, error) {
+	parent := child.manifest.Parent
+	_, parentKey, err := storage.Resolve(ctx, parent.Location)
+	if err != nil {
+		return chainLink{}, fmt.Errorf("invalid parent location of %s: %w", child.key, err)
+	}
+	base := parentKey[strings.LastIndexAny(parentKey, `/\`)+1:]
+	sibling := child.key[:strings.LastIndexAny(child.key, `/\`)+1] + base
+
+	link := chainLink{backend: child.backend, key: sibling}
+	info, err := link.backend.Stat(ctx, link.key)
+	if errors.Is(err, storage.ErrNotExist) {
+		link.backend, link.key, err = storage.Resolve(ctx, parent.Location)
+		if err != nil {
+			return chainLink{}, err
+		}
+		info, err = link.backend.Stat(ctx, link.key)
+	}
+	if errors.Is(err, storage.ErrNotExist) {
+		return chainLink{}, fmt.Errorf("parent %s of %s is missing, the incremental chain cannot be restored", parent.Location, child.key)
+	}
+	if err != nil {
+		return chainLink{}, err
+	}
+	link.size = info.Size
+
+	link.manifest, err = archive.ReadManifest(ctx, link.backend, link.key, identities)
+	if err != nil {
+		return chainLink{}, fmt.Errorf("failed to read parent %s: %w", link.key, err)
+	}
+	if link.manifest == nil || !link.manifest.CreatedAt.Equal(parent.CreatedAt) {
+		return chainLink{}, fmt.Errorf("%s is not the parent of %s: expected an archive created at %s", link.key, child.key, parent.CreatedAt.Format(time.RFC3339))
+	}
+	if parent.SHA256 != "" && link.manifest.SHA256 != "" && link.manifest.SHA256 != parent.SHA256 {
+		return chainLink{}, fmt.Errorf("parent %s of %s has changed since the incremental backup (checksum mismatch)", link.key, child.key)
+	}
+	return link, nil
+}
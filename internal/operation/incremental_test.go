@@ -0,0 +1,234 @@
+package operation
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/storage"
+)
+
+// createIncremental writes an incremental archive of files based on the archive
+// at parentPath, the way a backup does, and returns its manifest.
+func createIncremental(t *testing.T, path, parentPath string, files map[string]string) *archive.Manifest {
+	t.Helper()
+	ctx := context.Background()
+	b := &Backup{volume: "app"}
+	if err := b.IncrementalFrom(ctx, parentPath); err != nil {
+		t.Fatalf("IncrementalFrom() error: %v", err)
+	}
+	m := archive.NewManifest(archive.Volume{Name: "app"}, "gz")
+	m.SetParent(*b.parent)
+
+	var buf bytes.Buffer
+	index, err := writeArchive(&buf, volumeTar(t, files), m, archiveOptions{
+		compression: "gz",
+		parent:      b.parentIndex,
+		refetch: func(name string) (io.ReadCloser, error) {
+			// Like the Engine API, name the file by its base name
+			content := files[archive.CleanPath(name)]
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			tw.WriteHeader(&tar.Header{Name: filepath.Base(name), Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(content))})
+			io.WriteString(tw, content)
+			tw.Close()
+			return io.NopCloser(&buf), nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("writeArchive() error: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	backend := storage.FileBackend{}
+	if err := archive.WriteSidecar(ctx, backend, path, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.WriteIndexSidecar(ctx, backend, path, index, nil); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// createFull is createArchive with an index sidecar, so that it can be a parent.
+func createFull(t *testing.T, path string, files map[string]string) *archive.Manifest {
+	t.Helper()
+	m := createArchive(t, path, "app", "gz", files)
+	index, err := archive.ReadIndex(context.Background(), storage.FileBackend{}, path, nil)
+	if err != nil || index == nil {
+		t.Fatalf("ReadIndex() of the embedded index = %v, %v", index, err)
+	}
+	if err := archive.WriteIndexSidecar(context.Background(), storage.FileBackend{}, path, index, nil); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// readArchiveEntries returns the volume entries and the deletions of an archive.
+func readArchiveEntries(t *testing.T, path string) ([]string, []string) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reader, err := decompress(f, path, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	deleted, _, err := copyTar(tar.NewReader(reader), &out, PathFilter{}, nil, nil)
+	if err != nil {
+		t.Fatalf("copyTar() error: %v", err)
+	}
+	var names []string
+	for _, name := range tarNames(t, &out) {
+		if name != "./" {
+			names = append(names, archive.CleanPath(name))
+		}
+	}
+	sort.Strings(names)
+	return names, deleted
+}
+
+func TestIncrementalArchive(t *testing.T) {
+	dir := t.TempDir()
+	full := filepath.Join(dir, "app-1.tar.gz")
+	createFull(t, full, map[string]string{"same.txt": "same", "edit.txt": "before", "gone.txt": "bye", "touch.txt": "aaaa"})
+
+	incremental := filepath.Join(dir, "app-2.tar.gz")
+	m := createIncremental(t, incremental, full, map[string]string{
+		"same.txt":  "same",
+		"edit.txt":  "after!!",
+		"new.txt":   "new",
+		"touch.txt": "bbbb", // same size and mtime, different content
+	})
+
+	names, deleted := readArchiveEntries(t, incremental)
+	if strings.Join(names, ",") != "edit.txt,new.txt,touch.txt" {
+		t.Errorf("Incremental entries = %v; want the changed files only", names)
+	}
+	if strings.Join(deleted, ",") != "gone.txt" {
+		t.Errorf("Deletions = %v; want [gone.txt]", deleted)
+	}
+	if m.Version != archive.ManifestVersion || m.Parent == nil || m.Parent.Location != full {
+		t.Errorf("Manifest version %d, parent %+v; want version %d linking to %s", m.Version, m.Parent, archive.ManifestVersion, full)
+	}
+	if m.Files != 3 || m.Unchanged != 1 || m.Deleted != 1 {
+		t.Errorf("Manifest files %d, unchanged %d, deleted %d; want 3, 1, 1", m.Files, m.Unchanged, m.Deleted)
+	}
+	if err := Verify(context.Background(), incremental, nil, false); err != nil {
+		t.Errorf("Verify() of an incremental archive error: %v", err)
+	}
+
+	// The index describes the whole snapshot, so the next increment is based on it
+	next := filepath.Join(dir, "app-3.tar.gz")
+	createIncremental(t, next, incremental, map[string]string{"same.txt": "same", "edit.txt": "after!!", "touch.txt": "bbbb"})
+	names, deleted = readArchiveEntries(t, next)
+	if len(names) != 0 || strings.Join(deleted, ",") != "new.txt" {
+		t.Errorf("Second increment = %v, deleted %v; want nothing stored and new.txt deleted", names, deleted)
+	}
+}
+
+func TestIncrementalFromOtherVolume(t *testing.T) {
+	full := filepath.Join(t.TempDir(), "db.tar.gz")
+	createArchive(t, full, "db", "gz", map[string]string{"a": "a"})
+	b := &Backup{volume: "app"}
+	if err := b.IncrementalFrom(context.Background(), full); err == nil || !strings.Contains(err.Error(), "volume 'db'") {
+		t.Errorf("IncrementalFrom() another volume's backup error = %v", err)
+	}
+}
+
+func TestRestoreChain(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	files := map[string]string{"a.txt": "a"}
+	createFull(t, filepath.Join(dir, "app-1.tar.gz"), files)
+	createIncremental(t, filepath.Join(dir, "app-2.tar.gz"), filepath.Join(dir, "app-1.tar.gz"), files)
+	createIncremental(t, filepath.Join(dir, "app-3.tar.gz"), filepath.Join(dir, "app-2.tar.gz"), files)
+
+	// Chains are found next to the archive after moving them together
+	moved := t.TempDir()
+	for _, name := range []string{"app-1.tar.gz", "app-2.tar.gz", "app-3.tar.gz"} {
+		for _, suffix := range []string{"", archive.SidecarSuffix} {
+			os.Rename(filepath.Join(dir, name+suffix), filepath.Join(moved, name+suffix))
+		}
+	}
+	last := filepath.Join(moved, "app-3.tar.gz")
+	m, err := archive.ReadManifest(ctx, storage.FileBackend{}, last, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := restoreChain(ctx, chainLink{backend: storage.FileBackend{}, key: last, manifest: m}, nil)
+	if err != nil {
+		t.Fatalf("restoreChain() error: %v", err)
+	}
+	var keys []string
+	for _, link := range chain {
+		keys = append(keys, filepath.Base(link.key))
+	}
+	if strings.Join(keys, ",") != "app-1.tar.gz,app-2.tar.gz,app-3.tar.gz" {
+		t.Errorf("restoreChain() = %v; want the full archive first", keys)
+	}
+
+	os.Remove(filepath.Join(moved, "app-1.tar.gz"))
+	if _, err := restoreChain(ctx, chainLink{backend: storage.FileBackend{}, key: last, manifest: m}, nil); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("restoreChain() with a missing parent error = %v", err)
+	}
+}
+
+func TestRestoreChainWithFilter(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	full, increment := filepath.Join(dir, "app-1.tar.gz"), filepath.Join(dir, "app-2.tar.gz")
+	createFull(t, full, map[string]string{"a.txt": "a", "b.txt": "b"})
+	createIncremental(t, increment, full, map[string]string{"a.txt": "a", "b.txt": "changed"})
+	m, err := archive.ReadManifest(ctx, storage.FileBackend{}, increment, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := restoreChain(ctx, chainLink{backend: storage.FileBackend{}, key: increment, manifest: m}, nil)
+	if err != nil {
+		t.Fatalf("restoreChain() error: %v", err)
+	}
+
+	// The increment does not touch a.txt, which must not fail the restore
+	restore := func(filter PathFilter) (int, error) {
+		r := &Restore{filter: filter}
+		total := 0
+		for _, link := range chain {
+			f, err := os.Open(link.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader, err := decompress(f, link.key, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, matched, err := copyTar(tar.NewReader(reader), io.Discard, filter, nil, nil)
+			f.Close()
+			if err != nil {
+				t.Fatalf("copyTar() of %s error: %v", filepath.Base(link.key), err)
+			}
+			if link.key == increment && matched != 0 {
+				t.Errorf("copyTar() of the increment matched %d entries; want 0", matched)
+			}
+			total += matched
+		}
+		return total, r.checkSelection(total)
+	}
+	if matched, err := restore(PathFilter{Include: []string{"a.txt"}}); err != nil || matched != 1 {
+		t.Errorf("Restoring a.txt from the chain = %d, %v; want 1 matched entry", matched, err)
+	}
+	if _, err := restore(PathFilter{Include: []string{"c.txt"}}); err == nil || !strings.Contains(err.Error(), "no entries match") {
+		t.Errorf("Restoring c.txt from the chain error = %v; want no entries match", err)
+	}
+}
@@ -0,0 +1,175 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/rw"
+)
+
+// BackupInfo describes one archive at a location.
+type BackupInfo struct {
+	Key         string    `json:"key"`
+	Volume      string    `json:"volume,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Size        int64     `json:"size"`
+	Compression string    `json:"compression"`
+	Checksum    bool      `json:"checksum"`
+	Encrypted   bool      `json:"encrypted"`
+	// Manifest is false for archives written before manifests were introduced,
+	// whose volume is unknown and whose time is the object's modification time
+	Manifest bool `json:"manifest"`
+	// Parent is the key of the archive an incremental archive is based on, or
+	// the location recorded at backup time if it is not at the listed location
+	Parent string `json:"parent,omitempty"`
+	// Chain is the number of archives a restore applies, 1 for a full archive
+	// and 0 if a parent is missing from the listed location
+	Chain int `json:"chain"`
+}
+
+// ListFilter selects backups by volume and creation time. Zero fields match everything.
+type ListFilter struct {
+	Volume string
+	After  time.Time
+	Before time.Time
+}
+
+func (f ListFilter) matches(b BackupInfo) bool {
+	if f.Volume != "" && b.Volume != f.Volume {
+		return false
+	}
+	if !f.After.IsZero() && !b.CreatedAt.After(f.After) {
+		return false
+	}
+	if !f.Before.IsZero() && !b.CreatedAt.Before(f.Before) {
+		return false
+	}
+	return true
+}
+
+// ListBackups describes every archive below location that matches filter,
+// newest first. The details come from the manifest sidecars; archives without
+// one are described from the manifest at the start of the archive, unless they
+// are encrypted. Incremental archives are linked to their parents.
+func ListBackups(ctx context.Context, location string, filter ListFilter) ([]BackupInfo, error) {
+	archives, err := ListArchives(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []BackupInfo
+	parents := map[string]*archive.Parent{}
+	for _, obj := range archives.Objects {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		sidecars := archives.sidecars[obj.Key]
+		info := BackupInfo{
+			Key:       obj.Key,
+			CreatedAt: obj.ModTime.Local(),
+			Size:      obj.Size,
+			Checksum:  slices.Contains(sidecars, archive.ChecksumKey(obj.Key)),
+		}
+
+		var manifest *archive.Manifest
+		if slices.Contains(sidecars, archive.SidecarKey(obj.Key)) {
+			manifest, err = archive.ReadSidecar(ctx, archives.Backend, obj.Key)
+		} else {
+			manifest, err = archive.ReadEmbeddedManifest(ctx, archives.Backend, obj.Key, nil)
+		}
+		if errors.Is(err, rw.ErrEncrypted) {
+			info.Encrypted = true
+		} else if err != nil {
+			log.Printf("Warning: failed to read manifest of %s: %v", obj.Key, err)
+		}
+		if manifest != nil {
+			info.Manifest = true
+			info.Volume = manifest.Volume.Name
+			info.CreatedAt = manifest.CreatedAt.Local()
+			info.Compression = manifest.Compression
+			info.Encrypted = manifest.Encryption != ""
+			info.Checksum = info.Checksum || manifest.SHA256 != ""
+			if manifest.Parent != nil {
+				parents[obj.Key] = manifest.Parent
+			}
+		} else {
+			info.Compression, _ = rw.DetectCompression(strings.NewReader(""), rw.TrimEncryptedSuffix(obj.Key))
+		}
+
+		all = append(all, info)
+	}
+	linkChains(all, parents)
+
+	var backups []BackupInfo
+	for _, info := range all {
+		if filter.matches(info) {
+			backups = append(backups, info)
+		}
+	}
+
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	return backups, nil
+}
+
+// linkChains sets the parent and chain length of every backup. Like restores,
+// it looks for a parent next to its child first and then at the recorded
+// location; either must have been created at the recorded time.
+func linkChains(backups []BackupInfo, parents map[string]*archive.Parent) {
+	byKey := map[string]int{}
+	for i, b := range backups {
+		byKey[b.Key] = i
+	}
+	findParent := func(child int, parent *archive.Parent) (int, bool) {
+		b := backups[child]
+		base := parent.Location[strings.LastIndexAny(parent.Location, `/\`)+1:]
+		if i, ok := byKey[b.Key[:strings.LastIndexAny(b.Key, `/\`)+1]+base]; ok && i != child && backups[i].CreatedAt.Equal(parent.CreatedAt) {
+			return i, true
+		}
+		for i, candidate := range backups {
+			if i != child && strings.HasSuffix(parent.Location, candidate.Key) && candidate.CreatedAt.Equal(parent.CreatedAt) {
+				return i, true
+			}
+		}
+		return 0, false
+	}
+
+	chains := map[int]int{}
+	visiting := map[int]bool{}
+	var chain func(i int) int
+	chain = func(i int) int {
+		if n, ok := chains[i]; ok {
+			return n
+		}
+		parent, ok := parents[backups[i].Key]
+		if !ok {
+			chains[i] = 1
+			return 1
+		}
+		if visiting[i] {
+			return 0 // parent links that form a loop
+		}
+		visiting[i] = true
+		length := 0
+		if p, found := findParent(i, parent); found {
+			backups[i].Parent = backups[p].Key
+			if n := chain(p); n > 0 {
+				length = n + 1
+			}
+		} else {
+			backups[i].Parent = parent.Location
+		}
+		chains[i] = length
+		return length
+	}
+	for i := range backups {
+		backups[i].Chain = chain(i)
+	}
+}
@@ -0,0 +1,113 @@
+package operation
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/rw"
+	"docker-volume-backup/internal/storage"
+)
+
+func TestListBackups(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	files := map[string]string{"a.txt": "hello"}
+
+	// Sidecar manifests with fixed creation times
+	for i, volume := range []string{"db", "app"} {
+		path := filepath.Join(dir, volume+"-1.tar.gz")
+		m := createArchive(t, path, volume, "gz", files)
+		m.CreatedAt = time.Date(2024, 3, 1+i, 3, 0, 0, 0, time.UTC)
+		if err := archive.WriteSidecar(ctx, storage.FileBackend{}, path, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only the embedded manifest
+	embedded := filepath.Join(dir, "app-2.tar.gz")
+	createArchive(t, embedded, "app", "gz", files)
+	os.Remove(embedded + archive.SidecarSuffix)
+	os.Remove(embedded + archive.ChecksumSuffix)
+	// Written before manifests existed
+	var legacy bytes.Buffer
+	w, _ := rw.CreateWriter(&legacy, "zstd")
+	w.Write(volumeTar(t, files).Bytes())
+	w.Close()
+	os.WriteFile(filepath.Join(dir, "old.tar.zst"), legacy.Bytes(), 0o644)
+
+	backups, err := ListBackups(ctx, dir, ListFilter{})
+	if err != nil {
+		t.Fatalf("ListBackups() error: %v", err)
+	}
+	var got []string
+	for _, b := range backups {
+		got = append(got, fmt.Sprintf("%s:%s:%s:%v:%v", filepath.Base(b.Key), b.Volume, b.Compression, b.Checksum, b.Manifest))
+	}
+	// Newest first; the legacy archive and the embedded one both date from now
+	expected := []string{
+		"app-2.tar.gz:app:gz:false:true",
+		"old.tar.zst::zstd:false:false",
+		"app-1.tar.gz:app:gz:true:true",
+		"db-1.tar.gz:db:gz:true:true",
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("ListBackups() = %v; want %v", got, expected)
+	}
+	for _, want := range expected {
+		found := false
+		for _, g := range got {
+			found = found || g == want
+		}
+		if !found {
+			t.Errorf("ListBackups() = %v; missing %s", got, want)
+		}
+	}
+	if fmt.Sprint(got[2:]) != fmt.Sprint(expected[2:]) {
+		t.Errorf("ListBackups() order = %v; want oldest last %v", got, expected[2:])
+	}
+
+	tests := []struct {
+		name     string
+		filter   ListFilter
+		expected int
+	}{
+		{"volume", ListFilter{Volume: "app"}, 2},
+		{"after", ListFilter{After: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}, 3},
+		{"before", ListFilter{Before: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)}, 1},
+		{"volume and before", ListFilter{Volume: "app", Before: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}, 1},
+	}
+	for _, tt := range tests {
+		backups, err := ListBackups(ctx, dir, tt.filter)
+		if err != nil || len(backups) != tt.expected {
+			t.Errorf("%s: ListBackups() = %d backups, %v; want %d", tt.name, len(backups), err, tt.expected)
+		}
+	}
+}
+
+func TestListBackupsChains(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"a.txt": "a"}
+	createFull(t, filepath.Join(dir, "app-1.tar.gz"), files)
+	createIncremental(t, filepath.Join(dir, "app-2.tar.gz"), filepath.Join(dir, "app-1.tar.gz"), files)
+	createIncremental(t, filepath.Join(dir, "app-3.tar.gz"), filepath.Join(dir, "app-2.tar.gz"), files)
+	os.Remove(filepath.Join(dir, "app-1.tar.gz"))
+
+	backups, err := ListBackups(context.Background(), dir, ListFilter{})
+	if err != nil {
+		t.Fatalf("ListBackups() error: %v", err)
+	}
+	got := map[string]string{}
+	for _, b := range backups {
+		got[filepath.Base(b.Key)] = fmt.Sprintf("%s:%d", filepath.Base(b.Parent), b.Chain)
+	}
+	// app-1 is gone, so neither increment can be restored
+	expected := map[string]string{"app-2.tar.gz": "app-1.tar.gz:0", "app-3.tar.gz": "app-2.tar.gz:0"}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("ListBackups() chains = %v; want %v", got, expected)
+	}
+}
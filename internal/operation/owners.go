@@ -0,0 +1,244 @@
+package operation
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxID is the largest user or group ID; (uid_t)-1 means "unchanged" to chown.
+const maxID = 1<<32 - 2
+
+// OwnerOptions say how a restore changes the owners of the restored entries,
+// e.g. for a host with userns-remap or an image running its service under
+// another UID.
+type OwnerOptions struct {
+	// UIDs and GIDs map one ID, "from:to", or a range of IDs, "from:to:count"
+	UIDs []string
+	GIDs []string
+	// File holds more mappings, one per line as "uid from:to[:count]" or
+	// "gid from:to[:count]"; blank lines and lines starting with # are ignored
+	File string
+	// Chown gives every entry this owner, "uid" or "uid:gid", instead of mapping it
+	Chown string
+}
+
+// IsEmpty reports whether the options keep the recorded owners.
+func (o OwnerOptions) IsEmpty() bool {
+	return len(o.UIDs) == 0 && len(o.GIDs) == 0 && o.File == "" && o.Chown == ""
+}
+
+// idRange maps count IDs starting at from to the IDs starting at to.
+type idRange struct {
+	from, to, count int
+}
+
+func parseIDRange(s string) (idRange, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return idRange{}, fmt.Errorf("invalid ID mapping '%s', expected from:to or from:to:count", s)
+	}
+	r := idRange{count: 1}
+	fields := []*int{&r.from, &r.to, &r.count}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return idRange{}, fmt.Errorf("invalid ID mapping '%s', '%s' is not an ID", s, part)
+		}
+		*fields[i] = n
+	}
+	if r.count < 1 || r.from+r.count-1 > maxID || r.to+r.count-1 > maxID {
+		return idRange{}, fmt.Errorf("invalid ID mapping '%s', the range exceeds the valid IDs", s)
+	}
+	return r, nil
+}
+
+// idMap maps IDs by ranges, which do not overlap.
+type idMap []idRange
+
+func (m *idMap) add(r idRange) error {
+	for _, other := range *m {
+		if r.from < other.from+other.count && other.from < r.from+r.count {
+			return fmt.Errorf("ID mappings %d:%d:%d and %d:%d:%d overlap", other.from, other.to, other.count, r.from, r.to, r.count)
+		}
+	}
+	*m = append(*m, r)
+	return nil
+}
+
+// lookup returns the ID that id maps to, if a range covers it.
+func (m idMap) lookup(id int) (int, bool) {
+	for _, r := range m {
+		if id >= r.from && id < r.from+r.count {
+			return r.to + id - r.from, true
+		}
+	}
+	return 0, false
+}
+
+// ownerChange is an ID that was changed, "uid" or "gid".
+type ownerChange struct {
+	kind     string
+	from, to int
+}
+
+// OwnerMap changes the owners of restored entries and counts the changes. A
+// nil *OwnerMap keeps every owner.
+type OwnerMap struct {
+	uids, gids idMap
+	// chownUID and chownGID are the forced owner, -1 if not forced
+	chownUID, chownGID int
+	changes            map[ownerChange]int64
+}
+
+// LoadOwnerMap parses the mappings of the options. It returns nil if the
+// options keep the recorded owners.
+func LoadOwnerMap(opts OwnerOptions) (*OwnerMap, error) {
+	if opts.IsEmpty() {
+		return nil, nil
+	}
+	m := &OwnerMap{chownUID: -1, chownGID: -1, changes: map[ownerChange]int64{}}
+	for _, s := range opts.UIDs {
+		if err := m.addMapping("uid", s); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range opts.GIDs {
+		if err := m.addMapping("gid", s); err != nil {
+			return nil, err
+		}
+	}
+	if opts.File != "" {
+		if err := m.readFile(opts.File); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Chown != "" {
+		if err := m.parseChown(opts.Chown); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *OwnerMap) addMapping(kind, s string) error {
+	r, err := parseIDRange(s)
+	if err != nil {
+		return err
+	}
+	if kind == "uid" {
+		return m.uids.add(r)
+	}
+	return m.gids.add(r)
+}
+
+// readFile adds the mappings of a mapping file.
+func (m *OwnerMap) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read ID mapping file: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 || (fields[0] != "uid" && fields[0] != "gid") {
+			return fmt.Errorf("%s:%d: expected 'uid from:to[:count]' or 'gid from:to[:count]'", path, line)
+		}
+		if err := m.addMapping(fields[0], fields[1]); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read ID mapping file: %w", err)
+	}
+	return nil
+}
+
+// parseChown sets the forced owner, "uid" or "uid:gid". Forcing an ID rules
+// out mapping it.
+func (m *OwnerMap) parseChown(s string) error {
+	uid, gid, hasGID := strings.Cut(s, ":")
+	ids := []string{uid}
+	if hasGID {
+		ids = append(ids, gid)
+	}
+	for i, id := range ids {
+		n, err := strconv.Atoi(id)
+		if err != nil || n < 0 || n > maxID {
+			return fmt.Errorf("invalid owner '%s', expected uid or uid:gid", s)
+		}
+		if i == 0 {
+			m.chownUID = n
+		} else {
+			m.chownGID = n
+		}
+	}
+	if len(m.uids) > 0 {
+		return fmt.Errorf("--chown cannot be combined with UID mappings")
+	}
+	if hasGID && len(m.gids) > 0 {
+		return fmt.Errorf("--chown with a group cannot be combined with GID mappings")
+	}
+	return nil
+}
+
+// apply changes the owner of a restored entry. The user and group names are
+// cleared when their ID changes, as they named the original IDs; the daemon
+// restores by ID.
+func (m *OwnerMap) apply(header *tar.Header) {
+	if m == nil {
+		return
+	}
+	if uid, ok := m.newID(m.uids, m.chownUID, header.Uid); ok && uid != header.Uid {
+		m.changes[ownerChange{"uid", header.Uid, uid}]++
+		header.Uid, header.Uname = uid, ""
+	}
+	if gid, ok := m.newID(m.gids, m.chownGID, header.Gid); ok && gid != header.Gid {
+		m.changes[ownerChange{"gid", header.Gid, gid}]++
+		header.Gid, header.Gname = gid, ""
+	}
+}
+
+func (m *OwnerMap) newID(ids idMap, forced, id int) (int, bool) {
+	if forced >= 0 {
+		return forced, true
+	}
+	return ids.lookup(id)
+}
+
+// Summary lists the owners that were changed and on how many entries, UIDs
+// first, e.g. "uid 999 -> 1001 on 120 entries".
+func (m *OwnerMap) Summary() []string {
+	if m == nil {
+		return nil
+	}
+	changes := make([]ownerChange, 0, len(m.changes))
+	for change := range m.changes {
+		changes = append(changes, change)
+	}
+	slices.SortFunc(changes, func(a, b ownerChange) int {
+		if a.kind != b.kind {
+			return strings.Compare(b.kind, a.kind)
+		}
+		return a.from - b.from
+	})
+	summary := make([]string, 0, len(changes))
+	for _, change := range changes {
+		n := m.changes[change]
+		entries := "entries"
+		if n == 1 {
+			entries = "entry"
+		}
+		summary = append(summary, fmt.Sprintf("%s %d -> %d on %d %s", change.kind, change.from, change.to, n, entries))
+	}
+	return summary
+}
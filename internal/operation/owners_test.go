@@ -0,0 +1,122 @@
+package operation
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadOwnerMap(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ids")
+	os.WriteFile(file, []byte("# userns-remap of the new host\nuid 0:100000:65536\n\ngid 0:100000:65536\n"), 0o644)
+
+	tests := []struct {
+		name    string
+		opts    OwnerOptions
+		uid     int
+		gid     int
+		wantUID int
+		wantGID int
+	}{
+		{"single ID", OwnerOptions{UIDs: []string{"999:1001"}}, 999, 999, 1001, 999},
+		{"unmapped ID", OwnerOptions{UIDs: []string{"999:1001"}, GIDs: []string{"999:1001"}}, 33, 33, 33, 33},
+		{"range", OwnerOptions{UIDs: []string{"1000:2000:10"}}, 1009, 0, 2009, 0},
+		{"past the range", OwnerOptions{UIDs: []string{"1000:2000:10"}}, 1010, 0, 1010, 0},
+		{"file", OwnerOptions{File: file}, 999, 0, 100999, 100000},
+		{"chown", OwnerOptions{Chown: "1001:1002"}, 0, 999, 1001, 1002},
+		{"chown user with group mapping", OwnerOptions{Chown: "1001", GIDs: []string{"999:1001"}}, 0, 999, 1001, 1001},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := LoadOwnerMap(tt.opts)
+			if err != nil {
+				t.Fatalf("LoadOwnerMap() error: %v", err)
+			}
+			header := &tar.Header{Name: "./f", Uid: tt.uid, Gid: tt.gid, Uname: "postgres", Gname: "postgres"}
+			m.apply(header)
+			if header.Uid != tt.wantUID || header.Gid != tt.wantGID {
+				t.Errorf("apply() owner = %d:%d; want %d:%d", header.Uid, header.Gid, tt.wantUID, tt.wantGID)
+			}
+			if (header.Uname == "") != (tt.uid != tt.wantUID) || (header.Gname == "") != (tt.gid != tt.wantGID) {
+				t.Errorf("apply() names = %q:%q; want them cleared only for changed IDs", header.Uname, header.Gname)
+			}
+		})
+	}
+
+	if m, err := LoadOwnerMap(OwnerOptions{}); m != nil || err != nil {
+		t.Errorf("LoadOwnerMap() without options = %v, %v; want nil", m, err)
+	}
+}
+
+func TestLoadOwnerMapErrors(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ids")
+	os.WriteFile(file, []byte("uid 1:2\nuser 3:4\n"), 0o644)
+
+	tests := []struct {
+		name     string
+		opts     OwnerOptions
+		expected string
+	}{
+		{"missing target", OwnerOptions{UIDs: []string{"999"}}, "expected from:to"},
+		{"not a number", OwnerOptions{GIDs: []string{"www-data:33"}}, "is not an ID"},
+		{"negative", OwnerOptions{UIDs: []string{"-1:0"}}, "is not an ID"},
+		{"empty range", OwnerOptions{UIDs: []string{"0:1000:0"}}, "exceeds the valid IDs"},
+		{"too large", OwnerOptions{UIDs: []string{"0:4294967000:1000"}}, "exceeds the valid IDs"},
+		{"overlap", OwnerOptions{UIDs: []string{"1000:2000:10", "1005:3000"}}, "overlap"},
+		{"bad file line", OwnerOptions{File: file}, file + ":2: expected"},
+		{"missing file", OwnerOptions{File: file + ".missing"}, "failed to read ID mapping file"},
+		{"bad owner", OwnerOptions{Chown: "postgres"}, "expected uid or uid:gid"},
+		{"chown with UID mapping", OwnerOptions{Chown: "1001", UIDs: []string{"999:1001"}}, "UID mappings"},
+		{"chown with GID mapping", OwnerOptions{Chown: "1001:1001", GIDs: []string{"999:1001"}}, "GID mappings"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadOwnerMap(tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("LoadOwnerMap() error = %v; want %q", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCopyTarChangesOwners(t *testing.T) {
+	var src bytes.Buffer
+	tw := tar.NewWriter(&src)
+	for _, header := range []*tar.Header{
+		{Name: "./", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "./pgdata/", Typeflag: tar.TypeDir, Mode: 0o700, Uid: 999, Gid: 999},
+		{Name: "./pgdata/PG_VERSION", Typeflag: tar.TypeReg, Mode: 0o600, Uid: 999, Gid: 999, Uname: "postgres", Size: 2},
+		{Name: "./pgdata/postmaster.opts", Typeflag: tar.TypeReg, Mode: 0o600, Uid: 999, Gid: 999},
+	} {
+		tw.WriteHeader(header)
+		if header.Size > 0 {
+			tw.Write([]byte("16"))
+		}
+	}
+	tw.Close()
+
+	owners, err := LoadOwnerMap(OwnerOptions{UIDs: []string{"999:70"}, GIDs: []string{"999:70"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if _, _, err := copyTar(tar.NewReader(&src), &out, PathFilter{}, nil, owners); err != nil {
+		t.Fatalf("copyTar() error: %v", err)
+	}
+	headers := readHeaders(t, &out)
+	if h := headers["pgdata/PG_VERSION"]; h == nil || h.Uid != 70 || h.Gid != 70 || h.Uname != "" {
+		t.Errorf("pgdata/PG_VERSION = %+v; want owner 70:70 without a user name", h)
+	}
+	if h := headers[""]; h == nil || h.Uid != 0 {
+		t.Errorf("Volume root = %+v; want it still owned by root", h)
+	}
+
+	expected := []string{"uid 999 -> 70 on 3 entries", "gid 999 -> 70 on 3 entries"}
+	if got := owners.Summary(); !slices.Equal(got, expected) {
+		t.Errorf("Summary() = %v; want %v", got, expected)
+	}
+}
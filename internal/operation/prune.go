@@ -0,0 +1,208 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/retention"
+	"docker-volume-backup/internal/rw"
+	"docker-volume-backup/internal/storage"
+)
+
+// Archives is a set of archives in one storage backend.
+type Archives struct {
+	Backend storage.Backend
+	Objects []storage.ObjectInfo
+
+	// Sidecar keys by the key of the archive they belong to
+	sidecars map[string][]string
+	// oneVolume is set if the archives are known to be backups of one volume
+	oneVolume bool
+}
+
+func newArchives(backend storage.Backend, objects []storage.ObjectInfo, include func(key string) bool) *Archives {
+	archives := &Archives{Backend: backend, sidecars: map[string][]string{}}
+	for _, obj := range objects {
+		if include(obj.Key) {
+			archives.Objects = append(archives.Objects, obj)
+		}
+		if owner, ok := archive.SidecarOwner(obj.Key); ok {
+			archives.sidecars[owner] = append(archives.sidecars[owner], obj.Key)
+		}
+	}
+	return archives
+}
+
+// describe returns when an archive was created according to its manifest,
+// falling back to the modification time of the object, and the manifest. As
+// in ListBackups, archives without a sidecar are described from the manifest
+// at the start of the archive, unless they are encrypted.
+func (a *Archives) describe(ctx context.Context, obj storage.ObjectInfo) (time.Time, *archive.Manifest) {
+	var m *archive.Manifest
+	var err error
+	if slices.Contains(a.sidecars[obj.Key], archive.SidecarKey(obj.Key)) {
+		m, err = archive.ReadSidecar(ctx, a.Backend, obj.Key)
+	} else {
+		m, err = archive.ReadEmbeddedManifest(ctx, a.Backend, obj.Key, nil)
+	}
+	if err == nil && m != nil {
+		return m.CreatedAt.Local(), m
+	}
+	if err != nil && !errors.Is(err, rw.ErrEncrypted) {
+		log.Printf("Warning: failed to read manifest of %s, using its modification time: %v", obj.Key, err)
+	}
+	return obj.ModTime.Local(), nil
+}
+
+// ListArchives returns every archive below location, a local directory or an
+// object prefix such as s3://bucket/backups/. Other files are ignored.
+func ListArchives(ctx context.Context, location string) (*Archives, error) {
+	backend, prefix, err := storage.Resolve(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := backend.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return newArchives(backend, objects, rw.IsArchiveName), nil
+}
+
+// ListSeries returns the archives that a destination template has produced for
+// one volume of a job.
+func ListSeries(ctx context.Context, template string, vars DestinationVars) (*Archives, error) {
+	series, err := NewArchiveSeries(template, vars)
+	if err != nil {
+		return nil, err
+	}
+	backend, keyPrefix, err := storage.Resolve(ctx, series.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := backend.List(ctx, keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	// Backends may normalise a directory prefix, e.g. drop its trailing slash
+	if strings.HasSuffix(series.Prefix, "/") && !strings.HasSuffix(keyPrefix, "/") {
+		keyPrefix += "/"
+	}
+
+	archives := newArchives(backend, objects, func(key string) bool {
+		return series.Contains(keyPrefix, key)
+	})
+	archives.oneVolume = true
+	return archives, nil
+}
+
+// Prune applies a retention policy to the archives of each volume, as named by
+// their manifests, and deletes the archives that no rule keeps. Archives
+// without a manifest are treated as one more series, unless all archives are
+// known to be of one volume. The parents of kept incremental archives are kept
+// too, as restoring them needs the whole chain. The decisions are returned
+// newest first. With dryRun nothing is deleted.
+func Prune(ctx context.Context, archives *Archives, policy retention.Policy, dryRun bool) ([]retention.Decision, error) {
+	if policy.IsEmpty() {
+		return nil, fmt.Errorf("retention policy has no keep rules, refusing to remove every archive")
+	}
+
+	// Candidates by volume, "" for archives without a manifest
+	series := map[string][]retention.Archive{}
+	backups := make([]BackupInfo, 0, len(archives.Objects))
+	parents := map[string]*archive.Parent{}
+	for _, obj := range archives.Objects {
+		createdAt, manifest := archives.describe(ctx, obj)
+		info := BackupInfo{Key: obj.Key, CreatedAt: createdAt}
+		if manifest != nil {
+			info.Volume = manifest.Volume.Name
+			if manifest.Parent != nil {
+				parents[obj.Key] = manifest.Parent
+			}
+		}
+		backups = append(backups, info)
+		volume := info.Volume
+		if archives.oneVolume {
+			volume = ""
+		}
+		series[volume] = append(series[volume], retention.Archive{
+			Key:  obj.Key,
+			Time: createdAt,
+			Size: obj.Size,
+		})
+	}
+	if len(series) > 1 {
+		log.Printf("Applying the retention policy separately to %d volumes", len(series))
+		if n := len(series[""]); n > 0 {
+			log.Printf("Warning: %d archives have no manifest and are pruned as one series of their own", n)
+		}
+	}
+	var decisions []retention.Decision
+	for _, candidates := range series {
+		decisions = append(decisions, retention.Apply(candidates, policy)...)
+	}
+	keepParents(decisions, backups, parents)
+	slices.SortStableFunc(decisions, func(a, b retention.Decision) int {
+		if c := b.Time.Compare(a.Time); c != 0 {
+			return c
+		}
+		return strings.Compare(b.Key, a.Key)
+	})
+	if dryRun {
+		return decisions, nil
+	}
+
+	var errs []error
+	for _, d := range decisions {
+		if d.Keep {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return decisions, err
+		}
+		log.Printf("Removing archive %s", d.Key)
+		if err := archives.Backend.Delete(ctx, d.Key); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, sidecar := range archives.sidecars[d.Key] {
+			if err := archives.Backend.Delete(ctx, sidecar); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return decisions, errors.Join(errs...)
+}
+
+// keepParents keeps the archives that kept incremental archives are based on.
+func keepParents(decisions []retention.Decision, backups []BackupInfo, parents map[string]*archive.Parent) {
+	linkChains(backups, parents)
+	parentOf := map[string]string{}
+	for _, b := range backups {
+		parentOf[b.Key] = b.Parent
+	}
+	byKey := map[string]*retention.Decision{}
+	for i := range decisions {
+		byKey[decisions[i].Key] = &decisions[i]
+	}
+
+	for _, d := range decisions {
+		if !d.Keep {
+			continue
+		}
+		for child := d.Key; parentOf[child] != ""; child = parentOf[child] {
+			parent, ok := byKey[parentOf[child]]
+			if !ok || parent.Keep {
+				break
+			}
+			parent.Keep = true
+			parent.Reasons = append(parent.Reasons, "parent of "+child)
+		}
+	}
+}
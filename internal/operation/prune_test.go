@@ -0,0 +1,278 @@
+package operation
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/retention"
+	"docker-volume-backup/internal/storage"
+)
+
+// writeArchives creates empty files named after keys, with mtimes one day apart, newest first.
+func writeArchives(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	now := time.Now()
+	for i, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.AddDate(0, 0, -i)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func remaining(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			names = append(names, rel)
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names
+}
+
+func TestArchiveSeriesContains(t *testing.T) {
+	series, err := NewArchiveSeries("s3://bucket/{job}/{volume}-{timestamp}{ext}", DestinationVars{Job: "nightly", Volume: "app"})
+	if err != nil {
+		t.Fatalf("NewArchiveSeries() error: %v", err)
+	}
+	if series.Prefix != "s3://bucket/nightly/app-" {
+		t.Errorf("Prefix = %q; want %q", series.Prefix, "s3://bucket/nightly/app-")
+	}
+
+	tests := []struct {
+		key      string
+		expected bool
+	}{
+		{"nightly/app-20240309T030506Z.tar.gz", true},
+		{"nightly/app-20240309T030506Z.tar.zst", true},
+		{"nightly/app-20240309T030506Z.tar", true},
+		{"nightly/app-20240309T030506Z.tar.gz.age", true},
+		// Another volume whose name starts with the same prefix
+		{"nightly/app-data-20240309T030506Z.tar.gz", false},
+		{"nightly/app-20240309T030506Z.tar.gz.sha256", false},
+		{"nightly/app-latest.tar.gz", false},
+	}
+	for _, tt := range tests {
+		if got := series.Contains("nightly/app-", tt.key); got != tt.expected {
+			t.Errorf("Contains(%q) = %v; want %v", tt.key, got, tt.expected)
+		}
+	}
+
+	if _, err := NewArchiveSeries("/backups/{volume}.tar.gz", DestinationVars{Volume: "app"}); err == nil {
+		t.Error("NewArchiveSeries() without {timestamp} expected error but got none")
+	}
+}
+
+func TestPruneSeries(t *testing.T) {
+	dir := t.TempDir()
+	writeArchives(t, dir,
+		"app-20240305T030000Z.tar.gz",
+		"app-20240304T030000Z.tar.gz",
+		"app-20240303T030000Z.tar.zst",
+		"app-20240302T030000Z.tar.gz",
+	)
+	// Not part of the series
+	writeArchives(t, dir, "app-data-20240301T030000Z.tar.gz", "notes.txt")
+
+	archives, err := ListSeries(context.Background(), dir+"/", DestinationVars{Job: "nightly", Volume: "app"})
+	if err != nil {
+		t.Fatalf("ListSeries() error: %v", err)
+	}
+	if len(archives.Objects) != 4 {
+		t.Fatalf("ListSeries() found %d archives; want 4", len(archives.Objects))
+	}
+
+	// A dry run reports but keeps everything
+	decisions, err := Prune(context.Background(), archives, retention.Policy{Last: 2}, true)
+	if err != nil {
+		t.Fatalf("Prune(dry run) error: %v", err)
+	}
+	if len(decisions) != 4 || decisions[2].Keep || !decisions[1].Keep {
+		t.Errorf("Unexpected decisions: %v", decisions)
+	}
+	if n := len(remaining(t, dir)); n != 6 {
+		t.Errorf("Dry run removed files: %d left; want 6", n)
+	}
+
+	if _, err := Prune(context.Background(), archives, retention.Policy{Last: 2}, false); err != nil {
+		t.Fatalf("Prune() error: %v", err)
+	}
+	expected := []string{"app-20240304T030000Z.tar.gz", "app-20240305T030000Z.tar.gz", "app-data-20240301T030000Z.tar.gz", "notes.txt"}
+	if got := remaining(t, dir); fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Remaining files = %v; want %v", got, expected)
+	}
+}
+
+func TestPruneUsesManifests(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	// The mtimes say a is newest, but the manifests were copied along with the archives
+	writeArchives(t, dir, "a.tar.gz", "b.tar.gz")
+	for i, name := range []string{"a.tar.gz", "b.tar.gz"} {
+		m := archive.NewManifest(archive.Volume{Name: "app"}, "gz")
+		m.CreatedAt = time.Date(2024, 3, 1+i, 3, 0, 0, 0, time.UTC)
+		if err := archive.WriteSidecar(ctx, storage.FileBackend{}, filepath.Join(dir, name), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archives, err := ListArchives(ctx, dir)
+	if err != nil {
+		t.Fatalf("ListArchives() error: %v", err)
+	}
+	if _, err := Prune(ctx, archives, retention.Policy{Last: 1}, false); err != nil {
+		t.Fatalf("Prune() error: %v", err)
+	}
+	expected := []string{"b.tar.gz", "b.tar.gz" + archive.SidecarSuffix}
+	if got := remaining(t, dir); fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Remaining files = %v; want %v", got, expected)
+	}
+}
+
+func TestPruneRefusesEmptyPolicy(t *testing.T) {
+	dir := t.TempDir()
+	writeArchives(t, dir, "a.tar.gz")
+	archives, err := ListArchives(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("ListArchives() error: %v", err)
+	}
+	if _, err := Prune(context.Background(), archives, retention.Policy{}, false); err == nil {
+		t.Error("Prune() with an empty policy expected error but got none")
+	}
+	if n := len(remaining(t, dir)); n != 1 {
+		t.Errorf("Empty policy removed archives")
+	}
+}
+
+func TestPruneKeepsParents(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	// c is an increment of b, which is an increment of a
+	writeArchives(t, dir, "c.tar.gz", "b.tar.gz", "a.tar.gz")
+	names := []string{"a.tar.gz", "b.tar.gz", "c.tar.gz"}
+	var previous *archive.Manifest
+	for i, name := range names {
+		m := archive.NewManifest(archive.Volume{Name: "app"}, "gz")
+		m.CreatedAt = time.Date(2024, 3, 1+i, 3, 0, 0, 0, time.UTC)
+		if previous != nil {
+			m.SetParent(archive.Parent{Location: "/elsewhere/" + names[i-1], CreatedAt: previous.CreatedAt})
+		}
+		if err := archive.WriteSidecar(ctx, storage.FileBackend{}, filepath.Join(dir, name), m); err != nil {
+			t.Fatal(err)
+		}
+		previous = m
+	}
+
+	archives, err := ListArchives(ctx, dir)
+	if err != nil {
+		t.Fatalf("ListArchives() error: %v", err)
+	}
+	decisions, err := Prune(ctx, archives, retention.Policy{Last: 1}, true)
+	if err != nil {
+		t.Fatalf("Prune() error: %v", err)
+	}
+	for _, d := range decisions {
+		if !d.Keep {
+			t.Errorf("Prune() removes %s, which the kept increment needs", d.Key)
+		}
+	}
+	if reasons := fmt.Sprint(decisions[2].Reasons); reasons != "[parent of "+filepath.Join(dir, "b.tar.gz")+"]" {
+		t.Errorf("Reasons for keeping a.tar.gz = %s", reasons)
+	}
+}
+
+func TestPrunePerVolume(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	// One directory for every volume, as with the destination /backups/
+	names := []string{
+		"app-20240303T030000Z.tar.gz", "db-20240303T030000Z.tar.gz",
+		"app-20240302T030000Z.tar.gz", "db-20240302T030000Z.tar.gz",
+		"app-20240301T030000Z.tar.gz",
+	}
+	writeArchives(t, dir, names...)
+	for i, name := range names {
+		volume, _, _ := strings.Cut(name, "-")
+		m := archive.NewManifest(archive.Volume{Name: volume}, "gz")
+		m.CreatedAt = time.Date(2024, 3, 3-i/2, 3, 0, 0, 0, time.UTC)
+		if err := archive.WriteSidecar(ctx, storage.FileBackend{}, filepath.Join(dir, name), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Archives of older versions, without a manifest, form a series of their own
+	writeArchives(t, dir, "old-1.tar.gz", "old-2.tar.gz")
+
+	archives, err := ListArchives(ctx, dir)
+	if err != nil {
+		t.Fatalf("ListArchives() error: %v", err)
+	}
+	if _, err := Prune(ctx, archives, retention.Policy{Last: 1}, false); err != nil {
+		t.Fatalf("Prune() error: %v", err)
+	}
+	expected := []string{
+		"app-20240303T030000Z.tar.gz", "app-20240303T030000Z.tar.gz" + archive.SidecarSuffix,
+		"db-20240303T030000Z.tar.gz", "db-20240303T030000Z.tar.gz" + archive.SidecarSuffix,
+		"old-1.tar.gz",
+	}
+	if got := remaining(t, dir); fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Remaining files = %v; want %v", got, expected)
+	}
+}
+
+func TestPruneKeepsParentWithoutSidecar(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	// The archives were copied here, so the increment names its parent elsewhere
+	elsewhere := filepath.Join(t.TempDir(), "app-1.tar.gz")
+	createFull(t, elsewhere, map[string]string{"a.txt": "a"})
+	createIncremental(t, filepath.Join(dir, "app-2.tar.gz"), elsewhere, map[string]string{"a.txt": "b"})
+	// Writing a sidecar only warns on failure, which leaves the embedded manifest
+	full := filepath.Join(dir, "app-1.tar.gz")
+	if err := os.Rename(elsewhere, full); err != nil {
+		t.Fatal(err)
+	}
+	yesterday := time.Now().AddDate(0, 0, -1)
+	if err := os.Chtimes(full, yesterday, yesterday); err != nil {
+		t.Fatal(err)
+	}
+	// An archive of an older version, newer by mtime than the parent
+	writeArchives(t, dir, "app-0.tar.gz")
+
+	archives, err := ListArchives(ctx, dir)
+	if err != nil {
+		t.Fatalf("ListArchives() error: %v", err)
+	}
+	decisions, err := Prune(ctx, archives, retention.Policy{Last: 1}, false)
+	if err != nil {
+		t.Fatalf("Prune() error: %v", err)
+	}
+	if len(decisions) != 3 {
+		t.Fatalf("Prune() decided on %d archives; want 3", len(decisions))
+	}
+	for _, d := range decisions {
+		if !d.Keep {
+			t.Errorf("Prune() removes %s; want every archive kept", d.Key)
+		}
+	}
+	if reasons := fmt.Sprint(decisions[2].Reasons); reasons != "[parent of "+filepath.Join(dir, "app-2.tar.gz")+"]" {
+		t.Errorf("Reasons for keeping app-1.tar.gz = %s", reasons)
+	}
+}
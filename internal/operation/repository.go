@@ -0,0 +1,134 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"docker-volume-backup/internal/repo"
+)
+
+// BackupToRepository stores the volume in the deduplicating repository at
+// location as a new snapshot: the archive stream is split into chunks and only
+// the chunks the repository does not have yet are uploaded.
+func (b *Backup) BackupToRepository(ctx context.Context, location string) (*repo.Snapshot, error) {
+	var snapshot *repo.Snapshot
+	err := runHooks(ctx, b.volume, false, b.hooks, func() error {
+		var err error
+		snapshot, err = b.backupToRepository(ctx, location)
+		return err
+	})
+	return snapshot, err
+}
+
+func (b *Backup) backupToRepository(ctx context.Context, location string) (*repo.Snapshot, error) {
+	if b.keys.Encrypts() {
+		return nil, fmt.Errorf("repositories do not support encryption")
+	}
+	if b.parent != nil {
+		return nil, fmt.Errorf("--incremental-from does not apply to repositories, which only store new chunks anyway")
+	}
+	if b.dump {
+		return nil, fmt.Errorf("repositories cannot store database dumps")
+	}
+	repository, err := repo.Open(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := repository.Lock(ctx, repo.LockBackup)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	log.Printf("Backing up volume '%s' to repository %s", b.volume, location)
+	// Chunks are compressed one by one; a compressed stream would not deduplicate
+	b.compression = "none"
+	manifest := b.newManifest()
+	release, err := quiesceContainers(ctx, b.volume, b.containers)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	backupDone := make(chan error, 1)
+	go func() {
+		_, err := b.runBackup(ctx, pw, manifest)
+		pw.CloseWithError(err)
+		backupDone <- err
+	}()
+	chunks, stats, err := repository.Store(ctx, pr)
+	// Unblock the backup if storing stopped reading early
+	pr.CloseWithError(io.ErrClosedPipe)
+	backupErr := <-backupDone
+	releaseErr := release()
+	if backupErr != nil && !errors.Is(backupErr, io.ErrClosedPipe) {
+		return nil, errors.Join(backupErr, releaseErr)
+	}
+	if err != nil {
+		return nil, errors.Join(err, releaseErr)
+	}
+
+	snapshot := &repo.Snapshot{
+		CreatedAt: manifest.CreatedAt,
+		Manifest:  manifest,
+		Size:      stats.Size,
+		Chunks:    chunks,
+		NewChunks: stats.NewChunks,
+		NewSize:   stats.NewSize,
+	}
+	if err := repository.WriteSnapshot(ctx, snapshot); err != nil {
+		return nil, err
+	}
+	log.Printf("Stored %d chunks (%d bytes), of which %d were new (%d bytes, %d compressed)",
+		stats.Chunks, stats.Size, stats.NewChunks, stats.NewSize, stats.StoredSize)
+	log.Printf("Fidelity: %s", manifest.Fidelity)
+	log.Printf("Successfully backed up volume '%s' to snapshot %s", b.volume, snapshot.ID)
+	if releaseErr != nil {
+		return snapshot, fmt.Errorf("backup succeeded, but containers were not restarted: %w", releaseErr)
+	}
+	return snapshot, nil
+}
+
+// RestoreFromRepository restores a volume from a snapshot of the repository at
+// location, given by its ID or a unique prefix of it. The mode decides what
+// happens if the target already exists.
+func (r *Restore) RestoreFromRepository(ctx context.Context, location string, id string, mode RestoreMode) error {
+	repository, err := repo.Open(ctx, location)
+	if err != nil {
+		return err
+	}
+	snapshot, err := repository.FindSnapshot(ctx, id)
+	if err != nil {
+		return err
+	}
+	log.Printf("Snapshot %s contains %s", snapshot.ID, snapshot.Manifest.Provenance())
+	if snapshot.Manifest.Filter != nil {
+		log.Printf("Paths were filtered at backup time: %s", snapshot.Manifest.Filter)
+	}
+
+	return r.intoVolume(ctx, mode, &snapshot.Manifest.Volume, func() error {
+		log.Printf("Restoring snapshot %s to volume '%s'", snapshot.ID, r.volume)
+		if !r.filter.IsEmpty() {
+			log.Printf("Restoring only entries matching %s", r.filter)
+		}
+		stream := repository.NewReader(ctx, snapshot.Chunks)
+		defer stream.Close()
+		r.entries = newRestoredEntries(r.strict)
+		defer func() { r.entries = nil }()
+		matched, err := r.runRestore(ctx, stream, snapshot.ID, snapshot.Manifest.Compression, snapshot.Size)
+		if err != nil {
+			return err
+		}
+		if err := r.checkSelection(matched); err != nil {
+			return err
+		}
+		if err := r.finishEntries(ctx); err != nil {
+			return err
+		}
+		log.Printf("Successfully restored volume '%s' from snapshot %s", r.volume, snapshot.ID)
+		return nil
+	})
+}
@@ -0,0 +1,58 @@
+package operation
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/repo"
+)
+
+func TestRepositoryArchiveStream(t *testing.T) {
+	ctx := context.Background()
+	r, err := repo.Init(ctx, filepath.Join(t.TempDir(), "repo"))
+	if err != nil {
+		t.Fatalf("Init() error: %v", err)
+	}
+
+	// Store the archive stream of a backup the way BackupToRepository does
+	var stream bytes.Buffer
+	m := archive.NewManifest(archive.Volume{Name: "app"}, "none")
+	if _, err := writeArchive(&stream, volumeTar(t, map[string]string{"a.txt": "hello", "b/c.txt": "world"}), m, archiveOptions{compression: "none"}); err != nil {
+		t.Fatalf("writeArchive() error: %v", err)
+	}
+	chunks, stats, err := r.Store(ctx, &stream)
+	if err != nil {
+		t.Fatalf("Store() error: %v", err)
+	}
+	snapshot := &repo.Snapshot{CreatedAt: m.CreatedAt, Manifest: m, Size: stats.Size, Chunks: chunks}
+	if err := r.WriteSnapshot(ctx, snapshot); err != nil {
+		t.Fatalf("WriteSnapshot() error: %v", err)
+	}
+
+	// And read it back the way RestoreFromRepository does
+	found, err := r.FindSnapshot(ctx, snapshot.ID)
+	if err != nil {
+		t.Fatalf("FindSnapshot() error: %v", err)
+	}
+	in := r.NewReader(ctx, found.Chunks)
+	defer in.Close()
+	reader, err := decompress(in, found.ID, found.Manifest.Compression, nil)
+	if err != nil {
+		t.Fatalf("decompress() error: %v", err)
+	}
+	var out bytes.Buffer
+	if _, _, err := copyTar(tar.NewReader(reader), &out, PathFilter{}, nil, nil); err != nil {
+		t.Fatalf("copyTar() error: %v", err)
+	}
+	names := tarNames(t, &out)
+	sort.Strings(names)
+	if got := strings.Join(names, ","); got != "./,./a.txt,./b/c.txt" {
+		t.Errorf("Restored entries = %s", got)
+	}
+}
@@ -2,138 +2,318 @@ package operation
 
 import (
 	"archive/tar"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"os"
-	"os/exec"
+	"maps"
+	"slices"
 	"strings"
 
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/crypt"
 	"docker-volume-backup/internal/docker"
 	"docker-volume-backup/internal/rw"
-	"docker-volume-backup/internal/s3"
+	"docker-volume-backup/internal/storage"
 
 	"github.com/schollz/progressbar/v3"
 )
 
+// RestoreMode says how a restore treats a volume that already exists.
+type RestoreMode int
+
+const (
+	// RestoreNew refuses to restore into an existing volume.
+	RestoreNew RestoreMode = iota
+	// RestoreOverwrite clears an existing volume before restoring into it.
+	RestoreOverwrite
+	// RestoreMerge restores into an existing volume without clearing it: the
+	// restored paths are replaced and everything else is left untouched.
+	RestoreMerge
+)
+
 type Restore struct {
 	volume       string
+	filter       PathFilter
+	keys         *crypt.Keys
 	showProgress bool
+
+	// Labels given to the volume if the restore creates it, see SetLabels
+	labels    map[string]string
+	overrides VolumeOverrides
+	hooks     Hooks
+	strict    bool
+	owners    *OwnerMap
+	// Set while a restore runs, see restoredEntries
+	entries *restoredEntries
 }
 
-func NewRestore(volume string, showProgress bool) (*Restore, error) {
+// NewRestore prepares a restore of the archive entries selected by filter, or
+// of the whole archive if the filter is empty. Encrypted archives are decrypted
+// with the identities in keys.
+func NewRestore(volume string, filter PathFilter, keys *crypt.Keys, showProgress bool) (*Restore, error) {
 	// Validate inputs
 	if err := docker.ValidateVolumeName(volume); err != nil {
 		return nil, err
 	}
-	return &Restore{volume, showProgress}, nil
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	return &Restore{volume: volume, filter: filter, keys: keys, showProgress: showProgress}, nil
+}
+
+// SetLabels makes the restore create the volume with labels if it does not
+// exist yet, in addition to those recorded at backup time. Existing volumes
+// keep their labels.
+func (r *Restore) SetLabels(labels map[string]string) {
+	r.labels = labels
+}
+
+// SetVolumeOverrides changes the driver, driver options and labels recorded at
+// backup time that the restore creates the volume with if it does not exist yet.
+func (r *Restore) SetVolumeOverrides(overrides VolumeOverrides) {
+	r.overrides = overrides
+}
+
+// SetHooks adds hooks to run around the restore, before those declared by
+// labels on the containers using the volume.
+func (r *Restore) SetHooks(hooks Hooks) {
+	r.hooks = hooks
+}
+
+// SetStrict makes the restore fail instead of warning when an entry of the
+// archive is left out or restored differently, and check the metadata of the
+// restored entries in the volume afterwards.
+func (r *Restore) SetStrict(enabled bool) {
+	r.strict = enabled
 }
 
-// RestoreFromFile restores a volume from the specified file path. It optionally overwrites the target if it already exists.
-func (r *Restore) RestoreFromFile(src string, overwrite bool) error {
-	if err := ValidateFilePath(src); err != nil {
+// SetOwnerMap makes the restore change the owners of the restored entries.
+func (r *Restore) SetOwnerMap(owners *OwnerMap) {
+	r.owners = owners
+}
+
+// RestoreFrom restores a volume from src, which may be a local path or any
+// location with a registered storage scheme (e.g. s3://bucket/key).
+// The mode decides what happens if the target already exists.
+// If the restore fails or ctx is cancelled, a volume created by the restore is removed again.
+func (r *Restore) RestoreFrom(ctx context.Context, src string, mode RestoreMode) error {
+	backend, key, err := storage.Resolve(ctx, src)
+	if err != nil {
 		return err
 	}
 
-	// Check if volume exists
-	exists, err := docker.VolumeExists(r.volume)
+	// Make sure the backup is readable before touching the volume
+	info, err := backend.Stat(ctx, key)
 	if err != nil {
 		return err
 	}
+	// Reading the manifest also rejects archives from a newer, incompatible version
+	manifest, err := archive.ReadManifest(ctx, backend, key, r.keys.Decrypters())
+	if err != nil {
+		return err
+	}
+	if manifest != nil && manifest.Encryption != "" {
+		// The sidecar is not encrypted, so check that the archive can be decrypted
+		embedded, err := archive.ReadEmbeddedManifest(ctx, backend, key, r.keys.Decrypters())
+		if err != nil {
+			return err
+		}
+		// Only the embedded manifest has the values of the driver options
+		if embedded != nil {
+			manifest.Volume = embedded.Volume
+		}
+	}
+	if manifest != nil {
+		log.Printf("Archive contains %s", manifest.Provenance())
+		if manifest.Filter != nil {
+			log.Printf("Paths were filtered at backup time: %s", manifest.Filter)
+		}
+		if manifest.Dump != nil {
+			log.Printf("Archive also has a %s dump of container '%s', to replay it into a running database use restore --replay-dump <container> %s",
+				manifest.Dump.Engine, manifest.Dump.Container, src)
+		}
+	}
+	// An incremental archive needs every archive back to the full one
+	chain, err := restoreChain(ctx, chainLink{backend: backend, key: key, manifest: manifest, size: info.Size}, r.keys.Decrypters())
+	if err != nil {
+		return err
+	}
+	if len(chain) > 1 {
+		log.Printf("Archive is incremental, restoring a chain of %d archives starting with %s", len(chain), chain[0].key)
+		for _, link := range chain[:len(chain)-1] {
+			if link.manifest.Encryption != "" {
+				if _, err := archive.ReadEmbeddedManifest(ctx, link.backend, link.key, r.keys.Decrypters()); err != nil {
+					return err
+				}
+			}
+		}
+	}
 
-	if exists {
-		// Volume exists - check if overwrite flag is set
-		if !overwrite {
-			return fmt.Errorf("volume '%s' already exists. Use --overwrite flag to clear and restore, or delete the volume first", r.volume)
+	var recorded *archive.Volume
+	if manifest != nil {
+		recorded = &manifest.Volume
+	}
+	return r.intoVolume(ctx, mode, recorded, func() error {
+		log.Printf("Restoring %s to volume '%s'", src, r.volume)
+		if !r.filter.IsEmpty() {
+			log.Printf("Restoring only entries matching %s", r.filter)
+		}
+		r.entries = newRestoredEntries(r.strict)
+		defer func() { r.entries = nil }()
+		// An increment may well not touch the selected paths, only the whole chain must
+		matched := 0
+		for i, link := range chain {
+			if len(chain) > 1 {
+				log.Printf("Applying archive %d of %d: %s", i+1, len(chain), link.key)
+			}
+			n, err := r.restoreArchive(ctx, link)
+			if err != nil {
+				return err
+			}
+			matched += n
 		}
-		// Clear the existing volume before restore
-		if err := docker.ClearVolume(r.volume); err != nil {
+		if err := r.checkSelection(matched); err != nil {
 			return err
 		}
-	} else {
-		// Create new volume
-		if err := docker.CreateVolume(r.volume); err != nil {
+		if err := r.finishEntries(ctx); err != nil {
 			return err
 		}
-	}
+		log.Printf("Successfully restored volume '%s' from %s", r.volume, src)
+		return nil
+	})
+}
 
-	log.Printf("Restoring %s to volume '%s'", src, r.volume)
-	return r.runRestore(src)
+// intoVolume prepares the target volume as mode says and runs restore, between
+// the restore hooks. A missing volume is created with the settings recorded at
+// backup time, if known, changed by the volume overrides; it is removed again
+// if restore fails.
+func (r *Restore) intoVolume(ctx context.Context, mode RestoreMode, recorded *archive.Volume, restore func() error) error {
+	return runHooks(ctx, r.volume, true, r.hooks, func() error {
+		return r.prepareAndRestore(ctx, mode, recorded, restore)
+	})
 }
 
-// RestoreFromS3 restores a Docker volume from an S3 path. Requires the S3 path, and an overwrite flag for existing volumes.
-func (r *Restore) RestoreFromS3(path string, overwrite bool) error {
-	if err := s3.ValidatePath(path); err != nil {
+func (r *Restore) prepareAndRestore(ctx context.Context, mode RestoreMode, recorded *archive.Volume, restore func() error) (err error) {
+	existing, err := docker.InspectVolume(ctx, r.volume)
+	exists := err == nil
+	if err != nil && !errors.Is(err, docker.ErrNotFound) {
 		return err
 	}
 
-	// Check if volume exists
-	exists, err := docker.VolumeExists(r.volume)
-	if err != nil {
-		return err
+	if exists && mode != RestoreNew && recorded != nil && recorded.Driver != "" && !sameDriverSettings(existing, recorded) {
+		log.Printf("Warning: volume '%s' has other driver settings than the backed up volume (driver %s, backed up with %s), restoring into it as it is",
+			r.volume, existing.Driver, recorded.Driver)
 	}
-
 	if exists {
-		// Volume exists - check if overwrite flag is set
-		if !overwrite {
-			return fmt.Errorf("volume '%s' already exists. Use --overwrite flag to clear and restore, or delete the volume first", r.volume)
-		}
-		// Clear the existing volume before restore
-		if err := docker.ClearVolume(r.volume); err != nil {
-			return err
+		switch mode {
+		case RestoreOverwrite:
+			// Clear the existing volume before restore
+			if err := docker.ClearVolume(ctx, r.volume); err != nil {
+				return err
+			}
+		case RestoreMerge:
+			log.Printf("Merging into existing volume '%s', other contents are kept", r.volume)
+		default:
+			return fmt.Errorf("volume '%s' already exists. Use --overwrite flag to clear and restore, --merge to restore into it, or delete the volume first", r.volume)
 		}
+		defer func() {
+			if err != nil {
+				log.Printf("Warning: volume '%s' is incomplete after the failed restore", r.volume)
+			}
+		}()
 	} else {
-		// Create new volume
-		if err := docker.CreateVolume(r.volume); err != nil {
+		if err := r.createVolume(ctx, recorded); err != nil {
 			return err
 		}
+		defer func() {
+			if err != nil {
+				removeVolume(ctx, r.volume)
+			}
+		}()
 	}
+	return restore()
+}
 
-	// Create temporary file for download with proper permissions
-	// Preserve the original file extension for proper compression detection
-	ext := ".tar.gz" // default
-	if strings.HasSuffix(path, ".tar.zst") || strings.HasSuffix(path, ".zst") {
-		ext = ".tar.zst"
-	} else if strings.HasSuffix(path, ".tar") {
-		ext = ".tar"
-	}
-	tmpFile, err := os.CreateTemp("", fmt.Sprintf("docker-volume-restore-%s-*%s", r.volume, ext))
-	if err != nil {
-		return fmt.Errorf("failed to create temporary file: %w", err)
+// createVolume creates the target volume with the recorded settings changed by
+// the overrides, plus the labels given with SetLabels.
+func (r *Restore) createVolume(ctx context.Context, recorded *archive.Volume) error {
+	spec := r.overrides.volumeSpec(r.volume, recorded)
+	maps.Copy(spec.Labels, r.labels)
+	if spec.Driver != "" && (spec.Driver != "local" || len(spec.Options) > 0) {
+		// Option values may hold credentials, e.g. of a CIFS share
+		log.Printf("Recreating volume '%s' with driver %s and options %s",
+			r.volume, spec.Driver, strings.Join(slices.Sorted(maps.Keys(spec.Options)), ", "))
 	}
-	tmpFilePath := tmpFile.Name()
-	tmpFile.Close()
-	defer os.Remove(tmpFilePath)
-
-	// Download from S3
-	log.Printf("Downloading from S3: %s", path)
-	if err := s3.DownloadFile(path, tmpFilePath); err != nil {
+	if err := docker.CreateVolumeFrom(ctx, spec); err != nil {
+		if recorded != nil && !r.overrides.Plain && (recorded.Driver != "local" || len(recorded.DriverOptions) > 0) {
+			return fmt.Errorf("%w (the settings of the backed up volume can be changed with --volume-driver, --volume-opt and --drop-volume-opt, or ignored with --plain-volume)", err)
+		}
 		return err
 	}
+	return nil
+}
 
-	// Restore from local file
-	log.Printf("Restoring volume '%s' from downloaded backup", r.volume)
-	if err := r.runRestore(tmpFilePath); err != nil {
-		return fmt.Errorf("failed to restore from downloaded backup: %v", err)
+// checkSelection fails a filtered restore whose filter matched no entry of the
+// archives, counting both restored and deleted paths.
+func (r *Restore) checkSelection(matched int) error {
+	if r.filter.IsEmpty() {
+		return nil
 	}
+	if matched == 0 {
+		return fmt.Errorf("no entries match %s", r.filter)
+	}
+	log.Printf("Selected %d entries", matched)
+	return nil
+}
 
-	log.Printf("Successfully restored volume '%s' from %s", r.volume, path)
+// finishEntries checks the restored entries against the volume in strict mode
+// and logs the fidelity of the restore and the owners it changed.
+func (r *Restore) finishEntries(ctx context.Context) error {
+	if r.strict {
+		if err := r.entries.checkVolume(ctx, r.volume); err != nil {
+			return err
+		}
+	}
+	log.Printf("Fidelity: %s", r.entries.finish())
+	if r.owners != nil {
+		summary := r.owners.Summary()
+		if len(summary) == 0 {
+			log.Printf("No restored entry had an owner to change")
+		}
+		for _, line := range summary {
+			log.Printf("Changed owner: %s", line)
+		}
+	}
 	return nil
 }
 
-// runRestore performs the core logic to restore the contents of a compressed tar archive to a Docker volume.
-func (r *Restore) runRestore(src string) error {
+// restoreArchive restores one archive of a chain into the volume and returns
+// the number of entries the filter matched.
+func (r *Restore) restoreArchive(ctx context.Context, link chainLink) (int, error) {
+	in, err := link.backend.Open(ctx, link.key)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	compression := ""
+	if link.manifest != nil {
+		compression = link.manifest.Compression
+	}
+	return r.runRestore(ctx, in, link.key, compression, link.size)
+}
+
+// runRestore performs the core logic to restore the contents of a compressed tar archive to a Docker volume,
+// and removes the paths an incremental archive lists as deleted. It returns the number of entries the filter matched.
+// Without a known compression it is detected from the name; size drives the progress bar.
+func (r *Restore) runRestore(ctx context.Context, in io.Reader, name string, compression string, size int64) (int, error) {
 	// Get file size for progress bar
 	var bar *progressbar.ProgressBar
 	if r.showProgress {
-		fileSize, err := GetFileSize(src)
-		if err != nil {
-			log.Printf("Warning: could not determine file size: %v", err)
-		}
-
-		if fileSize > 0 {
+		if size > 0 {
 			bar = progressbar.DefaultBytes(
-				fileSize,
+				size,
 				"Restoring",
 			)
 		} else {
@@ -145,23 +325,16 @@ func (r *Restore) runRestore(src string) error {
 		defer bar.Finish()
 	}
 
-	// Open backup file
-	inFile, err := os.Open(src)
-	if err != nil {
-		return fmt.Errorf("failed to open backup file: %w", err)
-	}
-	defer inFile.Close()
-
-	// Wrap input file with progress tracking if enabled
-	var inReader io.Reader = inFile
+	// Wrap input with progress tracking if enabled
+	inReader := in
 	if bar != nil {
-		inReader = rw.NewProgressReader(inFile, bar)
+		inReader = rw.NewProgressReader(in, bar)
 	}
 
 	// Create reader with decompression
-	reader, err := rw.CreateReader(inReader, src)
+	reader, err := decompress(inReader, name, compression, r.keys)
 	if err != nil {
-		return fmt.Errorf("failed to create decompressed reader: %w", err)
+		return 0, err
 	}
 	defer reader.Close()
 
@@ -169,51 +342,132 @@ func (r *Restore) runRestore(src string) error {
 	tarReader := tar.NewReader(reader)
 
 	// Create a temporary container to access the volume
-	containerID, err := docker.CreateContainerWithVolume(r.volume)
+	containerID, err := docker.CreateContainerWithVolume(ctx, r.volume)
 	if err != nil {
-		return fmt.Errorf("failed to create temp container: %w", err)
+		return 0, fmt.Errorf("failed to create temp container: %w", err)
 	}
-	defer docker.RemoveContainer(containerID)
+	defer removeContainer(ctx, containerID)
 
-	// Use docker cp to write the tar stream to the volume
-	cmd := exec.Command("docker", "cp", "-", containerID+":/data/")
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stdin pipe: %w", err)
+	// Re-encode the archive into a pipe that feeds the Engine API archive upload
+	pr, pw := io.Pipe()
+	copyErr := make(chan error, 1)
+	var deleted []string
+	var matched int
+	go func() {
+		var err error
+		deleted, matched, err = copyTar(tarReader, pw, r.filter, r.entries, r.owners)
+		pw.CloseWithError(err)
+		copyErr <- err
+	}()
+
+	uploadErr := docker.CopyToContainer(ctx, containerID, "/data/", pr)
+	// Unblock the copy goroutine if the upload stopped reading early
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err := <-copyErr; err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		return 0, err
 	}
+	if uploadErr != nil {
+		return 0, uploadErr
+	}
+	if len(deleted) > 0 {
+		return matched, docker.RemovePaths(ctx, r.volume, deleted)
+	}
+	return matched, nil
+}
 
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start docker cp: %w", err)
+// decompress returns the decrypted and decompressed stream of an archive.
+// Encryption is detected from the stream's header. Without a known compression,
+// e.g. from the manifest sidecar, it is detected from the name.
+func decompress(r io.Reader, name string, compression string, keys *crypt.Keys) (io.ReadCloser, error) {
+	r, err := rw.CreateDecryptReader(r, keys.Decrypters())
+	if err != nil {
+		return nil, err
+	}
+	var reader io.ReadCloser
+	if compression != "" {
+		reader, err = rw.CreateReaderFor(r, compression)
+	} else {
+		reader, err = rw.CreateReader(r, rw.TrimEncryptedSuffix(name))
 	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create decompressed reader: %w", err)
+	}
+	return reader, nil
+}
 
-	// Write tar stream to docker cp
-	tarWriter := tar.NewWriter(stdin)
+// copyTar re-writes the volume entries selected by filter from tarReader into a
+// new tar stream on w, along with their parent directories. The archive's own
+// metadata, such as the manifest, is left out, and so are entries that cannot
+// be restored, which are reported to entries. The owners of the entries are
+// changed by owners. It returns the selected paths that an incremental archive
+// lists as deleted, and the number of entries and deletions the filter matched.
+func copyTar(tarReader *tar.Reader, w io.Writer, filter PathFilter, entries *restoredEntries, owners *OwnerMap) ([]string, int, error) {
+	tarWriter := tar.NewWriter(w)
+	var match func(string, bool) bool
+	if !filter.IsEmpty() {
+		match = func(name string, _ bool) bool { return filter.Match(name) }
+	}
+	selector := newEntrySelector(match)
+	var deleted []string
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("failed to read tar header: %w", err)
+			return nil, 0, fmt.Errorf("failed to read tar header: %w", err)
+		}
+		if archive.CleanPath(header.Name) == archive.DeletionsPath {
+			if deleted, err = archive.ParseDeletions(tarReader); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		if archive.IsReserved(header.Name) {
+			continue
+		}
+		ok, parents := selector.selectEntry(header)
+		if !ok {
+			continue
+		}
+		for _, dir := range parents {
+			owners.apply(dir)
+			if err := tarWriter.WriteHeader(dir); err != nil {
+				return nil, 0, fmt.Errorf("failed to write tar header: %w", err)
+			}
+			entries.add(dir)
+		}
+		if archive.EntryKind(header) == "" {
+			if err := entries.lose(archive.FidelityIssue{Path: header.Name, Dropped: true, Reason: fmt.Sprintf("unsupported entry type '%c'", header.Typeflag)}); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		// The daemon rejects the whole upload if a hardlink target is missing
+		if header.Typeflag == tar.TypeLink && match != nil && !match(indexPath(header.Linkname), false) {
+			if err := entries.lose(archive.FidelityIssue{Path: header.Name, Dropped: true, Reason: fmt.Sprintf("its hardlink target '%s' is not restored", header.Linkname)}); err != nil {
+				return nil, 0, err
+			}
+			continue
 		}
 
+		owners.apply(header)
 		if err := tarWriter.WriteHeader(header); err != nil {
-			return fmt.Errorf("failed to write tar header: %w", err)
+			return nil, 0, fmt.Errorf("failed to write tar header: %w", err)
 		}
+		entries.add(header)
 
 		if header.Typeflag == tar.TypeReg {
 			if _, err := io.Copy(tarWriter, tarReader); err != nil {
-				return fmt.Errorf("failed to write file data: %w", err)
+				return nil, 0, fmt.Errorf("failed to write file data: %w", err)
 			}
 		}
 	}
-
-	tarWriter.Close()
-	stdin.Close()
-
-	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("docker cp failed: %w", err)
+	if !filter.IsEmpty() {
+		deleted = slices.DeleteFunc(deleted, func(name string) bool { return !filter.Match(name) })
 	}
-
-	return nil
+	for _, name := range deleted {
+		entries.remove(name)
+	}
+	return deleted, selector.selected + len(deleted), tarWriter.Close()
 }
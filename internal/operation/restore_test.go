@@ -0,0 +1,74 @@
+package operation
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// tarNames returns the entry names of a tar stream.
+func tarNames(t *testing.T, r io.Reader) []string {
+	t.Helper()
+	var names []string
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatalf("failed to read tar: %v", err)
+		}
+		names = append(names, header.Name)
+	}
+}
+
+func TestCopyTarFilter(t *testing.T) {
+	// A volume archive with the directories before their contents, as the Engine API returns it
+	var src bytes.Buffer
+	tw := tar.NewWriter(&src)
+	for _, name := range []string{"./", "./etc/", "./etc/nginx/", "./etc/nginx/nginx.conf", "./etc/nginx/old.conf.bak", "./etc/hosts", "./logs/", "./logs/app.log"} {
+		header := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644}
+		if strings.HasSuffix(name, "/") {
+			header.Typeflag, header.Mode = tar.TypeDir, 0o755
+		}
+		tw.WriteHeader(header)
+	}
+	tw.Close()
+
+	tests := []struct {
+		name     string
+		filter   PathFilter
+		expected []string
+	}{
+		{"everything", PathFilter{}, []string{"./", "./etc/", "./etc/nginx/", "./etc/nginx/nginx.conf", "./etc/nginx/old.conf.bak", "./etc/hosts", "./logs/", "./logs/app.log"}},
+		// Parent directories are restored with the selected file
+		{"one file", PathFilter{Include: []string{"etc/hosts"}}, []string{"./", "./etc/", "./etc/hosts"}},
+		{"directory", PathFilter{Include: []string{"etc/nginx"}, Exclude: []string{"etc/nginx/*.bak"}}, []string{"./", "./etc/", "./etc/nginx/", "./etc/nginx/nginx.conf"}},
+		{"exclude only", PathFilter{Exclude: []string{"logs", "etc/nginx"}}, []string{"./", "./etc/", "./etc/hosts"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if _, _, err := copyTar(tar.NewReader(bytes.NewReader(src.Bytes())), &out, tt.filter, nil, nil); err != nil {
+				t.Fatalf("copyTar() error: %v", err)
+			}
+			if got := tarNames(t, &out); fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("copyTar() entries = %v; want %v", got, tt.expected)
+			}
+		})
+	}
+
+	missing := PathFilter{Include: []string{"missing"}}
+	_, matched, err := copyTar(tar.NewReader(bytes.NewReader(src.Bytes())), io.Discard, missing, nil, nil)
+	if err != nil || matched != 0 {
+		t.Errorf("copyTar() without matches = %d, %v; want 0 matched entries", matched, err)
+	}
+	r := &Restore{filter: missing}
+	if err := r.checkSelection(matched); err == nil || !strings.Contains(err.Error(), "no entries match") {
+		t.Errorf("checkSelection() without matches error = %v; want no entries match", err)
+	}
+}
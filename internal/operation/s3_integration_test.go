@@ -145,7 +145,7 @@ func TestS3BackupAndRestoreWithMinIO(t *testing.T) {
 			exec.Command("docker", "volume", "rm", volumeName).Run()
 
 			// Create test volume and write data to it
-			if err := docker.CreateVolume(volumeName); err != nil {
+			if err := docker.CreateVolume(ctx, volumeName); err != nil {
 				t.Fatalf("createVolume() error: %v", err)
 			}
 			defer exec.Command("docker", "volume", "rm", volumeName).Run()
@@ -162,12 +162,12 @@ func TestS3BackupAndRestoreWithMinIO(t *testing.T) {
 
 			// Backup the volume to MinIO S3
 			t.Logf("Backing up to MinIO S3: %s (endpoint: %s)", s3Path, endpoint)
-			backupOp, err := NewBackup(volumeName, tt.compress, false)
+			backupOp, err := NewBackup(ctx, volumeName, tt.compress, PathFilter{}, nil, false)
 			if err != nil {
 				t.Fatalf("Failed to create backup: %v", err)
 			}
-			if err := backupOp.BackupToS3(s3Path); err != nil {
-				t.Fatalf("BackupToS3() error: %v", err)
+			if err := backupOp.BackupTo(ctx, s3Path); err != nil {
+				t.Fatalf("BackupTo() error: %v", err)
 			}
 
 			// Verify the file exists in MinIO using AWS SDK
@@ -189,12 +189,12 @@ func TestS3BackupAndRestoreWithMinIO(t *testing.T) {
 
 			// Restore the volume from MinIO S3
 			t.Logf("Restoring from MinIO S3: %s", s3Path)
-			restoreOp, err := NewRestore(volumeName, false)
+			restoreOp, err := NewRestore(volumeName, PathFilter{}, nil, false)
 			if err != nil {
 				t.Fatalf("Failed to create restore: %v", err)
 			}
-			if err := restoreOp.RestoreFromS3(s3Path, true); err != nil {
-				t.Fatalf("RestoreFromS3() error: %v", err)
+			if err := restoreOp.RestoreFrom(ctx, s3Path, RestoreOverwrite); err != nil {
+				t.Fatalf("RestoreFrom() error: %v", err)
 			}
 
 			// Verify data was restored correctly
@@ -284,7 +284,7 @@ func TestS3BackupAndRestoreWithMinIOMultipleFiles(t *testing.T) {
 			exec.Command("docker", "volume", "rm", volumeName).Run()
 
 			// Create volume with multiple files
-			if err := docker.CreateVolume(volumeName); err != nil {
+			if err := docker.CreateVolume(ctx, volumeName); err != nil {
 				t.Fatalf("createVolume() error: %v", err)
 			}
 			defer exec.Command("docker", "volume", "rm", volumeName).Run()
@@ -306,11 +306,11 @@ func TestS3BackupAndRestoreWithMinIOMultipleFiles(t *testing.T) {
 			}
 
 			// Backup
-			backupOp, err := NewBackup(volumeName, tt.compress, false)
+			backupOp, err := NewBackup(ctx, volumeName, tt.compress, PathFilter{}, nil, false)
 			if err != nil {
 				t.Fatalf("Failed to create backup: %v", err)
 			}
-			if err := backupOp.BackupToS3(s3Path); err != nil {
+			if err := backupOp.BackupTo(ctx, s3Path); err != nil {
 				t.Fatalf("backupToS3() error: %v", err)
 			}
 
@@ -318,11 +318,11 @@ func TestS3BackupAndRestoreWithMinIOMultipleFiles(t *testing.T) {
 			exec.Command("docker", "volume", "rm", volumeName).Run()
 
 			// Restore
-			restoreOp, err := NewRestore(volumeName, false)
+			restoreOp, err := NewRestore(volumeName, PathFilter{}, nil, false)
 			if err != nil {
 				t.Fatalf("Failed to create restore: %v", err)
 			}
-			if err := restoreOp.RestoreFromS3(s3Path, true); err != nil {
+			if err := restoreOp.RestoreFrom(ctx, s3Path, RestoreOverwrite); err != nil {
 				t.Fatalf("restoreFromS3() error: %v", err)
 			}
 
@@ -399,18 +399,18 @@ func TestS3ValidationWithMinIO(t *testing.T) {
 	}
 
 	volumeName := "test-validation-volume"
-	docker.CreateVolume(volumeName)
+	docker.CreateVolume(ctx, volumeName)
 	defer exec.Command("docker", "volume", "rm", volumeName).Run()
 
 	for _, invalidPath := range invalidPaths {
 		t.Run("invalid_path_"+invalidPath, func(t *testing.T) {
-			backupOp, err := NewBackup(volumeName, "", false)
+			backupOp, err := NewBackup(ctx, volumeName, "", PathFilter{}, nil, false)
 			if err != nil {
 				t.Fatalf("Failed to create backup: %v", err)
 			}
-			err = backupOp.BackupToS3(invalidPath)
+			err = backupOp.BackupTo(ctx, invalidPath)
 			if err == nil {
-				t.Errorf("backupToS3() with invalid path %q should have failed but didn't", invalidPath)
+				t.Errorf("BackupTo() with invalid path %q should have failed but didn't", invalidPath)
 			}
 		})
 	}
@@ -0,0 +1,248 @@
+package operation
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/crypt"
+	"docker-volume-backup/internal/rw"
+	"docker-volume-backup/internal/storage"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// Verify checks the integrity of the archive at src without using Docker. It
+// compares the digest of the stored archive with its checksum sidecar (or the
+// one recorded in its manifest sidecar), decompresses and parses the whole tar
+// stream and compares every file with the digests recorded in the archive.
+// Encrypted archives are decrypted with the identities in keys; without any,
+// only the archive checksum is checked.
+func Verify(ctx context.Context, src string, keys *crypt.Keys, showProgress bool) error {
+	backend, key, err := storage.Resolve(ctx, src)
+	if err != nil {
+		return err
+	}
+	info, err := backend.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	expected, err := archive.ReadChecksumSidecar(ctx, backend, key)
+	if err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return err
+	}
+	compression := ""
+	manifest, err := archive.ReadSidecar(ctx, backend, key)
+	switch {
+	case err == nil:
+		compression = manifest.Compression
+		if expected == "" {
+			expected = manifest.SHA256
+		}
+	case !errors.Is(err, storage.ErrNotExist):
+		return err
+	}
+
+	in, err := backend.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	log.Printf("Verifying %s", src)
+	var inReader io.Reader = in
+	if showProgress {
+		size := info.Size
+		if size <= 0 {
+			size = -1 // indeterminate progress
+		}
+		bar := progressbar.DefaultBytes(size, "Verifying")
+		defer bar.Finish()
+		inReader = rw.NewProgressReader(in, bar)
+	}
+	digest := rw.NewDigestWriter(io.Discard)
+	inReader = io.TeeReader(inReader, digest)
+
+	contents, err := readArchive(ctx, inReader, key, compression, keys)
+	if errors.Is(err, rw.ErrEncrypted) {
+		if expected == "" {
+			return fmt.Errorf("cannot verify %s without an identity, it is encrypted and has no recorded checksum", src)
+		}
+		log.Printf("Warning: %s is encrypted and no identity was provided, only checking the archive checksum", src)
+	} else if err != nil {
+		return err
+	}
+	// Read up to the end of the object, so that the digest covers every stored byte
+	if _, err := io.Copy(io.Discard, inReader); err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	if contents != nil && contents.manifest != nil {
+		log.Printf("Archive contains %s", contents.manifest.Provenance())
+	}
+
+	var problems []error
+	switch {
+	case expected == "":
+		log.Printf("Warning: no archive checksum recorded for %s, only checking its contents", src)
+	case digest.Sum() != expected:
+		problems = append(problems, fmt.Errorf("archive checksum mismatch: got %s, want %s", digest.Sum(), expected))
+	default:
+		log.Printf("Archive checksum OK (sha256 %s)", expected)
+	}
+
+	switch {
+	case contents == nil:
+	case contents.checksums == nil:
+		log.Printf("Warning: archive has no file checksums, only checked that it can be read (%d files)", len(contents.files))
+	default:
+		fileProblems := compareFiles(contents.files, contents.checksums)
+		if len(fileProblems) == 0 {
+			log.Printf("All %d file checksums OK", len(contents.files))
+		}
+		problems = append(problems, fileProblems...)
+	}
+
+	// Archives without a sidecar still record the dump in the embedded manifest
+	if manifest == nil && contents != nil {
+		manifest = contents.manifest
+	}
+	if manifest != nil && manifest.Dump != nil {
+		if err := verifyDump(ctx, backend, key, manifest.Dump); err != nil {
+			problems = append(problems, err)
+		} else {
+			log.Printf("Database dump checksum OK (sha256 %s)", manifest.Dump.SHA256)
+		}
+	}
+
+	if len(problems) > 0 {
+		for _, p := range problems {
+			log.Printf("ERROR: %v", p)
+		}
+		return fmt.Errorf("archive %s is corrupt, see the errors above", src)
+	}
+	log.Printf("Successfully verified %s", src)
+	return nil
+}
+
+// verifyDump compares the digest of the database dump stored next to the
+// archive key with the one recorded in the archive's manifest.
+func verifyDump(ctx context.Context, backend storage.Backend, key string, dump *archive.Dump) error {
+	in, err := backend.Open(ctx, archive.DumpKey(key))
+	if errors.Is(err, storage.ErrNotExist) {
+		return fmt.Errorf("database dump %s is missing", archive.DumpKey(key))
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	digest := rw.NewDigestWriter(io.Discard)
+	if _, err := io.Copy(digest, in); err != nil {
+		return fmt.Errorf("failed to read database dump: %w", err)
+	}
+	if digest.Sum() != dump.SHA256 {
+		return fmt.Errorf("database dump checksum mismatch: got %s, want %s", digest.Sum(), dump.SHA256)
+	}
+	return nil
+}
+
+// readArchive decrypts and decompresses an archive and reads its contents up to
+// the end of the compressed stream.
+func readArchive(ctx context.Context, in io.Reader, name string, compression string, keys *crypt.Keys) (*archiveContents, error) {
+	reader, err := decompress(in, name, compression, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	contents, err := readContents(ctx, tar.NewReader(reader))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	return contents, nil
+}
+
+type archiveContents struct {
+	manifest  *archive.Manifest
+	files     map[string]string
+	checksums map[string]string
+}
+
+// readContents parses a whole tar stream and computes the digest of every regular file.
+func readContents(ctx context.Context, tarReader *tar.Reader) (*archiveContents, error) {
+	manifest, header, err := archive.ReadManifestEntry(tarReader)
+	if err != nil {
+		return nil, err
+	}
+	contents := &archiveContents{manifest: manifest, files: map[string]string{}}
+	for {
+		if header == nil {
+			header, err = tarReader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read tar header: %w", err)
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case archive.CleanPath(header.Name) == archive.ChecksumsPath:
+			contents.checksums, err = archive.ParseChecksums(tarReader)
+			if err != nil {
+				return nil, err
+			}
+		case archive.IsReserved(header.Name):
+		case header.Typeflag == tar.TypeReg:
+			digest := rw.NewDigestWriter(io.Discard)
+			if _, err := io.Copy(digest, tarReader); err != nil {
+				return nil, fmt.Errorf("failed to read file '%s': %w", header.Name, err)
+			}
+			contents.files[archive.CleanPath(header.Name)] = digest.Sum()
+		}
+		header = nil
+	}
+	return contents, nil
+}
+
+// compareFiles reports every file whose digest differs from the recorded one,
+// and files that are missing on either side.
+func compareFiles(files, checksums map[string]string) []error {
+	var problems []error
+	for _, name := range sortedKeys(checksums) {
+		sum, ok := files[name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Errorf("file '%s' is missing from the archive", name))
+		case sum != checksums[name]:
+			problems = append(problems, fmt.Errorf("file '%s' checksum mismatch", name))
+		}
+	}
+	for _, name := range sortedKeys(files) {
+		if _, ok := checksums[name]; !ok {
+			problems = append(problems, fmt.Errorf("file '%s' has no recorded checksum", name))
+		}
+	}
+	return problems
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,257 @@
+package operation
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/crypt"
+	"docker-volume-backup/internal/rw"
+	"docker-volume-backup/internal/storage"
+
+	"filippo.io/age"
+)
+
+// volumeTar returns a tar stream like the one the Engine API returns for a volume.
+func volumeTar(t *testing.T, files map[string]string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0o755})
+	for name, content := range files {
+		tw.WriteHeader(&tar.Header{Name: "./" + name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(content))})
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// createArchive writes an archive and its sidecars the way a backup does.
+func createArchive(t *testing.T, path, volume, compression string, files map[string]string) *archive.Manifest {
+	t.Helper()
+	return createEncryptedArchive(t, path, volume, compression, nil, files)
+}
+
+// createEncryptedArchive is createArchive for archives encrypted with keys.
+func createEncryptedArchive(t *testing.T, path, volume, compression string, keys *crypt.Keys, files map[string]string) *archive.Manifest {
+	t.Helper()
+	var buf bytes.Buffer
+	m := archive.NewManifest(archive.Volume{Name: volume, Driver: "local"}, compression)
+	if keys.Encrypts() {
+		m.Encryption = crypt.Scheme
+	}
+	if _, err := writeArchive(&buf, volumeTar(t, files), m, archiveOptions{compression: compression, keys: keys}); err != nil {
+		t.Fatalf("writeArchive() error: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := archive.WriteSidecar(ctx, storage.FileBackend{}, path, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.WriteChecksumSidecar(ctx, storage.FileBackend{}, path, m.SHA256); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestWriteArchiveManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.tar.zst")
+	m := createArchive(t, path, "app", "zstd", map[string]string{"a.txt": "hello", "b/c.txt": "world!"})
+	if m.Files != 2 || m.UncompressedSize != 11 || m.SHA256 == "" {
+		t.Errorf("Manifest statistics = %d files, %d bytes, sha256 %q; want 2 files, 11 bytes and a checksum", m.Files, m.UncompressedSize, m.SHA256)
+	}
+	info, _ := os.Stat(path)
+	if m.Size != info.Size() {
+		t.Errorf("Manifest size = %d; want %d", m.Size, info.Size())
+	}
+
+	sidecar, _ := os.ReadFile(path + archive.ChecksumSuffix)
+	if want := m.SHA256 + "  app.tar.zst\n"; string(sidecar) != want {
+		t.Errorf("Checksum sidecar = %q; want %q", sidecar, want)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	files := map[string]string{"a.txt": "hello", "b/c.txt": "world!"}
+
+	t.Run("valid", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "app.tar.gz")
+		createArchive(t, path, "app", "gz", files)
+		if err := Verify(ctx, path, nil, false); err != nil {
+			t.Errorf("Verify() error: %v", err)
+		}
+	})
+
+	t.Run("without sidecars", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "app.tar")
+		createArchive(t, path, "app", "none", files)
+		os.Remove(path + archive.SidecarSuffix)
+		os.Remove(path + archive.ChecksumSuffix)
+		if err := Verify(ctx, path, nil, false); err != nil {
+			t.Errorf("Verify() error: %v", err)
+		}
+	})
+
+	t.Run("archive checksum mismatch", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "app.tar.gz")
+		createArchive(t, path, "app", "gz", files)
+		other := filepath.Join(t.TempDir(), "other.tar.gz")
+		createArchive(t, other, "app", "gz", map[string]string{"a.txt": "changed"})
+		data, _ := os.ReadFile(other)
+		os.WriteFile(path, data, 0o644)
+
+		err := Verify(ctx, path, nil, false)
+		if err == nil || !strings.Contains(err.Error(), "corrupt") {
+			t.Errorf("Verify() error = %v; want corrupt archive", err)
+		}
+	})
+
+	t.Run("file checksum mismatch", func(t *testing.T) {
+		// An uncompressed archive where one byte of file data is flipped
+		path := filepath.Join(t.TempDir(), "app.tar")
+		createArchive(t, path, "app", "none", files)
+		os.Remove(path + archive.ChecksumSuffix)
+		os.Remove(path + archive.SidecarSuffix)
+		data, _ := os.ReadFile(path)
+		i := bytes.Index(data, []byte("world!"))
+		data[i] = 'W'
+		os.WriteFile(path, data, 0o644)
+
+		err := Verify(ctx, path, nil, false)
+		if err == nil || !strings.Contains(err.Error(), "corrupt") {
+			t.Errorf("Verify() error = %v; want corrupt archive", err)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "app.tar.gz")
+		createArchive(t, path, "app", "gz", files)
+		data, _ := os.ReadFile(path)
+		os.WriteFile(path, data[:len(data)-10], 0o644)
+		os.Remove(path + archive.ChecksumSuffix)
+		os.Remove(path + archive.SidecarSuffix)
+
+		if err := Verify(ctx, path, nil, false); err == nil {
+			t.Error("Verify() of a truncated archive expected error but got none")
+		}
+	})
+}
+
+func TestEncryptedArchive(t *testing.T) {
+	ctx := context.Background()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := &crypt.Keys{Recipients: []age.Recipient{identity.Recipient()}, Identities: []age.Identity{identity}}
+	path := filepath.Join(t.TempDir(), "app.tar.gz.age")
+	createEncryptedArchive(t, path, "app", "gz", keys, map[string]string{"a.txt": "secret"})
+
+	data, _ := os.ReadFile(path)
+	if bytes.Contains(data, []byte("a.txt")) {
+		t.Error("Encrypted archive contains plaintext file names")
+	}
+
+	if err := Verify(ctx, path, keys, false); err != nil {
+		t.Errorf("Verify() error: %v", err)
+	}
+	// Without an identity only the archive checksum can be checked
+	if err := Verify(ctx, path, nil, false); err != nil {
+		t.Errorf("Verify() without identity error: %v", err)
+	}
+
+	var got []string
+	err = ListContents(ctx, path, "", keys, func(e Entry) error {
+		got = append(got, e.Path)
+		return nil
+	})
+	if err != nil || len(got) != 1 || got[0] != "a.txt" {
+		t.Errorf("ListContents() = %v, %v; want [a.txt]", got, err)
+	}
+	err = ListContents(ctx, path, "", nil, func(Entry) error { return nil })
+	if !errors.Is(err, rw.ErrEncrypted) {
+		t.Errorf("ListContents() without identity error = %v; want ErrEncrypted", err)
+	}
+
+	// The embedded manifest is encrypted too
+	os.Remove(path + archive.SidecarSuffix)
+	if _, err := archive.ReadManifest(ctx, storage.FileBackend{}, path, nil); !errors.Is(err, rw.ErrEncrypted) {
+		t.Errorf("ReadManifest() without identity error = %v; want ErrEncrypted", err)
+	}
+	m, err := archive.ReadManifest(ctx, storage.FileBackend{}, path, keys.Decrypters())
+	if err != nil || m.Encryption != crypt.Scheme {
+		t.Errorf("ReadManifest() = %+v, %v; want an encrypted archive's manifest", m, err)
+	}
+
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ListContents(ctx, path, "", &crypt.Keys{Identities: []age.Identity{other}}, func(Entry) error { return nil })
+	if err == nil {
+		t.Error("ListContents() with the wrong identity expected error but got none")
+	}
+}
+
+func TestEncryptedSidecarOmitsDriverOptions(t *testing.T) {
+	ctx := context.Background()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := &crypt.Keys{Recipients: []age.Recipient{identity.Recipient()}, Identities: []age.Identity{identity}}
+	volume := archive.Volume{Name: "share", Driver: "local", DriverOptions: map[string]string{
+		"type": "cifs", "device": "//nas/share", "o": "username=backup,password=s3cret",
+	}}
+	m := archive.NewManifest(volume, "gz")
+	m.Encryption = crypt.Scheme
+	var buf bytes.Buffer
+	if _, err := writeArchive(&buf, volumeTar(t, map[string]string{"a.txt": "a"}), m, archiveOptions{compression: "gz", keys: keys}); err != nil {
+		t.Fatalf("writeArchive() error: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "share.tar.gz.age")
+	os.WriteFile(path, buf.Bytes(), 0o644)
+	if err := archive.WriteSidecar(ctx, storage.FileBackend{}, path, m); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(archive.SidecarKey(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range volume.DriverOptions {
+		if bytes.Contains(data, []byte(value)) {
+			t.Errorf("Sidecar of an encrypted archive contains the driver option value %q", value)
+		}
+	}
+	sidecar, err := archive.ReadSidecar(ctx, storage.FileBackend{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sidecar.Volume.OptionsRedacted || len(sidecar.Volume.DriverOptions) != 3 {
+		t.Errorf("Sidecar volume = %+v; want the option keys without values", sidecar.Volume)
+	}
+	if m.Volume.OptionsRedacted || m.Volume.DriverOptions["o"] == "" {
+		t.Error("WriteSidecar() changed the manifest it was given")
+	}
+
+	embedded, err := archive.ReadEmbeddedManifest(ctx, storage.FileBackend{}, path, keys.Decrypters())
+	if err != nil {
+		t.Fatalf("ReadEmbeddedManifest() error: %v", err)
+	}
+	if !maps.Equal(embedded.Volume.DriverOptions, volume.DriverOptions) {
+		t.Errorf("Embedded driver options = %v; want %v", embedded.Volume.DriverOptions, volume.DriverOptions)
+	}
+}
@@ -0,0 +1,83 @@
+package operation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"docker-volume-backup/internal/docker"
+)
+
+// VolumeSelector selects the volumes of a backup: volumes by name, every
+// volume carrying all of Labels, or every volume if All is set.
+type VolumeSelector struct {
+	Names []string
+	// Labels are selectors such as "key" or "key=value", as in `docker volume ls --filter label=...`
+	Labels []string
+	All    bool
+}
+
+// IsEmpty reports whether the selector selects no volume at all.
+func (s VolumeSelector) IsEmpty() bool {
+	return len(s.Names) == 0 && len(s.Labels) == 0 && !s.All
+}
+
+// Validate checks the volume names and label selectors.
+func (s VolumeSelector) Validate() error {
+	for _, volume := range s.Names {
+		if err := docker.ValidateVolumeName(volume); err != nil {
+			return err
+		}
+	}
+	for _, label := range s.Labels {
+		if err := ValidateLabelSelector(label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateLabelSelector checks that a label selector is "key" or "key=value".
+func ValidateLabelSelector(label string) error {
+	if key, _, _ := strings.Cut(label, "="); strings.TrimSpace(key) == "" {
+		return fmt.Errorf("invalid selector '%s', expected key or key=value", label)
+	}
+	return nil
+}
+
+// SelectVolumes returns the named volumes plus every volume matching the label
+// selectors, or every volume, sorted and without duplicates. Named volumes are
+// not checked for existence here, so that a backup can report them as failed.
+func SelectVolumes(ctx context.Context, s VolumeSelector) ([]string, error) {
+	seen := map[string]bool{}
+	for _, volume := range s.Names {
+		seen[volume] = true
+	}
+	if s.All || len(s.Labels) > 0 {
+		var labels []string
+		if !s.All {
+			labels = s.Labels
+		}
+		matched, err := docker.ListVolumes(ctx, labels)
+		if err != nil {
+			return nil, err
+		}
+		for _, volume := range matched {
+			seen[volume] = true
+		}
+	}
+	if len(seen) == 0 {
+		if s.All {
+			return nil, fmt.Errorf("there are no volumes")
+		}
+		return nil, fmt.Errorf("no volumes match labels %s", strings.Join(s.Labels, ", "))
+	}
+
+	volumes := make([]string, 0, len(seen))
+	for volume := range seen {
+		volumes = append(volumes, volume)
+	}
+	sort.Strings(volumes)
+	return volumes, nil
+}
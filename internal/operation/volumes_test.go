@@ -0,0 +1,54 @@
+package operation
+
+import (
+	"context"
+	"testing"
+)
+
+func TestVolumeSelectorValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		selector  VolumeSelector
+		shouldErr bool
+	}{
+		{"names", VolumeSelector{Names: []string{"vol1", "app_data"}}, false},
+		{"labels", VolumeSelector{Labels: []string{"env=prod", "backup"}}, false},
+		{"all", VolumeSelector{All: true}, false},
+		{"invalid name", VolumeSelector{Names: []string{"vol1", "../etc"}}, true},
+		{"empty label key", VolumeSelector{Labels: []string{"=prod"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.selector.Validate()
+			if tt.shouldErr && err == nil {
+				t.Errorf("Validate() expected error but got none")
+			}
+			if !tt.shouldErr && err != nil {
+				t.Errorf("Validate() unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestVolumeSelectorIsEmpty(t *testing.T) {
+	if !(VolumeSelector{}).IsEmpty() {
+		t.Error("empty selector should be empty")
+	}
+	for _, s := range []VolumeSelector{{Names: []string{"vol1"}}, {Labels: []string{"env"}}, {All: true}} {
+		if s.IsEmpty() {
+			t.Errorf("%+v should not be empty", s)
+		}
+	}
+}
+
+func TestSelectVolumesNames(t *testing.T) {
+	// Names alone do not need Docker
+	volumes, err := SelectVolumes(context.Background(), VolumeSelector{Names: []string{"vol2", "vol1", "vol2"}})
+	if err != nil {
+		t.Fatalf("SelectVolumes() unexpected error: %v", err)
+	}
+	if len(volumes) != 2 || volumes[0] != "vol1" || volumes[1] != "vol2" {
+		t.Errorf("SelectVolumes() = %v, want [vol1 vol2]", volumes)
+	}
+}
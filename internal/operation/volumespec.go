@@ -0,0 +1,91 @@
+package operation
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/docker"
+)
+
+// composeLabelPrefix starts every label Docker Compose puts on its volumes.
+const composeLabelPrefix = "com.docker.compose."
+
+// VolumeOverrides change the driver, driver options and labels a restore
+// creates a volume with, compared to those recorded at backup time, e.g. to
+// restore a volume on an NFS share on a host that mounts a different share.
+type VolumeOverrides struct {
+	// Driver replaces the recorded driver if set
+	Driver string
+	// Options and Labels ("key=value") are set on top of the recorded ones
+	Options []string
+	Labels  []string
+	// DropOptions and DropLabels remove recorded options and labels by key
+	DropOptions []string
+	DropLabels  []string
+	// Plain ignores the recorded settings and creates a volume with the
+	// default driver; Options and Labels still apply
+	Plain bool
+}
+
+// Validate checks that options and labels are "key=value" and that dropped keys are not empty.
+func (o VolumeOverrides) Validate() error {
+	for _, kv := range slices.Concat(o.Options, o.Labels) {
+		if key, _, ok := strings.Cut(kv, "="); !ok || strings.TrimSpace(key) == "" {
+			return fmt.Errorf("invalid volume setting '%s', expected key=value", kv)
+		}
+	}
+	for _, key := range slices.Concat(o.DropOptions, o.DropLabels) {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("the key of a volume setting to drop cannot be empty")
+		}
+	}
+	return nil
+}
+
+// IsEmpty reports whether the overrides keep the recorded settings.
+func (o VolumeOverrides) IsEmpty() bool {
+	return o.Driver == "" && len(o.Options) == 0 && len(o.Labels) == 0 &&
+		len(o.DropOptions) == 0 && len(o.DropLabels) == 0 && !o.Plain
+}
+
+// volumeSpec returns the settings to create volume name with: those recorded
+// at backup time, if known, changed by the overrides. Compose labels are only
+// kept for a volume restored under its original name, as Docker Compose would
+// otherwise take the copy for the volume of the original project.
+func (o VolumeOverrides) volumeSpec(name string, recorded *archive.Volume) docker.Volume {
+	spec := docker.Volume{Name: name, Options: map[string]string{}, Labels: map[string]string{}}
+	if recorded != nil && !o.Plain {
+		spec.Driver = recorded.Driver
+		maps.Copy(spec.Options, recorded.DriverOptions)
+		maps.Copy(spec.Labels, recorded.Labels)
+		if recorded.Name != name {
+			maps.DeleteFunc(spec.Labels, func(key, _ string) bool { return strings.HasPrefix(key, composeLabelPrefix) })
+		}
+	}
+	if o.Driver != "" {
+		spec.Driver = o.Driver
+	}
+	setPairs(spec.Options, o.Options, o.DropOptions)
+	setPairs(spec.Labels, o.Labels, o.DropLabels)
+	return spec
+}
+
+// setPairs removes the keys in drop from m and sets the "key=value" pairs.
+func setPairs(m map[string]string, pairs []string, drop []string) {
+	for _, key := range drop {
+		delete(m, key)
+	}
+	for _, kv := range pairs {
+		key, value, _ := strings.Cut(kv, "=")
+		m[key] = value
+	}
+}
+
+// sameDriverSettings reports whether an existing volume has the driver and
+// options recorded at backup time.
+func sameDriverSettings(existing *docker.Volume, recorded *archive.Volume) bool {
+	return existing.Driver == recorded.Driver && maps.Equal(existing.Options, recorded.DriverOptions)
+}
@@ -0,0 +1,85 @@
+package operation
+
+import (
+	"maps"
+	"testing"
+
+	"docker-volume-backup/internal/archive"
+)
+
+func TestVolumeSpec(t *testing.T) {
+	recorded := &archive.Volume{
+		Name:          "shop_uploads",
+		Driver:        "local",
+		DriverOptions: map[string]string{"type": "nfs", "o": "addr=10.0.0.1,rw", "device": ":/exports/uploads"},
+		Labels:        map[string]string{ComposeProjectLabel: "shop", ComposeVolumeLabel: "uploads", "tier": "gold"},
+	}
+	tests := []struct {
+		name      string
+		target    string
+		recorded  *archive.Volume
+		overrides VolumeOverrides
+		driver    string
+		options   map[string]string
+		labels    map[string]string
+	}{
+		{
+			"recorded settings", "shop_uploads", recorded, VolumeOverrides{},
+			"local", recorded.DriverOptions, recorded.Labels,
+		},
+		{
+			"renamed volume loses compose labels", "uploads_copy", recorded, VolumeOverrides{},
+			"local", recorded.DriverOptions, map[string]string{"tier": "gold"},
+		},
+		{
+			"overrides",
+			"shop_uploads",
+			recorded,
+			VolumeOverrides{Options: []string{"o=addr=10.0.0.2,rw"}, DropOptions: []string{"device"}, Labels: []string{"tier=silver"}, DropLabels: []string{ComposeVolumeLabel}},
+			"local",
+			map[string]string{"type": "nfs", "o": "addr=10.0.0.2,rw"},
+			map[string]string{ComposeProjectLabel: "shop", "tier": "silver"},
+		},
+		{
+			"plain volume", "shop_uploads", recorded, VolumeOverrides{Plain: true, Labels: []string{"restored=yes"}},
+			"", map[string]string{}, map[string]string{"restored": "yes"},
+		},
+		{
+			"other driver", "shop_uploads", recorded, VolumeOverrides{Driver: "rexray/ebs", DropOptions: []string{"type", "o", "device"}},
+			"rexray/ebs", map[string]string{}, recorded.Labels,
+		},
+		{
+			"nothing recorded", "data", nil, VolumeOverrides{},
+			"", map[string]string{}, map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := tt.overrides.volumeSpec(tt.target, tt.recorded)
+			if spec.Name != tt.target || spec.Driver != tt.driver || !maps.Equal(spec.Options, tt.options) || !maps.Equal(spec.Labels, tt.labels) {
+				t.Errorf("volumeSpec() = %+v; want driver %q, options %v, labels %v", spec, tt.driver, tt.options, tt.labels)
+			}
+		})
+	}
+
+	// The recorded manifest must not change
+	if len(recorded.DriverOptions) != 3 || len(recorded.Labels) != 3 {
+		t.Errorf("volumeSpec() changed the recorded volume: %+v", recorded)
+	}
+}
+
+func TestVolumeOverridesValidate(t *testing.T) {
+	valid := VolumeOverrides{Options: []string{"o=addr=10.0.0.1"}, Labels: []string{"empty="}, DropOptions: []string{"device"}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() error: %v", err)
+	}
+	for _, invalid := range []VolumeOverrides{
+		{Options: []string{"type"}},
+		{Labels: []string{"=value"}},
+		{DropLabels: []string{""}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Validate(%+v) expected error but got none", invalid)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// ChunkerParams bound the size of content-defined chunks. They are recorded in
+// the repository config, as changing them would stop new backups from
+// deduplicating against the chunks already stored.
+type ChunkerParams struct {
+	Algorithm string `json:"algorithm"`
+	Min       int    `json:"min"`
+	Avg       int    `json:"avg"`
+	Max       int    `json:"max"`
+}
+
+// DefaultChunkerParams are used for new repositories: chunks of 1 MiB on
+// average, which keeps the number of objects of a large volume manageable.
+var DefaultChunkerParams = ChunkerParams{
+	Algorithm: "fastcdc",
+	Min:       256 << 10,
+	Avg:       1 << 20,
+	Max:       4 << 20,
+}
+
+// Validate checks that the parameters describe a chunker this version implements.
+func (p ChunkerParams) Validate() error {
+	if p.Algorithm != "fastcdc" {
+		return fmt.Errorf("unsupported chunker '%s'", p.Algorithm)
+	}
+	if p.Min <= 0 || p.Min >= p.Avg || p.Avg >= p.Max {
+		return fmt.Errorf("invalid chunk sizes %d/%d/%d, expected min < avg < max", p.Min, p.Avg, p.Max)
+	}
+	if p.Avg&(p.Avg-1) != 0 {
+		return fmt.Errorf("average chunk size %d is not a power of two", p.Avg)
+	}
+	return nil
+}
+
+// gear maps each byte to a random value for the rolling hash. The values are
+// part of the repository format: other values would cut chunks elsewhere.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	// splitmix64 with a fixed seed
+	state := uint64(0x6476622d63686e6b)
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Chunker splits a stream into chunks whose boundaries depend on the content
+// (FastCDC), so that an insertion only changes the chunks around it and the
+// same data is cut the same way in every stream.
+type Chunker struct {
+	r      io.Reader
+	params ChunkerParams
+	// Masks with more and fewer bits than log2(avg), checked before and after
+	// the average size, so that chunk sizes cluster around it
+	maskS, maskL uint64
+	buf          []byte
+	n            int
+	eof          bool
+}
+
+// NewChunker returns a chunker reading from r. The parameters must be valid.
+func NewChunker(r io.Reader, params ChunkerParams) *Chunker {
+	avgBits := bits.TrailingZeros(uint(params.Avg))
+	return &Chunker{
+		r:      r,
+		params: params,
+		maskS:  mask(avgBits + 1),
+		maskL:  mask(avgBits - 1),
+		buf:    make([]byte, params.Max),
+	}
+}
+
+// mask returns a mask of the highest n bits of the rolling hash, which depend on
+// the widest window of preceding bytes.
+func mask(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// Next returns the next chunk in a new slice, or io.EOF after the last one.
+func (c *Chunker) Next() ([]byte, error) {
+	for !c.eof && c.n < len(c.buf) {
+		read, err := c.r.Read(c.buf[c.n:])
+		c.n += read
+		if errors.Is(err, io.EOF) {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if c.n == 0 {
+		return nil, io.EOF
+	}
+
+	cut := c.cut(c.buf[:c.n])
+	chunk := make([]byte, cut)
+	copy(chunk, c.buf[:cut])
+	c.n = copy(c.buf, c.buf[cut:c.n])
+	return chunk, nil
+}
+
+// cut returns the length of the chunk at the start of data.
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.params.Min {
+		return n
+	}
+	normal := min(c.params.Avg, n)
+	var hash uint64
+	i := c.params.Min
+	for ; i < normal; i++ {
+		hash = hash<<1 + gear[data[i]]
+		if hash&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		hash = hash<<1 + gear[data[i]]
+		if hash&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
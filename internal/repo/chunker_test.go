@@ -0,0 +1,76 @@
+package repo
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// testParams keep the test data small.
+var testParams = ChunkerParams{Algorithm: "fastcdc", Min: 1 << 10, Avg: 4 << 10, Max: 16 << 10}
+
+func chunks(t *testing.T, data []byte, params ChunkerParams) [][]byte {
+	t.Helper()
+	var out [][]byte
+	c := NewChunker(bytes.NewReader(data), params)
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		out = append(out, chunk)
+	}
+}
+
+func TestChunker(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	got := chunks(t, data, testParams)
+	if joined := bytes.Join(got, nil); !bytes.Equal(joined, data) {
+		t.Fatal("Chunks do not add up to the input")
+	}
+	for i, chunk := range got {
+		if len(chunk) > testParams.Max || (len(chunk) < testParams.Min && i != len(got)-1) {
+			t.Errorf("Chunk %d has %d bytes, outside of %d-%d", i, len(chunk), testParams.Min, testParams.Max)
+		}
+	}
+	if avg := len(data) / len(got); avg < testParams.Avg/2 || avg > testParams.Avg*2 {
+		t.Errorf("Average chunk size %d; want about %d", avg, testParams.Avg)
+	}
+
+	// Inserting data only changes the chunks around it
+	shifted := append([]byte("inserted"), data...)
+	seen := map[string]bool{}
+	for _, chunk := range got {
+		seen[string(chunk)] = true
+	}
+	var reused int
+	for _, chunk := range chunks(t, shifted, testParams) {
+		if seen[string(chunk)] {
+			reused++
+		}
+	}
+	if reused < len(got)-2 {
+		t.Errorf("After an insertion %d of %d chunks are the same; want all but the first", reused, len(got))
+	}
+}
+
+func TestChunkerParamsValidate(t *testing.T) {
+	if err := DefaultChunkerParams.Validate(); err != nil {
+		t.Errorf("DefaultChunkerParams.Validate() error: %v", err)
+	}
+	for _, p := range []ChunkerParams{
+		{Algorithm: "rabin", Min: 1, Avg: 2, Max: 4},
+		{Algorithm: "fastcdc", Min: 4, Avg: 2, Max: 8},
+		{Algorithm: "fastcdc", Min: 1, Avg: 3, Max: 8},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Validate(%+v) expected error but got none", p)
+		}
+	}
+}
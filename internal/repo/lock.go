@@ -0,0 +1,89 @@
+package repo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const locksDir = "locks/"
+
+// Lock kinds. Backups may run concurrently, but not while GC runs: a backup
+// reuses stored chunks that GC could remove before the snapshot using them is
+// written.
+const (
+	LockBackup = "backup"
+	LockGC     = "gc"
+)
+
+// lockInfo is the content of a lock object, for the error telling users who holds it.
+type lockInfo struct {
+	Kind      string    `json:"kind"`
+	Host      string    `json:"host"`
+	PID       int       `json:"pid"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Lock takes a lock of a kind on the repository and returns the function
+// releasing it. It fails if a conflicting lock is held. The lock is written
+// before checking for others, so that of two processes racing for conflicting
+// locks at least one fails.
+func (r *Repository) Lock(ctx context.Context, kind string) (func(), error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	info := lockInfo{Kind: kind, Host: host, PID: os.Getpid(), CreatedAt: time.Now().UTC().Truncate(time.Second)}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	key := r.key(locksDir + kind + "-" + hex.EncodeToString(suffix) + ".json")
+	if err := r.put(ctx, key, data); err != nil {
+		return nil, fmt.Errorf("failed to lock repository: %w", err)
+	}
+	unlock := func() {
+		// Also remove the lock after cancellation
+		if err := r.backend.Delete(context.WithoutCancel(ctx), key); err != nil {
+			log.Printf("Warning: failed to remove repository lock %s: %v", key, err)
+		}
+	}
+
+	objects, err := r.backend.List(ctx, r.key(locksDir))
+	if err != nil {
+		unlock()
+		return nil, fmt.Errorf("failed to list repository locks: %w", err)
+	}
+	for _, obj := range objects {
+		other := baseName(obj.Key)
+		if obj.Key == key || !strings.HasSuffix(other, ".json") {
+			continue
+		}
+		if kind == LockGC || strings.HasPrefix(other, LockGC+"-") {
+			unlock()
+			return nil, r.lockedError(ctx, obj.Key)
+		}
+	}
+	return unlock, nil
+}
+
+func (r *Repository) lockedError(ctx context.Context, key string) error {
+	data, err := r.get(ctx, key)
+	var info lockInfo
+	if err == nil {
+		err = json.Unmarshal(data, &info)
+	}
+	if err != nil {
+		return errors.New("repository is locked by " + key)
+	}
+	return fmt.Errorf("repository is locked for %s by process %d on %s since %s; if that process is gone, remove %s",
+		info.Kind, info.PID, info.Host, info.CreatedAt.Local().Format("2006-01-02 15:04:05"), key)
+}
@@ -0,0 +1,363 @@
+// Package repo implements deduplicating repositories: backups are split into
+// content-defined chunks, each stored once under its hash, and a snapshot
+// lists the chunks of one backup in order.
+//
+// A repository at a location has this layout:
+//
+//	config.json              format version and chunker parameters
+//	chunks/<ab>/<sha256>     zstd-compressed chunks, named by the hash of their content
+//	snapshots/<id>.json      one file per backup
+//	locks/<kind>-<id>.json   held by running backups and GC
+package repo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"docker-volume-backup/internal/storage"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Version is the repository format version written by this version.
+const Version = 1
+
+const (
+	configName   = "config.json"
+	chunksDir    = "chunks/"
+	snapshotsDir = "snapshots/"
+)
+
+// uploads is the number of chunks uploaded concurrently.
+const uploads = 4
+
+// Config describes a repository; it is written once by Init.
+type Config struct {
+	Version   int           `json:"version"`
+	CreatedAt time.Time     `json:"created_at"`
+	Chunker   ChunkerParams `json:"chunker"`
+}
+
+// Repository is an initialized repository on a storage backend.
+type Repository struct {
+	Location string
+	Config   Config
+
+	backend storage.Backend
+	prefix  string
+	// known holds the IDs of the stored chunks once loaded by Store
+	known map[string]bool
+}
+
+var (
+	encoder, _ = zstd.NewWriter(nil)
+	decoder, _ = zstd.NewReader(nil)
+)
+
+// Init creates an empty repository at location, which may be a local
+// directory or any location with a registered storage scheme.
+func Init(ctx context.Context, location string) (*Repository, error) {
+	backend, key, err := storage.Resolve(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	r := &Repository{Location: location, backend: backend, prefix: strings.TrimSuffix(key, "/")}
+	_, err = backend.Stat(ctx, r.key(configName))
+	if err == nil {
+		return nil, fmt.Errorf("%s is already a repository", location)
+	}
+	if !errors.Is(err, storage.ErrNotExist) {
+		return nil, err
+	}
+
+	r.Config = Config{Version: Version, CreatedAt: time.Now().UTC().Truncate(time.Second), Chunker: DefaultChunkerParams}
+	data, err := json.MarshalIndent(r.Config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := r.put(ctx, r.key(configName), append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to write repository config: %w", err)
+	}
+	return r, nil
+}
+
+// Open opens the repository at location.
+func Open(ctx context.Context, location string) (*Repository, error) {
+	backend, key, err := storage.Resolve(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	r := &Repository{Location: location, backend: backend, prefix: strings.TrimSuffix(key, "/")}
+	data, err := r.get(ctx, r.key(configName))
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil, fmt.Errorf("%s is not a repository, create one with init", location)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repository config: %w", err)
+	}
+	if err := json.Unmarshal(data, &r.Config); err != nil {
+		return nil, fmt.Errorf("failed to parse repository config: %w", err)
+	}
+	if r.Config.Version < 1 || r.Config.Version > Version {
+		return nil, fmt.Errorf("repository version %d is not supported (supported: %d), upgrade docker-volume-backup", r.Config.Version, Version)
+	}
+	if err := r.Config.Chunker.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid repository config: %w", err)
+	}
+	return r, nil
+}
+
+// key returns the key of an object of the repository.
+func (r *Repository) key(name string) string {
+	if r.prefix == "" {
+		return name
+	}
+	return r.prefix + "/" + name
+}
+
+func chunkName(id string) string {
+	return chunksDir + id[:2] + "/" + id
+}
+
+// baseName returns the last element of a key, whatever the separator of the backend.
+func baseName(key string) string {
+	return key[strings.LastIndexAny(key, `/\`)+1:]
+}
+
+func (r *Repository) put(ctx context.Context, key string, data []byte) error {
+	w, err := r.backend.Create(ctx, key)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Close()
+}
+
+func (r *Repository) get(ctx context.Context, key string) ([]byte, error) {
+	in, err := r.backend.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return io.ReadAll(in)
+}
+
+// chunkIDs lists the stored chunks with their sizes and modification times.
+func (r *Repository) chunkIDs(ctx context.Context) (map[string]storage.ObjectInfo, error) {
+	objects, err := r.backend.List(ctx, r.key(chunksDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chunks: %w", err)
+	}
+	chunks := make(map[string]storage.ObjectInfo, len(objects))
+	for _, obj := range objects {
+		if id := baseName(obj.Key); isChunkID(id) {
+			chunks[id] = obj
+		}
+	}
+	return chunks, nil
+}
+
+func isChunkID(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// StoreStats describe what Store read and added to the repository.
+type StoreStats struct {
+	Chunks    int
+	Size      int64
+	NewChunks int
+	NewSize   int64
+	// StoredSize is the compressed size of the new chunks
+	StoredSize int64
+}
+
+// Store splits the stream into chunks and uploads the ones the repository does
+// not have yet. It returns the IDs of the chunks in order, which is what a
+// snapshot records.
+func (r *Repository) Store(ctx context.Context, in io.Reader) ([]string, StoreStats, error) {
+	if r.known == nil {
+		chunks, err := r.chunkIDs(ctx)
+		if err != nil {
+			return nil, StoreStats{}, err
+		}
+		r.known = make(map[string]bool, len(chunks))
+		for id := range chunks {
+			r.known[id] = true
+		}
+	}
+	ids, stats, err := r.store(ctx, in)
+	if err != nil {
+		// Some chunks taken as stored may not have been uploaded
+		r.known = nil
+	}
+	return ids, stats, err
+}
+
+func (r *Repository) store(ctx context.Context, in io.Reader) ([]string, StoreStats, error) {
+	var stats StoreStats
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		sem = make(chan struct{}, uploads)
+	)
+	wait := func() error {
+		wg.Wait()
+		return context.Cause(ctx)
+	}
+
+	var ids []string
+	chunker := NewChunker(in, r.Config.Chunker)
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			cancel(err)
+			wg.Wait()
+			return nil, stats, err
+		}
+		sum := sha256.Sum256(chunk)
+		id := hex.EncodeToString(sum[:])
+		ids = append(ids, id)
+		stats.Chunks++
+		stats.Size += int64(len(chunk))
+		if r.known[id] {
+			continue
+		}
+		r.known[id] = true
+		stats.NewChunks++
+		stats.NewSize += int64(len(chunk))
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, stats, wait()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			compressed := encoder.EncodeAll(chunk, nil)
+			if err := r.put(ctx, r.key(chunkName(id)), compressed); err != nil {
+				cancel(fmt.Errorf("failed to store chunk %s: %w", id, err))
+				return
+			}
+			mu.Lock()
+			stats.StoredSize += int64(len(compressed))
+			mu.Unlock()
+		}()
+	}
+	if err := wait(); err != nil {
+		return nil, stats, err
+	}
+	return ids, stats, nil
+}
+
+// readChunk downloads a chunk and checks that its content matches its ID.
+func (r *Repository) readChunk(ctx context.Context, id string) ([]byte, error) {
+	if !isChunkID(id) {
+		return nil, fmt.Errorf("invalid chunk ID %q", id)
+	}
+	compressed, err := r.get(ctx, r.key(chunkName(id)))
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil, fmt.Errorf("chunk %s is missing from the repository", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk %s: %w", id, err)
+	}
+	data, err := decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s is corrupt: %w", id, err)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != id {
+		return nil, fmt.Errorf("chunk %s is corrupt: checksum mismatch", id)
+	}
+	return data, nil
+}
+
+// chunkResult is a chunk being downloaded by a Reader.
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
+// Reader reads the stream made of a list of chunks, downloading a few chunks ahead.
+type Reader struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	results chan chan chunkResult
+	current *bytes.Reader
+	err     error
+}
+
+// NewReader returns a reader of the stream made of the chunks ids. It must be
+// closed to stop downloading.
+func (r *Repository) NewReader(ctx context.Context, ids []string) *Reader {
+	ctx, cancel := context.WithCancel(ctx)
+	results := make(chan chan chunkResult, uploads)
+	go func() {
+		defer close(results)
+		for _, id := range ids {
+			result := make(chan chunkResult, 1)
+			select {
+			case results <- result:
+			case <-ctx.Done():
+				return
+			}
+			go func() {
+				data, err := r.readChunk(ctx, id)
+				result <- chunkResult{data, err}
+			}()
+		}
+	}()
+	return &Reader{ctx: ctx, cancel: cancel, results: results, current: bytes.NewReader(nil)}
+}
+
+func (rd *Reader) Read(p []byte) (int, error) {
+	for rd.err == nil {
+		if n, _ := rd.current.Read(p); n > 0 {
+			return n, nil
+		}
+		result, ok := <-rd.results
+		if !ok {
+			// The downloads also stop when the context is cancelled
+			rd.err = rd.ctx.Err()
+			if rd.err == nil {
+				rd.err = io.EOF
+			}
+			break
+		}
+		chunk := <-result
+		if chunk.err != nil {
+			rd.err = chunk.err
+			break
+		}
+		rd.current.Reset(chunk.data)
+	}
+	return 0, rd.err
+}
+
+// Close stops the downloads.
+func (rd *Reader) Close() error {
+	rd.cancel()
+	return nil
+}
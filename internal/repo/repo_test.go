@@ -0,0 +1,186 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"docker-volume-backup/internal/archive"
+)
+
+func testRepository(t *testing.T) *Repository {
+	t.Helper()
+	r, err := Init(context.Background(), filepath.Join(t.TempDir(), "repo"))
+	if err != nil {
+		t.Fatalf("Init() error: %v", err)
+	}
+	r.Config.Chunker = testParams
+	return r
+}
+
+func randomData(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// store stores data as a snapshot of volume.
+func store(t *testing.T, r *Repository, volume string, data []byte) (*Snapshot, StoreStats) {
+	t.Helper()
+	ctx := context.Background()
+	ids, stats, err := r.Store(ctx, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Store() error: %v", err)
+	}
+	m := archive.NewManifest(archive.Volume{Name: volume}, "none")
+	s := &Snapshot{CreatedAt: m.CreatedAt, Manifest: m, Size: stats.Size, Chunks: ids}
+	if err := r.WriteSnapshot(ctx, s); err != nil {
+		t.Fatalf("WriteSnapshot() error: %v", err)
+	}
+	return s, stats
+}
+
+func TestInitAndOpen(t *testing.T) {
+	ctx := context.Background()
+	location := filepath.Join(t.TempDir(), "repo")
+	if _, err := Open(ctx, location); err == nil || !strings.Contains(err.Error(), "not a repository") {
+		t.Errorf("Open() before Init() error = %v", err)
+	}
+	if _, err := Init(ctx, location); err != nil {
+		t.Fatalf("Init() error: %v", err)
+	}
+	if _, err := Init(ctx, location); err == nil {
+		t.Error("Init() of an existing repository expected error but got none")
+	}
+	r, err := Open(ctx, location)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	if r.Config.Version != Version || r.Config.Chunker != DefaultChunkerParams {
+		t.Errorf("Open() config = %+v", r.Config)
+	}
+}
+
+func TestStoreAndRead(t *testing.T) {
+	ctx := context.Background()
+	r := testRepository(t)
+	data := randomData(1, 256<<10)
+	first, stats := store(t, r, "app", data)
+	if stats.NewChunks != stats.Chunks || stats.StoredSize == 0 {
+		t.Errorf("First Store() stats = %+v; want every chunk new", stats)
+	}
+
+	// Another volume with nearly the same data only adds the changed chunks
+	similar := append([]byte("staging"), data...)
+	_, stats = store(t, r, "app-staging", similar)
+	if stats.NewChunks > 2 {
+		t.Errorf("Second Store() added %d of %d chunks; want at most 2", stats.NewChunks, stats.Chunks)
+	}
+
+	s, err := r.FindSnapshot(ctx, first.ID[:len(first.ID)-1])
+	if err != nil {
+		t.Fatalf("FindSnapshot() of an ID prefix error: %v", err)
+	}
+	stream := r.NewReader(ctx, s.Chunks)
+	defer stream.Close()
+	read, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("Reading the snapshot error: %v", err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Error("Read data differs from the stored data")
+	}
+
+	if _, err := r.FindSnapshot(ctx, "../config"); err == nil {
+		t.Error("FindSnapshot() of an invalid ID expected error but got none")
+	}
+}
+
+func TestReadCorruptChunk(t *testing.T) {
+	ctx := context.Background()
+	r := testRepository(t)
+	s, _ := store(t, r, "app", randomData(2, 64<<10))
+	corrupt := encoder.EncodeAll([]byte("something else"), nil)
+	if err := os.WriteFile(r.key(chunkName(s.Chunks[1])), corrupt, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stream := r.NewReader(ctx, s.Chunks)
+	defer stream.Close()
+	if _, err := io.ReadAll(stream); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Errorf("Reading a corrupt chunk error = %v", err)
+	}
+}
+
+func TestForgetAndGC(t *testing.T) {
+	ctx := context.Background()
+	r := testRepository(t)
+	shared := randomData(3, 64<<10)
+	first, _ := store(t, r, "app", shared)
+	store(t, r, "db", append(shared, randomData(4, 64<<10)...))
+
+	if err := r.Forget(ctx, first.ID); err != nil {
+		t.Fatalf("Forget() error: %v", err)
+	}
+	// The other snapshot starts with the same data, only the chunk cut at the
+	// end of the forgotten one is unused
+	stats, err := r.GC(ctx, 0, false)
+	if err != nil || stats.Removed != 1 {
+		t.Errorf("GC() = %+v, %v; want one chunk removed", stats, err)
+	}
+
+	snapshots, _ := r.Snapshots(ctx)
+	if len(snapshots) != 1 {
+		t.Fatalf("Snapshots() after Forget() = %d; want 1", len(snapshots))
+	}
+	if err := r.Forget(ctx, snapshots[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	// Recent chunks are kept for the grace period
+	if stats, _ := r.GC(ctx, time.Hour, false); stats.Removed != 0 || stats.Recent != stats.Chunks {
+		t.Errorf("GC() with a grace period = %+v; want every chunk kept", stats)
+	}
+	if stats, _ := r.GC(ctx, 0, true); stats.Removed != stats.Chunks {
+		t.Errorf("GC(dry run) = %+v; want every chunk reported", stats)
+	}
+	stats, err = r.GC(ctx, 0, false)
+	if err != nil || stats.Removed == 0 || stats.Removed != stats.Chunks {
+		t.Errorf("GC() = %+v, %v; want every chunk removed", stats, err)
+	}
+	if chunks, _ := r.chunkIDs(ctx); len(chunks) != 0 {
+		t.Errorf("%d chunks left after GC()", len(chunks))
+	}
+}
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	r := testRepository(t)
+	unlockBackup, err := r.Lock(ctx, LockBackup)
+	if err != nil {
+		t.Fatalf("Lock(backup) error: %v", err)
+	}
+	// Backups run concurrently, but not with GC
+	unlockOther, err := r.Lock(ctx, LockBackup)
+	if err != nil {
+		t.Fatalf("Second Lock(backup) error: %v", err)
+	}
+	unlockOther()
+	if _, err := r.GC(ctx, 0, false); err == nil || !strings.Contains(err.Error(), "locked for backup") {
+		t.Errorf("GC() during a backup error = %v", err)
+	}
+	unlockBackup()
+
+	unlockGC, err := r.Lock(ctx, LockGC)
+	if err != nil {
+		t.Fatalf("Lock(gc) error: %v", err)
+	}
+	defer unlockGC()
+	if _, err := r.Lock(ctx, LockBackup); err == nil || !strings.Contains(err.Error(), "locked for gc") {
+		t.Errorf("Lock(backup) during GC error = %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package repo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"docker-volume-backup/internal/archive"
+	"docker-volume-backup/internal/storage"
+)
+
+// Snapshot is one backup stored in a repository: the chunks of its archive
+// stream, in order, and the archive's manifest.
+type Snapshot struct {
+	ID        string            `json:"id"`
+	CreatedAt time.Time         `json:"created_at"`
+	Manifest  *archive.Manifest `json:"manifest"`
+	// Size is the size of the archive stream
+	Size   int64    `json:"size"`
+	Chunks []string `json:"chunks"`
+	// NewChunks and NewSize describe the chunks the backup added to the repository
+	NewChunks int   `json:"new_chunks"`
+	NewSize   int64 `json:"new_size"`
+}
+
+// SnapshotTimeFormat starts snapshot IDs, so that they sort by creation time.
+const SnapshotTimeFormat = "20060102T150405Z"
+
+func newSnapshotID(createdAt time.Time) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return createdAt.UTC().Format(SnapshotTimeFormat) + "-" + hex.EncodeToString(suffix), nil
+}
+
+func snapshotName(id string) string {
+	return snapshotsDir + id + ".json"
+}
+
+// WriteSnapshot records a backup whose archive stream was stored as chunks,
+// setting the snapshot's ID. The chunks must have been stored before.
+func (r *Repository) WriteSnapshot(ctx context.Context, s *Snapshot) error {
+	id, err := newSnapshotID(s.CreatedAt)
+	if err != nil {
+		return err
+	}
+	s.ID = id
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := r.put(ctx, r.key(snapshotName(id)), data); err != nil {
+		return fmt.Errorf("failed to write snapshot %s: %w", id, err)
+	}
+	return nil
+}
+
+func (r *Repository) readSnapshot(ctx context.Context, key string) (*Snapshot, error) {
+	data, err := r.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %w", key, err)
+	}
+	if s.Manifest == nil {
+		return nil, fmt.Errorf("snapshot %s has no manifest", key)
+	}
+	if s.Manifest.Version > archive.ManifestVersion {
+		return nil, fmt.Errorf("snapshot %s has manifest version %d, newer than the supported version %d, upgrade docker-volume-backup", key, s.Manifest.Version, archive.ManifestVersion)
+	}
+	return &s, nil
+}
+
+// Snapshots returns every snapshot of the repository, oldest first.
+func (r *Repository) Snapshots(ctx context.Context) ([]*Snapshot, error) {
+	objects, err := r.backend.List(ctx, r.key(snapshotsDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	var snapshots []*Snapshot
+	for _, obj := range objects {
+		if !strings.HasSuffix(obj.Key, ".json") {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		s, err := r.readSnapshot(ctx, obj.Key)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, s)
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// FindSnapshot returns the snapshot with an ID, or the only one whose ID starts with it.
+func (r *Repository) FindSnapshot(ctx context.Context, id string) (*Snapshot, error) {
+	if id == "" || strings.Trim(id, "0123456789abcdefTZ-") != "" {
+		return nil, fmt.Errorf("invalid snapshot ID %q", id)
+	}
+	s, err := r.readSnapshot(ctx, r.key(snapshotName(id)))
+	if !errors.Is(err, storage.ErrNotExist) {
+		return s, err
+	}
+
+	snapshots, err := r.Snapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var found *Snapshot
+	for _, s := range snapshots {
+		if strings.HasPrefix(s.ID, id) {
+			if found != nil {
+				return nil, fmt.Errorf("snapshot ID %s is ambiguous, it matches %s and %s", id, found.ID, s.ID)
+			}
+			found = s
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("snapshot %s not found in %s", id, r.Location)
+	}
+	return found, nil
+}
+
+// Forget removes a snapshot. Its chunks stay in the repository until GC
+// finds that no other snapshot uses them.
+func (r *Repository) Forget(ctx context.Context, id string) error {
+	s, err := r.FindSnapshot(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := r.backend.Delete(ctx, r.key(snapshotName(s.ID))); err != nil {
+		return fmt.Errorf("failed to remove snapshot %s: %w", s.ID, err)
+	}
+	log.Printf("Removed snapshot %s of volume '%s'", s.ID, s.Manifest.Volume.Name)
+	return nil
+}
+
+// GCGracePeriod is how long GC keeps unused chunks. A backup uploads its
+// chunks before writing its snapshot, so recent chunks may belong to a backup
+// whose lock was removed by hand while it was still running.
+const GCGracePeriod = time.Hour
+
+// GCStats describe what GC found and removed.
+type GCStats struct {
+	Snapshots int
+	Chunks    int
+	// Unused chunks, of which Recent were kept for the grace period
+	Unused      int
+	Recent      int
+	Removed     int
+	RemovedSize int64
+}
+
+// GC removes the chunks that no snapshot uses and that are older than grace.
+// It locks the repository against backups. Every snapshot must be readable, as
+// the chunks of an unreadable one cannot be told apart from unused ones. With
+// dryRun nothing is removed.
+func (r *Repository) GC(ctx context.Context, grace time.Duration, dryRun bool) (GCStats, error) {
+	var stats GCStats
+	if !dryRun {
+		unlock, err := r.Lock(ctx, LockGC)
+		if err != nil {
+			return stats, err
+		}
+		defer unlock()
+	}
+	chunks, err := r.chunkIDs(ctx)
+	if err != nil {
+		return stats, err
+	}
+	snapshots, err := r.Snapshots(ctx)
+	if err != nil {
+		return stats, fmt.Errorf("refusing to collect garbage: %w", err)
+	}
+	used := map[string]bool{}
+	for _, s := range snapshots {
+		for _, id := range s.Chunks {
+			used[id] = true
+		}
+	}
+	stats.Snapshots = len(snapshots)
+	stats.Chunks = len(chunks)
+
+	cutoff := time.Now().Add(-grace)
+	ids := make([]string, 0, len(chunks))
+	for id := range chunks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var errs []error
+	for _, id := range ids {
+		if used[id] {
+			continue
+		}
+		stats.Unused++
+		obj := chunks[id]
+		if obj.ModTime.After(cutoff) {
+			stats.Recent++
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		if !dryRun {
+			if err := r.backend.Delete(ctx, obj.Key); err != nil {
+				errs = append(errs, fmt.Errorf("failed to remove chunk %s: %w", id, err))
+				continue
+			}
+		}
+		stats.Removed++
+		stats.RemovedSize += obj.Size
+	}
+	return stats, errors.Join(errs...)
+}
@@ -0,0 +1,159 @@
+package retention
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is a grandfather-father-son retention policy. An archive is kept if
+// any rule selects it; everything else may be deleted.
+type Policy struct {
+	// Last keeps the newest N archives.
+	Last int
+	// Daily, Weekly, Monthly and Yearly keep the newest archive of each of the
+	// N most recent days, ISO weeks, months and years that have archives.
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
+	// Within keeps all archives created within this duration of the newest archive.
+	// It is relative to the newest archive rather than to now, so a series that
+	// stopped receiving backups is not deleted.
+	Within time.Duration
+}
+
+// IsEmpty reports whether the policy has no rules. Applying an empty policy would
+// remove every archive, so callers should refuse it.
+func (p Policy) IsEmpty() bool {
+	return p.Last <= 0 && p.Daily <= 0 && p.Weekly <= 0 && p.Monthly <= 0 && p.Yearly <= 0 && p.Within <= 0
+}
+
+// Archive is one backup in a series.
+type Archive struct {
+	Key  string
+	Time time.Time
+	Size int64
+}
+
+// Decision records whether an archive is kept and which rules kept it.
+type Decision struct {
+	Archive
+	Keep    bool
+	Reasons []string
+}
+
+func (d Decision) String() string {
+	action := "remove"
+	if d.Keep {
+		action = "keep"
+	}
+	s := fmt.Sprintf("%-6s  %s  %s", action, d.Time.Format("2006-01-02 15:04:05"), d.Key)
+	if len(d.Reasons) > 0 {
+		s += "  (" + strings.Join(d.Reasons, ", ") + ")"
+	}
+	return s
+}
+
+type bucketRule struct {
+	name   string
+	count  int
+	period func(t time.Time) string
+}
+
+// Apply decides which archives of a single series to keep. The decisions are
+// returned newest first. Periods are evaluated in each archive time's location.
+func Apply(archives []Archive, p Policy) []Decision {
+	decisions := make([]Decision, len(archives))
+	for i, a := range archives {
+		decisions[i] = Decision{Archive: a}
+	}
+	sort.SliceStable(decisions, func(i, j int) bool {
+		if !decisions[i].Time.Equal(decisions[j].Time) {
+			return decisions[i].Time.After(decisions[j].Time)
+		}
+		return decisions[i].Key > decisions[j].Key
+	})
+	if len(decisions) == 0 {
+		return decisions
+	}
+
+	rules := []*bucketRule{
+		{"last", p.Last, nil},
+		{"daily", p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", p.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+	lastPeriod := map[string]string{}
+	newest := decisions[0].Time
+
+	for i := range decisions {
+		d := &decisions[i]
+		for _, rule := range rules {
+			if rule.count <= 0 {
+				continue
+			}
+			if rule.period == nil {
+				d.Reasons = append(d.Reasons, fmt.Sprintf("last %d", p.Last-rule.count+1))
+				rule.count--
+				continue
+			}
+			// Archives are sorted newest first, so the first one seen in a period is its newest
+			period := rule.period(d.Time)
+			if period == lastPeriod[rule.name] {
+				continue
+			}
+			lastPeriod[rule.name] = period
+			d.Reasons = append(d.Reasons, rule.name+" "+period)
+			rule.count--
+		}
+		if p.Within > 0 && newest.Sub(d.Time) <= p.Within {
+			d.Reasons = append(d.Reasons, "within "+FormatDuration(p.Within))
+		}
+		d.Keep = len(d.Reasons) > 0
+	}
+	return decisions
+}
+
+var durationDaysPattern = regexp.MustCompile(`(\d+)([dw])`)
+
+// ParseDuration parses a Go duration such as "36h", additionally accepting
+// days ("d") and weeks ("w"), e.g. "30d" or "1w12h".
+func ParseDuration(s string) (time.Duration, error) {
+	var total time.Duration
+	rest := durationDaysPattern.ReplaceAllStringFunc(s, func(match string) string {
+		days, _ := strconv.Atoi(match[:len(match)-1])
+		if strings.HasSuffix(match, "w") {
+			days *= 7
+		}
+		total += time.Duration(days) * 24 * time.Hour
+		return ""
+	})
+	if rest != "" {
+		d, err := time.ParseDuration(rest)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration '%s'", s)
+		}
+		total += d
+	}
+	if total <= 0 {
+		return 0, fmt.Errorf("invalid duration '%s': must be positive", s)
+	}
+	return total, nil
+}
+
+// FormatDuration formats whole days as "30d" and anything else as a Go duration.
+func FormatDuration(d time.Duration) string {
+	const day = 24 * time.Hour
+	if d%day == 0 {
+		return fmt.Sprintf("%dd", d/day)
+	}
+	return d.String()
+}
@@ -0,0 +1,125 @@
+package retention
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dailyArchives returns one archive per day at 03:00 UTC, newest first, ending at end.
+func dailyArchives(end time.Time, days int) []Archive {
+	var archives []Archive
+	for i := 0; i < days; i++ {
+		t := end.AddDate(0, 0, -i)
+		archives = append(archives, Archive{Key: "vol-" + t.Format("20060102"), Time: t})
+	}
+	return archives
+}
+
+func kept(decisions []Decision) []string {
+	var keys []string
+	for _, d := range decisions {
+		if d.Keep {
+			keys = append(keys, strings.TrimPrefix(d.Key, "vol-"))
+		}
+	}
+	return keys
+}
+
+func TestApply(t *testing.T) {
+	// Sunday
+	end := time.Date(2024, 3, 31, 3, 0, 0, 0, time.UTC)
+	archives := dailyArchives(end, 800)
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		expected []string
+	}{
+		{"last", Policy{Last: 3}, []string{"20240331", "20240330", "20240329"}},
+		{"daily", Policy{Daily: 2}, []string{"20240331", "20240330"}},
+		// ISO weeks end on Sunday
+		{"weekly", Policy{Weekly: 3}, []string{"20240331", "20240324", "20240317"}},
+		{"monthly", Policy{Monthly: 3}, []string{"20240331", "20240229", "20240131"}},
+		{"yearly", Policy{Yearly: 3}, []string{"20240331", "20231231", "20221231"}},
+		{"within", Policy{Within: 48 * time.Hour}, []string{"20240331", "20240330", "20240329"}},
+		{"combined", Policy{Last: 1, Weekly: 2, Monthly: 2}, []string{"20240331", "20240324", "20240229"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions := Apply(archives, tt.policy)
+			if len(decisions) != len(archives) {
+				t.Fatalf("Apply() returned %d decisions; want %d", len(decisions), len(archives))
+			}
+			if got := kept(decisions); fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("Kept %v; want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestApplyReasons(t *testing.T) {
+	end := time.Date(2024, 3, 31, 3, 0, 0, 0, time.UTC)
+	// Oldest first, to check that Apply sorts
+	archives := []Archive{
+		{Key: "a", Time: end.AddDate(0, 0, -1)},
+		{Key: "b", Time: end.Add(-time.Hour)},
+		{Key: "c", Time: end},
+	}
+
+	decisions := Apply(archives, Policy{Last: 1, Daily: 2, Within: 2 * time.Hour})
+	expected := []struct {
+		key     string
+		reasons string
+	}{
+		{"c", "last 1, daily 2024-03-31, within 2h0m0s"},
+		// Same day as c, so not the newest of its day
+		{"b", "within 2h0m0s"},
+		{"a", "daily 2024-03-30"},
+	}
+	for i, want := range expected {
+		d := decisions[i]
+		if d.Key != want.key || strings.Join(d.Reasons, ", ") != want.reasons || !d.Keep {
+			t.Errorf("Decision %d = %s %v; want %s kept for %s", i, d.Key, d.Reasons, want.key, want.reasons)
+		}
+	}
+
+	decisions = Apply(archives, Policy{Last: 1})
+	if d := decisions[2]; d.Keep || !strings.HasPrefix(d.String(), "remove") {
+		t.Errorf("Oldest archive decision = %q; want remove", d.String())
+	}
+}
+
+func TestApplyWithinIsRelativeToNewest(t *testing.T) {
+	// A series that stopped a year ago keeps its recent archives
+	end := time.Now().AddDate(-1, 0, 0)
+	decisions := Apply(dailyArchives(end, 10), Policy{Within: 7 * 24 * time.Hour})
+	if n := len(kept(decisions)); n != 8 {
+		t.Errorf("Kept %d archives; want 8", n)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+	}{
+		{"36h", 36 * time.Hour},
+		{"30d", 30 * 24 * time.Hour},
+		{"1w12h", 7*24*time.Hour + 12*time.Hour},
+	}
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.input)
+		if err != nil || got != tt.expected {
+			t.Errorf("ParseDuration(%q) = %v, %v; want %v", tt.input, got, err, tt.expected)
+		}
+	}
+
+	for _, input := range []string{"", "soon", "0d", "-1h"} {
+		if _, err := ParseDuration(input); err == nil {
+			t.Errorf("ParseDuration(%q) expected error but got none", input)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package rw
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// DigestWriter computes the SHA-256 and size of everything written through it
+type DigestWriter struct {
+	writer io.Writer
+	hash   hash.Hash
+	size   int64
+}
+
+func NewDigestWriter(writer io.Writer) *DigestWriter {
+	return &DigestWriter{writer: writer, hash: sha256.New()}
+}
+
+func (dw *DigestWriter) Write(p []byte) (int, error) {
+	n, err := dw.writer.Write(p)
+	dw.hash.Write(p[:n])
+	dw.size += int64(n)
+	return n, err
+}
+
+// Sum returns the hex encoded SHA-256 of the bytes written so far
+func (dw *DigestWriter) Sum() string {
+	return hex.EncodeToString(dw.hash.Sum(nil))
+}
+
+// Size returns the number of bytes written so far
+func (dw *DigestWriter) Size() int64 {
+	return dw.size
+}
@@ -0,0 +1,61 @@
+package rw
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// EncryptedSuffix is appended to the extension of encrypted archives
+const EncryptedSuffix = ".age"
+
+// ErrEncrypted is returned when reading an encrypted archive without identities
+var ErrEncrypted = errors.New("archive is encrypted, provide an age identity or the passphrase")
+
+var ageMagic = []byte("age-encryption.org/")
+
+// CreateEncryptWriter encrypts everything written to it for the recipients with
+// age. Close must be called to write the final chunk.
+func CreateEncryptWriter(w io.Writer, recipients []age.Recipient) (io.WriteCloser, error) {
+	ew, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create encrypted writer: %w", err)
+	}
+	return ew, nil
+}
+
+// DetectEncryption reports whether r is an age encrypted stream, based on its
+// header. The returned reader must be used instead of r, as the sniffed bytes are buffered.
+func DetectEncryption(r io.Reader) (bool, io.Reader) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(ageMagic))
+	return bytes.Equal(magic, ageMagic), br
+}
+
+// CreateDecryptReader decrypts r if it is an age encrypted stream and returns
+// it unchanged otherwise. Encrypted streams without identities fail with ErrEncrypted.
+func CreateDecryptReader(r io.Reader, identities []age.Identity) (io.Reader, error) {
+	encrypted, r := DetectEncryption(r)
+	if !encrypted {
+		return r, nil
+	}
+	if len(identities) == 0 {
+		return nil, ErrEncrypted
+	}
+	dr, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt archive: %w", err)
+	}
+	return dr, nil
+}
+
+// TrimEncryptedSuffix returns name without the .age suffix, so that the
+// compression can be detected from the remaining extension
+func TrimEncryptedSuffix(name string) string {
+	return strings.TrimSuffix(name, EncryptedSuffix)
+}
@@ -1,6 +1,8 @@
 package rw
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
@@ -9,21 +11,61 @@ import (
 	"github.com/klauspost/compress/zstd"
 )
 
-// CreateReader creates a reader with automatic decompression based on file extension
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// DetectCompression returns the compression type ("gz", "zstd" or "none") of an
+// archive, based on the file extension and falling back to the content's magic bytes.
+// The returned reader must be used instead of r, as the sniffed bytes are buffered.
+func DetectCompression(r io.Reader, filename string) (string, io.Reader) {
+	switch {
+	case strings.HasSuffix(filename, ".gz") || strings.HasSuffix(filename, ".tgz"):
+		return "gz", r
+	case strings.HasSuffix(filename, ".zst"):
+		return "zstd", r
+	case strings.HasSuffix(filename, ".tar"):
+		return "none", r
+	}
+
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return "gz", br
+	case bytes.HasPrefix(magic, zstdMagic):
+		return "zstd", br
+	}
+	return "none", br
+}
+
+// CreateReader creates a reader with automatic decompression based on the file
+// extension, or on the content when the extension is not recognised
 func CreateReader(r io.Reader, filename string) (io.ReadCloser, error) {
-	if strings.HasSuffix(filename, ".gz") || strings.HasSuffix(filename, ".tar.gz") {
+	compression, r := DetectCompression(r, filename)
+	return CreateReaderFor(r, compression)
+}
+
+// CreateReaderFor creates a reader for a known compression type, e.g. the one
+// recorded in an archive's manifest
+func CreateReaderFor(r io.Reader, compressionType string) (io.ReadCloser, error) {
+	switch compressionType {
+	case "gz":
 		gzr, err := gzip.NewReader(r)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
 		}
 		return gzr, nil
-	} else if strings.HasSuffix(filename, ".zst") || strings.HasSuffix(filename, ".tar.zst") {
+	case "zstd":
 		zr, err := zstd.NewReader(r)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create zstd reader: %w", err)
 		}
-		return io.NopCloser(zr.IOReadCloser()), nil
+		return zr.IOReadCloser(), nil
+	case "none":
+		return io.NopCloser(r), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression type: %s", compressionType)
 	}
-	// No compression
-	return io.NopCloser(r), nil
 }
@@ -0,0 +1,119 @@
+package rw
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestCreateReaderDetectsCompression(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression string
+		filename    string
+	}{
+		{"gzip by extension", "gz", "backup.tar.gz"},
+		{"zstd by extension", "zstd", "backup.tar.zst"},
+		{"none by extension", "none", "backup.tar"},
+		{"gzip by content", "gz", "backups/vol-latest"},
+		{"zstd by content", "zstd", "backups/vol-latest"},
+		{"none by content", "none", "backups/vol-latest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := CreateWriter(&buf, tt.compression)
+			if err != nil {
+				t.Fatalf("CreateWriter(%q) error: %v", tt.compression, err)
+			}
+			io.WriteString(w, "volume contents")
+			w.Close()
+
+			detected, _ := DetectCompression(bytes.NewReader(buf.Bytes()), tt.filename)
+			if detected != tt.compression {
+				t.Errorf("DetectCompression() = %q; want %q", detected, tt.compression)
+			}
+
+			r, err := CreateReader(&buf, tt.filename)
+			if err != nil {
+				t.Fatalf("CreateReader() error: %v", err)
+			}
+			defer r.Close()
+			data, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("Read error: %v", err)
+			}
+			if string(data) != "volume contents" {
+				t.Errorf("CreateReader() data = %q; want %q", data, "volume contents")
+			}
+		})
+	}
+}
+
+func TestDigestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	dw := NewDigestWriter(&buf)
+	io.WriteString(dw, "volume contents")
+
+	// sha256sum of "volume contents"
+	expected := "4fa1537ccbca00a673fcca5248dca12e62ead3f947967f80c2ed0e44e0d6963c"
+	if dw.Sum() != expected {
+		t.Errorf("Sum() = %s; want %s", dw.Sum(), expected)
+	}
+	if dw.Size() != 15 || buf.Len() != 15 {
+		t.Errorf("Size() = %d, written %d; want 15", dw.Size(), buf.Len())
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	recipient, err := age.NewScryptRecipient("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient.SetWorkFactor(10)
+	var buf bytes.Buffer
+	ew, err := CreateEncryptWriter(&buf, []age.Recipient{recipient})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(ew, "volume contents")
+	if err := ew.Close(); err != nil {
+		t.Fatal(err)
+	}
+	encrypted := buf.Bytes()
+
+	if _, err := CreateDecryptReader(bytes.NewReader(encrypted), nil); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("CreateDecryptReader() without identities error = %v; want ErrEncrypted", err)
+	}
+	wrong, _ := age.NewScryptIdentity("wrong")
+	if _, err := CreateDecryptReader(bytes.NewReader(encrypted), []age.Identity{wrong}); err == nil {
+		t.Error("CreateDecryptReader() with the wrong passphrase expected error but got none")
+	}
+
+	identity, _ := age.NewScryptIdentity("correct horse")
+	r, err := CreateDecryptReader(bytes.NewReader(encrypted), []age.Identity{identity})
+	if err != nil {
+		t.Fatalf("CreateDecryptReader() error: %v", err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "volume contents" {
+		t.Errorf("Decrypted = %q; want %q", got, "volume contents")
+	}
+
+	// Plaintext passes through unchanged
+	r, err = CreateDecryptReader(strings.NewReader("plain"), nil)
+	if err != nil {
+		t.Fatalf("CreateDecryptReader() of plaintext error: %v", err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "plain" {
+		t.Errorf("Plaintext = %q; want %q", got, "plain")
+	}
+
+	if !IsArchiveName("vol.tar.zst.age") || IsArchiveName("vol.age") {
+		t.Error("IsArchiveName() should accept archive extensions followed by .age only")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"compress/gzip"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/klauspost/compress/zstd"
 )
@@ -30,3 +31,29 @@ func CreateWriter(w io.Writer, compressionType string) (io.WriteCloser, error) {
 		return nil, fmt.Errorf("unsupported compression type: %s", compressionType)
 	}
 }
+
+// Extension returns the conventional archive file extension for a compression type
+func Extension(compressionType string) (string, error) {
+	switch compressionType {
+	case "none":
+		return ".tar", nil
+	case "gz":
+		return ".tar.gz", nil
+	case "zstd":
+		return ".tar.zst", nil
+	default:
+		return "", fmt.Errorf("unsupported compression type: %s", compressionType)
+	}
+}
+
+// IsArchiveName reports whether name has one of the archive extensions returned by
+// Extension, optionally followed by the suffix of encrypted archives
+func IsArchiveName(name string) bool {
+	name = TrimEncryptedSuffix(name)
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".tar.zst"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
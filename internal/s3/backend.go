@@ -0,0 +1,216 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"docker-volume-backup/internal/storage"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+func init() {
+	storage.Register("s3", func(ctx context.Context, location string) (storage.Backend, string, error) {
+		bucket, key, _ := strings.Cut(location, "/")
+		if bucket == "" {
+			return nil, "", fmt.Errorf("invalid S3 path format, expected s3://bucket/key")
+		}
+		client, err := NewClient(ctx)
+		if err != nil {
+			return nil, "", err
+		}
+		return NewBackend(client, bucket), key, nil
+	})
+}
+
+const (
+	// DefaultPartSize allows objects of up to ~625 GiB within the 10,000 part limit.
+	DefaultPartSize = 64 * 1024 * 1024
+	// DefaultConcurrency is the number of parts uploaded in parallel.
+	DefaultConcurrency = 2
+	// DefaultRangeSize is the size of each ranged GET when reading an object.
+	DefaultRangeSize = 32 * 1024 * 1024
+)
+
+// Backend stores objects in a single S3 bucket.
+type Backend struct {
+	client      *s3.Client
+	bucket      string
+	partSize    int64
+	concurrency int
+	rangeSize   int64
+}
+
+// NewBackend creates a backend for bucket using an existing client.
+func NewBackend(client *s3.Client, bucket string) *Backend {
+	return &Backend{
+		client:      client,
+		bucket:      bucket,
+		partSize:    DefaultPartSize,
+		concurrency: DefaultConcurrency,
+		rangeSize:   DefaultRangeSize,
+	}
+}
+
+// isNotFound reports whether err is an S3 "no such key" style error.
+func isNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+		return true
+	}
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NotFound" || apiErr.ErrorCode() == "NoSuchKey")
+}
+
+// uploadWriter streams everything written to it into a multipart upload through a pipe.
+type uploadWriter struct {
+	pw   *io.PipeWriter
+	stop func() bool
+	done chan error
+}
+
+// errUploadAborted is passed to the uploader when a backup is abandoned, so the
+// multipart upload is aborted instead of being completed.
+var errUploadAborted = errors.New("upload aborted")
+
+func (w *uploadWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *uploadWriter) Close() error {
+	w.pw.Close()
+	err := <-w.done
+	w.stop()
+	if err != nil {
+		return fmt.Errorf("failed to upload to S3: %w", err)
+	}
+	return nil
+}
+
+func (w *uploadWriter) Abort() error {
+	w.pw.CloseWithError(errUploadAborted)
+	<-w.done
+	w.stop()
+	return nil
+}
+
+// Create starts a streaming multipart upload. At most partSize*concurrency bytes
+// are buffered in memory, independent of the archive size.
+//
+// Cancelling ctx fails the upload through the pipe rather than through the
+// uploader's own context, so the uploader can still abort the multipart upload
+// and no orphaned parts are left in the bucket.
+func (b *Backend) Create(ctx context.Context, key string) (storage.Writer, error) {
+	if key == "" {
+		return nil, fmt.Errorf("invalid S3 path format, expected s3://bucket/key")
+	}
+
+	uploader := manager.NewUploader(b.client, func(u *manager.Uploader) {
+		u.PartSize = b.partSize
+		u.Concurrency = b.concurrency
+	})
+
+	pr, pw := io.Pipe()
+	w := &uploadWriter{
+		pw:   pw,
+		stop: context.AfterFunc(ctx, func() { pr.CloseWithError(ctx.Err()) }),
+		done: make(chan error, 1),
+	}
+	go func() {
+		_, err := uploader.Upload(context.WithoutCancel(ctx), &s3.PutObjectInput{
+			Bucket: aws.String(b.bucket),
+			Key:    aws.String(key),
+			Body:   pr,
+		})
+		// Fail pending writes if the upload stopped early
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+// Open streams the object with ranged GETs. Nothing is written to local disk.
+func (b *Backend) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	head, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("s3://%s/%s: %w", b.bucket, key, storage.ErrNotExist)
+		}
+		return nil, fmt.Errorf("failed to download from S3: %w", err)
+	}
+	if aws.ToString(head.ETag) == "" {
+		return nil, fmt.Errorf("s3://%s/%s: %w", b.bucket, key, errEmptyETag)
+	}
+
+	return &rangeReader{
+		ctx:     ctx,
+		backend: b,
+		key:     key,
+		etag:    aws.ToString(head.ETag),
+		size:    aws.ToInt64(head.ContentLength),
+	}, nil
+}
+
+func (b *Backend) Stat(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	out, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return storage.ObjectInfo{}, fmt.Errorf("s3://%s/%s: %w", b.bucket, key, storage.ErrNotExist)
+		}
+		return storage.ObjectInfo{}, fmt.Errorf("failed to stat S3 object: %w", err)
+	}
+	return storage.ObjectInfo{
+		Key:     key,
+		Size:    aws.ToInt64(out.ContentLength),
+		ModTime: aws.ToTime(out.LastModified),
+	}, nil
+}
+
+// List pages through ListObjectsV2 and returns every object under prefix.
+func (b *Backend) List(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	var objects []storage.ObjectInfo
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list S3 objects: %w", err)
+		}
+		for _, obj := range page.Contents {
+			objects = append(objects, storage.ObjectInfo{
+				Key:     aws.ToString(obj.Key),
+				Size:    aws.ToInt64(obj.Size),
+				ModTime: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (b *Backend) Delete(ctx context.Context, key string) error {
+	_, err := b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete S3 object: %w", err)
+	}
+	return nil
+}
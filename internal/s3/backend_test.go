@@ -0,0 +1,208 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	"docker-volume-backup/internal/storage"
+)
+
+func TestBackendStreamingMultipartUpload(t *testing.T) {
+	fake, backend := newTestBackend(t)
+
+	// 12 MiB with 5 MiB parts results in three parts
+	data := make([]byte, 12*1024*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	writeObject(t, backend, "backups/vol.tar.gz", data)
+
+	got, ok := fake.get("bucket", "backups/vol.tar.gz")
+	if !ok {
+		t.Fatal("Object was not uploaded")
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Uploaded object differs: got %d bytes, want %d", len(got), len(data))
+	}
+	if n := fake.pendingUploads(); n != 0 {
+		t.Errorf("%d multipart uploads left open", n)
+	}
+}
+
+func TestBackendSmallUpload(t *testing.T) {
+	fake, backend := newTestBackend(t)
+
+	writeObject(t, backend, "small.tar", []byte("tiny archive"))
+
+	got, _ := fake.get("bucket", "small.tar")
+	if string(got) != "tiny archive" {
+		t.Errorf("Uploaded object = %q; want %q", got, "tiny archive")
+	}
+}
+
+func TestBackendAbortDiscardsUpload(t *testing.T) {
+	fake, backend := newTestBackend(t)
+
+	w, err := backend.Create(context.Background(), "aborted.tar.gz")
+	if err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	// Write more than one part so a multipart upload is in progress
+	if _, err := w.Write(make([]byte, 6*1024*1024)); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatalf("Abort() error: %v", err)
+	}
+
+	if _, ok := fake.get("bucket", "aborted.tar.gz"); ok {
+		t.Error("Aborted upload produced an object")
+	}
+	if n := fake.pendingUploads(); n != 0 {
+		t.Errorf("%d multipart uploads left open after Abort", n)
+	}
+}
+
+func TestBackendStatListDelete(t *testing.T) {
+	fake, backend := newTestBackend(t)
+	fake.pageSize = 2
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		fake.put("bucket", fmt.Sprintf("backups/vol-%d.tar.gz", i), []byte("data"))
+	}
+	fake.put("bucket", "other/vol.tar.gz", []byte("data"))
+
+	info, err := backend.Stat(ctx, "backups/vol-0.tar.gz")
+	if err != nil {
+		t.Fatalf("Stat() error: %v", err)
+	}
+	if info.Size != 4 {
+		t.Errorf("Stat() size = %d; want 4", info.Size)
+	}
+	if _, err := backend.Stat(ctx, "backups/missing.tar.gz"); !errors.Is(err, storage.ErrNotExist) {
+		t.Errorf("Stat(missing) error = %v; want ErrNotExist", err)
+	}
+
+	// Pages of two objects force the paginator to follow continuation tokens
+	objects, err := backend.List(ctx, "backups/")
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+	if len(objects) != 5 {
+		t.Fatalf("List() returned %d objects; want 5", len(objects))
+	}
+
+	if err := backend.Delete(ctx, "backups/vol-0.tar.gz"); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	if _, ok := fake.get("bucket", "backups/vol-0.tar.gz"); ok {
+		t.Error("Delete() did not remove the object")
+	}
+}
+
+func TestBackendOpen(t *testing.T) {
+	fake, backend := newTestBackend(t)
+	fake.put("bucket", "vol.tar", []byte("archive contents"))
+
+	r, err := backend.Open(context.Background(), "vol.tar")
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if string(data) != "archive contents" {
+		t.Errorf("Open() data = %q; want %q", data, "archive contents")
+	}
+
+	if _, err := backend.Open(context.Background(), "missing.tar"); !errors.Is(err, storage.ErrNotExist) {
+		t.Errorf("Open(missing) error = %v; want ErrNotExist", err)
+	}
+}
+
+func TestBackendOpenUsesRangedGets(t *testing.T) {
+	fake, backend := newTestBackend(t)
+	backend.rangeSize = 1024
+
+	data := make([]byte, 2500)
+	rand.New(rand.NewSource(2)).Read(data)
+	fake.put("bucket", "vol.tar.zst", data)
+
+	r, err := backend.Open(context.Background(), "vol.tar.zst")
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("Read %d bytes that differ from the %d byte object", len(got), len(data))
+	}
+
+	want := []string{"bytes=0-1023", "bytes=1024-2047", "bytes=2048-2499"}
+	if fmt.Sprint(fake.gets) != fmt.Sprint(want) {
+		t.Errorf("GET ranges = %v; want %v", fake.gets, want)
+	}
+}
+
+func TestBackendOpenResumesBrokenRange(t *testing.T) {
+	fake, backend := newTestBackend(t)
+	backend.rangeSize = 1000
+
+	data := make([]byte, 3000)
+	rand.New(rand.NewSource(3)).Read(data)
+	fake.put("bucket", "vol.tar.gz", data)
+	fake.truncateGets = 1
+
+	r, err := backend.Open(context.Background(), "vol.tar.gz")
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("Resumed download does not match the object")
+	}
+	// The first range broke after 500 bytes and was resumed from there
+	if len(fake.gets) < 2 || fake.gets[1] != "bytes=500-1499" {
+		t.Errorf("GET ranges = %v; want resume at bytes=500-1499", fake.gets)
+	}
+}
+
+func TestBackendCancelAbortsUpload(t *testing.T) {
+	fake, backend := newTestBackend(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	w, err := backend.Create(ctx, "cancelled.tar.gz")
+	if err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	if _, err := w.Write(make([]byte, 6*1024*1024)); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+
+	cancel()
+	if _, err := w.Write([]byte("more")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Write() after cancel error = %v; want context.Canceled", err)
+	}
+	w.Abort()
+
+	if _, ok := fake.get("bucket", "cancelled.tar.gz"); ok {
+		t.Error("Cancelled upload produced an object")
+	}
+	// The multipart upload must be aborted even though ctx is cancelled
+	if n := fake.pendingUploads(); n != 0 {
+		t.Errorf("%d multipart uploads left open after cancel", n)
+	}
+}
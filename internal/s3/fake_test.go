@@ -0,0 +1,296 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeS3 is a minimal in-process, path-style S3 server that supports the calls
+// made by Backend: single and multipart uploads, (ranged) GET, HEAD, DELETE and
+// ListObjectsV2 with pagination.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte // "bucket/key" -> data
+	uploads  map[string]map[int][]byte
+	nextID   int
+	pageSize int
+	gets     []string // Range headers of GET requests, in order
+
+	// truncateGets makes the next n GET responses drop the connection halfway
+	truncateGets int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *s3.Client) {
+	t.Helper()
+	fake := &fakeS3{
+		objects:  map[string][]byte{},
+		uploads:  map[string]map[int][]byte{},
+		pageSize: 1000,
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := s3.New(s3.Options{
+		BaseEndpoint:               aws.String(server.URL),
+		Region:                     "us-east-1",
+		Credentials:                credentials.NewStaticCredentialsProvider("test", "test", ""),
+		UsePathStyle:               true,
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+	})
+	return fake, client
+}
+
+// put stores an object directly, bypassing the API.
+func (f *fakeS3) put(bucket, key string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[bucket+"/"+key] = data
+}
+
+// get returns a stored object.
+func (f *fakeS3) get(bucket, key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[bucket+"/"+key]
+	return data, ok
+}
+
+func (f *fakeS3) pendingUploads() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.uploads)
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	data, _ := xml.Marshal(v)
+	w.Write(data)
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	id := bucket + "/" + key
+
+	switch {
+	case r.Method == http.MethodGet && key == "" && query.Get("list-type") == "2":
+		f.list(w, bucket, query)
+
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.nextID++
+		uploadID := strconv.Itoa(f.nextID)
+		f.uploads[uploadID] = map[int][]byte{}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: uploadID})
+
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		parts[number] = data
+		w.Header().Set("ETag", etag(data))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		numbers := make([]int, 0, len(parts))
+		for n := range parts {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		var data []byte
+		for _, n := range numbers {
+			data = append(data, parts[n]...)
+		}
+		f.objects[id] = data
+		delete(f.uploads, query.Get("uploadId"))
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: bucket, Key: key, ETag: etag(data)})
+
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objects[id] = data
+		w.Header().Set("ETag", etag(data))
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[id]
+		if !ok {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Last-Modified", time.Unix(1700000000, 0).UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", etag(data))
+		if match := r.Header.Get("If-Match"); match != "" && match != etag(data) {
+			writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if r.Method == http.MethodGet {
+			f.gets = append(f.gets, r.Header.Get("Range"))
+		}
+		if rng := r.Header.Get("Range"); rng != "" && r.Method == http.MethodGet {
+			var start, end int
+			if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); err != nil {
+				end = len(data) - 1
+				fmt.Sscanf(rng, "bytes=%d-", &start)
+			}
+			if end >= len(data) {
+				end = len(data) - 1
+			}
+			if start >= len(data) {
+				writeS3Error(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+			w.WriteHeader(http.StatusPartialContent)
+			if f.truncateGets > 0 {
+				// Short write against the declared Content-Length breaks the connection
+				f.truncateGets--
+				w.Write(data[start : start+(end-start+1)/2])
+				return
+			}
+			w.Write(data[start : end+1])
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, bucket string, query map[string][]string) {
+	prefix := ""
+	if p := query["prefix"]; len(p) > 0 {
+		prefix = p[0]
+	}
+	start := ""
+	if token := query["continuation-token"]; len(token) > 0 {
+		start = token[0]
+	}
+
+	var keys []string
+	for id := range f.objects {
+		b, key, _ := strings.Cut(id, "/")
+		if b == bucket && strings.HasPrefix(key, prefix) && key > start {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type object struct {
+		Key          string
+		Size         int64
+		LastModified string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		KeyCount              int
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []object
+	}{Name: bucket, Prefix: prefix}
+
+	if len(keys) > f.pageSize {
+		keys = keys[:f.pageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, object{
+			Key:          key,
+			Size:         int64(len(f.objects[bucket+"/"+key])),
+			LastModified: "2023-11-14T22:13:20.000Z",
+		})
+	}
+	result.KeyCount = len(result.Contents)
+	writeXML(w, result)
+}
+
+// newTestBackend returns a backend for "bucket" on a fresh fake server, using
+// small parts so multipart uploads are exercised with little data.
+func newTestBackend(t *testing.T) (*fakeS3, *Backend) {
+	fake, client := newFakeS3(t)
+	backend := NewBackend(client, "bucket")
+	backend.partSize = 5 * 1024 * 1024
+	return fake, backend
+}
+
+// writeObject streams data through Backend.Create.
+func writeObject(t *testing.T, backend *Backend, key string, data []byte) {
+	t.Helper()
+	w, err := backend.Create(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule returns the activation times of a job.
+type Schedule interface {
+	// Next returns the first activation strictly after t.
+	Next(t time.Time) time.Time
+}
+
+// Every activates at a fixed interval.
+type Every time.Duration
+
+func (e Every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// Cron is a parsed cron expression, evaluated in the local time zone.
+type Cron struct {
+	second, minute, hour, dom, month, dow uint64
+	// A day matches when both day fields match, unless both are restricted,
+	// in which case matching either is enough (as in standard cron).
+	domStar, dowStar bool
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+type bounds struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	secondBounds = bounds{"second", 0, 59, nil}
+	minuteBounds = bounds{"minute", 0, 59, nil}
+	hourBounds   = bounds{"hour", 0, 23, nil}
+	domBounds    = bounds{"day of month", 1, 31, nil}
+	monthBounds  = bounds{"month", 1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is accepted as an alias for Sunday
+	dowBounds = bounds{"day of week", 0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// Parse parses a schedule specification:
+//   - a standard 5-field cron expression: "minute hour day-of-month month day-of-week"
+//   - a 6-field cron expression with a leading seconds field: "*/30 * * * * *"
+//   - a descriptor: @yearly, @monthly, @weekly, @daily, @hourly
+//   - a fixed interval: "@every 90m"
+//
+// Fields accept *, ?, lists (1,15), ranges (1-5), steps (*/10, 0-30/5) and
+// month/weekday names (jan, mon).
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if rest, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule '%s': %w", spec, err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("invalid schedule '%s': interval must be at least 1s", spec)
+		}
+		return Every(d), nil
+	}
+	if strings.HasPrefix(spec, "@") {
+		expanded, ok := descriptors[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule '%s': unknown descriptor", spec)
+		}
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("invalid schedule '%s': expected 5 or 6 fields, got %d", spec, len(fields))
+	}
+
+	c := &Cron{}
+	var err error
+	parsers := []struct {
+		bits *uint64
+		b    bounds
+	}{
+		{&c.second, secondBounds}, {&c.minute, minuteBounds}, {&c.hour, hourBounds},
+		{&c.dom, domBounds}, {&c.month, monthBounds}, {&c.dow, dowBounds},
+	}
+	for i, p := range parsers {
+		if *p.bits, err = parseField(fields[i], p.b); err != nil {
+			return nil, fmt.Errorf("invalid schedule '%s': %w", spec, err)
+		}
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1 << 0
+	}
+	c.domStar = fields[3] == "*" || fields[3] == "?"
+	c.dowStar = fields[5] == "*" || fields[5] == "?"
+	if c.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("invalid schedule '%s': never matches", spec)
+	}
+	return c, nil
+}
+
+// parseField returns a bit set of the values matched by a comma-separated field.
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step '%s' in %s field", stepPart, b.name)
+			}
+		}
+
+		var lo, hi int
+		switch {
+		case rangePart == "*" || rangePart == "?":
+			lo, hi = b.min, b.max
+		case strings.Contains(rangePart, "-"):
+			from, to, _ := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = parseValue(from, b); err != nil {
+				return 0, err
+			}
+			if hi, err = parseValue(to, b); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range '%s' in %s field", rangePart, b.name)
+			}
+		default:
+			var err error
+			if lo, err = parseValue(rangePart, b); err != nil {
+				return 0, err
+			}
+			// "5/15" means every 15 starting at 5
+			hi = lo
+			if hasStep {
+				hi = b.max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(s string, b bounds) (int, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < b.min || v > b.max {
+		return 0, fmt.Errorf("invalid value '%s' in %s field (allowed %d-%d)", s, b.name, b.min, b.max)
+	}
+	return v, nil
+}
+
+func has(bits uint64, v int) bool {
+	return bits&(1<<uint(v)) != 0
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom, dow := has(c.dom, t.Day()), has(c.dow, int(t.Weekday()))
+	if !c.domStar && !c.dowStar {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// Next returns the first matching second after t, or the zero time if the
+// expression never matches (e.g. February 30th).
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Second).Add(time.Second)
+	// Every valid expression matches within a leap year cycle
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case !has(c.month, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !has(c.hour, t.Hour()):
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location()), time.Hour)
+		case !has(c.minute, t.Minute()):
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location()), time.Minute)
+		case !has(c.second, t.Second()):
+			t = t.Add(time.Second)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// forward returns next, or t+step when next is not after t. time.Date may resolve
+// a wall clock time in a repeated DST hour to its first occurrence.
+func forward(t, next time.Time, step time.Duration) time.Time {
+	if !next.After(t) {
+		return t.Add(step)
+	}
+	return next
+}
@@ -0,0 +1,89 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAndNext(t *testing.T) {
+	// Saturday
+	from := time.Date(2024, 3, 9, 10, 17, 42, 0, time.Local)
+
+	tests := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2024, 3, 9, 10, 18, 0, 0, time.Local)},
+		{"*/30 * * * * *", time.Date(2024, 3, 9, 10, 18, 0, 0, time.Local)},
+		{"15,45 * * * * *", time.Date(2024, 3, 9, 10, 17, 45, 0, time.Local)},
+		{"0 3 * * *", time.Date(2024, 3, 10, 3, 0, 0, 0, time.Local)},
+		{"30 2 * * mon-fri", time.Date(2024, 3, 11, 2, 30, 0, 0, time.Local)},
+		{"0 0 1 jan *", time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)},
+		{"0 12 * * 7", time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local)},
+		{"5/20 * * * *", time.Date(2024, 3, 9, 10, 25, 0, 0, time.Local)},
+		// Both day fields restricted: the 15th or any Monday
+		{"0 0 15 * 1", time.Date(2024, 3, 11, 0, 0, 0, 0, time.Local)},
+		{"0 0 29 2 ?", time.Date(2024, 2, 29, 0, 0, 0, 0, time.Local).AddDate(4, 0, 0)},
+		{"@hourly", time.Date(2024, 3, 9, 11, 0, 0, 0, time.Local)},
+		{"@daily", time.Date(2024, 3, 10, 0, 0, 0, 0, time.Local)},
+		{"@weekly", time.Date(2024, 3, 10, 0, 0, 0, 0, time.Local)},
+		{"@monthly", time.Date(2024, 4, 1, 0, 0, 0, 0, time.Local)},
+		{"@every 90m", from.Add(90 * time.Minute)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.spec, err)
+			}
+			if got := s.Next(from); !got.Equal(tt.expected) {
+				t.Errorf("Next() = %v; want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"0 0 30 feb *",
+		"@fortnightly",
+		"@every 500ms",
+		"@every often",
+	}
+	for _, spec := range specs {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) expected error but got none", spec)
+		}
+	}
+}
+
+func TestNextAcrossDST(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Lisbon")
+	if err != nil {
+		t.Skip("time zone data not available")
+	}
+	s, _ := Parse("30 * * * *")
+
+	// Clocks go back from 02:00 WEST to 01:00 WET on 2024-10-27; every half hour must still fire
+	from := time.Date(2024, 10, 27, 0, 45, 0, 0, loc)
+	var times []time.Time
+	for i := 0; i < 3; i++ {
+		from = s.Next(from)
+		times = append(times, from)
+	}
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap != time.Hour {
+			t.Errorf("Gap between %v and %v = %v; want 1h", times[i-1], times[i], gap)
+		}
+	}
+}
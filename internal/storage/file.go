@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func init() {
+	Register("file", func(_ context.Context, location string) (Backend, string, error) {
+		if err := ValidateFilePath(location); err != nil {
+			return nil, "", err
+		}
+		// Keys are clean paths, so they compare equal to the paths returned by List
+		return FileBackend{}, filepath.Clean(location), nil
+	})
+}
+
+// ValidateFilePath checks if the provided file path is valid and does not contain illegal or unsafe patterns.
+func ValidateFilePath(path string) error {
+	if path == "" {
+		return fmt.Errorf("file path cannot be empty")
+	}
+	// Check for path traversal attempts
+	if strings.Contains(path, "..") {
+		return fmt.Errorf("path traversal not allowed in '%s'", path)
+	}
+	return nil
+}
+
+// FileBackend stores objects on the local filesystem. Keys are file paths.
+type FileBackend struct{}
+
+// fileWriter writes to a temporary file next to the destination and renames it into place on Close.
+type fileWriter struct {
+	*os.File
+	dest string
+}
+
+func (w *fileWriter) Close() error {
+	// CreateTemp makes the file private, the destination gets the usual mode
+	if err := w.File.Chmod(os.FileMode(fileMode)); err != nil {
+		w.File.Close()
+		os.Remove(w.File.Name())
+		return fmt.Errorf("failed to write %s: %w", w.dest, err)
+	}
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.File.Name())
+		return fmt.Errorf("failed to write %s: %w", w.dest, err)
+	}
+	if err := os.Rename(w.File.Name(), w.dest); err != nil {
+		os.Remove(w.File.Name())
+		return fmt.Errorf("failed to move backup into place: %w", err)
+	}
+	return nil
+}
+
+func (w *fileWriter) Abort() error {
+	w.File.Close()
+	return os.Remove(w.File.Name())
+}
+
+func (FileBackend) Create(_ context.Context, key string) (Writer, error) {
+	dir := filepath.Dir(key)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(key)+".*.partial")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backup file: %w", err)
+	}
+	return &fileWriter{File: f, dest: key}, nil
+}
+
+func (FileBackend) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotExist)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup file: %w", err)
+	}
+	return f, nil
+}
+
+func (FileBackend) Stat(_ context.Context, key string) (ObjectInfo, error) {
+	info, err := os.Stat(key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ObjectInfo{}, fmt.Errorf("%s: %w", key, ErrNotExist)
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if info.IsDir() {
+		return ObjectInfo{}, fmt.Errorf("%s is a directory", key)
+	}
+	return ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// List walks the directory tree below prefix. If prefix is not a directory,
+// the files in its parent directory are matched by name prefix.
+func (FileBackend) List(_ context.Context, prefix string) ([]ObjectInfo, error) {
+	root := prefix
+	if info, err := os.Stat(prefix); err != nil || !info.IsDir() {
+		root = filepath.Dir(prefix)
+	}
+	namePrefix := filepath.Clean(prefix)
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if root != prefix && path != root && !strings.HasPrefix(path, namePrefix) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		// Skip in-progress writes from Create
+		if strings.HasSuffix(path, ".partial") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: path, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", prefix, err)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (FileBackend) Delete(_ context.Context, key string) error {
+	err := os.Remove(key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s: %w", key, ErrNotExist)
+	}
+	return err
+}
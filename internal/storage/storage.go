@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotExist is returned (possibly wrapped) when an object does not exist.
+var ErrNotExist = errors.New("object does not exist")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Writer is an object being written. Close commits the object; Abort discards
+// everything written so far so that no partial object is left behind.
+type Writer interface {
+	io.WriteCloser
+	Abort() error
+}
+
+// Backend is a place backups can be streamed to and read from.
+// Keys are backend-specific object names (file paths, S3 keys, ...).
+type Backend interface {
+	// Create opens a writer for a new object, replacing any existing object on Close.
+	Create(ctx context.Context, key string) (Writer, error)
+	// Open opens an object for reading.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Stat returns information about an object.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// List returns all objects whose key starts with prefix, sorted by key.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Delete removes an object.
+	Delete(ctx context.Context, key string) error
+}
+
+// Factory creates a backend for a location. It receives the part of the location
+// after "<scheme>://" and returns the backend and the object key inside it.
+type Factory func(ctx context.Context, location string) (Backend, string, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register makes a backend available for locations starting with "<scheme>://".
+// It is meant to be called from a backend package's init function.
+func Register(scheme string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[scheme]; dup {
+		panic("storage: backend registered twice for scheme " + scheme)
+	}
+	registry[scheme] = factory
+}
+
+// Schemes returns the registered URL schemes, sorted.
+func Schemes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	schemes := make([]string, 0, len(registry))
+	for scheme := range registry {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Resolve returns the backend and object key for a location such as
+// "s3://bucket/key" or "/backups/volume.tar.gz". Locations without a scheme
+// are local file paths.
+func Resolve(ctx context.Context, location string) (Backend, string, error) {
+	scheme, rest, found := strings.Cut(location, "://")
+	if !found {
+		scheme, rest = "file", location
+	}
+
+	registryMu.RLock()
+	factory, ok := registry[scheme]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported storage scheme '%s' (supported: %s)", scheme, strings.Join(Schemes(), ", "))
+	}
+	return factory(ctx, rest)
+}
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateFilePath(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		shouldErr bool
+	}{
+		{"valid absolute", "/tmp/backup.tar.gz", false},
+		{"valid relative", "backup.tar.gz", false},
+		{"valid nested", "/var/backups/data/backup.tar", false},
+		{"empty", "", true},
+		{"path traversal", "../etc/passwd", true},
+		{"path traversal absolute", "/tmp/../etc/passwd", true},
+		{"double dot in name", "backup..tar", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateFilePath(tt.path)
+			if tt.shouldErr && err == nil {
+				t.Errorf("ValidateFilePath(%q) expected error but got none", tt.path)
+			}
+			if !tt.shouldErr && err != nil {
+				t.Errorf("ValidateFilePath(%q) unexpected error: %v", tt.path, err)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name      string
+		location  string
+		wantKey   string
+		shouldErr bool
+	}{
+		{"plain path", "/backups/vol.tar.gz", "/backups/vol.tar.gz", false},
+		{"relative path", "vol.tar.gz", "vol.tar.gz", false},
+		{"file url", "file:///backups/vol.tar.gz", "/backups/vol.tar.gz", false},
+		{"unknown scheme", "ftp://host/vol.tar.gz", "", true},
+		{"traversal", "/backups/../etc/passwd", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, key, err := Resolve(context.Background(), tt.location)
+			if tt.shouldErr {
+				if err == nil {
+					t.Errorf("Resolve(%q) expected error but got none", tt.location)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve(%q) unexpected error: %v", tt.location, err)
+			}
+			if _, ok := backend.(FileBackend); !ok {
+				t.Errorf("Resolve(%q) backend = %T; want FileBackend", tt.location, backend)
+			}
+			if key != tt.wantKey {
+				t.Errorf("Resolve(%q) key = %q; want %q", tt.location, key, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestFileBackendWriteAndRead(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	key := filepath.Join(dir, "nested", "vol.tar.gz")
+	backend := FileBackend{}
+
+	w, err := backend.Create(ctx, key)
+	if err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	io.WriteString(w, "archive data")
+
+	// Nothing is visible at the destination until the writer is closed
+	if _, err := backend.Stat(ctx, key); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat() before Close error = %v; want ErrNotExist", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	info, err := backend.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat() error: %v", err)
+	}
+	if info.Size != int64(len("archive data")) {
+		t.Errorf("Stat() size = %d; want %d", info.Size, len("archive data"))
+	}
+
+	r, err := backend.Open(ctx, key)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "archive data" {
+		t.Errorf("Open() data = %q; want %q", data, "archive data")
+	}
+
+	if err := backend.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	if _, err := backend.Open(ctx, key); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Open() after Delete error = %v; want ErrNotExist", err)
+	}
+}
+
+func TestFileBackendAbort(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	key := filepath.Join(dir, "vol.tar.gz")
+
+	w, err := FileBackend{}.Create(ctx, key)
+	if err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	io.WriteString(w, "partial")
+	if err := w.Abort(); err != nil {
+		t.Fatalf("Abort() error: %v", err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("Abort() left %d files behind", len(entries))
+	}
+}
+
+func TestFileBackendMode(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	key := filepath.Join(dir, "vol.tar.gz")
+
+	w, err := FileBackend{}.Create(ctx, key)
+	if err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	io.WriteString(w, "data")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	// Archives get the same mode as any file the user creates
+	reference, err := os.Create(filepath.Join(dir, "reference"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reference.Close()
+
+	got, _ := os.Stat(key)
+	want, _ := os.Stat(reference.Name())
+	if got.Mode() != want.Mode() {
+		t.Errorf("Archive mode = %v; want %v, as os.Create gives", got.Mode(), want.Mode())
+	}
+}
+
+func TestFileBackendList(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for _, name := range []string{"app-1.tar.gz", "app-2.tar.gz", "db-1.tar.gz", "sub/app-3.tar.gz"} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(name), 0644)
+	}
+	os.WriteFile(filepath.Join(dir, ".app-4.tar.gz.123.partial"), nil, 0644)
+
+	tests := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{"directory", dir, []string{"app-1.tar.gz", "app-2.tar.gz", "db-1.tar.gz", "sub/app-3.tar.gz"}},
+		{"name prefix", filepath.Join(dir, "app-"), []string{"app-1.tar.gz", "app-2.tar.gz"}},
+		{"missing directory", filepath.Join(dir, "missing") + "/", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects, err := FileBackend{}.List(ctx, tt.prefix)
+			if err != nil {
+				t.Fatalf("List(%q) error: %v", tt.prefix, err)
+			}
+			if len(objects) != len(tt.want) {
+				t.Fatalf("List(%q) returned %d objects; want %d", tt.prefix, len(objects), len(tt.want))
+			}
+			for i, obj := range objects {
+				if want := filepath.Join(dir, tt.want[i]); obj.Key != want {
+					t.Errorf("List(%q)[%d] = %q; want %q", tt.prefix, i, obj.Key, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package storage
+
+import "syscall"
+
+// fileMode is the mode os.Create would give new files, 0666 less the umask.
+// The umask can only be read by setting it, which is safe before main starts.
+var fileMode = func() uint32 {
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return 0o666 &^ uint32(mask)
+}()
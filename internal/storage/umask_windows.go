@@ -0,0 +1,7 @@
+//go:build windows
+
+package storage
+
+// fileMode is the mode os.Create would give new files; Windows only keeps
+// the write bit.
+var fileMode uint32 = 0o666
@@ -0,0 +1,5 @@
+package version
+
+// Version is the tool version recorded in backup manifests. Release builds set it with
+// -ldflags "-X docker-volume-backup/internal/version.Version=v1.2.3".
+var Version = "dev"